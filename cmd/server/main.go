@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 	"blueberrydb/internal/config"
 	"blueberrydb/internal/logger"
 	"blueberrydb/pkg/blueberrydb"
@@ -26,24 +28,80 @@ func main() {
 	}
 	defer aof.Close()
 
+	if cfg.Databases < 1 {
+		logger.Error("invalid databases, at least one database is needed")
+		os.Exit(1)
+	}
+	blueberrydb.SetDatabases(cfg.Databases)
+
 	// reload previous commands from aof file
 	logger.Info(fmt.Sprintf("restoring previous database state from: %s", cfg.AofFilePath))
 
-	aof.Read(func(value blueberrydb.Value) {
+	err = aof.Read(func(value blueberrydb.Value) {
 		command := strings.ToUpper(value.GetArray()[0].GetBulk())
 		args := value.GetArray()[1:]
 
+		// the commands after a SELECT record ran against that database
+		if command == "SELECT" && len(args) == 1 {
+			index, err := strconv.Atoi(args[0].GetBulk())
+			if err != nil || !blueberrydb.SelectDatabase(index) {
+				logger.Error(fmt.Sprintf("Invalid SELECT in aof: %s", args[0].GetBulk()))
+			}
+			return
+		}
+
 		handler, ok := blueberrydb.Handlers[command]
-		if !ok {
+		if _, valid := blueberrydb.CheckCommand(value); !ok || !valid {
 			logger.Debug(fmt.Sprintf("Invalid command: %s", command))
 			return
 		}
 
 		handler(args)
 	})
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error restoring the aof file: %s", err.Error()))
+		os.Exit(1)
+	}
 
 	logger.Info(fmt.Sprintf("previous database state restored successfully"))
 
+	// the restored keys are counted against maxmemory
+	blueberrydb.EstimateMemory()
+
+	// commands persisted by their effects write to the aof from now on
+	blueberrydb.SetPropagator(func(value blueberrydb.Value) {
+		aof.Write(value)
+	})
+
+	// reclaim expired data in the background
+	blueberrydb.StartActiveExpire()
+
+	blueberrydb.SetPubsubLimits(blueberrydb.OutputLimits{
+		Hard:        cfg.PubsubHardLimit,
+		Soft:        cfg.PubsubSoftLimit,
+		SoftSeconds: cfg.PubsubSoftSeconds,
+	})
+
+	blueberrydb.SetScriptTimeLimit(time.Duration(cfg.ScriptTimeLimit) * time.Millisecond)
+
+	if err := blueberrydb.SetKeyspaceEvents(cfg.NotifyKeyspaceEvents); err != nil {
+		logger.Error("invalid notify_keyspace_events. err: " + err.Error())
+		os.Exit(1)
+	}
+
+	if err := blueberrydb.SetMaxMemory(cfg.MaxMemory); err != nil {
+		logger.Error("invalid maxmemory. err: " + err.Error())
+		os.Exit(1)
+	}
+	if err := blueberrydb.SetMaxMemoryPolicy(cfg.MaxMemoryPolicy); err != nil {
+		logger.Error("invalid maxmemory_policy. err: " + err.Error())
+		os.Exit(1)
+	}
+	if err := blueberrydb.SetMaxMemorySamples(cfg.MaxMemorySamples); err != nil {
+		logger.Error("invalid maxmemory_samples. err: " + err.Error())
+		os.Exit(1)
+	}
+
 	// listen on the port
 	ln, err := net.Listen("tcp", cfg.ServerPort)
 	if err != nil {
@@ -63,18 +121,22 @@ func main() {
 			continue
 		}
 
-		go handleConnection(conn, aof, *cfg)
+		go handleConnection(conn, *cfg)
 
 	}
 
 }
 
 // goroutine to handle individual connection
-func handleConnection(conn net.Conn, aof *blueberrydb.Aof, cfg config.Config) {
-	defer conn.Close()
+func handleConnection(conn net.Conn, cfg config.Config) {
+	// replies are queued on the client and written by its own goroutine
+	client := blueberrydb.NewClient(conn)
+	defer client.Close()
+
+	// one reader per connection, pipelined commands stay in its buffer
+	resp := blueberrydb.NewResp(conn)
 
 	for {
-		resp := blueberrydb.NewResp(conn)
 		value, err := resp.Read()
 		if err != nil {
 			logger.Error(fmt.Sprintf("error reading command: %s", err.Error()))
@@ -96,18 +158,16 @@ func handleConnection(conn net.Conn, aof *blueberrydb.Aof, cfg config.Config) {
 
 		// debug command
 
-		writer := blueberrydb.NewWriter(conn)
-
 		// Handle AUTH command
 		if command == "AUTH" {
 			result := blueberrydb.Auth(args, conn, cfg.Password);
-			writer.Write(result);
+			client.Write(result);
 			continue;
 		}
 
 		// AUTH command if password is set: non-empty password string
 		if cfg.Password != "" && !blueberrydb.CheckAuth(conn) {
-			writer.Write(*blueberrydb.NewValue("string", "ERR authentication required", 0, "", nil))
+			client.Write(*blueberrydb.NewValue("error", "NOAUTH Authentication required.", 0, "", nil))
 			continue;
 		}
 
@@ -116,25 +176,40 @@ func handleConnection(conn net.Conn, aof *blueberrydb.Aof, cfg config.Config) {
 			// debug
 			logger.Debug("command executed: QUIT")
 
-			// Send OK response before closing the connection
-			writer.Write(*blueberrydb.NewValue("string", "OK", 0, "", nil))
-			conn.Close()
+			// Send OK response before closing the connection, Close flushes it
+			client.Write(*blueberrydb.NewValue("string", "OK", 0, "", nil))
 			return
 		}
 
-		handler, ok := blueberrydb.Handlers[command]
-		if !ok {
-			logger.Error("Invalid Command: " + command)
-			writer.Write(*blueberrydb.NewValue("string", "", 0, "", nil))
+		// a subscribed connection only takes subscription commands
+		if client.Subscribed() && !blueberrydb.AllowedWhileSubscribed(command) {
+			client.Write(*blueberrydb.NewValue("error", fmt.Sprintf("ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT are allowed in this context", strings.ToLower(command)), 0, "", nil))
 			continue
 		}
 
-		// write to aof for set and hset commands
-		if command == "SET" || command == "HSET" || command == "DEL" {
-			aof.Write(value)
+		// inside MULTI commands are queued until EXEC
+		if client.Queue(value) {
+			continue
+		}
+
+		// unknown commands and calls with the wrong number of arguments never run
+		if reply, ok := blueberrydb.CheckCommand(value); !ok {
+			logger.Debug("Invalid Command: " + command)
+			client.Write(reply)
+			continue
 		}
 
-		result := handler(args)
-		writer.Write(result)
+		// commands that work on the connection itself
+		if clientHandler, ok := blueberrydb.ClientHandlers[command]; ok {
+			clientHandler(client, args)
+			continue
+		}
+
+		// relative expirations run and persist in their absolute form
+		value = blueberrydb.RewriteCommand(value)
+
+		// commands that modify the dataset are written to the aof as they run
+		result := blueberrydb.Execute(client, value)
+		client.Write(result)
 	}
 }
@@ -0,0 +1,3 @@
+// extension modules linked into the server, each registers its commands when
+// imported, the ones that are only examples need their build tag
+package main
@@ -0,0 +1,8 @@
+//go:build examplemodule
+
+// the example module, linked in for the tests with -tags examplemodule
+package main
+
+import (
+	_ "blueberrydb/pkg/modules/example"
+)
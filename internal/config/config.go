@@ -9,10 +9,19 @@ import (
 
 type Config struct {
 	ServerPort string;
+	Databases int; // number of logical databases, SELECT takes 0 to Databases-1
 	AofEnabled bool;
 	AofFilePath string;
 	LogLevel string; // info, debug, error
 	Password string;
+	PubsubHardLimit int; // bytes of pending output before a subscriber is disconnected
+	PubsubSoftLimit int; // bytes a subscriber may stay above for PubsubSoftSeconds
+	PubsubSoftSeconds int;
+	NotifyKeyspaceEvents string; // event classes published on key changes, "" disables them
+	ScriptTimeLimit int; // milliseconds a script runs before other clients get BUSY replies
+	MaxMemory string; // bytes the dataset may take, with an optional unit, "0" for no limit
+	MaxMemoryPolicy string; // how keys are evicted once over MaxMemory
+	MaxMemorySamples int; // keys sampled for each eviction
 }
 
 func LoadConfig() *Config {
@@ -22,6 +31,16 @@ func LoadConfig() *Config {
 	viper.SetConfigType("toml")
 	viper.AddConfigPath(".");
 
+	// same output buffer limits for subscribers as redis
+	viper.SetDefault("clients.pubsub_hard_limit", 32 * 1024 * 1024);
+	viper.SetDefault("clients.pubsub_soft_limit", 8 * 1024 * 1024);
+	viper.SetDefault("clients.pubsub_soft_seconds", 60);
+	viper.SetDefault("scripting.busy_reply_threshold", 5000);
+	viper.SetDefault("server.databases", 16);
+	viper.SetDefault("memory.maxmemory", "0");
+	viper.SetDefault("memory.maxmemory_policy", "noeviction");
+	viper.SetDefault("memory.maxmemory_samples", 5);
+
 	// read config file if it exist
 	err := viper.ReadInConfig();
 	if err != nil {
@@ -31,10 +50,19 @@ func LoadConfig() *Config {
 	// create and populate the config struct
 	config := &Config{
 		ServerPort: viper.GetString("server.port"),	
+		Databases: viper.GetInt("server.databases"),
 		AofEnabled: viper.GetBool("persistence.enabled"),
 		AofFilePath: viper.GetString("persistence.file_path"),
 		LogLevel: viper.GetString("logging.level"),
 		Password: viper.GetString("security.password"),
+		PubsubHardLimit: viper.GetInt("clients.pubsub_hard_limit"),
+		PubsubSoftLimit: viper.GetInt("clients.pubsub_soft_limit"),
+		PubsubSoftSeconds: viper.GetInt("clients.pubsub_soft_seconds"),
+		NotifyKeyspaceEvents: viper.GetString("server.notify_keyspace_events"),
+		ScriptTimeLimit: viper.GetInt("scripting.busy_reply_threshold"),
+		MaxMemory: viper.GetString("memory.maxmemory"),
+		MaxMemoryPolicy: viper.GetString("memory.maxmemory_policy"),
+		MaxMemorySamples: viper.GetInt("memory.maxmemory_samples"),
 	}
 	
 	return config;
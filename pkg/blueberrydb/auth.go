@@ -12,7 +12,12 @@ var clientAuthState = map[net.Conn]bool{}
 // AUTH command
 func Auth(args []Value, conn net.Conn, password string) Value {
 	if len(args) != 1 {
-		return Value{typ: "error", str: "ERR wrong number of arguments for 'AUTH' command"}
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'auth' command"}
+	}
+
+	// nothing to authenticate against
+	if password == "" {
+		return Value{typ: "error", str: "ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?"}
 	}
 
 	// compare password
@@ -25,7 +30,7 @@ func Auth(args []Value, conn net.Conn, password string) Value {
 	}
 
 	logger.Info("client authentication failed")
-	return Value{typ: "error", str: "ERR invalid password"}
+	return Value{typ: "error", str: "WRONGPASS invalid username-password pair or user is disabled."}
 }
 
 // check auth state of a connection
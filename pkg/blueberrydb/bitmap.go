@@ -0,0 +1,635 @@
+// bitmap commands: bit level operations on string values
+package blueberrydb
+
+import (
+	"blueberrydb/internal/logger"
+	"fmt"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// bit offsets are limited to 2^32 bits, a 512MB string
+const maxBitOffset = 1<<32 - 1
+
+// returns the bytes of a string key, nil when it is missing or expired, caller must hold SETsMu
+func bitmapBytes(key string) ([]byte, bool) {
+	entry, ok := SETs[key]
+	if !ok || entry.expired(time.Now().Unix()) {
+		return nil, false
+	}
+
+	return []byte(entry.stringValue()), true
+}
+
+// stores bytes as a raw string keeping the expiration of the key, caller must hold the SETsMu write lock
+func bitmapStore(key string, buf []byte) {
+	entry, _ := stringLiveEntry(key)
+	entry.value = string(buf)
+	entry.num = 0
+	entry.encoding = encodingRaw
+	SETs[key] = entry
+	keyspaceAdd(key, keyString)
+}
+
+// grows a bitmap with zero bytes so that the given bit fits
+func bitmapGrow(buf []byte, bit uint64) []byte {
+	need := int(bit/8) + 1
+	if need > len(buf) {
+		buf = append(buf, make([]byte, need-len(buf))...)
+	}
+
+	return buf
+}
+
+// parses a bit offset argument
+func parseBitOffset(arg string) (uint64, bool) {
+	offset, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || offset < 0 || offset > maxBitOffset {
+		return 0, false
+	}
+
+	return uint64(offset), true
+}
+
+// value of a single bit, bits are numbered from the most significant bit of the first byte
+func getBit(buf []byte, offset uint64) int {
+	index := offset / 8
+	if index >= uint64(len(buf)) {
+		return 0
+	}
+
+	return int(buf[index]>>(7-offset%8)) & 1
+}
+
+// sets a single bit, the buffer must be large enough
+func setBit(buf []byte, offset uint64, bit int) {
+	mask := byte(1 << (7 - offset%8))
+	if bit == 1 {
+		buf[offset/8] |= mask
+	} else {
+		buf[offset/8] &^= mask
+	}
+}
+
+// SETBIT command: returns the previous value of the bit
+func setbit(args []Value) Value {
+	key := args[0].bulk
+
+	offset, ok := parseBitOffset(args[1].bulk)
+	if !ok {
+		return Value{typ: "error", str: "ERR bit offset is not an integer or out of range"}
+	}
+
+	if args[2].bulk != "0" && args[2].bulk != "1" {
+		return Value{typ: "error", str: "ERR bit is not an integer or out of range"}
+	}
+	bit := int(args[2].bulk[0] - '0')
+
+	SETsMu.Lock()
+	defer SETsMu.Unlock()
+
+	buf, _ := bitmapBytes(key)
+	buf = bitmapGrow(buf, offset)
+
+	previous := getBit(buf, offset)
+	setBit(buf, offset, bit)
+	bitmapStore(key, buf)
+	notifyKeyspaceEvent(notifyString, "setbit", key)
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: SETBIT %s %d %d", key, offset, bit))
+
+	return Value{typ: "integer", num: previous}
+}
+
+// GETBIT command
+func getbit(args []Value) Value {
+	key := args[0].bulk
+
+	offset, ok := parseBitOffset(args[1].bulk)
+	if !ok {
+		return Value{typ: "error", str: "ERR bit offset is not an integer or out of range"}
+	}
+
+	SETsMu.RLock()
+	buf, _ := bitmapBytes(key)
+	SETsMu.RUnlock()
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: GETBIT %s %d", key, offset))
+
+	return Value{typ: "integer", num: getBit(buf, offset)}
+}
+
+// resolves an inclusive start-end range that may count from the end, returns false for an empty range
+func normalizeRange(start int64, end int64, total int64) (int64, int64, bool) {
+	if start < 0 {
+		start = max(total+start, 0)
+	}
+	if end < 0 {
+		end = max(total+end, 0)
+	}
+	end = min(end, total-1)
+
+	if start > end || total == 0 {
+		return 0, 0, false
+	}
+
+	return start, end, true
+}
+
+// parses the optional "start end [BYTE|BIT]" arguments of BITCOUNT and BITPOS into a bit range
+func parseBitRange(args []Value, length int, endRequired bool) (int64, int64, bool, bool, string) {
+	totalBits := int64(length) * 8
+	if len(args) == 0 {
+		return 0, totalBits - 1, totalBits > 0, false, ""
+	}
+
+	if len(args) > 3 || (endRequired && len(args) < 2) {
+		return 0, 0, false, false, "ERR syntax error"
+	}
+
+	start, err := strconv.ParseInt(args[0].bulk, 10, 64)
+	if err != nil {
+		return 0, 0, false, false, "ERR value is not an integer or out of range"
+	}
+
+	endGiven := len(args) >= 2
+	end := int64(math.MaxInt64)
+	if endGiven {
+		end, err = strconv.ParseInt(args[1].bulk, 10, 64)
+		if err != nil {
+			return 0, 0, false, false, "ERR value is not an integer or out of range"
+		}
+	}
+
+	isBit := false
+	if len(args) == 3 {
+		switch strings.ToUpper(args[2].bulk) {
+		case "BIT":
+			isBit = true
+		case "BYTE":
+		default:
+			return 0, 0, false, false, "ERR syntax error"
+		}
+	}
+
+	if isBit {
+		if !endGiven {
+			end = totalBits - 1
+		}
+		start, end, ok := normalizeRange(start, end, totalBits)
+		return start, end, ok, endGiven, ""
+	}
+
+	if !endGiven {
+		end = int64(length) - 1
+	}
+	start, end, ok := normalizeRange(start, end, int64(length))
+
+	return start * 8, end*8 + 7, ok, endGiven, ""
+}
+
+// BITCOUNT command: number of set bits, optionally within a byte or bit range
+func bitcount(args []Value) Value {
+	key := args[0].bulk
+
+	SETsMu.RLock()
+	buf, _ := bitmapBytes(key)
+	SETsMu.RUnlock()
+
+	start, end, ok, _, errMsg := parseBitRange(args[1:], len(buf), true)
+	if errMsg != "" {
+		return Value{typ: "error", str: errMsg}
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: BITCOUNT %s", key))
+
+	if !ok {
+		return Value{typ: "integer", num: 0}
+	}
+
+	count := 0
+	for bit := uint64(start); bit <= uint64(end); {
+		// count whole bytes at once when the range covers them
+		if bit%8 == 0 && bit+7 <= uint64(end) {
+			count += bits.OnesCount8(buf[bit/8])
+			bit += 8
+			continue
+		}
+
+		count += getBit(buf, bit)
+		bit++
+	}
+
+	return Value{typ: "integer", num: count}
+}
+
+// BITPOS command: position of the first bit set to 0 or 1
+func bitpos(args []Value) Value {
+	key := args[0].bulk
+
+	if args[1].bulk != "0" && args[1].bulk != "1" {
+		return Value{typ: "error", str: "ERR The bit argument must be 1 or 0."}
+	}
+	bit := int(args[1].bulk[0] - '0')
+
+	SETsMu.RLock()
+	buf, exists := bitmapBytes(key)
+	SETsMu.RUnlock()
+
+	start, end, ok, endGiven, errMsg := parseBitRange(args[2:], len(buf), false)
+	if errMsg != "" {
+		return Value{typ: "error", str: errMsg}
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: BITPOS %s %d", key, bit))
+
+	// a missing key is an endless run of zero bits
+	if !exists {
+		if bit == 1 {
+			return Value{typ: "integer", num: -1}
+		}
+		return Value{typ: "integer", num: 0}
+	}
+
+	if !ok {
+		return Value{typ: "integer", num: -1}
+	}
+
+	// bytes that cannot contain the wanted bit are skipped whole
+	skip := byte(0x00)
+	if bit == 0 {
+		skip = 0xff
+	}
+
+	for pos := uint64(start); pos <= uint64(end); {
+		if pos%8 == 0 && pos+7 <= uint64(end) && buf[pos/8] == skip {
+			pos += 8
+			continue
+		}
+
+		if getBit(buf, pos) == bit {
+			return Value{typ: "integer", num: int(pos)}
+		}
+		pos++
+	}
+
+	// without an explicit end the string is considered padded with zeros on the right
+	if bit == 0 && !endGiven {
+		return Value{typ: "integer", num: int(end + 1)}
+	}
+
+	return Value{typ: "integer", num: -1}
+}
+
+// BITOP command: AND, OR, XOR or NOT of string keys stored in destkey
+func bitop(args []Value) Value {
+	op := strings.ToUpper(args[0].bulk)
+	dest := args[1].bulk
+	sources := args[2:]
+
+	switch op {
+	case "AND", "OR", "XOR":
+	case "NOT":
+		if len(sources) != 1 {
+			return Value{typ: "error", str: "ERR BITOP NOT must be called with a single source key."}
+		}
+	default:
+		return Value{typ: "error", str: "ERR syntax error"}
+	}
+
+	// the destination may hold a value of any type
+	SETsMu.Lock()
+	HSETsMu.Lock()
+	ZSETsMu.Lock()
+	STREAMsMu.Lock()
+	defer SETsMu.Unlock()
+	defer HSETsMu.Unlock()
+	defer ZSETsMu.Unlock()
+	defer STREAMsMu.Unlock()
+
+	// missing keys are zero bytes, shorter strings are zero padded
+	inputs := make([][]byte, 0, len(sources))
+	length := 0
+	for _, source := range sources {
+		buf, _ := bitmapBytes(source.bulk)
+		inputs = append(inputs, buf)
+		length = max(length, len(buf))
+	}
+
+	result := make([]byte, length)
+	for i := 0; i < length; i++ {
+		var acc byte
+		for n, input := range inputs {
+			var b byte
+			if i < len(input) {
+				b = input[i]
+			}
+
+			switch {
+			case n == 0:
+				acc = b
+			case op == "AND":
+				acc &= b
+			case op == "OR":
+				acc |= b
+			case op == "XOR":
+				acc ^= b
+			}
+		}
+
+		if op == "NOT" {
+			acc = ^acc
+		}
+		result[i] = acc
+	}
+
+	// an empty result removes the destination
+	if databases[selectedDB].remove(dest) && length == 0 {
+		notifyKeyspaceEvent(notifyGeneric, "del", dest)
+	}
+	if length > 0 {
+		SETs[dest] = SetValStruct{value: string(result), encoding: encodingRaw}
+		keyspaceAdd(dest, keyString)
+		notifyKeyspaceEvent(notifyString, "set", dest)
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: BITOP %s %s", op, dest))
+
+	return Value{typ: "integer", num: length}
+}
+
+// integer field of a BITFIELD operation
+type bitfieldType struct {
+	signed bool
+	bits   int
+}
+
+// one GET, SET or INCRBY operation of a BITFIELD command
+type bitfieldOp struct {
+	op       string
+	typ      bitfieldType
+	offset   uint64
+	value    int64
+	overflow string
+}
+
+// parses i1..i64 and u1..u63
+func parseBitfieldType(arg string) (bitfieldType, bool) {
+	if len(arg) < 2 || (arg[0] != 'i' && arg[0] != 'u' && arg[0] != 'I' && arg[0] != 'U') {
+		return bitfieldType{}, false
+	}
+
+	n, err := strconv.Atoi(arg[1:])
+	signed := arg[0] == 'i' || arg[0] == 'I'
+	if err != nil || n < 1 || (signed && n > 64) || (!signed && n > 63) {
+		return bitfieldType{}, false
+	}
+
+	return bitfieldType{signed: signed, bits: n}, true
+}
+
+// parses a bit offset, "#n" means the n-th field of the given width
+func parseBitfieldOffset(arg string, width int) (uint64, bool) {
+	multiply := strings.HasPrefix(arg, "#")
+	if multiply {
+		arg = arg[1:]
+	}
+
+	offset, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || offset < 0 {
+		return 0, false
+	}
+
+	if multiply {
+		if offset > math.MaxInt64/int64(width) {
+			return 0, false
+		}
+		offset *= int64(width)
+	}
+
+	if offset+int64(width)-1 > maxBitOffset {
+		return 0, false
+	}
+
+	return uint64(offset), true
+}
+
+// reads an unsigned field of up to 64 bits
+func getUnsignedBitfield(buf []byte, offset uint64, width int) uint64 {
+	var value uint64
+	for i := 0; i < width; i++ {
+		value = value<<1 | uint64(getBit(buf, offset+uint64(i)))
+	}
+
+	return value
+}
+
+// reads a two's complement field of up to 64 bits
+func getSignedBitfield(buf []byte, offset uint64, width int) int64 {
+	value := getUnsignedBitfield(buf, offset, width)
+
+	// sign extend when the top bit of the field is set
+	if width < 64 && value&(1<<(width-1)) != 0 {
+		value |= ^uint64(0) << width
+	}
+
+	return int64(value)
+}
+
+// writes the low bits of value into a field, the buffer must be large enough
+func setBitfield(buf []byte, offset uint64, width int, value uint64) {
+	for i := 0; i < width; i++ {
+		bit := int(value>>(width-1-i)) & 1
+		setBit(buf, offset+uint64(i), bit)
+	}
+}
+
+// reads the field as an int64 according to its type
+func (t bitfieldType) get(buf []byte, offset uint64) int64 {
+	if t.signed {
+		return getSignedBitfield(buf, offset, t.bits)
+	}
+
+	return int64(getUnsignedBitfield(buf, offset, t.bits))
+}
+
+// computes value+incr for the field type, applying the overflow policy,
+// returns false when the policy is FAIL and the result does not fit
+func (t bitfieldType) apply(value int64, incr int64, overflow string) (int64, bool) {
+	var minValue, maxValue int64
+	if t.signed {
+		maxValue = int64(uint64(1)<<(t.bits-1) - 1)
+		minValue = -maxValue - 1
+	} else {
+		maxValue = int64(uint64(1)<<t.bits - 1)
+	}
+
+	high := value > maxValue || (incr > 0 && value > maxValue-incr)
+	var low bool
+	if t.signed {
+		low = value < minValue || (incr < 0 && value < minValue-incr)
+	} else {
+		low = value < 0 || (incr < 0 && value+incr < 0)
+	}
+
+	if !high && !low {
+		return value + incr, true
+	}
+
+	switch overflow {
+	case "SAT":
+		if high {
+			return maxValue, true
+		}
+		return minValue, true
+	case "FAIL":
+		return 0, false
+	}
+
+	// WRAP keeps the low bits of the two's complement sum
+	sum := uint64(value) + uint64(incr)
+	if t.bits == 64 {
+		return int64(sum), true
+	}
+
+	mask := uint64(1)<<t.bits - 1
+	sum &= mask
+	if t.signed && sum&(1<<(t.bits-1)) != 0 {
+		sum |= ^mask
+	}
+
+	return int64(sum), true
+}
+
+// BITFIELD command: arbitrary width integer fields inside a string
+func bitfield(args []Value) Value {
+	return bitfieldGeneric("bitfield", args, false)
+}
+
+// BITFIELD_RO command: read only variant that accepts GET only
+func bitfieldRo(args []Value) Value {
+	return bitfieldGeneric("bitfield_ro", args, true)
+}
+
+// shared implementation of BITFIELD and BITFIELD_RO
+func bitfieldGeneric(name string, args []Value, readOnly bool) Value {
+	key := args[0].bulk
+	overflow := "WRAP"
+	ops := []bitfieldOp{}
+	writes := false
+
+	for i := 1; i < len(args); {
+		op := strings.ToUpper(args[i].bulk)
+		remaining := len(args) - i - 1
+
+		if op == "OVERFLOW" {
+			if remaining < 1 {
+				return Value{typ: "error", str: "ERR syntax error"}
+			}
+			overflow = strings.ToUpper(args[i+1].bulk)
+			if overflow != "WRAP" && overflow != "SAT" && overflow != "FAIL" {
+				return Value{typ: "error", str: "ERR Invalid OVERFLOW type specified"}
+			}
+			i += 2
+			continue
+		}
+
+		if (op != "GET" || remaining < 2) && ((op != "SET" && op != "INCRBY") || remaining < 3) {
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+
+		if readOnly && op != "GET" {
+			return Value{typ: "error", str: "ERR BITFIELD_RO only supports the GET subcommand"}
+		}
+
+		typ, ok := parseBitfieldType(args[i+1].bulk)
+		if !ok {
+			return Value{typ: "error", str: "ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is."}
+		}
+
+		offset, ok := parseBitfieldOffset(args[i+2].bulk, typ.bits)
+		if !ok {
+			return Value{typ: "error", str: "ERR bit offset is not an integer or out of range"}
+		}
+
+		parsed := bitfieldOp{op: op, typ: typ, offset: offset, overflow: overflow}
+		if op == "GET" {
+			i += 3
+		} else {
+			value, err := strconv.ParseInt(args[i+3].bulk, 10, 64)
+			if err != nil {
+				return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+			}
+			parsed.value = value
+			writes = true
+			i += 4
+		}
+
+		ops = append(ops, parsed)
+	}
+
+	if writes {
+		SETsMu.Lock()
+		defer SETsMu.Unlock()
+	} else {
+		SETsMu.RLock()
+		defer SETsMu.RUnlock()
+	}
+
+	buf, _ := bitmapBytes(key)
+	changed := false
+	results := make([]Value, 0, len(ops))
+
+	for _, op := range ops {
+		switch op.op {
+		case "GET":
+			results = append(results, Value{typ: "integer", num: int(op.typ.get(buf, op.offset))})
+
+		case "SET":
+			buf = bitmapGrow(buf, op.offset+uint64(op.typ.bits)-1)
+			previous := op.typ.get(buf, op.offset)
+
+			value, ok := op.typ.apply(op.value, 0, op.overflow)
+			if !ok {
+				results = append(results, Value{typ: "null"})
+				continue
+			}
+
+			setBitfield(buf, op.offset, op.typ.bits, uint64(value))
+			changed = true
+			results = append(results, Value{typ: "integer", num: int(previous)})
+
+		case "INCRBY":
+			buf = bitmapGrow(buf, op.offset+uint64(op.typ.bits)-1)
+			current := op.typ.get(buf, op.offset)
+
+			value, ok := op.typ.apply(current, op.value, op.overflow)
+			if !ok {
+				results = append(results, Value{typ: "null"})
+				continue
+			}
+
+			setBitfield(buf, op.offset, op.typ.bits, uint64(value))
+			changed = true
+			results = append(results, Value{typ: "integer", num: int(value)})
+		}
+	}
+
+	if changed {
+		bitmapStore(key, buf)
+		notifyKeyspaceEvent(notifyString, "setbit", key)
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: %s %s (%d operations)", strings.ToUpper(name), key, len(ops)))
+
+	return Value{typ: "array", array: results}
+}
@@ -0,0 +1,208 @@
+// client connections: replies are queued and written by a goroutine per client,
+// so that messages pushed by other clients never wait for a slow reader
+package blueberrydb
+
+import (
+	"blueberrydb/internal/logger"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// output buffer limits of a client class, 0 disables a limit
+type OutputLimits struct {
+	Hard        int // bytes, disconnects right away
+	Soft        int // bytes, disconnects when exceeded for SoftSeconds
+	SoftSeconds int
+}
+
+// commands that need the connection they run on, they queue their replies themselves
+var ClientHandlers = map[string]func(*Client, []Value){
+	"PING": pingClient,
+
+	// pub/sub
+	"SUBSCRIBE":    subscribe,
+	"UNSUBSCRIBE":  unsubscribe,
+	"PSUBSCRIBE":   psubscribe,
+	"PUNSUBSCRIBE": punsubscribe,
+	"SSUBSCRIBE":   ssubscribe,
+	"SUNSUBSCRIBE": sunsubscribe,
+
+	// transactions
+	"MULTI":   multi,
+	"EXEC":    exec,
+	"DISCARD": discard,
+	"WATCH":   watch,
+	"UNWATCH": unwatch,
+
+	// databases
+	"SELECT": selectCommand,
+}
+
+// limits for clients with subscriptions, same defaults as redis
+var pubsubLimits = OutputLimits{Hard: 32 << 20, Soft: 8 << 20, SoftSeconds: 60}
+
+func SetPubsubLimits(limits OutputLimits) {
+	pubsubLimits = limits
+}
+
+// ids handed out to connections, starting at 1
+var nextClientID atomic.Int64
+
+// open connections and the bytes of replies queued on all of them
+var connectedClients atomic.Int64
+var clientsOutputMemory atomic.Int64
+
+type Client struct {
+	id   int64
+	conn net.Conn
+
+	mu          sync.Mutex
+	pending     [][]byte // marshalled replies not written yet
+	pendingSize int
+	softSince   time.Time // when the soft limit started being exceeded
+	wake        chan struct{}
+	closed      bool
+
+	// subscriptions, guarded by pubsubMu
+	channels      map[string]bool
+	patterns      map[string]bool
+	shardChannels map[string]bool
+
+	// transaction state, only used by the connection's own goroutine
+	multi bool
+	dirty bool // a command failed to queue, EXEC aborts
+	queue []Value
+
+	// the database commands run against, only used by the connection's own goroutine
+	db int
+
+	// watched keys and whether one of them changed, guarded by watchMu
+	watched    map[watchedKey]bool
+	watchDirty bool
+}
+
+func NewClient(conn net.Conn) *Client {
+	c := &Client{
+		id:            nextClientID.Add(1),
+		conn:          conn,
+		wake:          make(chan struct{}, 1),
+		channels:      map[string]bool{},
+		patterns:      map[string]bool{},
+		shardChannels: map[string]bool{},
+		watched:       map[watchedKey]bool{},
+	}
+
+	connectedClients.Add(1)
+	go c.writeLoop()
+
+	return c
+}
+
+// writes queued replies until the client is closed and its queue drained
+func (c *Client) writeLoop() {
+	for {
+		_, open := <-c.wake
+
+		c.mu.Lock()
+		batch := c.pending
+		clientsOutputMemory.Add(-int64(c.pendingSize))
+		c.pending = nil
+		c.pendingSize = 0
+		c.mu.Unlock()
+
+		for _, reply := range batch {
+			if _, err := c.conn.Write(reply); err != nil {
+				c.kill()
+				return
+			}
+		}
+
+		if !open {
+			c.conn.Close()
+			return
+		}
+	}
+}
+
+// queues a reply, reports false once the client is closed
+func (c *Client) enqueue(reply []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return false
+	}
+
+	c.pending = append(c.pending, reply)
+	c.pendingSize += len(reply)
+	clientsOutputMemory.Add(int64(len(reply)))
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+
+	return true
+}
+
+// queues a reply to a command of this client
+func (c *Client) Write(v Value) {
+	c.enqueue(v.Marshal())
+}
+
+// queues a message pushed by another client, disconnecting
+// subscribers that do not keep up within the output buffer limits
+func (c *Client) push(message []byte) {
+	if !c.enqueue(message) {
+		return
+	}
+
+	c.mu.Lock()
+	size := c.pendingSize
+	overSoft := pubsubLimits.Soft > 0 && size > pubsubLimits.Soft
+	if !overSoft {
+		c.softSince = time.Time{}
+	} else if c.softSince.IsZero() {
+		c.softSince = time.Now()
+	}
+	softExpired := overSoft && time.Since(c.softSince) >= time.Duration(pubsubLimits.SoftSeconds)*time.Second
+	c.mu.Unlock()
+
+	if (pubsubLimits.Hard > 0 && size > pubsubLimits.Hard) || softExpired {
+		logger.Info(fmt.Sprintf("closing subscriber %s over output buffer limits (%d bytes pending)", c.conn.RemoteAddr(), size))
+		c.kill()
+	}
+}
+
+// closes the connection right away, dropping queued replies
+func (c *Client) kill() {
+	c.mu.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.wake)
+	}
+	clientsOutputMemory.Add(-int64(c.pendingSize))
+	c.pending = nil
+	c.pendingSize = 0
+	c.mu.Unlock()
+
+	c.conn.Close()
+}
+
+// flushes queued replies, closes the connection and drops the subscriptions
+func (c *Client) Close() {
+	pubsubUnsubscribeAll(c)
+	c.unwatchAll()
+
+	c.mu.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.wake)
+	}
+	c.mu.Unlock()
+
+	connectedClients.Add(-1)
+}
@@ -0,0 +1,208 @@
+// extension api: Go packages linked into the server register their own commands
+package blueberrydb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// an entry of the command table, extensions add theirs with RegisterCommand
+type Command struct {
+	Name string
+
+	// number of arguments counting the command name, -N means at least N
+	Arity int
+
+	// "write" commands are persisted in the aof as sent and replayed through the
+	// handler, so they have to be deterministic given the dataset and their
+	// arguments, "readonly" ones can not call commands that modify the dataset,
+	// "admin" marks commands meant for operators only
+	Flags []string
+
+	// positions of the keys among the arguments, the command name being 0,
+	// LastKey -1 is the last argument, FirstKey 0 when the command takes no keys
+	FirstKey int
+	LastKey  int
+	KeyStep  int
+
+	// shown by COMMAND DOCS, Module also for COMMAND LIST FILTERBY MODULE
+	Summary string
+	Group   string
+	Module  string
+
+	Handler func(ctx *CommandContext) Value
+
+	// positions of the keys for commands whose keys move with their arguments
+	keys func(argv []Value) ([]int, bool)
+}
+
+func (cmd *Command) hasFlag(flag string) bool {
+	for _, f := range cmd.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// flags a command may declare, COMMAND reports them
+var commandFlags = map[string]bool{
+	"write":    true,
+	"readonly": true,
+	"denyoom":  true,
+	"admin":    true,
+	"pubsub":   true,
+	"noscript": true,
+	"blocking": true,
+	"loading":  true,
+	"stale":    true,
+	"fast":     true,
+	"no-auth":  true,
+}
+
+// commands registered by extensions, by upper case name, only changed before the server starts
+var registeredCommands = map[string]*Command{}
+
+// what a registered command runs with
+type CommandContext struct {
+	command *Command
+	client  *Client
+	args    []Value
+}
+
+// the connection a command runs on
+type ClientInfo struct {
+	ID   int64
+	Addr string
+}
+
+// adds a command to the server, call it from an init function of the package
+// that implements the command so it is in place before the aof is replayed
+func RegisterCommand(cmd Command) error {
+	name := strings.ToUpper(cmd.Name)
+	if name == "" || strings.ContainsAny(name, " \r\n") {
+		return fmt.Errorf("invalid command name '%s'", cmd.Name)
+	}
+	if _, ok := lookupCommand(name); ok {
+		return fmt.Errorf("command '%s' already exists", cmd.Name)
+	}
+	if cmd.Handler == nil {
+		return errors.New("a command needs a handler")
+	}
+	if cmd.Arity == 0 {
+		return errors.New("arity can not be 0, the command name counts as an argument")
+	}
+
+	for _, flag := range cmd.Flags {
+		if !commandFlags[flag] {
+			return fmt.Errorf("unknown command flag '%s'", flag)
+		}
+	}
+	if cmd.hasFlag("write") && cmd.hasFlag("readonly") {
+		return errors.New("a command can not be both write and readonly")
+	}
+
+	if cmd.FirstKey < 0 || cmd.FirstKey == 0 && (cmd.LastKey != 0 || cmd.KeyStep != 0) {
+		return errors.New("invalid key positions")
+	}
+	if cmd.FirstKey > 0 && (cmd.KeyStep <= 0 || cmd.LastKey < -1 || cmd.LastKey > 0 && cmd.LastKey < cmd.FirstKey) {
+		return errors.New("invalid key positions")
+	}
+
+	cmd.Name = name
+	if cmd.Group == "" {
+		cmd.Group = "module"
+	}
+	registeredCommands[name] = &cmd
+	commandTable[name] = &cmd
+
+	// replaying the aof has no connection
+	Handlers[name] = func(args []Value) Value {
+		return registeredCommands[name].call(nil, args)
+	}
+
+	// writes are persisted as sent and run alone, nothing else may write
+	// to the aof while the handler's own calls are kept out of it
+	if cmd.hasFlag("write") {
+		writeCommands[name] = true
+		exclusiveCommands[name] = true
+	}
+
+	return nil
+}
+
+// runs the handler, caller must hold commandsMu, the write lock for write commands
+func (cmd *Command) call(c *Client, args []Value) Value {
+	if cmd.hasFlag("write") {
+		persist, blocking := propagator, blockingDisabled
+		propagator, blockingDisabled = nil, true
+		defer func() {
+			propagator, blockingDisabled = persist, blocking
+		}()
+	}
+
+	return cmd.Handler(&CommandContext{command: cmd, client: c, args: args})
+}
+
+// the arguments after the command name
+func (ctx *CommandContext) Args() []string {
+	args := make([]string, 0, len(ctx.args))
+	for _, arg := range ctx.args {
+		args = append(args, arg.bulk)
+	}
+	return args
+}
+
+// the keys among the arguments, by the key positions of the command
+func (ctx *CommandContext) Keys() []string {
+	cmd := ctx.command
+	if cmd.FirstKey == 0 {
+		return nil
+	}
+
+	last := cmd.LastKey
+	if last < 0 || last > len(ctx.args) {
+		last = len(ctx.args)
+	}
+
+	keys := []string{}
+	for i := cmd.FirstKey; i <= last; i += cmd.KeyStep {
+		keys = append(keys, ctx.args[i-1].bulk)
+	}
+	return keys
+}
+
+// the connection the command runs on, false while the aof is replayed or when called from a script
+func (ctx *CommandContext) Client() (ClientInfo, bool) {
+	if ctx.client == nil {
+		return ClientInfo{}, false
+	}
+	return ClientInfo{ID: ctx.client.id, Addr: ctx.client.conn.RemoteAddr().String()}, true
+}
+
+// runs another command against the dataset and returns its reply,
+// readonly commands can only call commands that do not modify it
+func (ctx *CommandContext) Call(args ...string) Value {
+	if len(args) == 0 {
+		return Value{typ: "error", str: "ERR Call needs at least the command name"}
+	}
+
+	command := strings.ToUpper(args[0])
+	if _, ok := Handlers[command]; !ok {
+		return Value{typ: "error", str: fmt.Sprintf("ERR unknown command '%s'", args[0])}
+	}
+	if cmd, ok := lookupCommand(command); ok && cmd.hasFlag("noscript") {
+		return Value{typ: "error", str: fmt.Sprintf("ERR command '%s' can not be called from '%s'", strings.ToLower(command), strings.ToLower(ctx.command.Name))}
+	}
+	if !ctx.command.hasFlag("write") && modifiesDataset(command) {
+		return Value{typ: "error", str: "ERR Write commands are not allowed from read-only commands"}
+	}
+
+	values := make([]Value, 0, len(args))
+	for _, arg := range args {
+		values = append(values, Value{typ: "bulk", bulk: arg})
+	}
+
+	return execute(ctx.client, RewriteCommand(Value{typ: "array", array: values}))
+}
@@ -0,0 +1,501 @@
+// command table: arity, flags, key positions and docs of every command, served by COMMAND
+package blueberrydb
+
+import (
+	"blueberrydb/internal/logger"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var builtinCommands = []Command{
+	// connection
+	{Name: "AUTH", Arity: -2, Flags: []string{"noscript", "loading", "stale", "fast", "no-auth"}, Group: "connection", Summary: "Authenticates the connection."},
+	{Name: "QUIT", Arity: -1, Flags: []string{"noscript", "loading", "stale", "fast", "no-auth"}, Group: "connection", Summary: "Closes the connection."},
+	{Name: "PING", Arity: -1, Flags: []string{"fast", "stale"}, Group: "connection", Summary: "Returns the server's liveliness response."},
+	{Name: "SELECT", Arity: 2, Flags: []string{"noscript", "loading", "stale", "fast"}, Group: "connection", Summary: "Changes the selected database."},
+
+	// server
+	{Name: "COMMAND", Arity: -1, Flags: []string{"loading", "stale"}, Group: "server", Summary: "Returns detailed information about all commands."},
+	{Name: "CONFIG", Arity: -2, Flags: []string{"admin", "noscript", "loading", "stale"}, Group: "server", Summary: "Gets or sets configuration parameters."},
+	{Name: "INFO", Arity: -1, Flags: []string{"loading", "stale"}, Group: "server", Summary: "Returns information and statistics about the server."},
+	{Name: "MEMORY", Arity: -2, Flags: []string{"readonly"}, FirstKey: 2, LastKey: 2, KeyStep: 1, Group: "server", Summary: "Reports on the memory used by keys, clients and the server."},
+	{Name: "DBSIZE", Arity: 1, Flags: []string{"readonly", "fast"}, Group: "server", Summary: "Returns the number of keys in the database."},
+	{Name: "FLUSHDB", Arity: -1, Flags: []string{"write"}, Group: "server", Summary: "Removes all keys from the current database."},
+	{Name: "FLUSHALL", Arity: -1, Flags: []string{"write"}, Group: "server", Summary: "Removes all keys from all databases."},
+	{Name: "SWAPDB", Arity: 3, Flags: []string{"write", "fast"}, Group: "server", Summary: "Swaps two databases."},
+
+	// generic
+	{Name: "DEL", Arity: -2, Flags: []string{"write"}, FirstKey: 1, LastKey: -1, KeyStep: 1, Group: "generic", Summary: "Deletes one or more keys."},
+	{Name: "EXPIRE", Arity: 3, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "generic", Summary: "Sets the expiration time of a key in seconds."},
+	{Name: "KEYS", Arity: 2, Flags: []string{"readonly"}, Group: "generic", Summary: "Returns all key names that match a pattern."},
+	{Name: "SCAN", Arity: -2, Flags: []string{"readonly"}, Group: "generic", Summary: "Iterates over the key names in the database."},
+	{Name: "TYPE", Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "generic", Summary: "Determines the type of value stored at a key."},
+	{Name: "RENAME", Arity: 3, Flags: []string{"write"}, FirstKey: 1, LastKey: 2, KeyStep: 1, Group: "generic", Summary: "Renames a key and overwrites the destination."},
+	{Name: "RENAMENX", Arity: 3, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 2, KeyStep: 1, Group: "generic", Summary: "Renames a key only when the target key name doesn't exist."},
+	{Name: "COPY", Arity: -3, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 2, KeyStep: 1, Group: "generic", Summary: "Copies the value of a key to a new key."},
+	{Name: "RANDOMKEY", Arity: 1, Flags: []string{"readonly"}, Group: "generic", Summary: "Returns a random key name from the database."},
+	{Name: "TOUCH", Arity: -2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: -1, KeyStep: 1, Group: "generic", Summary: "Returns the number of existing keys out of those specified after updating the time they were last accessed."},
+	{Name: "OBJECT", Arity: -2, Flags: []string{"readonly"}, FirstKey: 2, LastKey: 2, KeyStep: 1, Group: "generic", Summary: "Returns information about the internals of a key."},
+	{Name: "DUMP", Arity: 2, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "generic", Summary: "Returns a serialized representation of the value stored at a key."},
+	{Name: "RESTORE", Arity: -4, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "generic", Summary: "Creates a key from the serialized representation of a value."},
+	{Name: "MIGRATE", Arity: -6, Flags: []string{"write"}, keys: migrateKeys, Group: "generic", Summary: "Atomically transfers a key from one server instance to another."},
+	{Name: "SORT", Arity: -2, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "generic", Summary: "Sorts the elements in a list, a set, or a sorted set."},
+	{Name: "SORT_RO", Arity: -2, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "generic", Summary: "Returns the sorted elements of a list, a set, or a sorted set."},
+	{Name: "MOVE", Arity: 3, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "generic", Summary: "Moves a key to another database."},
+
+	// strings
+	{Name: "SET", Arity: 3, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "string", Summary: "Sets the string value of a key."},
+	{Name: "GET", Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "string", Summary: "Returns the string value of a key."},
+	{Name: "INCR", Arity: 2, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "string", Summary: "Increments the integer value of a key by one."},
+	{Name: "DECR", Arity: 2, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "string", Summary: "Decrements the integer value of a key by one."},
+	{Name: "INCRBY", Arity: 3, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "string", Summary: "Increments the integer value of a key by a number."},
+	{Name: "DECRBY", Arity: 3, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "string", Summary: "Decrements the integer value of a key by a number."},
+	{Name: "INCRBYFLOAT", Arity: 3, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "string", Summary: "Increments the floating point value of a key by a number."},
+	{Name: "APPEND", Arity: 3, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "string", Summary: "Appends a string to the value of a key."},
+	{Name: "STRLEN", Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "string", Summary: "Returns the length of a string value."},
+	{Name: "GETRANGE", Arity: 4, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "string", Summary: "Returns a substring of the string stored at a key."},
+	{Name: "SETRANGE", Arity: 4, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "string", Summary: "Overwrites a part of a string value with another by an offset."},
+	{Name: "LCS", Arity: -3, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 2, KeyStep: 1, Group: "string", Summary: "Finds the longest common substring."},
+
+	// bitmaps
+	{Name: "SETBIT", Arity: 4, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "bitmap", Summary: "Sets or clears the bit at offset of the string value."},
+	{Name: "GETBIT", Arity: 3, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "bitmap", Summary: "Returns a bit value by offset."},
+	{Name: "BITCOUNT", Arity: -2, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "bitmap", Summary: "Counts the number of set bits in a string."},
+	{Name: "BITPOS", Arity: -3, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "bitmap", Summary: "Finds the first set or clear bit in a string."},
+	{Name: "BITOP", Arity: -4, Flags: []string{"write", "denyoom"}, FirstKey: 2, LastKey: -1, KeyStep: 1, Group: "bitmap", Summary: "Performs bitwise operations on multiple strings and stores the result."},
+	{Name: "BITFIELD", Arity: -2, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "bitmap", Summary: "Performs arbitrary bitfield integer operations on strings."},
+	{Name: "BITFIELD_RO", Arity: -2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "bitmap", Summary: "Performs arbitrary read-only bitfield integer operations on strings."},
+
+	// hyperloglog
+	{Name: "PFADD", Arity: -2, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "hyperloglog", Summary: "Adds elements to a HyperLogLog key."},
+	{Name: "PFCOUNT", Arity: -2, Flags: []string{"readonly"}, FirstKey: 1, LastKey: -1, KeyStep: 1, Group: "hyperloglog", Summary: "Returns the approximated cardinality of the sets observed by the HyperLogLog keys."},
+	{Name: "PFMERGE", Arity: -2, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: -1, KeyStep: 1, Group: "hyperloglog", Summary: "Merges one or more HyperLogLog values into a single key."},
+	{Name: "PFDEBUG", Arity: 3, Flags: []string{"write", "denyoom", "admin"}, FirstKey: 2, LastKey: 2, KeyStep: 1, Group: "hyperloglog", Summary: "Internal commands for debugging HyperLogLog values."},
+
+	// hashes
+	{Name: "HSET", Arity: -4, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "hash", Summary: "Creates or modifies the value of a field in a hash."},
+	{Name: "HMSET", Arity: -4, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "hash", Summary: "Sets the values of multiple fields."},
+	{Name: "HSETNX", Arity: 4, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "hash", Summary: "Sets the value of a field in a hash only when the field doesn't exist."},
+	{Name: "HGET", Arity: 3, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "hash", Summary: "Returns the value of a field in a hash."},
+	{Name: "HMGET", Arity: -3, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "hash", Summary: "Returns the values of all fields in a hash."},
+	{Name: "HDEL", Arity: -3, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "hash", Summary: "Deletes one or more fields and their values from a hash."},
+	{Name: "HGETALL", Arity: 2, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "hash", Summary: "Returns all fields and values in a hash."},
+	{Name: "HKEYS", Arity: 2, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "hash", Summary: "Returns all fields in a hash."},
+	{Name: "HVALS", Arity: 2, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "hash", Summary: "Returns all values in a hash."},
+	{Name: "HLEN", Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "hash", Summary: "Returns the number of fields in a hash."},
+	{Name: "HEXISTS", Arity: 3, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "hash", Summary: "Determines whether a field exists in a hash."},
+	{Name: "HSTRLEN", Arity: 3, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "hash", Summary: "Returns the length of the value of a field."},
+	{Name: "HINCRBY", Arity: 4, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "hash", Summary: "Increments the integer value of a field in a hash by a number."},
+	{Name: "HINCRBYFLOAT", Arity: 4, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "hash", Summary: "Increments the floating point value of a field by a number."},
+	{Name: "HRANDFIELD", Arity: -2, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "hash", Summary: "Returns one or more random fields from a hash."},
+	{Name: "HSCAN", Arity: -3, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "hash", Summary: "Iterates over fields and values of a hash."},
+	{Name: "HEXPIRE", Arity: -5, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "hash", Summary: "Sets the expiration time of hash fields in seconds."},
+	{Name: "HPEXPIRE", Arity: -5, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "hash", Summary: "Sets the expiration time of hash fields in milliseconds."},
+	{Name: "HEXPIREAT", Arity: -5, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "hash", Summary: "Sets the expiration time of hash fields to a unix timestamp in seconds."},
+	{Name: "HPEXPIREAT", Arity: -5, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "hash", Summary: "Sets the expiration time of hash fields to a unix timestamp in milliseconds."},
+	{Name: "HTTL", Arity: -4, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "hash", Summary: "Returns the time to live of hash fields in seconds."},
+	{Name: "HPTTL", Arity: -4, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "hash", Summary: "Returns the time to live of hash fields in milliseconds."},
+	{Name: "HPERSIST", Arity: -4, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "hash", Summary: "Removes the expiration time of hash fields."},
+
+	// sorted sets
+	{Name: "ZADD", Arity: -4, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "sorted-set", Summary: "Adds one or more members to a sorted set, or updates their scores."},
+	{Name: "ZREM", Arity: -3, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "sorted-set", Summary: "Removes one or more members from a sorted set."},
+	{Name: "ZSCORE", Arity: 3, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "sorted-set", Summary: "Returns the score of a member in a sorted set."},
+	{Name: "ZCARD", Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "sorted-set", Summary: "Returns the number of members in a sorted set."},
+	{Name: "ZRANGE", Arity: -4, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "sorted-set", Summary: "Returns members in a sorted set within a range of indexes."},
+	{Name: "ZSCAN", Arity: -3, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "sorted-set", Summary: "Iterates over members and scores of a sorted set."},
+
+	// sets
+	{Name: "SSCAN", Arity: -3, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "set", Summary: "Iterates over members of a set."},
+
+	// geo
+	{Name: "GEOADD", Arity: -5, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "geo", Summary: "Adds one or more members to a geospatial index."},
+	{Name: "GEOPOS", Arity: -2, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "geo", Summary: "Returns the longitude and latitude of members from a geospatial index."},
+	{Name: "GEODIST", Arity: -4, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "geo", Summary: "Returns the distance between two members of a geospatial index."},
+	{Name: "GEOHASH", Arity: -2, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "geo", Summary: "Returns members from a geospatial index as geohash strings."},
+	{Name: "GEOSEARCH", Arity: -6, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "geo", Summary: "Queries a geospatial index for members inside an area of a box or a circle."},
+	{Name: "GEOSEARCHSTORE", Arity: -7, Flags: []string{"write", "denyoom"}, FirstKey: 1, LastKey: 2, KeyStep: 1, Group: "geo", Summary: "Queries a geospatial index for members inside an area and stores the result."},
+
+	// streams
+	{Name: "XADD", Arity: -5, Flags: []string{"write", "denyoom", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "stream", Summary: "Appends a new message to a stream."},
+	{Name: "XRANGE", Arity: -4, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "stream", Summary: "Returns the messages from a stream within a range of IDs."},
+	{Name: "XREVRANGE", Arity: -4, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "stream", Summary: "Returns the messages from a stream within a range of IDs in reverse order."},
+	{Name: "XLEN", Arity: 2, Flags: []string{"readonly", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "stream", Summary: "Returns the number of messages in a stream."},
+	{Name: "XDEL", Arity: -3, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "stream", Summary: "Returns the number of messages after removing them from a stream."},
+	{Name: "XTRIM", Arity: -4, Flags: []string{"write"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "stream", Summary: "Deletes messages from the beginning of a stream."},
+	{Name: "XREAD", Arity: -4, Flags: []string{"readonly", "blocking"}, keys: streamsKeys, Group: "stream", Summary: "Returns messages from multiple streams with IDs greater than the ones requested."},
+	{Name: "XGROUP", Arity: -2, Flags: []string{"write", "denyoom"}, FirstKey: 2, LastKey: 2, KeyStep: 1, Group: "stream", Summary: "Creates, modifies and destroys consumer groups and consumers."},
+	{Name: "XREADGROUP", Arity: -7, Flags: []string{"write", "blocking"}, keys: streamsKeys, Group: "stream", Summary: "Returns new or historical messages from a stream for a consumer in a group."},
+	{Name: "XACK", Arity: -4, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "stream", Summary: "Returns the number of messages that were successfully acknowledged by the consumer group member of a stream."},
+	{Name: "XPENDING", Arity: -3, Flags: []string{"readonly"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "stream", Summary: "Returns the information and entries from a stream consumer group's pending entries list."},
+	{Name: "XCLAIM", Arity: -6, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "stream", Summary: "Changes, or acquires, ownership of a message in a consumer group."},
+	{Name: "XAUTOCLAIM", Arity: -6, Flags: []string{"write", "fast"}, FirstKey: 1, LastKey: 1, KeyStep: 1, Group: "stream", Summary: "Changes, or acquires, ownership of messages in a consumer group, as if the messages were delivered to a consumer group member."},
+	{Name: "XINFO", Arity: -3, Flags: []string{"readonly"}, FirstKey: 2, LastKey: 2, KeyStep: 1, Group: "stream", Summary: "Returns information about streams, consumer groups and consumers."},
+
+	// pub/sub
+	{Name: "PUBLISH", Arity: 3, Flags: []string{"pubsub", "loading", "stale", "fast"}, Group: "pubsub", Summary: "Posts a message to a channel."},
+	{Name: "SPUBLISH", Arity: 3, Flags: []string{"pubsub", "loading", "stale", "fast"}, Group: "pubsub", Summary: "Posts a message to a shard channel."},
+	{Name: "PUBSUB", Arity: -2, Flags: []string{"pubsub", "loading", "stale"}, Group: "pubsub", Summary: "Returns information about channels, patterns and shard channels."},
+	{Name: "SUBSCRIBE", Arity: -2, Flags: []string{"pubsub", "noscript", "loading", "stale"}, Group: "pubsub", Summary: "Listens for messages published to channels."},
+	{Name: "UNSUBSCRIBE", Arity: -1, Flags: []string{"pubsub", "noscript", "loading", "stale"}, Group: "pubsub", Summary: "Stops listening to messages posted to channels."},
+	{Name: "PSUBSCRIBE", Arity: -2, Flags: []string{"pubsub", "noscript", "loading", "stale"}, Group: "pubsub", Summary: "Listens for messages published to channels that match one or more patterns."},
+	{Name: "PUNSUBSCRIBE", Arity: -1, Flags: []string{"pubsub", "noscript", "loading", "stale"}, Group: "pubsub", Summary: "Stops listening to messages published to channels that match one or more patterns."},
+	{Name: "SSUBSCRIBE", Arity: -2, Flags: []string{"pubsub", "noscript", "loading", "stale"}, Group: "pubsub", Summary: "Listens for messages published to shard channels."},
+	{Name: "SUNSUBSCRIBE", Arity: -1, Flags: []string{"pubsub", "noscript", "loading", "stale"}, Group: "pubsub", Summary: "Stops listening to messages posted to shard channels."},
+
+	// transactions
+	{Name: "MULTI", Arity: 1, Flags: []string{"noscript", "loading", "stale", "fast"}, Group: "transactions", Summary: "Starts a transaction."},
+	{Name: "EXEC", Arity: 1, Flags: []string{"noscript", "loading", "stale"}, Group: "transactions", Summary: "Executes all commands in a transaction."},
+	{Name: "DISCARD", Arity: 1, Flags: []string{"noscript", "loading", "stale", "fast"}, Group: "transactions", Summary: "Discards a transaction."},
+	{Name: "WATCH", Arity: -2, Flags: []string{"noscript", "loading", "stale", "fast"}, FirstKey: 1, LastKey: -1, KeyStep: 1, Group: "transactions", Summary: "Monitors changes to keys to determine the execution of a transaction."},
+	{Name: "UNWATCH", Arity: 1, Flags: []string{"noscript", "loading", "stale", "fast"}, Group: "transactions", Summary: "Forgets about watched keys of a transaction."},
+
+	// scripting
+	{Name: "EVAL", Arity: -3, Flags: []string{"noscript", "stale"}, keys: scriptKeys, Group: "scripting", Summary: "Executes a server-side Lua script."},
+	{Name: "EVALSHA", Arity: -3, Flags: []string{"noscript", "stale"}, keys: scriptKeys, Group: "scripting", Summary: "Executes a server-side Lua script by SHA1 digest."},
+	{Name: "SCRIPT", Arity: -2, Flags: []string{"noscript"}, Group: "scripting", Summary: "Loads, checks for, flushes and kills Lua scripts."},
+	{Name: "FUNCTION", Arity: -2, Flags: []string{"noscript"}, Group: "scripting", Summary: "Loads, lists, dumps, restores, deletes and flushes function libraries."},
+	{Name: "FCALL", Arity: -3, Flags: []string{"noscript", "stale"}, keys: scriptKeys, Group: "scripting", Summary: "Invokes a function."},
+	{Name: "FCALL_RO", Arity: -3, Flags: []string{"readonly", "noscript", "stale"}, keys: scriptKeys, Group: "scripting", Summary: "Invokes a read-only function."},
+}
+
+// the command table by upper case name, built in commands and the ones extensions register
+var commandTable = map[string]*Command{}
+
+func init() {
+	for i := range builtinCommands {
+		commandTable[builtinCommands[i].Name] = &builtinCommands[i]
+	}
+}
+
+func lookupCommand(name string) (*Command, bool) {
+	cmd, ok := commandTable[strings.ToUpper(name)]
+	return cmd, ok
+}
+
+// keys of "numkeys key [key ...] arg [arg ...]" after the script or function name
+func scriptKeys(argv []Value) ([]int, bool) {
+	numkeys, err := strconv.Atoi(argv[2].bulk)
+	if err != nil || numkeys < 0 || numkeys > len(argv)-3 {
+		return nil, false
+	}
+
+	positions := make([]int, 0, numkeys)
+	for i := 3; i < 3+numkeys; i++ {
+		positions = append(positions, i)
+	}
+	return positions, true
+}
+
+// keys of "... STREAMS key [key ...] id [id ...]"
+func streamsKeys(argv []Value) ([]int, bool) {
+	for i := 1; i < len(argv); i++ {
+		if strings.ToUpper(argv[i].bulk) != "STREAMS" {
+			continue
+		}
+
+		rest := len(argv) - i - 1
+		if rest == 0 || rest%2 != 0 {
+			return nil, false
+		}
+
+		positions := make([]int, 0, rest/2)
+		for j := i + 1; j <= i+rest/2; j++ {
+			positions = append(positions, j)
+		}
+		return positions, true
+	}
+
+	return nil, false
+}
+
+// positions of the keys in a full call, the command name being 0
+func (cmd *Command) keyPositions(argv []Value) ([]int, bool) {
+	if cmd.keys != nil {
+		return cmd.keys(argv)
+	}
+	if cmd.FirstKey == 0 {
+		return nil, true
+	}
+
+	last := cmd.LastKey
+	if last < 0 || last >= len(argv) {
+		last = len(argv) - 1
+	}
+
+	positions := []int{}
+	for i := cmd.FirstKey; i <= last; i += cmd.KeyStep {
+		positions = append(positions, i)
+	}
+	return positions, true
+}
+
+func (cmd *Command) arityMatches(argc int) bool {
+	return cmd.Arity > 0 && argc == cmd.Arity || cmd.Arity < 0 && argc >= -cmd.Arity
+}
+
+// the error reply for a call of an unknown command or with the wrong number of arguments
+func CheckCommand(value Value) (Value, bool) {
+	if _, ok := lookupCommand(value.array[0].bulk); !ok {
+		return unknownCommandError(value), false
+	}
+	if reply, wrong := arityError(value); wrong {
+		return reply, false
+	}
+	return Value{}, true
+}
+
+// same as redis: the first arguments, quoted and cut short, on a single line
+func unknownCommandError(value Value) Value {
+	oneLine := strings.NewReplacer("\r", " ", "\n", " ")
+
+	args := ""
+	for _, arg := range value.array[1:] {
+		if len(args) >= 128 {
+			break
+		}
+		text := arg.bulk
+		if len(text) > 128 {
+			text = text[:128]
+		}
+		args += fmt.Sprintf("'%s' ", oneLine.Replace(text))
+	}
+
+	return Value{typ: "error", str: fmt.Sprintf("ERR unknown command '%s', with args beginning with: %s", oneLine.Replace(value.array[0].bulk), args)}
+}
+
+// the error for a call with a number of arguments the command does not take
+func arityError(value Value) (Value, bool) {
+	cmd, ok := lookupCommand(value.array[0].bulk)
+	if !ok || cmd.arityMatches(len(value.array)) {
+		return Value{}, false
+	}
+
+	return Value{typ: "error", str: fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd.Name))}, true
+}
+
+// categories follow from the flags and the group of a command
+var groupCategories = map[string]string{
+	"generic":      "@keyspace",
+	"string":       "@string",
+	"bitmap":       "@bitmap",
+	"hyperloglog":  "@hyperloglog",
+	"hash":         "@hash",
+	"set":          "@set",
+	"sorted-set":   "@sortedset",
+	"geo":          "@geo",
+	"stream":       "@stream",
+	"pubsub":       "@pubsub",
+	"transactions": "@transaction",
+	"scripting":    "@scripting",
+	"connection":   "@connection",
+}
+
+// acl categories of a command
+func (cmd *Command) categories() []string {
+	categories := []string{}
+	if cmd.hasFlag("write") {
+		categories = append(categories, "@write")
+	}
+	if cmd.hasFlag("readonly") {
+		categories = append(categories, "@read")
+	}
+	if cmd.hasFlag("admin") {
+		categories = append(categories, "@admin", "@dangerous")
+	}
+	if cmd.hasFlag("fast") {
+		categories = append(categories, "@fast")
+	} else {
+		categories = append(categories, "@slow")
+	}
+	if cmd.hasFlag("blocking") {
+		categories = append(categories, "@blocking")
+	}
+	if category, ok := groupCategories[cmd.Group]; ok {
+		categories = append(categories, category)
+	}
+	if cmd.hasFlag("pubsub") && cmd.Group != "pubsub" {
+		categories = append(categories, "@pubsub")
+	}
+	return categories
+}
+
+// one entry of COMMAND and COMMAND INFO
+func (cmd *Command) info() Value {
+	flags := []Value{}
+	for _, flag := range cmd.Flags {
+		flags = append(flags, Value{typ: "string", str: flag})
+	}
+	if cmd.keys != nil {
+		flags = append(flags, Value{typ: "string", str: "movablekeys"})
+	}
+
+	categories := []Value{}
+	for _, category := range cmd.categories() {
+		categories = append(categories, Value{typ: "string", str: category})
+	}
+
+	return Value{typ: "array", array: []Value{
+		{typ: "bulk", bulk: strings.ToLower(cmd.Name)},
+		{typ: "integer", num: cmd.Arity},
+		{typ: "array", array: flags},
+		{typ: "integer", num: cmd.FirstKey},
+		{typ: "integer", num: cmd.LastKey},
+		{typ: "integer", num: cmd.KeyStep},
+		{typ: "array", array: categories},
+		{typ: "array", array: []Value{}}, // tips
+		{typ: "array", array: []Value{}}, // key specifications
+		{typ: "array", array: []Value{}}, // subcommands
+	}}
+}
+
+// one entry of COMMAND DOCS
+func (cmd *Command) docs() Value {
+	docs := []Value{
+		{typ: "bulk", bulk: "summary"}, {typ: "bulk", bulk: cmd.Summary},
+		{typ: "bulk", bulk: "group"}, {typ: "bulk", bulk: cmd.Group},
+	}
+	if cmd.Module != "" {
+		docs = append(docs, Value{typ: "bulk", bulk: "module"}, Value{typ: "bulk", bulk: cmd.Module})
+	}
+	return Value{typ: "array", array: docs}
+}
+
+func sortedCommandNames() []string {
+	names := make([]string, 0, len(commandTable))
+	for name := range commandTable {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// COMMAND LIST [FILTERBY MODULE name | ACLCAT category | PATTERN pattern]
+func commandList(args []Value) Value {
+	filter := func(cmd *Command) bool { return true }
+
+	if len(args) > 0 {
+		if len(args) != 3 || strings.ToUpper(args[0].bulk) != "FILTERBY" {
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+
+		value := args[2].bulk
+		switch strings.ToUpper(args[1].bulk) {
+		case "MODULE":
+			filter = func(cmd *Command) bool { return cmd.Module == value }
+		case "ACLCAT":
+			filter = func(cmd *Command) bool {
+				for _, category := range cmd.categories() {
+					if strings.EqualFold(category, "@"+value) {
+						return true
+					}
+				}
+				return false
+			}
+		case "PATTERN":
+			filter = func(cmd *Command) bool { return globMatch(strings.ToLower(value), strings.ToLower(cmd.Name)) }
+		default:
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+	}
+
+	names := []Value{}
+	for _, name := range sortedCommandNames() {
+		if filter(commandTable[name]) {
+			names = append(names, Value{typ: "bulk", bulk: strings.ToLower(name)})
+		}
+	}
+	return Value{typ: "array", array: names}
+}
+
+// COMMAND GETKEYS command [arg ...]
+func commandGetKeys(argv []Value) Value {
+	cmd, ok := lookupCommand(argv[0].bulk)
+	if !ok {
+		return Value{typ: "error", str: "ERR Invalid command specified"}
+	}
+	if !cmd.arityMatches(len(argv)) {
+		return Value{typ: "error", str: "ERR Invalid number of arguments specified for command"}
+	}
+
+	positions, ok := cmd.keyPositions(argv)
+	if !ok {
+		return Value{typ: "error", str: "ERR Invalid arguments specified for command"}
+	}
+	if len(positions) == 0 {
+		return Value{typ: "error", str: "ERR The command has no key arguments"}
+	}
+
+	keys := make([]Value, 0, len(positions))
+	for _, i := range positions {
+		keys = append(keys, Value{typ: "bulk", bulk: argv[i].bulk})
+	}
+	return Value{typ: "array", array: keys}
+}
+
+// COMMAND command: COUNT, INFO, DOCS, LIST and GETKEYS, all commands without a subcommand
+func commandCommand(args []Value) Value {
+	if len(args) == 0 {
+		// debug
+		logger.Debug("command executed: COMMAND")
+
+		entries := []Value{}
+		for _, name := range sortedCommandNames() {
+			entries = append(entries, commandTable[name].info())
+		}
+		return Value{typ: "array", array: entries}
+	}
+
+	subcommand := strings.ToUpper(args[0].bulk)
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: COMMAND %s", subcommand))
+
+	switch {
+	case subcommand == "COUNT" && len(args) == 1:
+		return Value{typ: "integer", num: len(commandTable)}
+
+	case subcommand == "INFO":
+		if len(args) == 1 {
+			return commandCommand(nil)
+		}
+
+		entries := make([]Value, 0, len(args)-1)
+		for _, arg := range args[1:] {
+			if cmd, ok := lookupCommand(arg.bulk); ok {
+				entries = append(entries, cmd.info())
+			} else {
+				entries = append(entries, Value{typ: "null"})
+			}
+		}
+		return Value{typ: "array", array: entries}
+
+	case subcommand == "DOCS":
+		names := []string{}
+		for _, arg := range args[1:] {
+			names = append(names, arg.bulk)
+		}
+		if len(args) == 1 {
+			names = sortedCommandNames()
+		}
+
+		// unknown commands are left out
+		docs := []Value{}
+		for _, name := range names {
+			if cmd, ok := lookupCommand(name); ok {
+				docs = append(docs, Value{typ: "bulk", bulk: strings.ToLower(cmd.Name)}, cmd.docs())
+			}
+		}
+		return Value{typ: "array", array: docs}
+
+	case subcommand == "LIST":
+		return commandList(args[1:])
+
+	case subcommand == "GETKEYS" && len(args) >= 2:
+		return commandGetKeys(args[1:])
+	}
+
+	return Value{typ: "error", str: fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try COMMAND HELP.", args[0].bulk)}
+}
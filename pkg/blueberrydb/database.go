@@ -0,0 +1,348 @@
+// logical databases: SELECT, MOVE, SWAPDB, FLUSHDB, FLUSHALL and DBSIZE
+package blueberrydb
+
+import (
+	"blueberrydb/internal/logger"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// the data of one logical database
+type database struct {
+	strings         map[string]SetValStruct
+	volatileStrings map[string]struct{}
+	hashes          map[string]map[string]HashFieldStruct
+	volatileHashes  map[string]struct{}
+	zsets           map[string]*SortedSet
+	streams         map[string]*Stream
+	index           *keyIndex // every key of the maps above
+}
+
+func newDatabase() *database {
+	return &database{
+		strings:         map[string]SetValStruct{},
+		volatileStrings: map[string]struct{}{},
+		hashes:          map[string]map[string]HashFieldStruct{},
+		volatileHashes:  map[string]struct{}{},
+		zsets:           map[string]*SortedSet{},
+		streams:         map[string]*Stream{},
+		index:           newKeyIndex(),
+	}
+}
+
+// the databases by index, SETs, HSETs, ZSETs and STREAMs are the maps of the
+// selected one, which only changes under the commandsMu write lock
+var databases = newDatabases(16)
+var selectedDB = 0
+
+func newDatabases(n int) []*database {
+	dbs := make([]*database, n)
+	for i := range dbs {
+		dbs[i] = newDatabase()
+	}
+	return dbs
+}
+
+// sets the number of databases, call it before the aof is replayed
+func SetDatabases(n int) {
+	databases = newDatabases(n)
+	datasetMemory.Store(0)
+	selectDatabase(0)
+}
+
+// makes the commands that follow run against database index, used while the aof is replayed
+func SelectDatabase(index int) bool {
+	if index < 0 || index >= len(databases) {
+		return false
+	}
+
+	selectDatabase(index)
+	return true
+}
+
+// points the package level maps at a database, caller must hold the commandsMu write lock
+func selectDatabase(index int) {
+	db := databases[index]
+	SETs, volatileStrings = db.strings, db.volatileStrings
+	HSETs, volatileHashes = db.hashes, db.volatileHashes
+	ZSETs, STREAMs = db.zsets, db.streams
+	selectedDB = index
+}
+
+// takes commandsMu with database index selected, the write lock when exclusive,
+// giving up once a script holding it runs past the time limit
+func lockDatabase(index int, exclusive bool) bool {
+	for {
+		if !lockCommands(exclusive) {
+			return false
+		}
+		if exclusive {
+			selectDatabase(index)
+			return true
+		}
+		if selectedDB == index {
+			return true
+		}
+
+		// switching needs the write lock, the read lock is taken again once it is done
+		commandsMu.RUnlock()
+		if !lockCommands(true) {
+			return false
+		}
+		selectDatabase(index)
+		commandsMu.Unlock()
+	}
+}
+
+// takes the commandsMu read lock with database index selected, for blocking
+// commands that gave up the lock while waiting
+func rlockDatabase(index int) {
+	commandsMu.RLock()
+	for selectedDB != index {
+		commandsMu.RUnlock()
+		commandsMu.Lock()
+		selectDatabase(index)
+		commandsMu.Unlock()
+		commandsMu.RLock()
+	}
+}
+
+// the database the aof was last switched to with a SELECT record
+var aofDB = 0
+var aofDBMu = sync.Mutex{}
+
+// writes a SELECT record before a command of another database than the one
+// before it, caller must hold commandsMu
+func propagateSelect(persist func(Value)) {
+	aofDBMu.Lock()
+	defer aofDBMu.Unlock()
+
+	if aofDB == selectedDB {
+		return
+	}
+
+	aofDB = selectedDB
+	persist(Value{typ: "array", array: []Value{
+		{typ: "bulk", bulk: "SELECT"},
+		{typ: "bulk", bulk: strconv.Itoa(selectedDB)},
+	}})
+}
+
+// parses a database index argument
+func parseDatabaseIndex(arg string) (int, string) {
+	index, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, "ERR value is not an integer or out of range"
+	}
+	if index < 0 || index >= len(databases) {
+		return 0, "ERR DB index is out of range"
+	}
+	return index, ""
+}
+
+// the number of keys in a database, caller must hold commandsMu
+func (db *database) size() int {
+	return len(db.strings) + len(db.hashes) + len(db.zsets) + len(db.streams)
+}
+
+// the number of keys with an expiration, caller must hold commandsMu
+func (db *database) expires() int {
+	count := 0
+	for key := range db.volatileStrings {
+		if entry, ok := db.strings[key]; ok && entry.expiresAt > 0 {
+			count++
+		}
+	}
+	return count
+}
+
+// SELECT command: the database the following commands of the connection run against
+func selectCommand(c *Client, args []Value) {
+	index, errMsg := parseDatabaseIndex(args[0].bulk)
+	if errMsg != "" {
+		c.Write(Value{typ: "error", str: errMsg})
+		return
+	}
+
+	c.db = index
+	c.Write(Value{typ: "string", str: "OK"})
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: SELECT %d", index))
+}
+
+// SELECT queued in a transaction, the commands after it run against the new
+// database, caller must hold the commandsMu write lock
+func (c *Client) selectInTransaction(arg Value) Value {
+	index, errMsg := parseDatabaseIndex(arg.bulk)
+	if errMsg != "" {
+		return Value{typ: "error", str: errMsg}
+	}
+
+	c.db = index
+	selectDatabase(index)
+
+	return Value{typ: "string", str: "OK"}
+}
+
+// MOVE command: moves a key to another database unless it exists there, caller
+// must hold the commandsMu write lock
+func move(args []Value) Value {
+	key := args[0].bulk
+	target, errMsg := parseDatabaseIndex(args[1].bulk)
+	if errMsg != "" {
+		return Value{typ: "error", str: errMsg}
+	}
+	if target == selectedDB {
+		return Value{typ: "error", str: "ERR source and destination objects are the same"}
+	}
+
+	src, dst := databases[selectedDB], databases[target]
+
+	v, ok := src.lookup(key)
+	if !ok {
+		return Value{typ: "integer", num: 0}
+	}
+	if _, exists := dst.lookup(key); exists {
+		return Value{typ: "integer", num: 0}
+	}
+
+	meta := src.index.meta(key)
+	src.remove(key)
+	dst.remove(key)
+	dst.store(key, v)
+	dst.index.inherit(key, meta)
+
+	notifyKeyspaceEvent(notifyGeneric, "move_from", key)
+	notifyDatabaseEvent(target, notifyGeneric, "move_to", key)
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: MOVE %s %d", key, target))
+
+	return Value{typ: "integer", num: 1}
+}
+
+// SWAPDB command: exchanges the data of two databases, connections stay on
+// their index and see the other data, caller must hold the commandsMu write lock
+func swapdb(args []Value) Value {
+	first, err := strconv.Atoi(args[0].bulk)
+	if err != nil {
+		return Value{typ: "error", str: "ERR invalid first DB index"}
+	}
+	second, err := strconv.Atoi(args[1].bulk)
+	if err != nil {
+		return Value{typ: "error", str: "ERR invalid second DB index"}
+	}
+	if first < 0 || first >= len(databases) || second < 0 || second >= len(databases) {
+		return Value{typ: "error", str: "ERR DB index is out of range"}
+	}
+
+	databases[first], databases[second] = databases[second], databases[first]
+	selectDatabase(selectedDB)
+
+	// what watched keys and blocked reads of both databases refer to has changed
+	touchWatchedDatabases(first, second)
+	for _, db := range []*database{databases[first], databases[second]} {
+		for key := range db.streams {
+			signalStreamWaiters(key)
+		}
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: SWAPDB %d %d", first, second))
+
+	return Value{typ: "string", str: "OK"}
+}
+
+// parses the optional ASYNC or SYNC of FLUSHDB and FLUSHALL, the old maps are
+// left to the garbage collector either way so both behave the same
+func parseFlushMode(args []Value) string {
+	if len(args) == 0 {
+		return ""
+	}
+
+	mode := strings.ToUpper(args[0].bulk)
+	if len(args) > 1 || mode != "ASYNC" && mode != "SYNC" {
+		return "ERR syntax error"
+	}
+	return ""
+}
+
+// FLUSHDB command: removes every key of the selected database, caller must hold the commandsMu write lock
+func flushdb(args []Value) Value {
+	if errMsg := parseFlushMode(args); errMsg != "" {
+		return Value{typ: "error", str: errMsg}
+	}
+
+	datasetMemory.Add(-databases[selectedDB].index.memory)
+	databases[selectedDB] = newDatabase()
+	selectDatabase(selectedDB)
+	touchWatchedDatabases(selectedDB)
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: FLUSHDB (db %d)", selectedDB))
+
+	return Value{typ: "string", str: "OK"}
+}
+
+// FLUSHALL command: removes every key of every database, caller must hold the commandsMu write lock
+func flushall(args []Value) Value {
+	if errMsg := parseFlushMode(args); errMsg != "" {
+		return Value{typ: "error", str: errMsg}
+	}
+
+	indexes := make([]int, len(databases))
+	for i := range databases {
+		datasetMemory.Add(-databases[i].index.memory)
+		databases[i] = newDatabase()
+		indexes[i] = i
+	}
+	selectDatabase(selectedDB)
+	touchWatchedDatabases(indexes...)
+
+	// debug
+	logger.Debug("command executed: FLUSHALL")
+
+	return Value{typ: "string", str: "OK"}
+}
+
+// DBSIZE command: the number of keys in the selected database
+func dbsize(args []Value) Value {
+	SETsMu.RLock()
+	HSETsMu.RLock()
+	ZSETsMu.RLock()
+	STREAMsMu.RLock()
+	size := databases[selectedDB].size()
+	STREAMsMu.RUnlock()
+	ZSETsMu.RUnlock()
+	HSETsMu.RUnlock()
+	SETsMu.RUnlock()
+
+	// debug
+	logger.Debug("command executed: DBSIZE")
+
+	return Value{typ: "integer", num: size}
+}
+
+// the keyspace section of INFO, databases without keys are left out
+func keyspaceInfo() string {
+	SETsMu.RLock()
+	HSETsMu.RLock()
+	ZSETsMu.RLock()
+	STREAMsMu.RLock()
+	defer SETsMu.RUnlock()
+	defer HSETsMu.RUnlock()
+	defer ZSETsMu.RUnlock()
+	defer STREAMsMu.RUnlock()
+
+	var info strings.Builder
+	info.WriteString("# Keyspace\n")
+	for i, db := range databases {
+		if size := db.size(); size > 0 {
+			info.WriteString(fmt.Sprintf("db%d:keys=%d,expires=%d,avg_ttl=0\n", i, size, db.expires()))
+		}
+	}
+	return info.String()
+}
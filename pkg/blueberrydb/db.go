@@ -2,6 +2,7 @@ package blueberrydb
 
 import (
 	"blueberrydb/internal/logger"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -10,15 +11,243 @@ import (
 )
 
 var Handlers = map[string]func([]Value) Value{
-	"PING":   ping,
-	"SET":    set,
-	"DEL":    deleteKeys,
-	"GET":    get,
-	"HSET":   hset,
-	"HGET":   hget,
-	"CONFIG": config,
-	"INFO":   info,
-	"EXPIRE": expire,
+	"PING":    ping,
+	"COMMAND": commandCommand,
+	"SET":     set,
+	"DEL":     deleteKeys,
+	"GET":     get,
+	"CONFIG":  config,
+	"INFO":    info,
+	"MEMORY":  memory,
+	"EXPIRE":  expire,
+
+	// keyspace iteration
+	"KEYS": keys,
+	"SCAN": scan,
+
+	// generic key commands
+	"TYPE":      typeCommand,
+	"RENAME":    rename,
+	"RENAMENX":  renamenx,
+	"COPY":      copyCommand,
+	"RANDOMKEY": randomkey,
+	"TOUCH":     touch,
+	"OBJECT":    object,
+	"DUMP":      dump,
+	"RESTORE":   restore,
+	"MIGRATE":   migrate,
+	"SORT":      sortCommand,
+	"SORT_RO":   sortRO,
+
+	// databases, SELECT is in ClientHandlers
+	"MOVE":     move,
+	"SWAPDB":   swapdb,
+	"FLUSHDB":  flushdb,
+	"FLUSHALL": flushall,
+	"DBSIZE":   dbsize,
+
+	// strings
+	"INCR":        incr,
+	"DECR":        decr,
+	"INCRBY":      incrby,
+	"DECRBY":      decrby,
+	"INCRBYFLOAT": incrbyfloat,
+	"APPEND":      appendCommand,
+	"STRLEN":      strlen,
+	"GETRANGE":    getrange,
+	"SETRANGE":    setrange,
+	"LCS":         lcs,
+
+	// bitmaps
+	"SETBIT":      setbit,
+	"GETBIT":      getbit,
+	"BITCOUNT":    bitcount,
+	"BITPOS":      bitpos,
+	"BITOP":       bitop,
+	"BITFIELD":    bitfield,
+	"BITFIELD_RO": bitfieldRo,
+
+	// hyperloglog
+	"PFADD":   pfadd,
+	"PFCOUNT": pfcount,
+	"PFMERGE": pfmerge,
+	"PFDEBUG": pfdebug,
+
+	// hashes
+	"HSET":         hset,
+	"HMSET":        hmset,
+	"HSETNX":       hsetnx,
+	"HGET":         hget,
+	"HMGET":        hmget,
+	"HDEL":         hdel,
+	"HGETALL":      hgetall,
+	"HKEYS":        hkeys,
+	"HVALS":        hvals,
+	"HLEN":         hlen,
+	"HEXISTS":      hexists,
+	"HSTRLEN":      hstrlen,
+	"HINCRBY":      hincrby,
+	"HINCRBYFLOAT": hincrbyfloat,
+	"HRANDFIELD":   hrandfield,
+	"HEXPIRE":      hexpire,
+	"HPEXPIRE":     hpexpire,
+	"HEXPIREAT":    hexpireat,
+	"HPEXPIREAT":   hpexpireat,
+	"HTTL":         httl,
+	"HPTTL":        hpttl,
+	"HPERSIST":     hpersist,
+	"HSCAN":        hscan,
+
+	// sorted sets
+	"ZADD":   zadd,
+	"ZREM":   zrem,
+	"ZSCORE": zscore,
+	"ZCARD":  zcard,
+	"ZRANGE": zrange,
+	"ZSCAN":  zscan,
+
+	// sets, only SSCAN for clients that iterate every type
+	"SSCAN": sscan,
+
+	// geo
+	"GEOADD":         geoadd,
+	"GEOPOS":         geopos,
+	"GEODIST":        geodist,
+	"GEOHASH":        geohashCommand,
+	"GEOSEARCH":      geosearch,
+	"GEOSEARCHSTORE": geosearchstore,
+
+	// streams
+	"XADD":       xadd,
+	"XRANGE":     xrange,
+	"XREVRANGE":  xrevrange,
+	"XLEN":       xlen,
+	"XDEL":       xdel,
+	"XTRIM":      xtrim,
+	"XREAD":      xread,
+	"XGROUP":     xgroup,
+	"XREADGROUP": xreadgroup,
+	"XACK":       xack,
+	"XPENDING":   xpending,
+	"XCLAIM":     xclaim,
+	"XAUTOCLAIM": xautoclaim,
+	"XINFO":      xinfo,
+
+	// pub/sub, subscriptions are in ClientHandlers
+	"PUBLISH":  publish,
+	"SPUBLISH": spublish,
+	"PUBSUB":   pubsub,
+}
+
+// commands that modify the dataset and have to be written to the aof,
+// stream commands are missing as they write their effects with propagate
+var writeCommands = map[string]bool{
+	"SET":            true,
+	"DEL":            true,
+	"MOVE":           true,
+	"RENAME":         true,
+	"RENAMENX":       true,
+	"COPY":           true,
+	"RESTORE":        true,
+	"SWAPDB":         true,
+	"FLUSHDB":        true,
+	"FLUSHALL":       true,
+	"INCR":           true,
+	"DECR":           true,
+	"INCRBY":         true,
+	"DECRBY":         true,
+	"INCRBYFLOAT":    true,
+	"APPEND":         true,
+	"SETRANGE":       true,
+	"SETBIT":         true,
+	"BITOP":          true,
+	"BITFIELD":       true,
+	"PFADD":          true,
+	"PFMERGE":        true,
+	"PFDEBUG":        true,
+	"HSET":           true,
+	"HMSET":          true,
+	"HSETNX":         true,
+	"HDEL":           true,
+	"HINCRBY":        true,
+	"HINCRBYFLOAT":   true,
+	"HPEXPIREAT":     true,
+	"HPERSIST":       true,
+	"ZADD":           true,
+	"ZREM":           true,
+	"GEOADD":         true,
+	"GEOSEARCHSTORE": true,
+}
+
+// commands with a relative or second based deadline, rewritten into an absolute
+// form before they run so that replaying the aof keeps the original deadline
+var absoluteRewrites = map[string]func(command string, args []Value) ([]Value, bool){
+	"HEXPIRE":   hashExpireAbsolute,
+	"HPEXPIRE":  hashExpireAbsolute,
+	"HEXPIREAT": hashExpireAbsolute,
+	"RESTORE":   restoreAbsolute,
+}
+
+// returns the form of a command that is executed and written to the aof
+func RewriteCommand(value Value) Value {
+	if len(value.array) == 0 {
+		return value
+	}
+
+	command := strings.ToUpper(value.array[0].bulk)
+	rewrite, ok := absoluteRewrites[command]
+	if !ok {
+		return value
+	}
+
+	// leave invalid commands alone so the handler reports the error
+	rewritten, ok := rewrite(command, value.array[1:])
+	if !ok {
+		return value
+	}
+
+	return Value{typ: "array", array: rewritten}
+}
+
+// reports whether a command has to be persisted in the aof
+func IsWriteCommand(command string) bool {
+	return writeCommands[command]
+}
+
+// reports whether a command may modify the dataset, however it is persisted
+func modifiesDataset(command string) bool {
+	cmd, ok := lookupCommand(command)
+	return ok && cmd.hasFlag("write")
+}
+
+// writes the effects of a command to the aof, nil while the aof is being replayed
+var propagator func(Value)
+
+// sets where commands that cannot be replayed as sent write their effects,
+// e.g. XADD with a generated id is persisted with the id it was given
+func SetPropagator(fn func(Value)) {
+	// the aof goes on from the database its last SELECT record switched to
+	aofDB = selectedDB
+
+	propagator = func(value Value) {
+		propagateSelect(fn)
+		fn(value)
+	}
+}
+
+// persists a command built from args, callers hold the lock of the data they
+// changed so effects reach the aof in the order they were applied
+func propagate(args ...string) {
+	if propagator == nil {
+		return
+	}
+
+	values := make([]Value, 0, len(args))
+	for _, arg := range args {
+		values = append(values, Value{typ: "bulk", bulk: arg})
+	}
+
+	propagator(Value{typ: "array", array: values})
 }
 
 // PING Command
@@ -41,6 +270,8 @@ func ping(args []Value) Value {
 
 type SetValStruct struct {
 	value     string
+	num       int64 // value of int encoded strings
+	encoding  int   // encodingRaw or encodingInt
 	expiresAt int64 // 0 -> no expiration
 }
 
@@ -48,19 +279,21 @@ var SETs = map[string]SetValStruct{}
 var SETsMu = sync.RWMutex{}
 
 func set(args []Value) Value {
-	if len(args) != 2 {
-		return Value{typ: "error", str: "ERR wrong number of arguments for 'set' command"}
-	}
-
 	key := args[0].bulk
 	value := args[1].bulk
 
-	// acquire writers lock and write then Unlock
+	// acquire writers locks of every map, the value held before may be of any type
 	SETsMu.Lock()
-	SETs[key] = SetValStruct{
-		value:     value,
-		expiresAt: 0, // no expiration by default
-	}
+	HSETsMu.Lock()
+	ZSETsMu.Lock()
+	STREAMsMu.Lock()
+	databases[selectedDB].remove(key)
+	SETs[key] = newStringEntry(value) // no expiration by default
+	keyspaceAdd(key, keyString)
+	notifyKeyspaceEvent(notifyString, "set", key)
+	STREAMsMu.Unlock()
+	ZSETsMu.Unlock()
+	HSETsMu.Unlock()
 	SETsMu.Unlock()
 
 	// debug
@@ -71,10 +304,6 @@ func set(args []Value) Value {
 
 // GET Command
 func get(args []Value) Value {
-	if len(args) != 1 {
-		return Value{typ: "error", str: "ERR wrong number of arguments for 'get' command"}
-	}
-
 	key := args[0].bulk
 
 	// acquire readers' lock, read and then unlock
@@ -91,7 +320,7 @@ func get(args []Value) Value {
 	if entry.expiresAt > 0 && time.Now().Unix() > entry.expiresAt {
 		// delete the expired key and return null
 		SETsMu.Lock()
-		delete(SETs, key)
+		stringLiveEntry(key)
 		SETsMu.Unlock()
 
 		return Value{typ: "null"}
@@ -100,40 +329,47 @@ func get(args []Value) Value {
 	// debug
 	logger.Debug(fmt.Sprintf("command executed: GET %s", key))
 
-	return Value{typ: "bulk", bulk: entry.value}
+	return Value{typ: "bulk", bulk: entry.stringValue()}
 }
 
-// DELETE command: delete multiple keys and values
+// DELETE command: delete multiple keys and values of any type, replies with
+// the number of keys that existed
 func deleteKeys(args []Value) Value {
-	if len(args) != 1 {
-		return Value{typ: "error", str: "ERR wrong number of arguments for 'del' command"}
-	}
-
-	key := args[0].bulk
-
-	// acquire lock, delete the key value pair and unlock
 	SETsMu.Lock()
-	delete(SETs, key)
-	SETsMu.Unlock()
+	HSETsMu.Lock()
+	ZSETsMu.Lock()
+	STREAMsMu.Lock()
+	defer SETsMu.Unlock()
+	defer HSETsMu.Unlock()
+	defer ZSETsMu.Unlock()
+	defer STREAMsMu.Unlock()
+
+	db := databases[selectedDB]
+	deleted := 0
+	for _, arg := range args {
+		if db.remove(arg.bulk) {
+			deleted++
+			notifyKeyspaceEvent(notifyGeneric, "del", arg.bulk)
+		}
+	}
 
 	// debug
-	logger.Debug(fmt.Sprintf("command executed: DEL %s", key))
+	logger.Debug(fmt.Sprintf("command executed: DEL (%d keys, %d deleted)", len(args), deleted))
 
-	return Value{typ: "string", str: "1"}
+	return Value{typ: "integer", num: deleted}
 }
 
 // EXPIRE command: set an expire on a key
 func expire(args []Value) Value {
-	if len(args) != 2 {
-		return Value{typ: "error", str: "ERR wrong number of arguments for  'expire' command"}
-	}
-
 	key := args[0].bulk
 	secondString := args[1].bulk
 
 	seconds, err := strconv.Atoi(secondString)
-	if err != nil || seconds <= 0 {
-		return Value{typ: "error", str: "Invalid seconds argument"}
+	if err != nil {
+		return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+	}
+	if seconds <= 0 {
+		return Value{typ: "error", str: "ERR invalid expire time in 'expire' command"}
 	}
 
 	// get the current time in unix timestamp and add the seconds to get the expiresAt
@@ -143,79 +379,26 @@ func expire(args []Value) Value {
 	// acquire write lock and set the expire on key
 	SETsMu.Lock()
 	entry, ok := SETs[key]
+	ok = ok && !entry.expired(currentTime)
 	if ok {
 		entry.expiresAt = expiresAt
 		SETs[key] = entry
+		volatileStrings[key] = struct{}{}
+		notifyKeyspaceEvent(notifyGeneric, "expire", key)
 	}
 	SETsMu.Unlock()
 
 	if !ok {
-		return Value{typ: "null"} // key does not exists
+		return Value{typ: "integer", num: 0} // key does not exists
 	}
 
 	logger.Debug(fmt.Sprintf("command executed: EXPIRE %s %s", key, secondString))
 
-	return Value{typ: "string", str: "1"}
-}
-
-// HSET command
-var HSETs = map[string]map[string]string{}
-var HSETsMu = sync.RWMutex{}
-
-func hset(args []Value) Value {
-	if len(args) != 3 {
-		return Value{typ: "error", str: "ERR wrong number of arguments for 'hset' command"}
-	}
-
-	hash := args[0].bulk
-	key := args[1].bulk
-	value := args[2].bulk
-
-	// acquire writer's lock, set and unlock
-	HSETsMu.Lock()
-	if _, ok := HSETs[hash]; !ok {
-		HSETs[hash] = map[string]string{}
-	}
-	HSETs[hash][key] = value
-	HSETsMu.Unlock()
-
-	// debug
-	logger.Debug(fmt.Sprintf("command executed: HSET %s %s %s", hash, key, value))
-
-	return Value{typ: "string", str: "OK"}
-}
-
-// HGET command
-func hget(args []Value) Value {
-	if len(args) != 2 {
-		return Value{typ: "error", str: "ERR wrong number of arguments for 'hget' command"}
-	}
-
-	hash := args[0].bulk
-	key := args[1].bulk
-
-	// acquire readers' lock, read and unlock
-	HSETsMu.RLock()
-	value, ok := HSETs[hash][key]
-	HSETsMu.RUnlock()
-
-	// null check
-	if !ok {
-		return Value{typ: "null"}
-	}
-
-	// debug
-	logger.Debug(fmt.Sprintf("command executed: HGET %s %s", hash, key))
-
-	return Value{typ: "bulk", bulk: value}
+	return Value{typ: "integer", num: 1}
 }
 
 // CONFIG command: Minimal implementation for redis benchmark
 func config(args []Value) Value {
-	if len(args) < 1 {
-		return Value{typ: "error", str: "ERR wrong number of arguments for 'config' command"}
-	}
-
 	if len(args) > 1 && strings.ToUpper(args[0].bulk) == "GET" {
 		key := strings.ToLower(args[1].bulk)
 
@@ -225,9 +408,19 @@ func config(args []Value) Value {
 		// simulate basic config responses
 		switch key {
 		case "maxmemory":
-			return Value{typ: "array", str: "", bulk: "", array: []Value{
+			return Value{typ: "array", array: []Value{
 				{typ: "bulk", bulk: "maxmemory"},
-				{typ: "bulk", bulk: "0"}, // TODO: placeholder value
+				{typ: "bulk", bulk: strconv.FormatInt(maxMemory.Load(), 10)},
+			}}
+		case "maxmemory-policy":
+			return Value{typ: "array", array: []Value{
+				{typ: "bulk", bulk: "maxmemory-policy"},
+				{typ: "bulk", bulk: evictionPolicyNames[evictionPolicy.Load()]},
+			}}
+		case "maxmemory-samples":
+			return Value{typ: "array", array: []Value{
+				{typ: "bulk", bulk: "maxmemory-samples"},
+				{typ: "bulk", bulk: strconv.Itoa(int(evictionSamples.Load()))},
 			}}
 		case "timeout":
 			return Value{typ: "array", str: "", bulk: "", array: []Value{
@@ -239,12 +432,53 @@ func config(args []Value) Value {
 				{typ: "bulk", bulk: "save"},
 				{typ: "bulk", bulk: "3600 1 300 100 60 10000"},
 			}}
+		case "databases":
+			return Value{typ: "array", array: []Value{
+				{typ: "bulk", bulk: "databases"},
+				{typ: "bulk", bulk: strconv.Itoa(len(databases))},
+			}}
+		case "notify-keyspace-events":
+			return Value{typ: "array", array: []Value{
+				{typ: "bulk", bulk: "notify-keyspace-events"},
+				{typ: "bulk", bulk: keyspaceEventsString(int(keyspaceEvents.Load()))},
+			}}
 		default:
 			// Return empty array for unrecognized config keys
 			return Value{typ: "array", array: []Value{}}
 		}
 	}
 
+	if len(args) == 3 && strings.ToUpper(args[0].bulk) == "SET" {
+		key := strings.ToLower(args[1].bulk)
+
+		// debug
+		logger.Debug(fmt.Sprintf("command executed: CONFIG SET %s %s", key, args[2].bulk))
+
+		var err error
+		switch key {
+		case "notify-keyspace-events":
+			err = SetKeyspaceEvents(args[2].bulk)
+		case "maxmemory":
+			err = SetMaxMemory(args[2].bulk)
+		case "maxmemory-policy":
+			err = SetMaxMemoryPolicy(args[2].bulk)
+		case "maxmemory-samples":
+			samples, convErr := strconv.Atoi(args[2].bulk)
+			if convErr != nil {
+				err = errors.New("argument couldn't be parsed into an integer")
+			} else {
+				err = SetMaxMemorySamples(samples)
+			}
+		default:
+			return Value{typ: "error", str: fmt.Sprintf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", key)}
+		}
+
+		if err != nil {
+			return Value{typ: "error", str: fmt.Sprintf("ERR CONFIG SET failed (possibly related to argument '%s') - %s", key, err.Error())}
+		}
+		return Value{typ: "string", str: "OK"}
+	}
+
 	// debug
 	logger.Error("commmand errored: unsupported CONFIG command")
 
@@ -261,18 +495,16 @@ uptime_in_days: 0
 # Clients
 connected_clients: 1
 # Memory
-used_memory: 2048
-# Persistence
+` + memoryInfo() + `# Persistence
 rdb_last_save_time: 0
 # Stats
 total_connections_received: 1
 total_commands_processed: 1
+evicted_keys: ` + strconv.FormatInt(evictedKeys.Load(), 10) + `
 # CPU
 used_cpu_sys: 0.00
 used_cpu_user: 0.00
-# Keyspace
-db0:keys=1,expires=0,avg_ttl=0
-`
+` + keyspaceInfo()
 
 	// debug
 	logger.Debug("commmand executed: INFO")
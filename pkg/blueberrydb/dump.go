@@ -0,0 +1,212 @@
+// DUMP and RESTORE: a value serialized in its rdb encoding, followed by the
+// rdb version and a crc64 of everything before it, the layout redis uses so
+// payloads move between blueberrydb and redis
+package blueberrydb
+
+import (
+	"blueberrydb/internal/logger"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const dumpPayloadError = "ERR DUMP payload version or checksum are wrong"
+
+// the DUMP payload of a value
+func dumpPayload(v keyValue) []byte {
+	typ, body, version := encodeValue(v)
+
+	payload := append([]byte{typ}, body...)
+	payload = binary.LittleEndian.AppendUint16(payload, version)
+	return binary.LittleEndian.AppendUint64(payload, rdbChecksum(payload))
+}
+
+// the value of a DUMP payload, an error message when the footer does not
+// check out or the value cannot be read
+func restorePayload(payload []byte) (keyValue, string) {
+	if len(payload) < 10 {
+		return keyValue{}, dumpPayloadError
+	}
+
+	footer := len(payload) - 10
+	version := binary.LittleEndian.Uint16(payload[footer:])
+	checksum := binary.LittleEndian.Uint64(payload[footer+2:])
+	if version > rdbVersion || checksum != rdbChecksum(payload[:footer+2]) {
+		return keyValue{}, dumpPayloadError
+	}
+
+	r := &rdbReader{buf: payload[:footer]}
+	v, ok := decodeValue(r.byte(), r)
+	if !ok || r.bad || r.pos != footer {
+		return keyValue{}, "ERR Bad data format"
+	}
+	return v, ""
+}
+
+// DUMP command: the serialized value of a key, null when it does not exist
+func dump(args []Value) Value {
+	key := args[0].bulk
+
+	SETsMu.RLock()
+	HSETsMu.RLock()
+	ZSETsMu.RLock()
+	STREAMsMu.RLock()
+	defer SETsMu.RUnlock()
+	defer HSETsMu.RUnlock()
+	defer ZSETsMu.RUnlock()
+	defer STREAMsMu.RUnlock()
+
+	v, ok := databases[selectedDB].lookup(key)
+	if !ok {
+		return Value{typ: "null"}
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: DUMP %s", key))
+
+	return Value{typ: "bulk", bulk: string(dumpPayload(v))}
+}
+
+// options of RESTORE after the payload
+type restoreOptions struct {
+	replace  bool
+	absTTL   bool
+	idleTime int64 // seconds, -1 when not given
+	freq     int   // -1 when not given
+}
+
+func parseRestoreOptions(args []Value) (restoreOptions, string) {
+	opts := restoreOptions{idleTime: -1, freq: -1}
+
+	for i := 0; i < len(args); i++ {
+		option := strings.ToUpper(args[i].bulk)
+		switch {
+		case option == "REPLACE":
+			opts.replace = true
+		case option == "ABSTTL":
+			opts.absTTL = true
+		case option == "IDLETIME" && i+1 < len(args) && opts.freq == -1:
+			i++
+			idleTime, err := strconv.ParseInt(args[i].bulk, 10, 64)
+			if err != nil {
+				return opts, "ERR value is not an integer or out of range"
+			}
+			if idleTime < 0 {
+				return opts, "ERR Invalid IDLETIME value, must be >= 0"
+			}
+			opts.idleTime = idleTime
+		case option == "FREQ" && i+1 < len(args) && opts.idleTime == -1:
+			i++
+			freq, err := strconv.Atoi(args[i].bulk)
+			if err != nil {
+				return opts, "ERR value is not an integer or out of range"
+			}
+			if freq < 0 || freq > 255 {
+				return opts, "ERR Invalid FREQ value, must be >= 0 and <= 255"
+			}
+			opts.freq = freq
+		default:
+			return opts, "ERR syntax error"
+		}
+	}
+
+	return opts, ""
+}
+
+// RESTORE command: creates a key from a DUMP payload, ttl is in milliseconds
+// and 0 for none, caller must hold the commandsMu write lock
+func restore(args []Value) Value {
+	key := args[0].bulk
+
+	ttl, err := strconv.ParseInt(args[1].bulk, 10, 64)
+	if err != nil {
+		return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+	}
+	if ttl < 0 {
+		return Value{typ: "error", str: "ERR Invalid TTL value, must be >= 0"}
+	}
+
+	opts, errMsg := parseRestoreOptions(args[3:])
+	if errMsg != "" {
+		return Value{typ: "error", str: errMsg}
+	}
+
+	db := databases[selectedDB]
+	if _, exists := db.lookup(key); exists && !opts.replace {
+		return Value{typ: "error", str: "BUSYKEY Target key name already exists."}
+	}
+
+	v, errMsg := restorePayload([]byte(args[2].bulk))
+	if errMsg != "" {
+		return Value{typ: "error", str: errMsg}
+	}
+
+	now := time.Now().UnixMilli()
+	expiresAt := ttl
+	if ttl > 0 && !opts.absTTL {
+		expiresAt = now + ttl
+	}
+	if ttl > 0 && v.bit != keyString {
+		return Value{typ: "error", str: "ERR TTL is only supported on string keys"}
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: RESTORE %s", key))
+
+	deleted := db.remove(key)
+
+	// a value that is already expired is not created, the old one is still replaced
+	if ttl > 0 && expiresAt <= now {
+		if deleted {
+			notifyKeyspaceEvent(notifyGeneric, "del", key)
+		}
+		return Value{typ: "string", str: "OK"}
+	}
+
+	if ttl > 0 {
+		// string deadlines are kept in seconds and reached once that second is over
+		v.str.expiresAt = expiresAt / 1000
+	}
+	db.store(key, v)
+
+	meta := newKeyMeta(v.bit)
+	if opts.idleTime >= 0 {
+		meta.lastAccess = now - opts.idleTime*1000
+	}
+	if opts.freq >= 0 {
+		meta.lfu = uint8(opts.freq)
+	}
+	db.index.inherit(key, meta)
+
+	notifyKeyspaceEvent(notifyGeneric, "restore", key)
+
+	return Value{typ: "string", str: "OK"}
+}
+
+// rewrites RESTORE with a relative ttl into RESTORE ... ABSTTL
+func restoreAbsolute(command string, args []Value) ([]Value, bool) {
+	if len(args) < 3 {
+		return nil, false
+	}
+
+	ttl, err := strconv.ParseInt(args[1].bulk, 10, 64)
+	if err != nil || ttl <= 0 {
+		return nil, false
+	}
+	for _, arg := range args[3:] {
+		if strings.ToUpper(arg.bulk) == "ABSTTL" {
+			return nil, false
+		}
+	}
+
+	rewritten := []Value{
+		{typ: "bulk", bulk: command},
+		args[0],
+		{typ: "bulk", bulk: strconv.FormatInt(time.Now().UnixMilli()+ttl, 10)},
+	}
+	rewritten = append(rewritten, args[2:]...)
+
+	return append(rewritten, Value{typ: "bulk", bulk: "ABSTTL"}), true
+}
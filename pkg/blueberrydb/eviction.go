@@ -0,0 +1,364 @@
+// maxmemory: once the dataset grows past it keys are evicted by the
+// maxmemory-policy before each command, with noeviction or nothing left to
+// evict the commands that would take more memory are refused instead
+package blueberrydb
+
+import (
+	"blueberrydb/internal/logger"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// eviction policies, in the order of their names
+const (
+	evictNoEviction = iota
+	evictAllKeysLRU
+	evictAllKeysLFU
+	evictAllKeysRandom
+	evictVolatileLRU
+	evictVolatileLFU
+	evictVolatileRandom
+	evictVolatileTTL
+)
+
+var evictionPolicyNames = []string{
+	"noeviction",
+	"allkeys-lru",
+	"allkeys-lfu",
+	"allkeys-random",
+	"volatile-lru",
+	"volatile-lfu",
+	"volatile-random",
+	"volatile-ttl",
+}
+
+// candidates kept between evictions, as in redis
+const evictionPoolSize = 16
+
+// maxmemory in bytes, 0 for no limit
+var maxMemory atomic.Int64
+
+var evictionPolicy atomic.Int32
+
+// keys sampled in each database to find the next one to evict, maxmemory-samples
+var evictionSamples atomic.Int32
+
+// keys evicted since the server started
+var evictedKeys atomic.Int64
+
+func init() {
+	evictionSamples.Store(5)
+}
+
+// parses a number of bytes with an optional unit: b, k, kb, m, mb, g or gb,
+// the ones without b are powers of 1000
+func parseMemory(arg string) (int64, bool) {
+	units := []struct {
+		suffix string
+		mul    int64
+	}{
+		{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000}, {"b", 1},
+	}
+
+	number, mul := strings.ToLower(arg), int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(number, unit.suffix) {
+			number, mul = strings.TrimSuffix(number, unit.suffix), unit.mul
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/mul {
+		return 0, false
+	}
+	return n * mul, true
+}
+
+// sets maxmemory, from the config file or CONFIG SET, "0" removes the limit
+func SetMaxMemory(arg string) error {
+	bytes, ok := parseMemory(arg)
+	if !ok {
+		return errors.New("argument must be a memory value")
+	}
+
+	maxMemory.Store(bytes)
+	return nil
+}
+
+// sets maxmemory-policy, from the config file or CONFIG SET
+func SetMaxMemoryPolicy(name string) error {
+	for policy, policyName := range evictionPolicyNames {
+		if strings.EqualFold(name, policyName) {
+			evictionPolicy.Store(int32(policy))
+			return nil
+		}
+	}
+	return errors.New("argument(s) must be one of the following: " + strings.Join(evictionPolicyNames, ", "))
+}
+
+// sets maxmemory-samples, from the config file or CONFIG SET
+func SetMaxMemorySamples(samples int) error {
+	if samples < 1 || samples > 64 {
+		return errors.New("argument must be between 1 and 64 inclusive")
+	}
+
+	evictionSamples.Store(int32(samples))
+	return nil
+}
+
+// reports whether the memory used is over maxmemory, the aof buffer and the
+// replication backlog do not count, as in redis
+func overMaxMemory() bool {
+	limit := maxMemory.Load()
+	if limit == 0 {
+		return false
+	}
+
+	stats := currentMemoryStats()
+	return stats.dataset+stats.clients > limit
+}
+
+// the reply to a command that would take more memory while the dataset is
+// over maxmemory, commands that free memory or only read still run
+func oomError(value Value) (Value, bool) {
+	cmd, ok := lookupCommand(value.array[0].bulk)
+	if !ok || !cmd.hasFlag("denyoom") || !overMaxMemory() {
+		return Value{}, false
+	}
+	return Value{typ: "error", str: "OOM command not allowed when used memory > 'maxmemory'."}, true
+}
+
+// evicts keys until the dataset fits in maxmemory, takes the commandsMu write lock
+func evictIfNeeded() {
+	if !overMaxMemory() || evictionPolicy.Load() == evictNoEviction {
+		return
+	}
+
+	if !lockCommands(true) {
+		return
+	}
+	defer commandsMu.Unlock()
+
+	current := selectedDB
+	defer selectDatabase(current)
+
+	policy := int(evictionPolicy.Load())
+	for overMaxMemory() {
+		db, key, ok := evictionCandidate(policy)
+		if !ok {
+			// debug
+			logger.Debug("no key left to evict, commands needing memory are refused")
+			return
+		}
+		if !evictKey(db, key) {
+			// debug
+			logger.Debug(fmt.Sprintf("eviction candidate %s held nothing, eviction stopped", key))
+			return
+		}
+	}
+}
+
+// removes a key the way DEL does and tells the subscribers of evicted events,
+// reports whether there was a key to remove, caller must hold the commandsMu write lock
+func evictKey(index int, key string) bool {
+	if databases[index].index.meta(key) == nil {
+		return false
+	}
+
+	selectDatabase(index)
+	databases[index].remove(key)
+
+	propagate("DEL", key)
+	notifyDatabaseEvent(index, notifyEvicted, "evicted", key)
+	evictedKeys.Add(1)
+
+	// debug
+	logger.Debug(fmt.Sprintf("key evicted: %s (db %d)", key, index))
+
+	return true
+}
+
+func volatilePolicy(policy int) bool {
+	return policy >= evictVolatileLRU
+}
+
+// a key that may be evicted, the higher the score the sooner
+type evictionEntry struct {
+	db    int
+	key   string
+	score uint64
+}
+
+// the best candidates seen so far by ascending score, guarded by commandsMu
+var evictionPool = []evictionEntry{}
+
+// the database random policies look at first, so every database loses keys in turn
+var evictionNextDB = 0
+
+// the next key to evict by the policy, caller must hold the commandsMu write lock
+func evictionCandidate(policy int) (int, string, bool) {
+	if policy == evictAllKeysRandom || policy == evictVolatileRandom {
+		return randomEvictionCandidate(volatilePolicy(policy))
+	}
+
+	for {
+		sampled := 0
+		for index, db := range databases {
+			sampled += sampleEvictionPool(index, db, policy)
+		}
+		if sampled == 0 {
+			return 0, "", false
+		}
+
+		// the best candidate that is still there, entries go stale as keys
+		// are removed or databases flushed
+		for len(evictionPool) > 0 {
+			best := evictionPool[len(evictionPool)-1]
+			evictionPool = evictionPool[:len(evictionPool)-1]
+
+			if best.db < len(databases) && evictable(databases[best.db], best.key, policy) {
+				return best.db, best.key, true
+			}
+		}
+	}
+}
+
+// reports whether a key is held in the database and, for volatile policies,
+// has a ttl, volatile keys without one are dropped from volatileStrings on the way
+func evictable(db *database, key string, policy int) bool {
+	if volatilePolicy(policy) {
+		if db.strings[key].expiresAt == 0 {
+			delete(db.volatileStrings, key)
+			return false
+		}
+		return true
+	}
+	return db.index.meta(key) != nil
+}
+
+// adds keys of a database to the pool with their scores, returns how many
+// of them could be evicted
+func sampleEvictionPool(index int, db *database, policy int) int {
+	samples := int(evictionSamples.Load())
+
+	keys := []string{}
+	if volatilePolicy(policy) {
+		keys = firstKeys(db.volatileStrings, samples)
+	} else {
+		keys = db.sampleKeys(samples)
+	}
+
+	now, sampled := time.Now().UnixMilli(), 0
+	for _, key := range keys {
+		if !evictable(db, key, policy) {
+			continue
+		}
+		sampled++
+
+		score := uint64(0)
+		switch policy {
+		case evictVolatileTTL:
+			// the sooner the deadline the higher the score
+			score = math.MaxUint64 - uint64(db.strings[key].expiresAt)
+		case evictAllKeysLFU, evictVolatileLFU:
+			score = 255 - uint64(db.index.meta(key).frequency(now))
+		default:
+			score = uint64(max(now-db.index.meta(key).lastAccess, 0))
+		}
+
+		insertEvictionPool(evictionEntry{db: index, key: key, score: score})
+	}
+
+	return sampled
+}
+
+// the first n keys of a map, go starts iterating at a random place so these
+// are distinct keys from anywhere in it, the way redis samples its dicts
+func firstKeys[V any](m map[string]V, n int) []string {
+	keys := make([]string, 0, n)
+	for key := range m {
+		if len(keys) == n {
+			break
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// up to n keys of a database, from one of its maps picked by its share of
+// the keys, the key index is not used as similar keys get similar hashes
+func (db *database) sampleKeys(n int) []string {
+	pick := rand.Intn(max(len(db.strings)+len(db.hashes)+len(db.zsets)+len(db.streams), 1))
+
+	switch {
+	case pick < len(db.strings):
+		return firstKeys(db.strings, n)
+	case pick < len(db.strings)+len(db.hashes):
+		return firstKeys(db.hashes, n)
+	case pick < len(db.strings)+len(db.hashes)+len(db.zsets):
+		return firstKeys(db.zsets, n)
+	}
+	return firstKeys(db.streams, n)
+}
+
+// keeps the entry if it scores higher than the worst one of a full pool
+func insertEvictionPool(entry evictionEntry) {
+	for i, e := range evictionPool {
+		if e.db == entry.db && e.key == entry.key {
+			evictionPool = append(evictionPool[:i], evictionPool[i+1:]...)
+			break
+		}
+	}
+
+	if len(evictionPool) == evictionPoolSize && entry.score <= evictionPool[0].score {
+		return
+	}
+
+	i := sort.Search(len(evictionPool), func(i int) bool { return evictionPool[i].score > entry.score })
+	evictionPool = append(evictionPool, evictionEntry{})
+	copy(evictionPool[i+1:], evictionPool[i:])
+	evictionPool[i] = entry
+
+	if len(evictionPool) > evictionPoolSize {
+		evictionPool = evictionPool[1:]
+	}
+}
+
+// a random key of the first database from evictionNextDB on that has one
+func randomEvictionCandidate(volatile bool) (int, string, bool) {
+	for i := range databases {
+		index := (evictionNextDB + i) % len(databases)
+		db := databases[index]
+
+		if volatile {
+			// entries left behind by keys that are gone are dropped as they are met
+			for key := range db.volatileStrings {
+				if evictable(db, key, evictVolatileRandom) {
+					evictionNextDB = index + 1
+					return index, key, true
+				}
+			}
+			continue
+		}
+
+		if keys := db.sampleKeys(1); len(keys) > 0 {
+			evictionNextDB = index + 1
+			return index, keys[0], true
+		}
+	}
+	return 0, "", false
+}
+
+// the maxmemory settings for the memory section of INFO
+func maxMemoryInfo() string {
+	return fmt.Sprintf("maxmemory: %d\nmaxmemory_policy: %s\n", maxMemory.Load(), evictionPolicyNames[evictionPolicy.Load()])
+}
@@ -0,0 +1,62 @@
+// active expiration: periodically reclaims expired data nobody reads anymore
+package blueberrydb
+
+import (
+	"time"
+)
+
+const (
+	activeExpireInterval = 100 * time.Millisecond
+	activeExpireBudget   = 25 * time.Millisecond
+	activeExpireSample   = 20
+)
+
+// start the background goroutine that reclaims expired data
+func StartActiveExpire() {
+	go func() {
+		for {
+			time.Sleep(activeExpireInterval)
+			activeExpireCycle()
+		}
+	}()
+}
+
+// samples volatile data of each database and keeps going while more than a
+// quarter of the sample was expired
+func activeExpireCycle() {
+	start := time.Now()
+
+	for db := 0; db < len(databases) && time.Since(start) < activeExpireBudget; db++ {
+		// switching databases takes the write lock, skip those with nothing to expire
+		if !hasVolatileData(db) {
+			continue
+		}
+
+		// expiring keys in the middle of a transaction would break its isolation
+		rlockDatabase(db)
+
+		for _, pass := range []func(int) (int, int){activeExpireStrings, activeExpireHashFields} {
+			for time.Since(start) < activeExpireBudget {
+				sampled, expired := pass(activeExpireSample)
+				if sampled == 0 || expired*4 <= sampled {
+					break
+				}
+			}
+		}
+
+		commandsMu.RUnlock()
+	}
+}
+
+// reports whether a database has strings or hash fields with an expiration
+func hasVolatileData(db int) bool {
+	commandsMu.RLock()
+	defer commandsMu.RUnlock()
+
+	SETsMu.RLock()
+	defer SETsMu.RUnlock()
+	HSETsMu.RLock()
+	defer HSETsMu.RUnlock()
+
+	return len(databases[db].volatileStrings) > 0 || len(databases[db].volatileHashes) > 0
+}
@@ -0,0 +1,512 @@
+// functions: lua libraries loaded with FUNCTION LOAD register named functions called with FCALL
+package blueberrydb
+
+import (
+	"blueberrydb/internal/logger"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+func init() {
+	Handlers["FUNCTION"] = function
+	Handlers["FCALL"] = fcall
+	Handlers["FCALL_RO"] = fcallRo
+}
+
+type library struct {
+	name      string
+	code      string
+	functions map[string]*libraryFunction
+}
+
+type libraryFunction struct {
+	name        string
+	description string
+	flags       []string
+	callback    *lua.LFunction
+}
+
+func (f *libraryFunction) readOnly() bool {
+	for _, flag := range f.flags {
+		if flag == "no-writes" {
+			return true
+		}
+	}
+	return false
+}
+
+// flags a function may declare, only no-writes changes how it runs for now
+var functionFlags = map[string]bool{
+	"no-writes":             true,
+	"allow-oom":             true,
+	"allow-stale":           true,
+	"no-cluster":            true,
+	"allow-cross-slot-keys": true,
+}
+
+// loaded libraries and the functions they registered, function names are
+// unique across libraries, guarded by commandsMu as FUNCTION and FCALL take the write lock
+type functionRegistry struct {
+	libraries map[string]*library
+	functions map[string]*libraryFunction
+}
+
+func newFunctionRegistry() *functionRegistry {
+	return &functionRegistry{libraries: map[string]*library{}, functions: map[string]*libraryFunction{}}
+}
+
+var functions = newFunctionRegistry()
+
+// copy that a restore loads into, the registry only changes if every library loads
+func (r *functionRegistry) clone() *functionRegistry {
+	c := newFunctionRegistry()
+	for name, lib := range r.libraries {
+		c.libraries[name] = lib
+	}
+	for name, f := range r.functions {
+		c.functions[name] = f
+	}
+	return c
+}
+
+func (r *functionRegistry) remove(name string) {
+	lib, ok := r.libraries[name]
+	if !ok {
+		return
+	}
+
+	for fname := range lib.functions {
+		delete(r.functions, fname)
+	}
+	delete(r.libraries, name)
+}
+
+func (r *functionRegistry) add(lib *library, replace bool) error {
+	if _, ok := r.libraries[lib.name]; ok && !replace {
+		return fmt.Errorf("Library '%s' already exists", lib.name)
+	}
+
+	// a replaced library may keep its own function names
+	old := r.libraries[lib.name]
+	for fname := range lib.functions {
+		if existing, ok := r.functions[fname]; ok && (old == nil || old.functions[fname] != existing) {
+			return fmt.Errorf("Function %s already exists", fname)
+		}
+	}
+
+	r.remove(lib.name)
+	r.libraries[lib.name] = lib
+	for fname, f := range lib.functions {
+		r.functions[fname] = f
+	}
+	return nil
+}
+
+// the library being loaded, redis.register_function adds to it, guarded by commandsMu
+var loadingLibrary *library
+
+// how long the code of a library may run while it registers its functions
+const functionLoadTimeout = 500 * time.Millisecond
+
+func validFunctionName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// reads the "#!lua name=<library>" line every library starts with
+func parseLibraryMetadata(code string) (string, error) {
+	line, _, _ := strings.Cut(code, "\n")
+	if !strings.HasPrefix(line, "#!") {
+		return "", errors.New("Missing library metadata")
+	}
+
+	fields := strings.Fields(line[2:])
+	if len(fields) == 0 {
+		return "", errors.New("Missing library metadata")
+	}
+	if fields[0] != "lua" {
+		return "", fmt.Errorf("Engine '%s' not found", fields[0])
+	}
+
+	name := ""
+	for _, field := range fields[1:] {
+		value, ok := strings.CutPrefix(field, "name=")
+		if !ok {
+			return "", fmt.Errorf("Invalid metadata value given: %s", field)
+		}
+		name = value
+	}
+
+	if name == "" {
+		return "", errors.New("Library name was not given")
+	}
+	if !validFunctionName(name) {
+		return "", errors.New("Library names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	}
+	return name, nil
+}
+
+// compiles a library and runs its code so it registers its functions, caller must hold the commandsMu write lock
+func loadLibrary(code string) (*library, error) {
+	name, err := parseLibraryMetadata(code)
+	if err != nil {
+		return nil, err
+	}
+
+	// the metadata line is not lua, a blank line keeps the line numbers of errors
+	_, body, _ := strings.Cut(code, "\n")
+	chunk, err := parse.Parse(strings.NewReader("\n"+body), "user_function")
+	if err != nil {
+		return nil, fmt.Errorf("Error compiling function: %s", singleLine(err.Error()))
+	}
+	proto, err := lua.Compile(chunk, "@user_function")
+	if err != nil {
+		return nil, fmt.Errorf("Error compiling function: %s", singleLine(err.Error()))
+	}
+
+	lib := &library{name: name, code: code, functions: map[string]*libraryFunction{}}
+
+	L := scriptState()
+	ctx, cancel := context.WithTimeout(context.Background(), functionLoadTimeout)
+	defer cancel()
+
+	loadingLibrary = lib
+	L.SetContext(ctx)
+	L.Push(L.NewFunctionFromProto(proto))
+	err = L.PCall(0, 0, nil)
+	L.RemoveContext()
+	loadingLibrary = nil
+
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, errors.New("FUNCTION LOAD timeout")
+		}
+		if errMsg, ok := raisedReply(err); ok {
+			return nil, fmt.Errorf("Error registering functions: %s", errMsg)
+		}
+		if apiErr, ok := err.(*lua.ApiError); ok {
+			return nil, fmt.Errorf("Error registering functions: %s", singleLine(apiErr.Object.String()))
+		}
+		return nil, fmt.Errorf("Error registering functions: %s", singleLine(err.Error()))
+	}
+
+	if len(lib.functions) == 0 {
+		return nil, errors.New("No functions registered")
+	}
+	return lib, nil
+}
+
+// redis.register_function(name, callback) or redis.register_function{function_name=..., callback=..., flags=..., description=...}
+func luaRegisterFunction(L *lua.LState) int {
+	if loadingLibrary == nil {
+		L.RaiseError("redis.register_function can only be called on FUNCTION LOAD command")
+		return 0
+	}
+
+	var name, callback, flags, description lua.LValue = lua.LNil, lua.LNil, lua.LNil, lua.LNil
+	switch L.GetTop() {
+	case 1:
+		table := L.CheckTable(1)
+		name = table.RawGetString("function_name")
+		callback = table.RawGetString("callback")
+		flags = table.RawGetString("flags")
+		description = table.RawGetString("description")
+	case 2:
+		name, callback = L.Get(1), L.Get(2)
+	default:
+		L.RaiseError("wrong number of arguments to redis.register_function")
+		return 0
+	}
+
+	fname, ok := name.(lua.LString)
+	if !ok {
+		L.RaiseError("function_name argument given to redis.register_function must be a string")
+		return 0
+	}
+	if !validFunctionName(string(fname)) {
+		L.RaiseError("Function names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+		return 0
+	}
+	fn, ok := callback.(*lua.LFunction)
+	if !ok {
+		L.RaiseError("callback argument given to redis.register_function must be a function")
+		return 0
+	}
+	if _, ok := loadingLibrary.functions[string(fname)]; ok {
+		L.RaiseError("Function already exists in the library")
+		return 0
+	}
+
+	f := &libraryFunction{name: string(fname), callback: fn, flags: []string{}}
+
+	if text, ok := description.(lua.LString); ok {
+		f.description = string(text)
+	} else if description != lua.LNil {
+		L.RaiseError("description argument given to redis.register_function must be a string")
+		return 0
+	}
+
+	if table, ok := flags.(*lua.LTable); ok {
+		for i := 1; i <= table.Len(); i++ {
+			flag, ok := table.RawGetInt(i).(lua.LString)
+			if !ok || !functionFlags[string(flag)] {
+				L.RaiseError("unknown flag given")
+				return 0
+			}
+			f.flags = append(f.flags, string(flag))
+		}
+	} else if flags != lua.LNil {
+		L.RaiseError("flags argument to redis.register_function must be a table representing function flags")
+		return 0
+	}
+
+	loadingLibrary.functions[f.name] = f
+	return 0
+}
+
+// FUNCTION DUMP payload: the code of every library as a resp array, sorted by library name
+func dumpFunctions() string {
+	names := make([]string, 0, len(functions.libraries))
+	for name := range functions.libraries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	codes := make([]Value, 0, len(names))
+	for _, name := range names {
+		codes = append(codes, Value{typ: "bulk", bulk: functions.libraries[name].code})
+	}
+
+	return string(Value{typ: "array", array: codes}.Marshal())
+}
+
+// loads the libraries of a FUNCTION DUMP payload according to policy
+func restoreFunctions(payload string, policy string) error {
+	value, err := NewResp(strings.NewReader(payload)).Read()
+	if err != nil || value.typ != "array" {
+		return errors.New("payload version or checksum are wrong")
+	}
+
+	registry := functions.clone()
+	if policy == "FLUSH" {
+		registry = newFunctionRegistry()
+	}
+
+	for _, code := range value.array {
+		if code.typ != "bulk" {
+			return errors.New("payload version or checksum are wrong")
+		}
+
+		lib, err := loadLibrary(code.bulk)
+		if err != nil {
+			return err
+		}
+		if err := registry.add(lib, policy == "REPLACE"); err != nil {
+			return err
+		}
+	}
+
+	functions = registry
+	return nil
+}
+
+// one entry of FUNCTION LIST
+func libraryInfo(lib *library, withCode bool) Value {
+	names := make([]string, 0, len(lib.functions))
+	for name := range lib.functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fns := make([]Value, 0, len(names))
+	for _, name := range names {
+		f := lib.functions[name]
+
+		description := Value{typ: "null"}
+		if f.description != "" {
+			description = Value{typ: "bulk", bulk: f.description}
+		}
+
+		flags := make([]Value, 0, len(f.flags))
+		for _, flag := range f.flags {
+			flags = append(flags, Value{typ: "bulk", bulk: flag})
+		}
+
+		fns = append(fns, Value{typ: "array", array: []Value{
+			{typ: "bulk", bulk: "name"}, {typ: "bulk", bulk: f.name},
+			{typ: "bulk", bulk: "description"}, description,
+			{typ: "bulk", bulk: "flags"}, {typ: "array", array: flags},
+		}})
+	}
+
+	info := []Value{
+		{typ: "bulk", bulk: "library_name"}, {typ: "bulk", bulk: lib.name},
+		{typ: "bulk", bulk: "engine"}, {typ: "bulk", bulk: "LUA"},
+		{typ: "bulk", bulk: "functions"}, {typ: "array", array: fns},
+	}
+	if withCode {
+		info = append(info, Value{typ: "bulk", bulk: "library_code"}, Value{typ: "bulk", bulk: lib.code})
+	}
+
+	return Value{typ: "array", array: info}
+}
+
+func functionList(args []Value) Value {
+	withCode := false
+	pattern := ""
+
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i].bulk) {
+		case "WITHCODE":
+			withCode = true
+		case "LIBRARYNAME":
+			if i+1 >= len(args) {
+				return Value{typ: "error", str: "ERR library name argument was not given"}
+			}
+			i++
+			pattern = args[i].bulk
+		default:
+			return Value{typ: "error", str: fmt.Sprintf("ERR Unknown argument %s", args[i].bulk)}
+		}
+	}
+
+	names := make([]string, 0, len(functions.libraries))
+	for name := range functions.libraries {
+		if pattern == "" || globMatch(pattern, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	libs := make([]Value, 0, len(names))
+	for _, name := range names {
+		libs = append(libs, libraryInfo(functions.libraries[name], withCode))
+	}
+
+	return Value{typ: "array", array: libs}
+}
+
+// FUNCTION command: LOAD, DELETE, FLUSH and RESTORE are persisted as sent once they succeed
+func function(args []Value) Value {
+	subcommand := strings.ToUpper(args[0].bulk)
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: FUNCTION %s", subcommand))
+
+	switch {
+	case subcommand == "LOAD" && (len(args) == 2 || len(args) == 3):
+		replace := false
+		if len(args) == 3 {
+			if strings.ToUpper(args[1].bulk) != "REPLACE" {
+				return Value{typ: "error", str: fmt.Sprintf("ERR Unknown option given: %s", args[1].bulk)}
+			}
+			replace = true
+		}
+
+		lib, err := loadLibrary(args[len(args)-1].bulk)
+		if err != nil {
+			return Value{typ: "error", str: "ERR " + err.Error()}
+		}
+		if err := functions.add(lib, replace); err != nil {
+			return Value{typ: "error", str: "ERR " + err.Error()}
+		}
+
+		propagate(commandStrings("FUNCTION", args)...)
+		return Value{typ: "bulk", bulk: lib.name}
+
+	case subcommand == "DELETE" && len(args) == 2:
+		if _, ok := functions.libraries[args[1].bulk]; !ok {
+			return Value{typ: "error", str: "ERR Library not found"}
+		}
+
+		functions.remove(args[1].bulk)
+		propagate(commandStrings("FUNCTION", args)...)
+		return Value{typ: "string", str: "OK"}
+
+	case subcommand == "FLUSH" && len(args) <= 2:
+		if len(args) == 2 {
+			mode := strings.ToUpper(args[1].bulk)
+			if mode != "ASYNC" && mode != "SYNC" {
+				return Value{typ: "error", str: "ERR FUNCTION FLUSH only supports SYNC|ASYNC option"}
+			}
+		}
+
+		functions = newFunctionRegistry()
+		propagate(commandStrings("FUNCTION", args)...)
+		return Value{typ: "string", str: "OK"}
+
+	case subcommand == "LIST":
+		return functionList(args[1:])
+
+	case subcommand == "DUMP" && len(args) == 1:
+		return Value{typ: "bulk", bulk: dumpFunctions()}
+
+	case subcommand == "RESTORE" && (len(args) == 2 || len(args) == 3):
+		policy := "APPEND"
+		if len(args) == 3 {
+			policy = strings.ToUpper(args[2].bulk)
+			if policy != "APPEND" && policy != "REPLACE" && policy != "FLUSH" {
+				return Value{typ: "error", str: "ERR Wrong restore policy given, value should be either FLUSH, APPEND or REPLACE."}
+			}
+		}
+
+		if err := restoreFunctions(args[1].bulk, policy); err != nil {
+			return Value{typ: "error", str: "ERR " + err.Error()}
+		}
+
+		propagate(commandStrings("FUNCTION", args)...)
+		return Value{typ: "string", str: "OK"}
+	}
+
+	return Value{typ: "error", str: fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try FUNCTION HELP.", args[0].bulk)}
+}
+
+// runs "function numkeys key [key ...] arg [arg ...]", the callback gets the keys and arguments as tables
+func callFunction(command string, args []Value, readOnly bool) Value {
+	keys, argv, errMsg := parseScriptKeys(args[1:])
+	if errMsg != "" {
+		return Value{typ: "error", str: errMsg}
+	}
+
+	f, ok := functions.functions[args[0].bulk]
+	if !ok {
+		return Value{typ: "error", str: "ERR Function not found"}
+	}
+	if readOnly && !f.readOnly() {
+		return Value{typ: "error", str: "ERR Can not execute a script with write flag using *_ro command."}
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: %s %s (%d keys)", command, f.name, len(keys)))
+
+	// KEYS and ARGV of the last EVAL are not for functions
+	L := scriptState()
+	L.G.Global.RawSetString("KEYS", lua.LNil)
+	L.G.Global.RawSetString("ARGV", lua.LNil)
+
+	return runLua(L, f.name, f.callback, f.readOnly(), luaStrings(L, keys), luaStrings(L, argv))
+}
+
+// FCALL command
+func fcall(args []Value) Value {
+	return callFunction("FCALL", args, false)
+}
+
+// FCALL_RO command: only runs functions flagged no-writes
+func fcallRo(args []Value) Value {
+	return callFunction("FCALL_RO", args, true)
+}
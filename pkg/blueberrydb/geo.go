@@ -0,0 +1,639 @@
+// geospatial commands: coordinates stored as geohash scores in sorted sets
+package blueberrydb
+
+import (
+	"blueberrydb/internal/logger"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// search area of GEOSEARCH, a circle or a box around a center point
+type geoShape struct {
+	byRadius   bool
+	longitude  float64
+	latitude   float64
+	radius     float64 // in the unit of the request
+	width      float64
+	height     float64
+	conversion float64 // meters per unit
+}
+
+// member matched by a search
+type geoPoint struct {
+	member    string
+	score     float64
+	longitude float64
+	latitude  float64
+	dist      float64 // in meters
+}
+
+// meters per unit accepted by the geo commands
+func geoUnitConversion(unit string) (float64, bool) {
+	switch strings.ToLower(unit) {
+	case "m":
+		return 1, true
+	case "km":
+		return 1000, true
+	case "ft":
+		return 0.3048, true
+	case "mi":
+		return 1609.34, true
+	}
+
+	return 0, false
+}
+
+// parses a longitude latitude pair and checks it can be geohash encoded
+func parseLongLat(longArg string, latArg string) (float64, float64, string) {
+	longitude, err1 := strconv.ParseFloat(longArg, 64)
+	latitude, err2 := strconv.ParseFloat(latArg, 64)
+	if err1 != nil || err2 != nil {
+		return 0, 0, "ERR value is not a valid float"
+	}
+
+	if longitude < geoLongMin || longitude > geoLongMax || latitude < geoLatMin || latitude > geoLatMax {
+		return 0, 0, fmt.Sprintf("ERR invalid longitude,latitude pair %f,%f", longitude, latitude)
+	}
+
+	return longitude, latitude, ""
+}
+
+// formats a coordinate, distances use fixed 4 decimals instead
+func formatCoordinate(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// longitude, latitude reply of GEOPOS and WITHCOORD
+func geoCoordinatesValue(longitude float64, latitude float64) Value {
+	return Value{typ: "array", array: []Value{
+		{typ: "bulk", bulk: formatCoordinate(longitude)},
+		{typ: "bulk", bulk: formatCoordinate(latitude)},
+	}}
+}
+
+// GEOADD command: adds members at the given coordinates
+func geoadd(args []Value) Value {
+	key := args[0].bulk
+	flags, start := parseZaddFlags(args[1:], "NX XX CH")
+	triplets := args[1+start:]
+
+	if len(triplets) == 0 || len(triplets)%3 != 0 {
+		return Value{typ: "error", str: "ERR syntax error"}
+	}
+
+	if errMsg := flags.validate(); errMsg != "" {
+		return Value{typ: "error", str: errMsg}
+	}
+
+	scores := make([]float64, 0, len(triplets)/3)
+	members := make([]string, 0, len(triplets)/3)
+	for i := 0; i < len(triplets); i += 3 {
+		longitude, latitude, errMsg := parseLongLat(triplets[i].bulk, triplets[i+1].bulk)
+		if errMsg != "" {
+			return Value{typ: "error", str: errMsg}
+		}
+
+		hash, _ := geohashEncodeWGS84(longitude, latitude, geoStepMax)
+		scores = append(scores, float64(hash.bits))
+		members = append(members, triplets[i+2].bulk)
+	}
+
+	ZSETsMu.Lock()
+	added, changed, _, _ := zaddGeneric(key, flags, scores, members)
+	ZSETsMu.Unlock()
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: GEOADD %s (%d members)", key, len(members)))
+
+	if flags.ch {
+		return Value{typ: "integer", num: added + changed}
+	}
+
+	return Value{typ: "integer", num: added}
+}
+
+// GEOPOS command: coordinates of members, null for missing ones
+func geopos(args []Value) Value {
+	key := args[0].bulk
+
+	ZSETsMu.RLock()
+	defer ZSETsMu.RUnlock()
+
+	zset := ZSETs[key]
+	values := make([]Value, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		if zset == nil {
+			values = append(values, Value{typ: "null"})
+			continue
+		}
+
+		score, ok := zset.score(arg.bulk)
+		if !ok {
+			values = append(values, Value{typ: "null"})
+			continue
+		}
+
+		longitude, latitude := geohashDecodeScore(score)
+		values = append(values, geoCoordinatesValue(longitude, latitude))
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: GEOPOS %s", key))
+
+	return Value{typ: "array", array: values}
+}
+
+// GEODIST command: distance between two members
+func geodist(args []Value) Value {
+	if len(args) != 3 && len(args) != 4 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'geodist' command"}
+	}
+
+	key := args[0].bulk
+
+	conversion := 1.0
+	if len(args) == 4 {
+		var ok bool
+		conversion, ok = geoUnitConversion(args[3].bulk)
+		if !ok {
+			return Value{typ: "error", str: "ERR unsupported unit provided. please use M, KM, FT, MI"}
+		}
+	}
+
+	ZSETsMu.RLock()
+	zset := ZSETs[key]
+	var score1, score2 float64
+	ok1, ok2 := false, false
+	if zset != nil {
+		score1, ok1 = zset.score(args[1].bulk)
+		score2, ok2 = zset.score(args[2].bulk)
+	}
+	ZSETsMu.RUnlock()
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: GEODIST %s %s %s", key, args[1].bulk, args[2].bulk))
+
+	if !ok1 || !ok2 {
+		return Value{typ: "null"}
+	}
+
+	long1, lat1 := geohashDecodeScore(score1)
+	long2, lat2 := geohashDecodeScore(score2)
+	dist := geohashGetDistance(long1, lat1, long2, lat2) / conversion
+
+	return Value{typ: "bulk", bulk: strconv.FormatFloat(dist, 'f', 4, 64)}
+}
+
+// GEOHASH command: standard 11 character geohash strings of members
+func geohashCommand(args []Value) Value {
+	const alphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+	key := args[0].bulk
+
+	ZSETsMu.RLock()
+	defer ZSETsMu.RUnlock()
+
+	zset := ZSETs[key]
+	values := make([]Value, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		var score float64
+		ok := false
+		if zset != nil {
+			score, ok = zset.score(arg.bulk)
+		}
+
+		if !ok {
+			values = append(values, Value{typ: "null"})
+			continue
+		}
+
+		// re-encode with the standard latitude range of -90..90 used by geohash.org
+		longitude, latitude := geohashDecodeScore(score)
+		hash, _ := geohashEncode(geoHashRange{-180, 180}, geoHashRange{-90, 90}, longitude, latitude, geoStepMax)
+
+		buf := make([]byte, 11)
+		for i := 0; i < 11; i++ {
+			// only 52 bits are available, the last character is always zero
+			idx := 0
+			if i < 10 {
+				idx = int(hash.bits>>(52-(uint(i)+1)*5)) & 0x1f
+			}
+			buf[i] = alphabet[idx]
+		}
+
+		values = append(values, Value{typ: "bulk", bulk: string(buf)})
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: GEOHASH %s", key))
+
+	return Value{typ: "array", array: values}
+}
+
+// bounding box of a shape as min longitude, min latitude, max longitude, max latitude
+func (s geoShape) boundingBox() (float64, float64, float64, float64) {
+	height := s.conversion * s.height / 2
+	width := s.conversion * s.width / 2
+	if s.byRadius {
+		height = s.conversion * s.radius
+		width = height
+	}
+
+	latDelta := radDeg(height / earthRadiusInMeters)
+	longDeltaTop := radDeg(width / earthRadiusInMeters / math.Cos(degRad(s.latitude+latDelta)))
+	longDeltaBottom := radDeg(width / earthRadiusInMeters / math.Cos(degRad(s.latitude-latDelta)))
+
+	// the hemispheres are mirrored, so the wider edge differs
+	if s.latitude < 0 {
+		return s.longitude - longDeltaBottom, s.latitude - latDelta, s.longitude + longDeltaBottom, s.latitude + latDelta
+	}
+
+	return s.longitude - longDeltaTop, s.latitude - latDelta, s.longitude + longDeltaTop, s.latitude + latDelta
+}
+
+// the geohash cell of the center and its neighbors that together cover the shape,
+// cells that cannot contain matches are left out
+func (s geoShape) searchAreas() []geoHashBits {
+	minLong, minLat, maxLong, maxLat := s.boundingBox()
+
+	// a box is covered by the circle through its corners
+	radiusMeters := s.radius
+	if !s.byRadius {
+		radiusMeters = math.Sqrt((s.width/2)*(s.width/2) + (s.height/2)*(s.height/2))
+	}
+	radiusMeters *= s.conversion
+
+	longRange := geoHashRange{geoLongMin, geoLongMax}
+	latRange := geoHashRange{geoLatMin, geoLatMax}
+
+	steps := geohashEstimateStepsByRadius(radiusMeters, s.latitude)
+	hash, _ := geohashEncode(longRange, latRange, s.longitude, s.latitude, steps)
+	neighbors := geohashNeighbors(hash)
+	area := geohashDecode(longRange, latRange, hash)
+
+	// the estimated step can be too coarse when the center is close to the edge of its cell
+	north := geohashDecode(longRange, latRange, neighbors.north)
+	south := geohashDecode(longRange, latRange, neighbors.south)
+	east := geohashDecode(longRange, latRange, neighbors.east)
+	west := geohashDecode(longRange, latRange, neighbors.west)
+
+	decreaseStep := north.latitude.max < maxLat || south.latitude.min > minLat ||
+		east.longitude.max < maxLong || west.longitude.min > minLong
+
+	if steps > 1 && decreaseStep {
+		steps--
+		hash, _ = geohashEncode(longRange, latRange, s.longitude, s.latitude, steps)
+		neighbors = geohashNeighbors(hash)
+		area = geohashDecode(longRange, latRange, hash)
+	}
+
+	exclude := map[*geoHashBits]bool{}
+	if steps >= 2 {
+		if area.latitude.min < minLat {
+			exclude[&neighbors.south] = true
+			exclude[&neighbors.southWest] = true
+			exclude[&neighbors.southEast] = true
+		}
+		if area.latitude.max > maxLat {
+			exclude[&neighbors.north] = true
+			exclude[&neighbors.northEast] = true
+			exclude[&neighbors.northWest] = true
+		}
+		if area.longitude.min < minLong {
+			exclude[&neighbors.west] = true
+			exclude[&neighbors.southWest] = true
+			exclude[&neighbors.northWest] = true
+		}
+		if area.longitude.max > maxLong {
+			exclude[&neighbors.east] = true
+			exclude[&neighbors.southEast] = true
+			exclude[&neighbors.northEast] = true
+		}
+	}
+
+	candidates := []*geoHashBits{
+		&hash, &neighbors.north, &neighbors.south, &neighbors.east, &neighbors.west,
+		&neighbors.northEast, &neighbors.northWest, &neighbors.southEast, &neighbors.southWest,
+	}
+
+	// near the poles several neighbors can be the same cell
+	seen := map[geoHashBits]bool{}
+	areas := []geoHashBits{}
+	for _, candidate := range candidates {
+		if exclude[candidate] || seen[*candidate] {
+			continue
+		}
+		seen[*candidate] = true
+		areas = append(areas, *candidate)
+	}
+
+	return areas
+}
+
+// distance of a point from the center if it lies inside the shape
+func (s geoShape) distanceIfInside(longitude float64, latitude float64) (float64, bool) {
+	if s.byRadius {
+		dist := geohashGetDistance(s.longitude, s.latitude, longitude, latitude)
+		return dist, dist <= s.radius*s.conversion
+	}
+
+	// latitude distance is cheaper, so it is checked first
+	if geohashGetLatDistance(latitude, s.latitude) > s.height*s.conversion/2 {
+		return 0, false
+	}
+
+	if geohashGetDistance(longitude, latitude, s.longitude, latitude) > s.width*s.conversion/2 {
+		return 0, false
+	}
+
+	return geohashGetDistance(s.longitude, s.latitude, longitude, latitude), true
+}
+
+// members of the sorted set inside the shape, stops after limit matches when limit > 0, caller must hold ZSETsMu
+func geoSearchMembers(zset *SortedSet, shape geoShape, limit int) []geoPoint {
+	points := []geoPoint{}
+
+	for _, area := range shape.searchAreas() {
+		// scores of every member inside the cell share the cell bits as prefix
+		shift := 52 - area.step*2
+		min := float64(area.bits << shift)
+		max := float64((area.bits + 1) << shift)
+
+		zset.scoreRange(min, max, func(member string, score float64) bool {
+			longitude, latitude := geohashDecodeScore(score)
+			if dist, ok := shape.distanceIfInside(longitude, latitude); ok {
+				points = append(points, geoPoint{member: member, score: score, longitude: longitude, latitude: latitude, dist: dist})
+			}
+
+			return limit == 0 || len(points) < limit
+		})
+
+		if limit > 0 && len(points) >= limit {
+			break
+		}
+	}
+
+	return points
+}
+
+// options of GEOSEARCH and GEOSEARCHSTORE
+type geoSearchOptions struct {
+	shape      geoShape
+	fromMember string
+	useMember  bool
+	sort       string // "", "ASC" or "DESC"
+	count      int
+	any        bool
+	withCoord  bool
+	withDist   bool
+	withHash   bool
+	storeDist  bool
+}
+
+// parses everything after the source key of GEOSEARCH and GEOSEARCHSTORE
+func parseGeoSearch(args []Value, store bool) (geoSearchOptions, string) {
+	opts := geoSearchOptions{}
+	fromSet, bySet := false, false
+
+	for i := 0; i < len(args); i++ {
+		remaining := len(args) - i - 1
+
+		switch option := strings.ToUpper(args[i].bulk); {
+		case option == "FROMMEMBER" && remaining >= 1:
+			if fromSet {
+				return opts, "ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH"
+			}
+			opts.fromMember = args[i+1].bulk
+			opts.useMember = true
+			fromSet = true
+			i++
+
+		case option == "FROMLONLAT" && remaining >= 2:
+			if fromSet {
+				return opts, "ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH"
+			}
+			longitude, latitude, errMsg := parseLongLat(args[i+1].bulk, args[i+2].bulk)
+			if errMsg != "" {
+				return opts, errMsg
+			}
+			opts.shape.longitude = longitude
+			opts.shape.latitude = latitude
+			fromSet = true
+			i += 2
+
+		case option == "BYRADIUS" && remaining >= 2:
+			if bySet {
+				return opts, "ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH"
+			}
+			radius, err := strconv.ParseFloat(args[i+1].bulk, 64)
+			if err != nil || radius < 0 {
+				return opts, "ERR need numeric radius"
+			}
+			conversion, ok := geoUnitConversion(args[i+2].bulk)
+			if !ok {
+				return opts, "ERR unsupported unit provided. please use M, KM, FT, MI"
+			}
+			opts.shape.byRadius = true
+			opts.shape.radius = radius
+			opts.shape.conversion = conversion
+			bySet = true
+			i += 2
+
+		case option == "BYBOX" && remaining >= 3:
+			if bySet {
+				return opts, "ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH"
+			}
+			width, err1 := strconv.ParseFloat(args[i+1].bulk, 64)
+			height, err2 := strconv.ParseFloat(args[i+2].bulk, 64)
+			if err1 != nil || err2 != nil || width < 0 || height < 0 {
+				return opts, "ERR need numeric width and height"
+			}
+			conversion, ok := geoUnitConversion(args[i+3].bulk)
+			if !ok {
+				return opts, "ERR unsupported unit provided. please use M, KM, FT, MI"
+			}
+			opts.shape.width = width
+			opts.shape.height = height
+			opts.shape.conversion = conversion
+			bySet = true
+			i += 3
+
+		case option == "ASC" || option == "DESC":
+			opts.sort = option
+
+		case option == "COUNT" && remaining >= 1:
+			count, err := strconv.Atoi(args[i+1].bulk)
+			if err != nil || count <= 0 {
+				return opts, "ERR COUNT must be > 0"
+			}
+			opts.count = count
+			i++
+			if i+1 < len(args) && strings.ToUpper(args[i+1].bulk) == "ANY" {
+				opts.any = true
+				i++
+			}
+
+		case option == "WITHCOORD" && !store:
+			opts.withCoord = true
+		case option == "WITHDIST" && !store:
+			opts.withDist = true
+		case option == "WITHHASH" && !store:
+			opts.withHash = true
+		case option == "STOREDIST" && store:
+			opts.storeDist = true
+
+		default:
+			return opts, "ERR syntax error"
+		}
+	}
+
+	if !fromSet {
+		return opts, "ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH"
+	}
+
+	if !bySet {
+		return opts, "ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH"
+	}
+
+	// a plain COUNT returns the closest matches
+	if opts.count > 0 && opts.sort == "" && !opts.any {
+		opts.sort = "ASC"
+	}
+
+	return opts, ""
+}
+
+// runs a parsed search against a key, caller must hold ZSETsMu
+func geoSearch(key string, opts geoSearchOptions) ([]geoPoint, string) {
+	zset, ok := ZSETs[key]
+	if !ok {
+		return []geoPoint{}, ""
+	}
+
+	shape := opts.shape
+	if opts.useMember {
+		score, ok := zset.score(opts.fromMember)
+		if !ok {
+			return nil, "ERR could not decode requested zset member"
+		}
+		shape.longitude, shape.latitude = geohashDecodeScore(score)
+	}
+
+	// without ANY every match has to be found before the closest can be picked
+	limit := 0
+	if opts.any {
+		limit = opts.count
+	}
+	points := geoSearchMembers(zset, shape, limit)
+
+	switch opts.sort {
+	case "ASC":
+		sort.SliceStable(points, func(i, j int) bool { return points[i].dist < points[j].dist })
+	case "DESC":
+		sort.SliceStable(points, func(i, j int) bool { return points[i].dist > points[j].dist })
+	}
+
+	if opts.count > 0 && len(points) > opts.count {
+		points = points[:opts.count]
+	}
+
+	return points, ""
+}
+
+// GEOSEARCH command: members inside a circle or box
+func geosearch(args []Value) Value {
+	key := args[0].bulk
+
+	opts, errMsg := parseGeoSearch(args[1:], false)
+	if errMsg != "" {
+		return Value{typ: "error", str: errMsg}
+	}
+
+	ZSETsMu.RLock()
+	points, errMsg := geoSearch(key, opts)
+	ZSETsMu.RUnlock()
+
+	if errMsg != "" {
+		return Value{typ: "error", str: errMsg}
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: GEOSEARCH %s (%d matches)", key, len(points)))
+
+	values := make([]Value, 0, len(points))
+	for _, point := range points {
+		if !opts.withCoord && !opts.withDist && !opts.withHash {
+			values = append(values, Value{typ: "bulk", bulk: point.member})
+			continue
+		}
+
+		item := []Value{{typ: "bulk", bulk: point.member}}
+		if opts.withDist {
+			dist := point.dist / opts.shape.conversion
+			item = append(item, Value{typ: "bulk", bulk: strconv.FormatFloat(dist, 'f', 4, 64)})
+		}
+		if opts.withHash {
+			item = append(item, Value{typ: "integer", num: int(point.score)})
+		}
+		if opts.withCoord {
+			item = append(item, geoCoordinatesValue(point.longitude, point.latitude))
+		}
+
+		values = append(values, Value{typ: "array", array: item})
+	}
+
+	return Value{typ: "array", array: values}
+}
+
+// GEOSEARCHSTORE command: stores the matches of a search as a sorted set
+func geosearchstore(args []Value) Value {
+	dest := args[0].bulk
+	key := args[1].bulk
+
+	opts, errMsg := parseGeoSearch(args[2:], true)
+	if errMsg != "" {
+		return Value{typ: "error", str: errMsg}
+	}
+
+	// the destination may hold a value of any type
+	SETsMu.Lock()
+	HSETsMu.Lock()
+	ZSETsMu.Lock()
+	STREAMsMu.Lock()
+	defer SETsMu.Unlock()
+	defer HSETsMu.Unlock()
+	defer ZSETsMu.Unlock()
+	defer STREAMsMu.Unlock()
+
+	points, errMsg := geoSearch(key, opts)
+	if errMsg != "" {
+		return Value{typ: "error", str: errMsg}
+	}
+
+	// the destination is replaced, an empty result removes it
+	if databases[selectedDB].remove(dest) && len(points) == 0 {
+		notifyKeyspaceEvent(notifyGeneric, "del", dest)
+	}
+	if len(points) == 0 {
+		return Value{typ: "integer", num: 0}
+	}
+
+	zset := newSortedSet()
+	for _, point := range points {
+		score := point.score
+		if opts.storeDist {
+			score = point.dist / opts.shape.conversion
+		}
+		zset.set(point.member, score)
+	}
+	ZSETs[dest] = zset
+	keyspaceAdd(dest, keyZset)
+	notifyKeyspaceEvent(notifyZset, "geosearchstore", dest)
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: GEOSEARCHSTORE %s %s (%d matches)", dest, key, len(points)))
+
+	return Value{typ: "integer", num: len(points)}
+}
@@ -0,0 +1,250 @@
+// geohash encoding of coordinates into 52 bit sorted set scores, same layout as redis
+package blueberrydb
+
+import (
+	"math"
+)
+
+const (
+	geoStepMax = 26 // 26 steps per coordinate, 52 bits in total
+
+	geoLatMin  = -85.05112878
+	geoLatMax  = 85.05112878
+	geoLongMin = -180.0
+	geoLongMax = 180.0
+
+	earthRadiusInMeters = 6372797.560856
+	mercatorMax         = 20037726.37
+)
+
+type geoHashRange struct {
+	min, max float64
+}
+
+// interleaved latitude (even bits) and longitude (odd bits) offsets
+type geoHashBits struct {
+	bits uint64
+	step uint
+}
+
+type geoHashArea struct {
+	hash      geoHashBits
+	longitude geoHashRange
+	latitude  geoHashRange
+}
+
+// the eight cells around a geohash cell
+type geoHashNeighbors struct {
+	north, east, west, south                   geoHashBits
+	northEast, southEast, northWest, southWest geoHashBits
+}
+
+// spreads the 32 bits of x and y over the even and odd bits of the result
+func interleave64(xlo uint32, ylo uint32) uint64 {
+	b := []uint64{0x5555555555555555, 0x3333333333333333, 0x0F0F0F0F0F0F0F0F, 0x00FF00FF00FF00FF, 0x0000FFFF0000FFFF}
+	s := []uint{1, 2, 4, 8, 16}
+
+	x := uint64(xlo)
+	y := uint64(ylo)
+	for i := 4; i >= 0; i-- {
+		x = (x | (x << s[i])) & b[i]
+		y = (y | (y << s[i])) & b[i]
+	}
+
+	return x | (y << 1)
+}
+
+// reverse of interleave64, even bits end up in the low half and odd bits in the high half
+func deinterleave64(interleaved uint64) uint64 {
+	b := []uint64{0x5555555555555555, 0x3333333333333333, 0x0F0F0F0F0F0F0F0F, 0x00FF00FF00FF00FF, 0x0000FFFF0000FFFF, 0x00000000FFFFFFFF}
+	s := []uint{0, 1, 2, 4, 8, 16}
+
+	x := interleaved
+	y := interleaved >> 1
+	for i := 0; i < 6; i++ {
+		x = (x | (x >> s[i])) & b[i]
+		y = (y | (y >> s[i])) & b[i]
+	}
+
+	return x | (y << 32)
+}
+
+// encodes a coordinate pair with the given precision
+func geohashEncode(longRange geoHashRange, latRange geoHashRange, longitude float64, latitude float64, step uint) (geoHashBits, bool) {
+	if longitude > geoLongMax || longitude < geoLongMin || latitude > geoLatMax || latitude < geoLatMin {
+		return geoHashBits{}, false
+	}
+
+	if latitude < latRange.min || latitude > latRange.max || longitude < longRange.min || longitude > longRange.max {
+		return geoHashBits{}, false
+	}
+
+	latOffset := (latitude - latRange.min) / (latRange.max - latRange.min)
+	longOffset := (longitude - longRange.min) / (longRange.max - longRange.min)
+
+	// convert to fixed point based on the step size
+	latOffset *= float64(uint64(1) << step)
+	longOffset *= float64(uint64(1) << step)
+
+	return geoHashBits{bits: interleave64(uint32(latOffset), uint32(longOffset)), step: step}, true
+}
+
+// encodes with the ranges used for sorted set scores
+func geohashEncodeWGS84(longitude float64, latitude float64, step uint) (geoHashBits, bool) {
+	return geohashEncode(geoHashRange{geoLongMin, geoLongMax}, geoHashRange{geoLatMin, geoLatMax}, longitude, latitude, step)
+}
+
+// area covered by a geohash cell
+func geohashDecode(longRange geoHashRange, latRange geoHashRange, hash geoHashBits) geoHashArea {
+	separated := deinterleave64(hash.bits)
+
+	latScale := latRange.max - latRange.min
+	longScale := longRange.max - longRange.min
+
+	ilato := uint32(separated)
+	ilono := uint32(separated >> 32)
+	cells := float64(uint64(1) << hash.step)
+
+	return geoHashArea{
+		hash: hash,
+		latitude: geoHashRange{
+			min: latRange.min + (float64(ilato)/cells)*latScale,
+			max: latRange.min + ((float64(ilato)+1)/cells)*latScale,
+		},
+		longitude: geoHashRange{
+			min: longRange.min + (float64(ilono)/cells)*longScale,
+			max: longRange.min + ((float64(ilono)+1)/cells)*longScale,
+		},
+	}
+}
+
+// center of a geohash cell, clamped to the valid coordinate range
+func geohashDecodeToLongLat(hash geoHashBits) (float64, float64) {
+	area := geohashDecode(geoHashRange{geoLongMin, geoLongMax}, geoHashRange{geoLatMin, geoLatMax}, hash)
+
+	longitude := (area.longitude.min + area.longitude.max) / 2
+	longitude = min(max(longitude, geoLongMin), geoLongMax)
+
+	latitude := (area.latitude.min + area.latitude.max) / 2
+	latitude = min(max(latitude, geoLatMin), geoLatMax)
+
+	return longitude, latitude
+}
+
+// coordinates of a 52 bit sorted set score
+func geohashDecodeScore(score float64) (float64, float64) {
+	return geohashDecodeToLongLat(geoHashBits{bits: uint64(score), step: geoStepMax})
+}
+
+// moves a geohash east (d > 0) or west (d < 0)
+func geohashMoveX(hash *geoHashBits, d int) {
+	if d == 0 {
+		return
+	}
+
+	x := hash.bits & 0xaaaaaaaaaaaaaaaa
+	y := hash.bits & 0x5555555555555555
+
+	zz := uint64(0x5555555555555555) >> (64 - hash.step*2)
+	if d > 0 {
+		x = x + (zz + 1)
+	} else {
+		x = x | zz
+		x = x - (zz + 1)
+	}
+
+	x &= uint64(0xaaaaaaaaaaaaaaaa) >> (64 - hash.step*2)
+	hash.bits = x | y
+}
+
+// moves a geohash north (d > 0) or south (d < 0)
+func geohashMoveY(hash *geoHashBits, d int) {
+	if d == 0 {
+		return
+	}
+
+	x := hash.bits & 0xaaaaaaaaaaaaaaaa
+	y := hash.bits & 0x5555555555555555
+
+	zz := uint64(0xaaaaaaaaaaaaaaaa) >> (64 - hash.step*2)
+	if d > 0 {
+		y = y + (zz + 1)
+	} else {
+		y = y | zz
+		y = y - (zz + 1)
+	}
+
+	y &= uint64(0x5555555555555555) >> (64 - hash.step*2)
+	hash.bits = x | y
+}
+
+// cells surrounding a geohash cell at the same precision
+func geohashNeighbors(hash geoHashBits) geoHashNeighbors {
+	move := func(dx int, dy int) geoHashBits {
+		neighbor := hash
+		geohashMoveX(&neighbor, dx)
+		geohashMoveY(&neighbor, dy)
+		return neighbor
+	}
+
+	return geoHashNeighbors{
+		east:      move(1, 0),
+		west:      move(-1, 0),
+		south:     move(0, -1),
+		north:     move(0, 1),
+		northWest: move(-1, 1),
+		southWest: move(-1, -1),
+		northEast: move(1, 1),
+		southEast: move(1, -1),
+	}
+}
+
+func degRad(angle float64) float64 {
+	return angle * (math.Pi / 180.0)
+}
+
+func radDeg(angle float64) float64 {
+	return angle / (math.Pi / 180.0)
+}
+
+// great circle distance in meters using the haversine formula
+func geohashGetDistance(lon1d float64, lat1d float64, lon2d float64, lat2d float64) float64 {
+	lat1r := degRad(lat1d)
+	lon1r := degRad(lon1d)
+	lat2r := degRad(lat2d)
+	lon2r := degRad(lon2d)
+
+	u := math.Sin((lat2r - lat1r) / 2)
+	v := math.Sin((lon2r - lon1r) / 2)
+
+	return 2.0 * earthRadiusInMeters * math.Asin(math.Sqrt(u*u+math.Cos(lat1r)*math.Cos(lat2r)*v*v))
+}
+
+// distance along a meridian, cheaper than the full formula
+func geohashGetLatDistance(lat1d float64, lat2d float64) float64 {
+	return earthRadiusInMeters * math.Abs(degRad(lat2d)-degRad(lat1d))
+}
+
+// precision whose cells are roughly as large as the search radius
+func geohashEstimateStepsByRadius(rangeMeters float64, latitude float64) uint {
+	if rangeMeters == 0 {
+		return geoStepMax
+	}
+
+	step := 1
+	for rangeMeters < mercatorMax {
+		rangeMeters *= 2
+		step++
+	}
+	step -= 2 // make sure the range is included in most of the base cases
+
+	// wider range towards the poles
+	if latitude > 66 || latitude < -66 {
+		step--
+		if latitude > 80 || latitude < -80 {
+			step--
+		}
+	}
+
+	return uint(min(max(step, 1), geoStepMax))
+}
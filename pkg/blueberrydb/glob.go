@@ -0,0 +1,104 @@
+// glob style pattern matching shared by pattern subscriptions and key listings
+package blueberrydb
+
+// matches str against pattern supporting *, ?, [abc], [^a-z] and \ escapes,
+// a failed match backtracks to the last * only, so matching stays linear in practice
+func globMatch(pattern string, str string) bool {
+	p, s := 0, 0
+	starP, starS := -1, -1
+
+	for s < len(str) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				for p < len(pattern) && pattern[p] == '*' {
+					p++
+				}
+				if p == len(pattern) {
+					return true
+				}
+				starP, starS = p, s
+				continue
+
+			case '?':
+				p++
+				s++
+				continue
+
+			case '[':
+				if matched, next := globClass(pattern, p, str[s]); matched {
+					p = next
+					s++
+					continue
+				}
+
+			case '\\':
+				if p+1 < len(pattern) && pattern[p+1] == str[s] {
+					p += 2
+					s++
+					continue
+				}
+				if p+1 == len(pattern) && str[s] == '\\' {
+					p++
+					s++
+					continue
+				}
+
+			default:
+				if pattern[p] == str[s] {
+					p++
+					s++
+					continue
+				}
+			}
+		}
+
+		// let the last * swallow one more character and retry
+		if starP < 0 {
+			return false
+		}
+		starS++
+		p, s = starP, starS
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+
+	return p == len(pattern)
+}
+
+// matches c against the class starting at pattern[i], returns the index after the class
+func globClass(pattern string, i int, c byte) (bool, int) {
+	i++
+	negate := i < len(pattern) && pattern[i] == '^'
+	if negate {
+		i++
+	}
+
+	matched := false
+	for i < len(pattern) && pattern[i] != ']' {
+		switch {
+		case pattern[i] == '\\' && i+1 < len(pattern):
+			i++
+			matched = matched || pattern[i] == c
+		case i+2 < len(pattern) && pattern[i+1] == '-':
+			start, end := pattern[i], pattern[i+2]
+			if start > end {
+				start, end = end, start
+			}
+			matched = matched || (c >= start && c <= end)
+			i += 2
+		default:
+			matched = matched || pattern[i] == c
+		}
+		i++
+	}
+
+	// an unterminated class runs to the end of the pattern
+	if i < len(pattern) {
+		i++
+	}
+
+	return matched != negate, i
+}
@@ -0,0 +1,499 @@
+// hash commands: field-value maps stored under a single key
+package blueberrydb
+
+import (
+	"blueberrydb/internal/logger"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type HashFieldStruct struct {
+	value     string
+	expiresAt int64 // unix milliseconds, 0 -> no expiration
+}
+
+var HSETs = map[string]map[string]HashFieldStruct{}
+var HSETsMu = sync.RWMutex{}
+
+// reports whether the field is past its expiration time
+func (f HashFieldStruct) expired(now int64) bool {
+	return f.expiresAt > 0 && now >= f.expiresAt
+}
+
+// returns the field if it exists and is not expired, caller must hold HSETsMu
+func hashLiveField(hash string, field string) (HashFieldStruct, bool) {
+	entry, ok := HSETs[hash][field]
+	if !ok || entry.expired(time.Now().UnixMilli()) {
+		return HashFieldStruct{}, false
+	}
+
+	return entry, true
+}
+
+// removes an expired field and the hash if it became empty, caller must hold the HSETsMu write lock
+func hashReclaimField(hash string, field string) {
+	entry, ok := HSETs[hash][field]
+	if !ok || !entry.expired(time.Now().UnixMilli()) {
+		return
+	}
+
+	delete(HSETs[hash], field)
+	notifyKeyspaceEvent(notifyHash, "hexpired", hash)
+	if len(HSETs[hash]) == 0 {
+		delete(HSETs, hash)
+		keyspaceRemove(hash, keyHash)
+		notifyKeyspaceEvent(notifyGeneric, "del", hash)
+	}
+}
+
+// returns the live fields of a hash, caller must hold HSETsMu
+func hashLiveFields(hash string) map[string]string {
+	now := time.Now().UnixMilli()
+
+	fields := make(map[string]string, len(HSETs[hash]))
+	for field, entry := range HSETs[hash] {
+		if !entry.expired(now) {
+			fields[field] = entry.value
+		}
+	}
+
+	return fields
+}
+
+// HSET command: set one or more field-value pairs, returns the number of new fields
+func hset(args []Value) Value {
+	if len(args) < 3 || len(args)%2 != 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'hset' command"}
+	}
+
+	hash := args[0].bulk
+	added := hashSetFields(hash, args[1:])
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: HSET %s (%d fields)", hash, (len(args)-1)/2))
+
+	return Value{typ: "integer", num: added}
+}
+
+// HMSET command: deprecated form of HSET that replies OK
+func hmset(args []Value) Value {
+	if len(args) < 3 || len(args)%2 != 1 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'hmset' command"}
+	}
+
+	hash := args[0].bulk
+	hashSetFields(hash, args[1:])
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: HMSET %s (%d fields)", hash, (len(args)-1)/2))
+
+	return Value{typ: "string", str: "OK"}
+}
+
+// set field-value pairs on a hash, creating it if needed, returns the number of new fields
+func hashSetFields(hash string, pairs []Value) int {
+	added := 0
+
+	// acquire writer's lock, set and unlock
+	HSETsMu.Lock()
+	defer HSETsMu.Unlock()
+
+	if _, ok := HSETs[hash]; !ok {
+		HSETs[hash] = map[string]HashFieldStruct{}
+		keyspaceAdd(hash, keyHash)
+	}
+
+	// overwriting a field also clears its expiration
+	for i := 0; i < len(pairs); i += 2 {
+		field := pairs[i].bulk
+		if _, exists := hashLiveField(hash, field); !exists {
+			added++
+		}
+		HSETs[hash][field] = HashFieldStruct{value: pairs[i+1].bulk}
+	}
+
+	notifyKeyspaceEvent(notifyHash, "hset", hash)
+
+	return added
+}
+
+// HSETNX command: set a field only if it does not exist yet
+func hsetnx(args []Value) Value {
+	hash := args[0].bulk
+	field := args[1].bulk
+	value := args[2].bulk
+
+	HSETsMu.Lock()
+	defer HSETsMu.Unlock()
+
+	if _, exists := hashLiveField(hash, field); exists {
+		return Value{typ: "integer", num: 0}
+	}
+
+	if _, ok := HSETs[hash]; !ok {
+		HSETs[hash] = map[string]HashFieldStruct{}
+		keyspaceAdd(hash, keyHash)
+	}
+	HSETs[hash][field] = HashFieldStruct{value: value}
+	notifyKeyspaceEvent(notifyHash, "hset", hash)
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: HSETNX %s %s %s", hash, field, value))
+
+	return Value{typ: "integer", num: 1}
+}
+
+// HGET command
+func hget(args []Value) Value {
+	hash := args[0].bulk
+	key := args[1].bulk
+
+	// acquire readers' lock, read and unlock
+	HSETsMu.RLock()
+	entry, ok := HSETs[hash][key]
+	HSETsMu.RUnlock()
+
+	// null check
+	if !ok {
+		return Value{typ: "null"}
+	}
+
+	// check if field is expired
+	if entry.expired(time.Now().UnixMilli()) {
+		// delete the expired field and return null
+		HSETsMu.Lock()
+		hashReclaimField(hash, key)
+		HSETsMu.Unlock()
+
+		return Value{typ: "null"}
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: HGET %s %s", hash, key))
+
+	return Value{typ: "bulk", bulk: entry.value}
+}
+
+// HMGET command: values of the given fields, null for missing ones
+func hmget(args []Value) Value {
+	hash := args[0].bulk
+
+	HSETsMu.RLock()
+	defer HSETsMu.RUnlock()
+
+	values := make([]Value, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		entry, ok := hashLiveField(hash, arg.bulk)
+		if !ok {
+			values = append(values, Value{typ: "null"})
+			continue
+		}
+		values = append(values, Value{typ: "bulk", bulk: entry.value})
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: HMGET %s (%d fields)", hash, len(args)-1))
+
+	return Value{typ: "array", array: values}
+}
+
+// HDEL command: remove fields, the hash itself is removed once empty
+func hdel(args []Value) Value {
+	hash := args[0].bulk
+	deleted := 0
+
+	HSETsMu.Lock()
+	fields, ok := HSETs[hash]
+	if ok {
+		now := time.Now().UnixMilli()
+		for _, arg := range args[1:] {
+			if entry, exists := fields[arg.bulk]; exists {
+				delete(fields, arg.bulk)
+
+				// expired fields are reclaimed but not reported
+				if !entry.expired(now) {
+					deleted++
+				}
+			}
+		}
+
+		if deleted > 0 {
+			notifyKeyspaceEvent(notifyHash, "hdel", hash)
+		}
+
+		if len(fields) == 0 {
+			delete(HSETs, hash)
+			keyspaceRemove(hash, keyHash)
+			notifyKeyspaceEvent(notifyGeneric, "del", hash)
+		}
+	}
+	HSETsMu.Unlock()
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: HDEL %s (%d deleted)", hash, deleted))
+
+	return Value{typ: "integer", num: deleted}
+}
+
+// HGETALL command: flat array of field, value pairs
+func hgetall(args []Value) Value {
+	hash := args[0].bulk
+
+	HSETsMu.RLock()
+	defer HSETsMu.RUnlock()
+
+	fields := hashLiveFields(hash)
+	values := make([]Value, 0, len(fields)*2)
+	for field, value := range fields {
+		values = append(values, Value{typ: "bulk", bulk: field}, Value{typ: "bulk", bulk: value})
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: HGETALL %s", hash))
+
+	return Value{typ: "array", array: values}
+}
+
+// HKEYS command
+func hkeys(args []Value) Value {
+	hash := args[0].bulk
+
+	HSETsMu.RLock()
+	defer HSETsMu.RUnlock()
+
+	fields := hashLiveFields(hash)
+	values := make([]Value, 0, len(fields))
+	for field := range fields {
+		values = append(values, Value{typ: "bulk", bulk: field})
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: HKEYS %s", hash))
+
+	return Value{typ: "array", array: values}
+}
+
+// HVALS command
+func hvals(args []Value) Value {
+	hash := args[0].bulk
+
+	HSETsMu.RLock()
+	defer HSETsMu.RUnlock()
+
+	fields := hashLiveFields(hash)
+	values := make([]Value, 0, len(fields))
+	for _, value := range fields {
+		values = append(values, Value{typ: "bulk", bulk: value})
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: HVALS %s", hash))
+
+	return Value{typ: "array", array: values}
+}
+
+// HLEN command
+func hlen(args []Value) Value {
+	hash := args[0].bulk
+
+	HSETsMu.RLock()
+	length := len(hashLiveFields(hash))
+	HSETsMu.RUnlock()
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: HLEN %s", hash))
+
+	return Value{typ: "integer", num: length}
+}
+
+// HEXISTS command
+func hexists(args []Value) Value {
+	hash := args[0].bulk
+	field := args[1].bulk
+
+	HSETsMu.RLock()
+	_, ok := hashLiveField(hash, field)
+	HSETsMu.RUnlock()
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: HEXISTS %s %s", hash, field))
+
+	if !ok {
+		return Value{typ: "integer", num: 0}
+	}
+
+	return Value{typ: "integer", num: 1}
+}
+
+// HSTRLEN command: length of the value stored at field
+func hstrlen(args []Value) Value {
+	hash := args[0].bulk
+	field := args[1].bulk
+
+	HSETsMu.RLock()
+	entry, _ := hashLiveField(hash, field)
+	HSETsMu.RUnlock()
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: HSTRLEN %s %s", hash, field))
+
+	return Value{typ: "integer", num: len(entry.value)}
+}
+
+// HINCRBY command: increment the integer stored at field
+func hincrby(args []Value) Value {
+	hash := args[0].bulk
+	field := args[1].bulk
+
+	increment, err := strconv.ParseInt(args[2].bulk, 10, 64)
+	if err != nil {
+		return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+	}
+
+	HSETsMu.Lock()
+	defer HSETsMu.Unlock()
+
+	var current int64
+	entry, ok := hashLiveField(hash, field)
+	if ok {
+		current, err = strconv.ParseInt(entry.value, 10, 64)
+		if err != nil {
+			return Value{typ: "error", str: "ERR hash value is not an integer"}
+		}
+	}
+
+	// reject results that do not fit in a signed 64 bit integer
+	if (increment > 0 && current > math.MaxInt64-increment) || (increment < 0 && current < math.MinInt64-increment) {
+		return Value{typ: "error", str: "ERR increment or decrement would overflow"}
+	}
+	current += increment
+
+	// the field keeps its expiration time
+	if _, ok := HSETs[hash]; !ok {
+		HSETs[hash] = map[string]HashFieldStruct{}
+		keyspaceAdd(hash, keyHash)
+	}
+	entry.value = strconv.FormatInt(current, 10)
+	HSETs[hash][field] = entry
+	notifyKeyspaceEvent(notifyHash, "hincrby", hash)
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: HINCRBY %s %s %d", hash, field, increment))
+
+	return Value{typ: "integer", num: int(current)}
+}
+
+// HINCRBYFLOAT command: increment the float stored at field
+func hincrbyfloat(args []Value) Value {
+	hash := args[0].bulk
+	field := args[1].bulk
+
+	increment, err := strconv.ParseFloat(args[2].bulk, 64)
+	if err != nil || math.IsNaN(increment) || math.IsInf(increment, 0) {
+		return Value{typ: "error", str: "ERR value is not a valid float"}
+	}
+
+	HSETsMu.Lock()
+	defer HSETsMu.Unlock()
+
+	var current float64
+	entry, ok := hashLiveField(hash, field)
+	if ok {
+		current, err = strconv.ParseFloat(entry.value, 64)
+		if err != nil {
+			return Value{typ: "error", str: "ERR hash value is not a float"}
+		}
+	}
+
+	current += increment
+	if math.IsNaN(current) || math.IsInf(current, 0) {
+		return Value{typ: "error", str: "ERR increment would produce NaN or Infinity"}
+	}
+
+	result := strconv.FormatFloat(current, 'f', -1, 64)
+
+	if _, ok := HSETs[hash]; !ok {
+		HSETs[hash] = map[string]HashFieldStruct{}
+		keyspaceAdd(hash, keyHash)
+	}
+	entry.value = result
+	HSETs[hash][field] = entry
+	notifyKeyspaceEvent(notifyHash, "hincrbyfloat", hash)
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: HINCRBYFLOAT %s %s %s", hash, field, args[2].bulk))
+
+	return Value{typ: "bulk", bulk: result}
+}
+
+// HRANDFIELD command: random fields, a negative count allows the same field more than once
+func hrandfield(args []Value) Value {
+	if len(args) < 1 || len(args) > 3 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'hrandfield' command"}
+	}
+
+	hash := args[0].bulk
+
+	// single field without count
+	if len(args) == 1 {
+		HSETsMu.RLock()
+		defer HSETsMu.RUnlock()
+
+		for field := range hashLiveFields(hash) {
+			return Value{typ: "bulk", bulk: field}
+		}
+
+		return Value{typ: "null"}
+	}
+
+	count, err := strconv.Atoi(args[1].bulk)
+	if err != nil {
+		return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+	}
+
+	withValues := false
+	if len(args) == 3 {
+		if strings.ToUpper(args[2].bulk) != "WITHVALUES" {
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+		withValues = true
+	}
+
+	HSETsMu.RLock()
+	defer HSETsMu.RUnlock()
+
+	live := hashLiveFields(hash)
+	fields := make([]string, 0, len(live))
+	for field := range live {
+		fields = append(fields, field)
+	}
+
+	var picked []string
+	if count >= 0 {
+		// distinct fields in random order
+		rand.Shuffle(len(fields), func(i, j int) { fields[i], fields[j] = fields[j], fields[i] })
+		picked = fields[:min(count, len(fields))]
+	} else if len(fields) > 0 {
+		for i := 0; i < -count; i++ {
+			picked = append(picked, fields[rand.Intn(len(fields))])
+		}
+	}
+
+	values := make([]Value, 0, len(picked))
+	for _, field := range picked {
+		values = append(values, Value{typ: "bulk", bulk: field})
+		if withValues {
+			values = append(values, Value{typ: "bulk", bulk: live[field]})
+		}
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: HRANDFIELD %s %d", hash, count))
+
+	return Value{typ: "array", array: values}
+}
@@ -0,0 +1,347 @@
+// per-field expiration of hash fields: HEXPIRE, HPEXPIRE, HEXPIREAT, HPEXPIREAT, HTTL, HPTTL and HPERSIST
+package blueberrydb
+
+import (
+	"blueberrydb/internal/logger"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hashes with at least one field that has an expiration, guarded by HSETsMu
+var volatileHashes = map[string]struct{}{}
+
+// parses the "FIELDS numfields field [field ...]" tail of a hash field command
+func parseHashFields(args []Value) ([]string, string) {
+	if len(args) < 2 || strings.ToUpper(args[0].bulk) != "FIELDS" {
+		return nil, "ERR syntax error"
+	}
+
+	numFields, err := strconv.Atoi(args[1].bulk)
+	if err != nil || numFields <= 0 {
+		return nil, "ERR Parameter `numFields` should be greater than 0"
+	}
+
+	if numFields != len(args)-2 {
+		return nil, "ERR The `numfields` parameter must match the number of arguments"
+	}
+
+	fields := make([]string, 0, numFields)
+	for _, arg := range args[2:] {
+		fields = append(fields, arg.bulk)
+	}
+
+	return fields, ""
+}
+
+// HEXPIRE command: set a ttl in seconds on hash fields
+func hexpire(args []Value) Value {
+	return hashExpireGeneric("hexpire", args, 1000, false)
+}
+
+// HPEXPIRE command: set a ttl in milliseconds on hash fields
+func hpexpire(args []Value) Value {
+	return hashExpireGeneric("hpexpire", args, 1, false)
+}
+
+// HEXPIREAT command: set an absolute unix time in seconds on hash fields
+func hexpireat(args []Value) Value {
+	return hashExpireGeneric("hexpireat", args, 1000, true)
+}
+
+// HPEXPIREAT command: set an absolute unix time in milliseconds on hash fields
+func hpexpireat(args []Value) Value {
+	return hashExpireGeneric("hpexpireat", args, 1, true)
+}
+
+// converts the time argument of a hash expire command into unix milliseconds
+func hashExpireTime(arg string, unit int64, absolute bool) (int64, bool) {
+	when, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || when < 0 || when > math.MaxInt64/unit {
+		return 0, false
+	}
+	when *= unit
+
+	if !absolute {
+		now := time.Now().UnixMilli()
+		if when > math.MaxInt64-now {
+			return 0, false
+		}
+		when += now
+	}
+
+	return when, true
+}
+
+// shared implementation of the hash expire commands, replies with one code per field:
+// -2 no such field, 0 condition not met, 1 expiration set, 2 field deleted
+func hashExpireGeneric(name string, args []Value, unit int64, absolute bool) Value {
+	hash := args[0].bulk
+	expiresAt, ok := hashExpireTime(args[1].bulk, unit, absolute)
+	if !ok {
+		return Value{typ: "error", str: fmt.Sprintf("ERR invalid expire time in '%s' command", name)}
+	}
+
+	// optional NX, XX, GT or LT condition
+	rest := args[2:]
+	condition := strings.ToUpper(rest[0].bulk)
+	switch condition {
+	case "NX", "XX", "GT", "LT":
+		rest = rest[1:]
+	default:
+		condition = ""
+	}
+
+	fields, errMsg := parseHashFields(rest)
+	if errMsg != "" {
+		return Value{typ: "error", str: errMsg}
+	}
+
+	now := time.Now().UnixMilli()
+	results := make([]Value, 0, len(fields))
+	updated, deleted := false, false
+
+	HSETsMu.Lock()
+	defer HSETsMu.Unlock()
+
+	for _, field := range fields {
+		entry, ok := hashLiveField(hash, field)
+		if !ok {
+			results = append(results, Value{typ: "integer", num: -2})
+			continue
+		}
+
+		// a field without ttl counts as an infinite ttl for GT and LT
+		var met bool
+		switch condition {
+		case "NX":
+			met = entry.expiresAt == 0
+		case "XX":
+			met = entry.expiresAt != 0
+		case "GT":
+			met = entry.expiresAt != 0 && expiresAt > entry.expiresAt
+		case "LT":
+			met = entry.expiresAt == 0 || expiresAt < entry.expiresAt
+		default:
+			met = true
+		}
+
+		if !met {
+			results = append(results, Value{typ: "integer", num: 0})
+			continue
+		}
+
+		// a deadline in the past deletes the field right away
+		if expiresAt <= now {
+			delete(HSETs[hash], field)
+			deleted = true
+			results = append(results, Value{typ: "integer", num: 2})
+			continue
+		}
+
+		entry.expiresAt = expiresAt
+		HSETs[hash][field] = entry
+		volatileHashes[hash] = struct{}{}
+		updated = true
+		results = append(results, Value{typ: "integer", num: 1})
+	}
+
+	if updated {
+		notifyKeyspaceEvent(notifyHash, "hexpire", hash)
+	}
+	if deleted {
+		notifyKeyspaceEvent(notifyHash, "hdel", hash)
+	}
+
+	if fields, ok := HSETs[hash]; ok && len(fields) == 0 {
+		delete(HSETs, hash)
+		keyspaceRemove(hash, keyHash)
+		notifyKeyspaceEvent(notifyGeneric, "del", hash)
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: %s %s %s", strings.ToUpper(name), hash, args[1].bulk))
+
+	return Value{typ: "array", array: results}
+}
+
+// HTTL command: remaining ttl of hash fields in seconds
+func httl(args []Value) Value {
+	return hashTTLGeneric("httl", args, 1000)
+}
+
+// HPTTL command: remaining ttl of hash fields in milliseconds
+func hpttl(args []Value) Value {
+	return hashTTLGeneric("hpttl", args, 1)
+}
+
+// shared implementation of HTTL and HPTTL, replies -2 for missing fields and -1 for fields without ttl
+func hashTTLGeneric(name string, args []Value, unit int64) Value {
+	hash := args[0].bulk
+	fields, errMsg := parseHashFields(args[1:])
+	if errMsg != "" {
+		return Value{typ: "error", str: errMsg}
+	}
+
+	now := time.Now().UnixMilli()
+	results := make([]Value, 0, len(fields))
+
+	HSETsMu.RLock()
+	defer HSETsMu.RUnlock()
+
+	for _, field := range fields {
+		entry, ok := hashLiveField(hash, field)
+		switch {
+		case !ok:
+			results = append(results, Value{typ: "integer", num: -2})
+		case entry.expiresAt == 0:
+			results = append(results, Value{typ: "integer", num: -1})
+		default:
+			// round up so a field never reports 0 seconds while still alive
+			remaining := (entry.expiresAt - now + unit - 1) / unit
+			results = append(results, Value{typ: "integer", num: int(remaining)})
+		}
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: %s %s", strings.ToUpper(name), hash))
+
+	return Value{typ: "array", array: results}
+}
+
+// HPERSIST command: remove the ttl of hash fields, replies 1 when removed, -1 without ttl, -2 when missing
+func hpersist(args []Value) Value {
+	hash := args[0].bulk
+	fields, errMsg := parseHashFields(args[1:])
+	if errMsg != "" {
+		return Value{typ: "error", str: errMsg}
+	}
+
+	results := make([]Value, 0, len(fields))
+	persisted := false
+
+	HSETsMu.Lock()
+	defer HSETsMu.Unlock()
+
+	for _, field := range fields {
+		entry, ok := hashLiveField(hash, field)
+		switch {
+		case !ok:
+			results = append(results, Value{typ: "integer", num: -2})
+		case entry.expiresAt == 0:
+			results = append(results, Value{typ: "integer", num: -1})
+		default:
+			entry.expiresAt = 0
+			HSETs[hash][field] = entry
+			persisted = true
+			results = append(results, Value{typ: "integer", num: 1})
+		}
+	}
+
+	if persisted {
+		notifyKeyspaceEvent(notifyHash, "hpersist", hash)
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: HPERSIST %s", hash))
+
+	return Value{typ: "array", array: results}
+}
+
+// rewrites HEXPIRE, HPEXPIRE and HEXPIREAT into HPEXPIREAT with an absolute deadline
+func hashExpireAbsolute(command string, args []Value) ([]Value, bool) {
+	if len(args) < 2 {
+		return nil, false
+	}
+
+	var expiresAt int64
+	var ok bool
+	switch command {
+	case "HEXPIRE":
+		expiresAt, ok = hashExpireTime(args[1].bulk, 1000, false)
+	case "HPEXPIRE":
+		expiresAt, ok = hashExpireTime(args[1].bulk, 1, false)
+	case "HEXPIREAT":
+		expiresAt, ok = hashExpireTime(args[1].bulk, 1000, true)
+	}
+
+	if !ok {
+		return nil, false
+	}
+
+	rewritten := []Value{
+		{typ: "bulk", bulk: "HPEXPIREAT"},
+		args[0],
+		{typ: "bulk", bulk: strconv.FormatInt(expiresAt, 10)},
+	}
+
+	return append(rewritten, args[2:]...), true
+}
+
+// deletes the expired fields of a hash and drops it from the volatile index once no
+// field has a ttl left, returns the number of reclaimed fields, caller must hold the HSETsMu write lock
+func hashExpireFields(hash string, now int64) int {
+	fields, ok := HSETs[hash]
+	if !ok {
+		delete(volatileHashes, hash)
+		return 0
+	}
+
+	reclaimed := 0
+	volatile := false
+	for field, entry := range fields {
+		if entry.expired(now) {
+			delete(fields, field)
+			reclaimed++
+			continue
+		}
+
+		if entry.expiresAt > 0 {
+			volatile = true
+		}
+	}
+
+	if !volatile {
+		delete(volatileHashes, hash)
+	}
+
+	if reclaimed > 0 {
+		notifyKeyspaceEvent(notifyHash, "hexpired", hash)
+	}
+
+	if len(fields) == 0 {
+		delete(HSETs, hash)
+		keyspaceRemove(hash, keyHash)
+		notifyKeyspaceEvent(notifyGeneric, "del", hash)
+	}
+
+	return reclaimed
+}
+
+// one active expire pass over a sample of volatile hashes, returns the number of sampled and expired hashes
+func activeExpireHashFields(sample int) (int, int) {
+	now := time.Now().UnixMilli()
+	sampled, expired := 0, 0
+
+	HSETsMu.Lock()
+	defer HSETsMu.Unlock()
+
+	// map iteration order is random which makes this a random sample
+	for hash := range volatileHashes {
+		if sampled == sample {
+			break
+		}
+		sampled++
+
+		if reclaimed := hashExpireFields(hash, now); reclaimed > 0 {
+			expired++
+
+			// debug
+			logger.Debug(fmt.Sprintf("active expire: reclaimed %d fields of %s", reclaimed, hash))
+		}
+	}
+
+	return sampled, expired
+}
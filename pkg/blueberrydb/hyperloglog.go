@@ -0,0 +1,609 @@
+// HyperLogLog cardinality estimation stored in string values, using the same
+// sparse and dense encodings as redis so that values can move between both
+package blueberrydb
+
+import (
+	"blueberrydb/internal/logger"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+)
+
+const (
+	hllP           = 14               // bits of the hash used to address a register
+	hllQ           = 64 - hllP        // bits of the hash used to count the run of zeros
+	hllRegisters   = 1 << hllP        // 16384 registers
+	hllPMask       = hllRegisters - 1 // mask selecting the register index
+	hllBits        = 6                // bits per dense register
+	hllRegisterMax = 1<<hllBits - 1
+
+	hllHeaderSize = 16
+	hllDenseSize  = hllHeaderSize + (hllRegisters*hllBits+7)/8
+
+	hllDense     = 0
+	hllSparse    = 1
+	hllMaxEncode = 1
+
+	// sparse opcodes: ZERO 00xxxxxx, XZERO 01xxxxxx yyyyyyyy, VAL 1vvvvvxx
+	hllSparseZeroMaxLen  = 64
+	hllSparseXZeroMaxLen = 16384
+	hllSparseValMaxValue = 32
+	hllSparseValMaxLen   = 4
+	hllSparseMaxBytes    = 3000 // larger sparse values are promoted to dense
+
+	hllAlphaInf = 0.721347520444481703680 // 0.5/ln(2)
+)
+
+const hllWrongType = "WRONGTYPE Key is not a valid HyperLogLog string value."
+const hllCorrupted = "INVALIDOBJ Corrupted HLL object detected"
+
+// MurmurHash2 64 bit variant used by redis to hash HyperLogLog elements
+func murmurHash64A(data []byte, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+
+	h := seed ^ (uint64(len(data)) * m)
+
+	for len(data) >= 8 {
+		k := binary.LittleEndian.Uint64(data)
+		k *= m
+		k ^= k >> r
+		k *= m
+
+		h ^= k
+		h *= m
+		data = data[8:]
+	}
+
+	if len(data) > 0 {
+		for i := len(data) - 1; i >= 0; i-- {
+			h ^= uint64(data[i]) << (8 * i)
+		}
+		h *= m
+	}
+
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+
+	return h
+}
+
+// returns the register index of an element and the length of its 000..1 pattern
+func hllPatLen(element string) (int, uint8) {
+	hash := murmurHash64A([]byte(element), 0xadc83b19)
+	index := int(hash & hllPMask)
+
+	// the sentinel bit makes sure the loop terminates with count <= Q+1
+	hash >>= hllP
+	hash |= 1 << hllQ
+
+	count := uint8(1)
+	for bit := uint64(1); hash&bit == 0; bit <<= 1 {
+		count++
+	}
+
+	return index, count
+}
+
+// reads a 6 bit register of a dense HyperLogLog
+func hllDenseGet(registers []byte, index int) uint8 {
+	byteIndex := index * hllBits / 8
+	fb := uint(index * hllBits & 7)
+
+	b0 := uint(registers[byteIndex])
+	var b1 uint
+	if byteIndex+1 < len(registers) {
+		b1 = uint(registers[byteIndex+1])
+	}
+
+	return uint8((b0>>fb | b1<<(8-fb)) & hllRegisterMax)
+}
+
+// writes a 6 bit register of a dense HyperLogLog
+func hllDenseSet(registers []byte, index int, value uint8) {
+	byteIndex := index * hllBits / 8
+	fb := uint(index * hllBits & 7)
+	v := uint(value)
+
+	registers[byteIndex] &^= byte(hllRegisterMax << fb)
+	registers[byteIndex] |= byte(v << fb)
+
+	if byteIndex+1 < len(registers) {
+		registers[byteIndex+1] &^= byte(hllRegisterMax >> (8 - fb))
+		registers[byteIndex+1] |= byte(v >> (8 - fb))
+	}
+}
+
+// header of a new HyperLogLog with the given encoding and a valid cached cardinality of 0
+func hllHeader(encoding byte) []byte {
+	header := make([]byte, hllHeaderSize)
+	copy(header, "HYLL")
+	header[4] = encoding
+
+	return header
+}
+
+// a new empty HyperLogLog, sparse with XZERO opcodes covering every register
+func hllCreate() []byte {
+	buf := hllHeader(hllSparse)
+	for remaining := hllRegisters; remaining > 0; remaining -= hllSparseXZeroMaxLen {
+		run := min(remaining, hllSparseXZeroMaxLen) - 1
+		buf = append(buf, 0x40|byte(run>>8), byte(run&0xff))
+	}
+
+	return buf
+}
+
+// checks the header of a string that should hold a HyperLogLog
+func hllIsValid(buf []byte) bool {
+	if len(buf) < hllHeaderSize || string(buf[:4]) != "HYLL" || buf[4] > hllMaxEncode {
+		return false
+	}
+
+	if buf[4] == hllDense && len(buf) != hllDenseSize {
+		return false
+	}
+
+	return true
+}
+
+// decodes the registers of a dense or sparse HyperLogLog into one byte per register
+func hllDecode(buf []byte) ([]uint8, bool) {
+	registers := make([]uint8, hllRegisters)
+
+	if buf[4] == hllDense {
+		for i := range registers {
+			registers[i] = hllDenseGet(buf[hllHeaderSize:], i)
+		}
+		return registers, true
+	}
+
+	index := 0
+	for p := hllHeaderSize; p < len(buf); {
+		op := buf[p]
+		switch {
+		case op&0xc0 == 0x00:
+			// ZERO: run of up to 64 empty registers
+			index += int(op&0x3f) + 1
+			p++
+		case op&0xc0 == 0x40:
+			// XZERO: run of up to 16384 empty registers
+			if p+1 >= len(buf) {
+				return nil, false
+			}
+			index += (int(op&0x3f)<<8 | int(buf[p+1])) + 1
+			p += 2
+		default:
+			// VAL: run of up to 4 registers with the same value
+			value := (op>>2)&0x1f + 1
+			run := int(op&0x3) + 1
+			if index+run > hllRegisters {
+				return nil, false
+			}
+			for i := 0; i < run; i++ {
+				registers[index+i] = value
+			}
+			index += run
+			p++
+		}
+
+		if index > hllRegisters {
+			return nil, false
+		}
+	}
+
+	if index != hllRegisters {
+		return nil, false
+	}
+
+	return registers, true
+}
+
+// encodes registers as sparse opcodes, fails when a register is too large for a VAL opcode
+// or the result would be bigger than the promotion threshold
+func hllEncodeSparse(header []byte, registers []uint8) ([]byte, bool) {
+	buf := append([]byte{}, header[:hllHeaderSize]...)
+	buf[4] = hllSparse
+
+	for i := 0; i < hllRegisters; {
+		value := registers[i]
+		run := 1
+
+		if value == 0 {
+			for i+run < hllRegisters && registers[i+run] == 0 {
+				run++
+			}
+
+			if run > hllSparseZeroMaxLen {
+				buf = append(buf, 0x40|byte((run-1)>>8), byte((run-1)&0xff))
+			} else {
+				buf = append(buf, byte(run-1))
+			}
+		} else {
+			if value > hllSparseValMaxValue {
+				return nil, false
+			}
+
+			for i+run < hllRegisters && run < hllSparseValMaxLen && registers[i+run] == value {
+				run++
+			}
+			buf = append(buf, 0x80|(value-1)<<2|byte(run-1))
+		}
+
+		if len(buf) > hllSparseMaxBytes {
+			return nil, false
+		}
+		i += run
+	}
+
+	return buf, true
+}
+
+// encodes registers in the dense 6 bit representation
+func hllEncodeDense(header []byte, registers []uint8) []byte {
+	buf := make([]byte, hllDenseSize)
+	copy(buf, header[:hllHeaderSize])
+	buf[4] = hllDense
+
+	for i, value := range registers {
+		hllDenseSet(buf[hllHeaderSize:], i, value)
+	}
+
+	return buf
+}
+
+// sparse when it fits, dense otherwise
+func hllEncode(header []byte, registers []uint8, dense bool) []byte {
+	if !dense {
+		if buf, ok := hllEncodeSparse(header, registers); ok {
+			return buf
+		}
+	}
+
+	return hllEncodeDense(header, registers)
+}
+
+// tau function of the Ertl estimator
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+
+	y := 1.0
+	z := 1 - x
+	for {
+		x = math.Sqrt(x)
+		zPrime := z
+		y *= 0.5
+		z -= math.Pow(1-x, 2) * y
+		if zPrime == z {
+			break
+		}
+	}
+
+	return z / 3
+}
+
+// sigma function of the Ertl estimator
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+
+	y := 1.0
+	z := x
+	for {
+		x *= x
+		zPrime := z
+		z += x * y
+		y += y
+		if zPrime == z {
+			break
+		}
+	}
+
+	return z
+}
+
+// estimates the cardinality from the register histogram, see "New cardinality
+// estimation algorithms for HyperLogLog sketches" by Otmar Ertl
+func hllCount(registers []uint8) uint64 {
+	m := float64(hllRegisters)
+	histogram := make([]int, 64)
+	for _, value := range registers {
+		histogram[value]++
+	}
+
+	z := m * hllTau((m-float64(histogram[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histogram[0])/m)
+
+	return uint64(math.Round(hllAlphaInf * m * m / z))
+}
+
+// cached cardinality of the header, the top bit of the last byte marks it stale
+func hllCachedCount(buf []byte) (uint64, bool) {
+	if buf[15]&0x80 != 0 {
+		return 0, false
+	}
+
+	return binary.LittleEndian.Uint64(buf[8:16]), true
+}
+
+// marks the cached cardinality as stale
+func hllInvalidateCache(buf []byte) {
+	buf[15] |= 0x80
+}
+
+// loads the HyperLogLog stored at key, caller must hold SETsMu
+func hllLoad(key string) ([]byte, bool, string) {
+	buf, ok := bitmapBytes(key)
+	if !ok {
+		return nil, false, ""
+	}
+
+	if !hllIsValid(buf) {
+		return nil, true, hllWrongType
+	}
+
+	return buf, true, ""
+}
+
+// PFADD command: returns 1 when a register changed or the key was created
+func pfadd(args []Value) Value {
+	key := args[0].bulk
+
+	SETsMu.Lock()
+	defer SETsMu.Unlock()
+
+	buf, exists, errMsg := hllLoad(key)
+	if errMsg != "" {
+		return Value{typ: "error", str: errMsg}
+	}
+
+	if !exists {
+		buf = hllCreate()
+	}
+
+	updated := false
+	if buf[4] == hllDense {
+		// dense registers are updated in place
+		for _, arg := range args[1:] {
+			index, count := hllPatLen(arg.bulk)
+			if count > hllDenseGet(buf[hllHeaderSize:], index) {
+				hllDenseSet(buf[hllHeaderSize:], index, count)
+				updated = true
+			}
+		}
+	} else {
+		registers, ok := hllDecode(buf)
+		if !ok {
+			return Value{typ: "error", str: hllCorrupted}
+		}
+
+		for _, arg := range args[1:] {
+			index, count := hllPatLen(arg.bulk)
+			if count > registers[index] {
+				registers[index] = count
+				updated = true
+			}
+		}
+
+		if updated {
+			buf = hllEncode(buf, registers, false)
+		}
+	}
+
+	if updated {
+		hllInvalidateCache(buf)
+	}
+
+	if updated || !exists {
+		bitmapStore(key, buf)
+		notifyKeyspaceEvent(notifyString, "pfadd", key)
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: PFADD %s (%d elements)", key, len(args)-1))
+
+	if updated || !exists {
+		return Value{typ: "integer", num: 1}
+	}
+
+	return Value{typ: "integer", num: 0}
+}
+
+// PFCOUNT command: cardinality of one HyperLogLog, or of the union of several
+func pfcount(args []Value) Value {
+	// the cached cardinality of a single key is refreshed, so a write lock is needed
+	SETsMu.Lock()
+	defer SETsMu.Unlock()
+
+	if len(args) == 1 {
+		key := args[0].bulk
+
+		buf, exists, errMsg := hllLoad(key)
+		if errMsg != "" {
+			return Value{typ: "error", str: errMsg}
+		}
+
+		if !exists {
+			return Value{typ: "integer", num: 0}
+		}
+
+		if count, ok := hllCachedCount(buf); ok {
+			return Value{typ: "integer", num: int(count)}
+		}
+
+		registers, ok := hllDecode(buf)
+		if !ok {
+			return Value{typ: "error", str: hllCorrupted}
+		}
+
+		count := hllCount(registers)
+		binary.LittleEndian.PutUint64(buf[8:16], count)
+		bitmapStore(key, buf)
+
+		// debug
+		logger.Debug(fmt.Sprintf("command executed: PFCOUNT %s", key))
+
+		return Value{typ: "integer", num: int(count)}
+	}
+
+	// union of every key, computed on the fly without touching the caches
+	union := make([]uint8, hllRegisters)
+	for _, arg := range args {
+		buf, exists, errMsg := hllLoad(arg.bulk)
+		if errMsg != "" {
+			return Value{typ: "error", str: errMsg}
+		}
+
+		if !exists {
+			continue
+		}
+
+		registers, ok := hllDecode(buf)
+		if !ok {
+			return Value{typ: "error", str: hllCorrupted}
+		}
+
+		for i, value := range registers {
+			union[i] = max(union[i], value)
+		}
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: PFCOUNT (%d keys)", len(args)))
+
+	return Value{typ: "integer", num: int(hllCount(union))}
+}
+
+// PFMERGE command: stores the union of the sources, and of destkey itself, in destkey
+func pfmerge(args []Value) Value {
+	dest := args[0].bulk
+
+	SETsMu.Lock()
+	defer SETsMu.Unlock()
+
+	merged := make([]uint8, hllRegisters)
+	dense := false
+
+	// the destination takes part in the union when it exists
+	for _, arg := range args {
+		buf, exists, errMsg := hllLoad(arg.bulk)
+		if errMsg != "" {
+			return Value{typ: "error", str: errMsg}
+		}
+
+		if !exists {
+			continue
+		}
+
+		if buf[4] == hllDense {
+			dense = true
+		}
+
+		registers, ok := hllDecode(buf)
+		if !ok {
+			return Value{typ: "error", str: hllCorrupted}
+		}
+
+		for i, value := range registers {
+			merged[i] = max(merged[i], value)
+		}
+	}
+
+	buf := hllEncode(hllHeader(hllSparse), merged, dense)
+	hllInvalidateCache(buf)
+	bitmapStore(dest, buf)
+	notifyKeyspaceEvent(notifyString, "pfadd", dest)
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: PFMERGE %s (%d sources)", dest, len(args)-1))
+
+	return Value{typ: "string", str: "OK"}
+}
+
+// PFDEBUG command: GETREG, DECODE, ENCODING and TODENSE for inspecting the encodings
+func pfdebug(args []Value) Value {
+	subcommand := strings.ToUpper(args[0].bulk)
+	key := args[1].bulk
+
+	SETsMu.Lock()
+	defer SETsMu.Unlock()
+
+	buf, exists, errMsg := hllLoad(key)
+	if errMsg != "" {
+		return Value{typ: "error", str: errMsg}
+	}
+
+	if !exists {
+		return Value{typ: "error", str: "ERR The specified key does not exist"}
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: PFDEBUG %s %s", subcommand, key))
+
+	switch subcommand {
+	case "GETREG", "TODENSE":
+		converted := false
+		if buf[4] == hllSparse {
+			registers, ok := hllDecode(buf)
+			if !ok {
+				return Value{typ: "error", str: hllCorrupted}
+			}
+			buf = hllEncodeDense(buf, registers)
+			bitmapStore(key, buf)
+			converted = true
+		}
+
+		if subcommand == "TODENSE" {
+			if converted {
+				return Value{typ: "integer", num: 1}
+			}
+			return Value{typ: "integer", num: 0}
+		}
+
+		values := make([]Value, 0, hllRegisters)
+		for i := 0; i < hllRegisters; i++ {
+			values = append(values, Value{typ: "integer", num: int(hllDenseGet(buf[hllHeaderSize:], i))})
+		}
+		return Value{typ: "array", array: values}
+
+	case "DECODE":
+		if buf[4] != hllSparse {
+			return Value{typ: "error", str: "ERR HLL encoding is not sparse"}
+		}
+
+		opcodes := []string{}
+		for p := hllHeaderSize; p < len(buf); p++ {
+			op := buf[p]
+			switch {
+			case op&0xc0 == 0x00:
+				opcodes = append(opcodes, fmt.Sprintf("Z:%d", int(op&0x3f)+1))
+			case op&0xc0 == 0x40:
+				if p+1 >= len(buf) {
+					return Value{typ: "error", str: hllCorrupted}
+				}
+				opcodes = append(opcodes, fmt.Sprintf("XZ:%d", (int(op&0x3f)<<8|int(buf[p+1]))+1))
+				p++
+			default:
+				opcodes = append(opcodes, fmt.Sprintf("v:%d,%d", (op>>2)&0x1f+1, int(op&0x3)+1))
+			}
+		}
+		return Value{typ: "string", str: strings.Join(opcodes, " ")}
+
+	case "ENCODING":
+		if buf[4] == hllDense {
+			return Value{typ: "string", str: "dense"}
+		}
+		return Value{typ: "string", str: "sparse"}
+	}
+
+	return Value{typ: "error", str: fmt.Sprintf("ERR Unknown PFDEBUG subcommand '%s'", args[0].bulk)}
+}
@@ -0,0 +1,478 @@
+// generic key commands: TYPE, RENAME, RENAMENX, COPY, RANDOMKEY, TOUCH and OBJECT
+package blueberrydb
+
+import (
+	"blueberrydb/internal/logger"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// same defaults as redis lfu-log-factor and lfu-decay-time
+const (
+	lfuInitVal   = 5
+	lfuLogFactor = 10
+	lfuDecayTime = time.Minute
+)
+
+// what the key index knows about a key besides its name
+type keyMeta struct {
+	types      int   // maps holding the key, a bit each
+	lastAccess int64 // unix ms
+	lfu        uint8 // logarithmic access counter, decays while the key is idle
+	memory     int64 // estimated bytes taken by the key and its value
+}
+
+func newKeyMeta(bit int) *keyMeta {
+	return &keyMeta{types: bit, lastAccess: time.Now().UnixMilli(), lfu: lfuInitVal}
+}
+
+// records an access, caller must hold the mutex of the index
+func (m *keyMeta) touch(now int64) {
+	m.lfu = m.frequency(now)
+
+	// the higher the counter the less likely it grows, so it stays in 8 bits
+	if m.lfu < 255 {
+		base := max(float64(m.lfu)-lfuInitVal, 0)
+		if rand.Float64() < 1/(base*lfuLogFactor+1) {
+			m.lfu++
+		}
+	}
+
+	m.lastAccess = now
+}
+
+// the access counter less one for each decay period the key was idle
+func (m *keyMeta) frequency(now int64) uint8 {
+	periods := (now - m.lastAccess) / lfuDecayTime.Milliseconds()
+	if periods >= int64(m.lfu) {
+		return 0
+	}
+	return m.lfu - uint8(periods)
+}
+
+// gives a key the access data of the key its value came from
+func (idx *keyIndex) inherit(key string, from *keyMeta) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if meta, ok := idx.tree.find(indexEntry(key)); ok && from != nil {
+		meta.lastAccess, meta.lfu = from.lastAccess, from.lfu
+	}
+}
+
+// commands that look at keys without counting as an access
+var noTouchCommands = map[string]bool{
+	"MEMORY": true,
+	"OBJECT": true,
+	"TYPE":   true,
+}
+
+// records an access to the keys of a command, caller must hold commandsMu
+func touchKeys(value Value) {
+	cmd, ok := lookupCommand(value.array[0].bulk)
+	if !ok || noTouchCommands[cmd.Name] {
+		return
+	}
+
+	positions, ok := cmd.keyPositions(value.array)
+	if !ok || len(positions) == 0 {
+		return
+	}
+
+	idx := databases[selectedDB].index
+	now := time.Now().UnixMilli()
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for _, i := range positions {
+		if meta, ok := idx.tree.find(indexEntry(value.array[i].bulk)); ok {
+			meta.touch(now)
+		}
+	}
+}
+
+// the value of a key whatever its type, for commands that copy or move keys
+type keyValue struct {
+	bit    int
+	str    SetValStruct
+	hash   map[string]HashFieldStruct
+	zset   *SortedSet
+	stream *Stream
+}
+
+// the value of a key, expired strings count as missing, caller must hold the commandsMu write lock
+func (db *database) lookup(key string) (keyValue, bool) {
+	if entry, ok := db.strings[key]; ok && !entry.expired(time.Now().Unix()) {
+		return keyValue{bit: keyString, str: entry}, true
+	}
+	if hash, ok := db.hashes[key]; ok {
+		return keyValue{bit: keyHash, hash: hash}, true
+	}
+	if zset, ok := db.zsets[key]; ok {
+		return keyValue{bit: keyZset, zset: zset}, true
+	}
+	if stream, ok := db.streams[key]; ok {
+		return keyValue{bit: keyStream, stream: stream}, true
+	}
+	return keyValue{}, false
+}
+
+// removes a key from every map, reports whether it held a value that had not
+// expired, caller must hold the commandsMu write lock or the write locks of every map
+func (db *database) remove(key string) bool {
+	_, existed := db.lookup(key)
+
+	// the volatile sets may still hold keys whose value is already gone
+	delete(db.volatileStrings, key)
+	delete(db.volatileHashes, key)
+
+	if _, ok := db.strings[key]; ok {
+		delete(db.strings, key)
+		db.index.remove(key, keyString)
+	}
+	if _, ok := db.hashes[key]; ok {
+		delete(db.hashes, key)
+		db.index.remove(key, keyHash)
+	}
+	if _, ok := db.zsets[key]; ok {
+		delete(db.zsets, key)
+		db.index.remove(key, keyZset)
+	}
+	if _, ok := db.streams[key]; ok {
+		delete(db.streams, key)
+		db.index.remove(key, keyStream)
+	}
+
+	return existed
+}
+
+// stores a value under a key that holds nothing, caller must hold the commandsMu write lock
+func (db *database) store(key string, v keyValue) {
+	switch v.bit {
+	case keyString:
+		db.strings[key] = v.str
+		if v.str.expiresAt > 0 {
+			db.volatileStrings[key] = struct{}{}
+		}
+	case keyHash:
+		db.hashes[key] = v.hash
+		for _, field := range v.hash {
+			if field.expiresAt > 0 {
+				db.volatileHashes[key] = struct{}{}
+				break
+			}
+		}
+	case keyZset:
+		db.zsets[key] = v.zset
+	case keyStream:
+		db.streams[key] = v.stream
+		signalStreamWaiters(key)
+	}
+
+	db.index.add(key, v.bit)
+	db.index.setMemory(key, keyMemoryUsage(key, v, memoryEstimateSamples))
+}
+
+// a copy that shares nothing the commands change in place
+func (v keyValue) clone() keyValue {
+	switch v.bit {
+	case keyHash:
+		hash := make(map[string]HashFieldStruct, len(v.hash))
+		for field, entry := range v.hash {
+			hash[field] = entry
+		}
+		v.hash = hash
+	case keyZset:
+		zset := newSortedSet()
+		for member, score := range v.zset.dict {
+			zset.set(member, score)
+		}
+		v.zset = zset
+	case keyStream:
+		v.stream = v.stream.clone()
+	}
+	return v
+}
+
+// a deep copy of a stream with its consumer groups
+func (s *Stream) clone() *Stream {
+	c := newStream()
+	c.length, c.lastID, c.maxDeletedID, c.entriesAdded = s.length, s.lastID, s.maxDeletedID, s.entriesAdded
+
+	// entries are replaced rather than changed, their fields can be shared
+	s.blocks.walk(func(key []byte, block *streamBlock) {
+		c.blocks.insert(key, &streamBlock{entries: append([]streamEntry(nil), block.entries...), live: block.live})
+	})
+
+	for name, g := range s.groups {
+		cg := newStreamGroup(g.lastID, g.entriesRead)
+
+		consumers := map[*streamConsumer]*streamConsumer{}
+		for consumerName, consumer := range g.consumers {
+			copied := &streamConsumer{name: consumer.name, seenTime: consumer.seenTime, activeTime: consumer.activeTime, pel: newRadixTree[*streamNACK]()}
+			cg.consumers[consumerName] = copied
+			consumers[consumer] = copied
+		}
+
+		// the group and its consumers share the entries of their pending lists
+		g.pel.walk(func(key []byte, nack *streamNACK) {
+			copied := &streamNACK{deliveryTime: nack.deliveryTime, deliveryCount: nack.deliveryCount, consumer: consumers[nack.consumer]}
+			cg.pel.insert(key, copied)
+			copied.consumer.pel.insert(key, copied)
+		})
+
+		c.groups[name] = cg
+	}
+
+	return c
+}
+
+// the TYPE name of a value
+func (v keyValue) typeName() string {
+	for _, t := range keyTypeNames {
+		if t.bit == v.bit {
+			return t.name
+		}
+	}
+	return "none"
+}
+
+// TYPE command
+func typeCommand(args []Value) Value {
+	key := args[0].bulk
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: TYPE %s", key))
+
+	return Value{typ: "string", str: keyType(key)}
+}
+
+// RENAME and RENAMENX, the value keeps its expiration, caller must hold the commandsMu write lock
+func renameGeneric(args []Value, nx bool) Value {
+	src, dst := args[0].bulk, args[1].bulk
+	db := databases[selectedDB]
+
+	v, ok := db.lookup(src)
+	if !ok {
+		return Value{typ: "error", str: "ERR no such key"}
+	}
+
+	if nx {
+		if _, exists := db.lookup(dst); exists {
+			return Value{typ: "integer", num: 0}
+		}
+	}
+
+	if src != dst {
+		meta := db.index.meta(src)
+
+		db.remove(src)
+		if db.remove(dst) {
+			notifyKeyspaceEvent(notifyGeneric, "del", dst)
+		}
+		db.store(dst, v)
+		db.index.inherit(dst, meta)
+	}
+
+	notifyKeyspaceEvent(notifyGeneric, "rename_from", src)
+	notifyKeyspaceEvent(notifyGeneric, "rename_to", dst)
+
+	if nx {
+		return Value{typ: "integer", num: 1}
+	}
+	return Value{typ: "string", str: "OK"}
+}
+
+// RENAME command
+func rename(args []Value) Value {
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: RENAME %s %s", args[0].bulk, args[1].bulk))
+
+	return renameGeneric(args, false)
+}
+
+// RENAMENX command
+func renamenx(args []Value) Value {
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: RENAMENX %s %s", args[0].bulk, args[1].bulk))
+
+	return renameGeneric(args, true)
+}
+
+// COPY command: copies a value to another key, of another database with DB,
+// caller must hold the commandsMu write lock
+func copyCommand(args []Value) Value {
+	src, dst := args[0].bulk, args[1].bulk
+	target, replace := selectedDB, false
+
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i].bulk) {
+		case "REPLACE":
+			replace = true
+		case "DB":
+			if i+1 == len(args) {
+				return Value{typ: "error", str: "ERR syntax error"}
+			}
+			i++
+
+			index, errMsg := parseDatabaseIndex(args[i].bulk)
+			if errMsg != "" {
+				return Value{typ: "error", str: errMsg}
+			}
+			target = index
+		default:
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+	}
+
+	if src == dst && target == selectedDB {
+		return Value{typ: "error", str: "ERR source and destination objects are the same"}
+	}
+
+	v, ok := databases[selectedDB].lookup(src)
+	if !ok {
+		return Value{typ: "integer", num: 0}
+	}
+
+	db := databases[target]
+	if _, exists := db.lookup(dst); exists && !replace {
+		return Value{typ: "integer", num: 0}
+	}
+
+	if db.remove(dst) {
+		notifyDatabaseEvent(target, notifyGeneric, "del", dst)
+	}
+	db.store(dst, v.clone())
+	notifyDatabaseEvent(target, notifyGeneric, "copy_to", dst)
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: COPY %s %s (db %d)", src, dst, target))
+
+	return Value{typ: "integer", num: 1}
+}
+
+// RANDOMKEY command: a key picked at a random hash, null when the database is empty
+func randomkey(args []Value) Value {
+	idx := databases[selectedDB].index
+
+	// debug
+	logger.Debug("command executed: RANDOMKEY")
+
+	// expired strings that were not reclaimed yet make a few picks miss
+	for tries := 0; tries < 100; tries++ {
+		idx.mu.Lock()
+		entry, _, ok := idx.tree.seekGE(binary.BigEndian.AppendUint64(nil, rand.Uint64()))
+		if !ok {
+			entry, _, ok = idx.tree.seekGE(nil)
+		}
+		idx.mu.Unlock()
+
+		if !ok {
+			return Value{typ: "null"}
+		}
+
+		if key := string(entry[8:]); keyType(key) != "none" {
+			return Value{typ: "bulk", bulk: key}
+		}
+	}
+
+	return Value{typ: "null"}
+}
+
+// TOUCH command: the number of keys that exist, the access itself is recorded
+// for every command before it runs
+func touch(args []Value) Value {
+	count := 0
+	for _, arg := range args {
+		if keyType(arg.bulk) != "none" {
+			count++
+		}
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: TOUCH (%d keys)", len(args)))
+
+	return Value{typ: "integer", num: count}
+}
+
+// OBJECT command: ENCODING, IDLETIME, FREQ and REFCOUNT of a key
+func object(args []Value) Value {
+	subcommand := strings.ToUpper(args[0].bulk)
+
+	if subcommand == "HELP" && len(args) == 1 {
+		lines := []string{
+			"OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"ENCODING <key>",
+			"    Return the kind of internal representation used in order to store the value",
+			"    associated with a <key>.",
+			"FREQ <key>",
+			"    Return the access frequency index of the <key>. The returned integer is",
+			"    proportional to the logarithm of the recent access frequency of the key.",
+			"IDLETIME <key>",
+			"    Return the idle time of the <key>, that is the approximated number of",
+			"    seconds elapsed since the last access to the key.",
+			"REFCOUNT <key>",
+			"    Return the number of references of the value associated with the specified",
+			"    <key>.",
+		}
+
+		reply := Value{typ: "array", array: []Value{}}
+		for _, line := range lines {
+			reply.array = append(reply.array, Value{typ: "string", str: line})
+		}
+		return reply
+	}
+
+	if len(args) != 2 {
+		return Value{typ: "error", str: fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try OBJECT HELP.", args[0].bulk)}
+	}
+
+	key := args[1].bulk
+	encoding := objectEncoding(key)
+	meta := databases[selectedDB].index.meta(key)
+	if encoding == "" || meta == nil {
+		return Value{typ: "null"}
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: OBJECT %s %s", subcommand, key))
+
+	switch subcommand {
+	case "ENCODING":
+		return Value{typ: "bulk", bulk: encoding}
+	case "REFCOUNT":
+		return Value{typ: "integer", num: 1}
+	case "IDLETIME":
+		return Value{typ: "integer", num: int(time.Now().UnixMilli()-meta.lastAccess) / 1000}
+	case "FREQ":
+		return Value{typ: "integer", num: int(meta.frequency(time.Now().UnixMilli()))}
+	}
+
+	return Value{typ: "error", str: fmt.Sprintf("ERR unknown subcommand '%s'. Try OBJECT HELP.", args[0].bulk)}
+}
+
+// how a value is stored: strings are int encoded or raw, the collections
+// always use one structure each, "" when the key does not exist
+func objectEncoding(key string) string {
+	switch keyType(key) {
+	case "string":
+		SETsMu.RLock()
+		defer SETsMu.RUnlock()
+
+		if SETs[key].encoding == encodingInt {
+			return "int"
+		}
+		return "raw"
+	case "hash":
+		return "hashtable"
+	case "zset":
+		return "skiplist"
+	case "stream":
+		return "stream"
+	}
+	return ""
+}
@@ -0,0 +1,136 @@
+// WRONGTYPE errors: each data type lives in its own map, a key held by one
+// type is rejected by the commands of the others before they run
+package blueberrydb
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const wrongTypeError = "WRONGTYPE Operation against a key holding the wrong kind of value"
+
+// the type the keys of a command group hold, groups not listed take keys of any type
+var groupTypes = map[string]string{
+	"string":      "string",
+	"bitmap":      "string",
+	"hyperloglog": "string",
+	"hash":        "hash",
+	"set":         "set",
+	"sorted-set":  "zset",
+	"geo":         "zset",
+	"stream":      "stream",
+}
+
+// destination key positions that are replaced whatever they held
+var overwrittenKeys = map[string]int{
+	"SET":            1,
+	"BITOP":          2,
+	"GEOSEARCHSTORE": 1,
+}
+
+// the type of the value held at key, "none" when the key does not exist
+func keyType(key string) string {
+	SETsMu.RLock()
+	entry, ok := SETs[key]
+	SETsMu.RUnlock()
+	if ok && !entry.expired(time.Now().Unix()) {
+		return "string"
+	}
+
+	HSETsMu.RLock()
+	_, ok = HSETs[key]
+	HSETsMu.RUnlock()
+	if ok {
+		return "hash"
+	}
+
+	ZSETsMu.RLock()
+	_, ok = ZSETs[key]
+	ZSETsMu.RUnlock()
+	if ok {
+		return "zset"
+	}
+
+	STREAMsMu.RLock()
+	_, ok = STREAMs[key]
+	STREAMsMu.RUnlock()
+	if ok {
+		return "stream"
+	}
+
+	return "none"
+}
+
+// the WRONGTYPE error when a key of the call holds a type the command does not work on
+func typeError(value Value) (Value, bool) {
+	cmd, ok := lookupCommand(value.array[0].bulk)
+	if !ok {
+		return Value{}, false
+	}
+	want, ok := groupTypes[cmd.Group]
+	if !ok {
+		return Value{}, false
+	}
+
+	positions, ok := cmd.keyPositions(value.array)
+	if !ok {
+		return Value{}, false
+	}
+	for _, i := range positions {
+		if overwrittenKeys[cmd.Name] == i {
+			continue
+		}
+		if held := keyType(value.array[i].bulk); held != "none" && held != want {
+			return Value{typ: "error", str: wrongTypeError}, true
+		}
+	}
+	return Value{}, false
+}
+
+// the checked keys of a command are locked from the WRONGTYPE check until the
+// command is done, keyType takes the map locks one at a time so two commands
+// could otherwise both find a key missing and create it with different types
+var keyTypeLocks [256]sync.Mutex
+
+// locks the keys of a built in typed command, returns the function that
+// unlocks them, blocking commands are left out as they wait without commandsMu
+// and create no keys
+func lockKeyTypes(value Value) func() {
+	command := value.array[0].bulk
+	if _, ok := registeredCommands[strings.ToUpper(command)]; ok {
+		return func() {}
+	}
+	cmd, ok := lookupCommand(command)
+	if !ok || cmd.hasFlag("blocking") {
+		return func() {}
+	}
+	if _, ok := groupTypes[cmd.Group]; !ok {
+		return func() {}
+	}
+	positions, ok := cmd.keyPositions(value.array)
+	if !ok {
+		return func() {}
+	}
+
+	// always in the same order, so commands locking several never deadlock
+	locks := []int{}
+	for _, i := range positions {
+		lock := int(scanHash(value.array[i].bulk) % uint64(len(keyTypeLocks)))
+		if !slices.Contains(locks, lock) {
+			locks = append(locks, lock)
+		}
+	}
+	sort.Ints(locks)
+
+	for _, lock := range locks {
+		keyTypeLocks[lock].Lock()
+	}
+	return func() {
+		for _, lock := range locks {
+			keyTypeLocks[lock].Unlock()
+		}
+	}
+}
@@ -0,0 +1,473 @@
+// memory accounting: an estimate of the bytes each key takes, kept in the key
+// index and summed up so maxmemory can be checked without walking the dataset,
+// and the MEMORY command reporting it with what clients take
+package blueberrydb
+
+import (
+	"blueberrydb/internal/logger"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// rough sizes of the structures holding values, what a key costs beyond the
+// bytes of its name, fields and members
+const (
+	keyOverhead            = 64 // map entry, index entry and its metadata
+	expireOverhead         = 32 // entry of the map of keys with a ttl
+	stringOverhead         = 32
+	hashOverhead           = 48
+	hashFieldOverhead      = 64
+	zsetOverhead           = 64
+	zsetMemberOverhead     = 80 // dict entry and skiplist node
+	streamOverhead         = 96
+	streamBlockOverhead    = 64
+	streamEntryOverhead    = 48
+	streamFieldOverhead    = 16
+	streamGroupOverhead    = 96
+	streamNACKOverhead     = 48
+	streamConsumerOverhead = 64
+)
+
+// elements of a collection looked at when a write changes it, the rest are
+// assumed to be of the same size
+const memoryEstimateSamples = 5
+
+// bytes taken by the keys of every database
+var datasetMemory atomic.Int64
+
+// the total size of count elements from the sizes of the first samples of
+// them, all of them when samples is 0
+func sampledSize(count int, samples int, each func(fn func(size int64) bool)) int64 {
+	if count == 0 {
+		return 0
+	}
+
+	total, seen := int64(0), 0
+	each(func(size int64) bool {
+		total += size
+		seen++
+		return samples == 0 || seen < samples
+	})
+
+	if seen == 0 {
+		return 0
+	}
+	return total * int64(count) / int64(seen)
+}
+
+// bytes taken by a value, looking at samples elements of collections, all of
+// them when samples is 0
+func (v keyValue) memoryUsage(samples int) int64 {
+	switch v.bit {
+	case keyString:
+		if v.str.encoding == encodingInt {
+			return stringOverhead
+		}
+		return stringOverhead + int64(len(v.str.value))
+	case keyHash:
+		return hashOverhead + sampledSize(len(v.hash), samples, func(fn func(int64) bool) {
+			for field, entry := range v.hash {
+				if !fn(hashFieldOverhead + int64(len(field)+len(entry.value))) {
+					return
+				}
+			}
+		})
+	case keyZset:
+		return zsetOverhead + sampledSize(len(v.zset.dict), samples, func(fn func(int64) bool) {
+			for member := range v.zset.dict {
+				if !fn(zsetMemberOverhead + int64(len(member))) {
+					return
+				}
+			}
+		})
+	case keyStream:
+		return v.stream.memoryUsage(samples)
+	}
+	return 0
+}
+
+// bytes taken by a stream, samples is the number of blocks looked at
+func (s *Stream) memoryUsage(samples int) int64 {
+	usage := streamOverhead + sampledSize(s.blocks.size, samples, func(fn func(int64) bool) {
+		key, block, ok := s.blocks.seekGE(nil)
+		for ok {
+			size := int64(streamBlockOverhead)
+			for _, entry := range block.entries {
+				size += streamEntryOverhead
+				for _, field := range entry.fields {
+					size += streamFieldOverhead + int64(len(field))
+				}
+			}
+
+			if !fn(size) {
+				return
+			}
+			key, block, ok = s.blocks.seekGE(append(key, 0))
+		}
+	})
+
+	for name, group := range s.groups {
+		usage += streamGroupOverhead + int64(len(name)) + int64(group.pel.size)*streamNACKOverhead
+		for consumerName := range group.consumers {
+			usage += streamConsumerOverhead + int64(len(consumerName))
+		}
+	}
+
+	return usage
+}
+
+// bytes taken by a key with its value
+func keyMemoryUsage(key string, v keyValue, samples int) int64 {
+	usage := keyOverhead + int64(len(key)) + v.memoryUsage(samples)
+	if v.bit == keyString && v.str.expiresAt > 0 {
+		usage += expireOverhead
+	}
+	return usage
+}
+
+// records the bytes a key takes, caller must hold the commandsMu write lock
+// or the read lock with the lock of the map holding the key
+func (idx *keyIndex) setMemory(key string, bytes int64) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if meta, ok := idx.tree.find(indexEntry(key)); ok {
+		idx.memory += bytes - meta.memory
+		datasetMemory.Add(bytes - meta.memory)
+		if bytes > meta.memory {
+			recordPeakMemory(currentMemoryStats().total())
+		}
+		meta.memory = bytes
+	}
+}
+
+// estimates the bytes a key of the database takes again, caller must hold
+// the lock of the map holding the key
+func (db *database) updateMemory(key string) {
+	if v, ok := db.lookup(key); ok {
+		db.index.setMemory(key, keyMemoryUsage(key, v, memoryEstimateSamples))
+	}
+}
+
+// estimates the keys of a write command again once it ran, caller must hold commandsMu
+func updateKeysMemory(value Value) {
+	cmd, ok := lookupCommand(value.array[0].bulk)
+	if !ok || !cmd.hasFlag("write") {
+		return
+	}
+
+	positions, ok := cmd.keyPositions(value.array)
+	if !ok || len(positions) == 0 {
+		return
+	}
+
+	SETsMu.RLock()
+	HSETsMu.RLock()
+	ZSETsMu.RLock()
+	STREAMsMu.RLock()
+	defer SETsMu.RUnlock()
+	defer HSETsMu.RUnlock()
+	defer ZSETsMu.RUnlock()
+	defer STREAMsMu.RUnlock()
+
+	db := databases[selectedDB]
+	for _, i := range positions {
+		db.updateMemory(value.array[i].bulk)
+	}
+}
+
+// estimates every key, for the dataset loaded from the aof whose commands did
+// not go through execute
+func EstimateMemory() {
+	commandsMu.Lock()
+	defer commandsMu.Unlock()
+
+	for _, db := range databases {
+		db.index.mu.Lock()
+		keys := make([]string, 0, db.index.tree.size)
+		db.index.tree.walk(func(entry []byte, _ *keyMeta) {
+			keys = append(keys, string(entry[8:]))
+		})
+		db.index.mu.Unlock()
+
+		for _, key := range keys {
+			db.updateMemory(key)
+		}
+	}
+}
+
+// a connection with its query buffer, besides the replies queued on it
+const clientOverhead = 4096 + 1024
+
+// the most memory used so far
+var peakMemory atomic.Int64
+
+// what the memory is taken by
+type memoryStats struct {
+	dataset int64 // keys with their values
+	clients int64 // connections and the replies queued on them
+	aof     int64 // written straight to the file, nothing is buffered
+	backlog int64 // there is no replication, so no backlog to keep
+}
+
+func currentMemoryStats() memoryStats {
+	return memoryStats{
+		dataset: datasetMemory.Load(),
+		clients: connectedClients.Load()*clientOverhead + clientsOutputMemory.Load(),
+	}
+}
+
+func (s memoryStats) total() int64 {
+	return s.dataset + s.clients + s.aof + s.backlog
+}
+
+// bytes used in all, recorded as the peak when above it
+func usedMemory() int64 {
+	used := currentMemoryStats().total()
+	recordPeakMemory(used)
+	return used
+}
+
+func recordPeakMemory(used int64) {
+	for peak := peakMemory.Load(); used > peak && !peakMemory.CompareAndSwap(peak, used); {
+		peak = peakMemory.Load()
+	}
+}
+
+// what holding the keys of a database costs besides the values
+type databaseOverhead struct {
+	index   int
+	keys    int64
+	main    int64 // entries of the maps and the key index
+	expires int64 // entries of the map of keys with a ttl
+}
+
+// the overheads of the databases holding keys, caller must hold the locks of every map
+func databaseOverheads() []databaseOverhead {
+	overheads := []databaseOverhead{}
+	for i, db := range databases {
+		size, expires := int64(db.size()), int64(db.expires())
+		if size > 0 {
+			overheads = append(overheads, databaseOverhead{index: i, keys: size, main: size * keyOverhead, expires: expires * expireOverhead})
+		}
+	}
+	return overheads
+}
+
+// the keys of every database and the bytes the server takes besides the
+// values, caller must hold the locks of every map
+func memoryOverhead(stats memoryStats) (int64, int64) {
+	keys, overhead := int64(0), stats.clients+stats.aof+stats.backlog
+	for _, db := range databaseOverheads() {
+		keys += db.keys
+		overhead += db.main + db.expires
+	}
+	return keys, overhead
+}
+
+// a number of bytes the way INFO shows it, as in redis
+func bytesToHuman(n int64) string {
+	units := []string{"B", "K", "M", "G", "T"}
+	value, unit := float64(n), 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+
+	if unit == 0 {
+		return fmt.Sprintf("%dB", n)
+	}
+	return fmt.Sprintf("%.2f%s", value, units[unit])
+}
+
+// the memory section of INFO
+func memoryInfo() string {
+	used, stats := usedMemory(), currentMemoryStats()
+
+	SETsMu.RLock()
+	HSETsMu.RLock()
+	ZSETsMu.RLock()
+	STREAMsMu.RLock()
+	_, overhead := memoryOverhead(stats)
+	STREAMsMu.RUnlock()
+	ZSETsMu.RUnlock()
+	HSETsMu.RUnlock()
+	SETsMu.RUnlock()
+
+	var info strings.Builder
+	info.WriteString(fmt.Sprintf("used_memory: %d\n", used))
+	info.WriteString(fmt.Sprintf("used_memory_human: %s\n", bytesToHuman(used)))
+	info.WriteString(fmt.Sprintf("used_memory_peak: %d\n", peakMemory.Load()))
+	info.WriteString(fmt.Sprintf("used_memory_peak_human: %s\n", bytesToHuman(peakMemory.Load())))
+	info.WriteString(fmt.Sprintf("used_memory_overhead: %d\n", overhead))
+	info.WriteString(fmt.Sprintf("used_memory_dataset: %d\n", used-overhead))
+	info.WriteString(fmt.Sprintf("mem_clients_normal: %d\n", stats.clients))
+	info.WriteString(fmt.Sprintf("mem_aof_buffer: %d\n", stats.aof))
+	info.WriteString(fmt.Sprintf("mem_replication_backlog: %d\n", stats.backlog))
+	info.WriteString(maxMemoryInfo())
+	return info.String()
+}
+
+// MEMORY command: USAGE, STATS and DOCTOR
+func memory(args []Value) Value {
+	subcommand := strings.ToUpper(args[0].bulk)
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: MEMORY %s", subcommand))
+
+	switch {
+	case subcommand == "HELP" && len(args) == 1:
+		lines := []string{
+			"MEMORY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"DOCTOR",
+			"    Return memory problems reports.",
+			"STATS",
+			"    Return information about the memory usage of the server.",
+			"USAGE <key> [SAMPLES <count>]",
+			"    Return memory in bytes used by <key> and its value. Nested values are",
+			"    sampled up to <count> times (default: 5, 0 means sample all).",
+		}
+
+		reply := Value{typ: "array", array: []Value{}}
+		for _, line := range lines {
+			reply.array = append(reply.array, Value{typ: "string", str: line})
+		}
+		return reply
+	case subcommand == "USAGE" && len(args) >= 2:
+		return memoryUsageCommand(args[1:])
+	case subcommand == "STATS" && len(args) == 1:
+		return memoryStatsCommand()
+	case subcommand == "DOCTOR" && len(args) == 1:
+		return Value{typ: "bulk", bulk: memoryDoctor()}
+	}
+
+	return Value{typ: "error", str: fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try MEMORY HELP.", args[0].bulk)}
+}
+
+// MEMORY USAGE key [SAMPLES count]: the bytes a key takes, null when it does not exist
+func memoryUsageCommand(args []Value) Value {
+	key, samples := args[0].bulk, memoryEstimateSamples
+
+	for i := 1; i < len(args); i++ {
+		if strings.ToUpper(args[i].bulk) != "SAMPLES" || i+1 == len(args) {
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+		i++
+
+		count, err := strconv.Atoi(args[i].bulk)
+		if err != nil || count < 0 {
+			return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+		}
+		samples = count
+	}
+
+	SETsMu.RLock()
+	HSETsMu.RLock()
+	ZSETsMu.RLock()
+	STREAMsMu.RLock()
+	defer SETsMu.RUnlock()
+	defer HSETsMu.RUnlock()
+	defer ZSETsMu.RUnlock()
+	defer STREAMsMu.RUnlock()
+
+	v, ok := databases[selectedDB].lookup(key)
+	if !ok {
+		return Value{typ: "null"}
+	}
+	return Value{typ: "integer", num: int(keyMemoryUsage(key, v, samples))}
+}
+
+// MEMORY STATS: where the memory goes, as name value pairs
+func memoryStatsCommand() Value {
+	used, stats := usedMemory(), currentMemoryStats()
+	peak := peakMemory.Load()
+
+	SETsMu.RLock()
+	HSETsMu.RLock()
+	ZSETsMu.RLock()
+	STREAMsMu.RLock()
+	defer SETsMu.RUnlock()
+	defer HSETsMu.RUnlock()
+	defer ZSETsMu.RUnlock()
+	defer STREAMsMu.RUnlock()
+
+	integer := func(n int64) Value { return Value{typ: "integer", num: int(n)} }
+
+	databaseStats := []Value{}
+	for _, db := range databaseOverheads() {
+		databaseStats = append(databaseStats, Value{typ: "bulk", bulk: fmt.Sprintf("db.%d", db.index)}, Value{typ: "array", array: []Value{
+			{typ: "bulk", bulk: "overhead.hashtable.main"}, integer(db.main),
+			{typ: "bulk", bulk: "overhead.hashtable.expires"}, integer(db.expires),
+		}})
+	}
+	keys, overhead := memoryOverhead(stats)
+
+	dataset := used - overhead
+	percentage := func(part, whole int64) Value {
+		if whole == 0 {
+			return Value{typ: "bulk", bulk: "0"}
+		}
+		return Value{typ: "bulk", bulk: strconv.FormatFloat(float64(part)*100/float64(whole), 'f', -1, 64)}
+	}
+	bytesPerKey := int64(0)
+	if keys > 0 {
+		bytesPerKey = dataset / keys
+	}
+
+	reply := Value{typ: "array", array: []Value{
+		{typ: "bulk", bulk: "peak.allocated"}, integer(peak),
+		{typ: "bulk", bulk: "total.allocated"}, integer(used),
+		{typ: "bulk", bulk: "replication.backlog"}, integer(stats.backlog),
+		{typ: "bulk", bulk: "clients.slaves"}, integer(0),
+		{typ: "bulk", bulk: "clients.normal"}, integer(stats.clients),
+		{typ: "bulk", bulk: "aof.buffer"}, integer(stats.aof),
+	}}
+	reply.array = append(reply.array, databaseStats...)
+	reply.array = append(reply.array,
+		Value{typ: "bulk", bulk: "overhead.total"}, integer(overhead),
+		Value{typ: "bulk", bulk: "keys.count"}, integer(keys),
+		Value{typ: "bulk", bulk: "keys.bytes-per-key"}, integer(bytesPerKey),
+		Value{typ: "bulk", bulk: "dataset.bytes"}, integer(dataset),
+		Value{typ: "bulk", bulk: "dataset.percentage"}, percentage(dataset, used),
+		Value{typ: "bulk", bulk: "peak.percentage"}, percentage(used, peak),
+	)
+	return reply
+}
+
+// MEMORY DOCTOR: advice drawn from the numbers MEMORY STATS reports
+func memoryDoctor() string {
+	used, stats := usedMemory(), currentMemoryStats()
+
+	// too little data to tell anything
+	if used < 5<<20 {
+		return "Hi Sam, this instance is empty or is using very little memory, my issues detector can't be used in these conditions. Please, leave for your mission on Earth and fill it with some data. The new Sam and I will be back to our programming as soon as I finished rebooting."
+	}
+
+	issues := []string{}
+
+	if peak := peakMemory.Load(); peak > used*3/2 {
+		issues = append(issues, fmt.Sprintf("Peak memory: In the past this instance used more than 150%% the memory that is currently using (%s at the peak, %s now). The memory freed by deleting or evicting keys may not have been returned to the system yet.", bytesToHuman(peak), bytesToHuman(used)))
+	}
+
+	if clients := connectedClients.Load(); clients > 0 && clientsOutputMemory.Load()/clients > 200<<10 {
+		issues = append(issues, "Big client buffers: The clients output buffers are in general bigger than 200 KB on average. This is usually caused by clients that read replies slower than they are produced, or by subscribers that do not keep up with the messages published to them. Consider lowering the pubsub output buffer limits.")
+	}
+
+	if limit := maxMemory.Load(); limit > 0 && stats.dataset+stats.clients > limit*9/10 && evictionPolicy.Load() == evictNoEviction {
+		issues = append(issues, fmt.Sprintf("Close to maxmemory: This instance uses %s of the %s maxmemory allows and its policy is noeviction, so commands that need more memory will be refused with OOM errors. Consider raising maxmemory or a maxmemory-policy that evicts keys.", bytesToHuman(stats.dataset+stats.clients), bytesToHuman(limit)))
+	}
+
+	if len(issues) == 0 {
+		return "Hi Sam, I can't find any memory issue in your instance. I can only account for what occurs on this base."
+	}
+
+	var report strings.Builder
+	report.WriteString("Sam, I detected a few issues in this blueberrydb instance memory implants:\n\n")
+	for _, issue := range issues {
+		report.WriteString(" * " + issue + "\n\n")
+	}
+	report.WriteString("I'm here to keep you safe, Sam. I want to help you.\n")
+	return report.String()
+}
@@ -0,0 +1,317 @@
+// MIGRATE: moves keys to another server by sending their DUMP payloads as
+// RESTORE commands, over connections kept for later calls to the same server
+package blueberrydb
+
+import (
+	"blueberrydb/internal/logger"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// idle connections to other servers are closed after this long, as in redis
+const migrateCacheTTL = 10 * time.Second
+
+// a connection to the server keys are migrated to
+type migrateConn struct {
+	conn    net.Conn
+	resp    *Resp
+	db      int // database selected on the other end, -1 before the first SELECT
+	lastUse time.Time
+}
+
+// cached connections by host:port
+var migrateConns = map[string]*migrateConn{}
+var migrateConnsMu = sync.Mutex{}
+
+// the cached connection to addr or a new one, caller must hold migrateConnsMu
+func migrateConnect(addr string, timeout time.Duration) (*migrateConn, bool, error) {
+	if mc, ok := migrateConns[addr]; ok {
+		return mc, true, nil
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, false, err
+	}
+
+	mc := &migrateConn{conn: conn, resp: NewResp(conn), db: -1, lastUse: time.Now()}
+	migrateConns[addr] = mc
+	time.AfterFunc(migrateCacheTTL, func() { migrateCloseIdle(addr, mc) })
+
+	return mc, false, nil
+}
+
+// closes a cached connection that was not used for migrateCacheTTL, checks
+// again later when it was
+func migrateCloseIdle(addr string, mc *migrateConn) {
+	migrateConnsMu.Lock()
+	defer migrateConnsMu.Unlock()
+
+	if migrateConns[addr] != mc {
+		return
+	}
+	if idle := time.Since(mc.lastUse); idle < migrateCacheTTL {
+		time.AfterFunc(migrateCacheTTL-idle, func() { migrateCloseIdle(addr, mc) })
+		return
+	}
+
+	mc.conn.Close()
+	delete(migrateConns, addr)
+}
+
+// closes a connection after an i/o error, caller must hold migrateConnsMu
+func migrateDrop(addr string, mc *migrateConn) {
+	mc.conn.Close()
+	if migrateConns[addr] == mc {
+		delete(migrateConns, addr)
+	}
+}
+
+// options of MIGRATE after the timeout
+type migrateOptions struct {
+	copy    bool
+	replace bool
+	auth    []string // AUTH arguments sent before the keys, empty for none
+	keys    []string // keys given with KEYS
+}
+
+func parseMigrateOptions(args []Value) (migrateOptions, string) {
+	opts := migrateOptions{}
+
+	for i := 0; i < len(args); i++ {
+		option := strings.ToUpper(args[i].bulk)
+		switch {
+		case option == "COPY":
+			opts.copy = true
+		case option == "REPLACE":
+			opts.replace = true
+		case option == "AUTH" && i+1 < len(args):
+			opts.auth = []string{args[i+1].bulk}
+			i++
+		case option == "AUTH2" && i+2 < len(args):
+			opts.auth = []string{args[i+1].bulk, args[i+2].bulk}
+			i += 2
+		case option == "KEYS":
+			for _, key := range args[i+1:] {
+				opts.keys = append(opts.keys, key.bulk)
+			}
+			i = len(args)
+		default:
+			return opts, "ERR syntax error"
+		}
+	}
+
+	return opts, ""
+}
+
+// keys of "host port key db timeout [options] [KEYS key [key ...]]", the key
+// argument is empty when KEYS is used
+func migrateKeys(argv []Value) ([]int, bool) {
+	if argv[3].bulk != "" {
+		return []int{3}, true
+	}
+
+	for i := 6; i < len(argv); i++ {
+		switch strings.ToUpper(argv[i].bulk) {
+		case "AUTH":
+			i++
+		case "AUTH2":
+			i += 2
+		case "KEYS":
+			positions := []int{}
+			for j := i + 1; j < len(argv); j++ {
+				positions = append(positions, j)
+			}
+			return positions, true
+		}
+	}
+	return nil, true
+}
+
+// a key read for migration
+type migratedKey struct {
+	key     string
+	ttl     int64 // milliseconds left, 0 for none
+	payload string
+}
+
+// an i/o failure on a connection to another server, op says what was being done
+type migrateIOError struct {
+	op  string
+	err error
+}
+
+func (e *migrateIOError) Error() string {
+	return e.op + ": " + e.err.Error()
+}
+
+func (e *migrateIOError) Unwrap() error {
+	return e.err
+}
+
+// sends the keys as RESTORE commands, after AUTH and a SELECT when the other
+// end is on another database, and returns the replies to the RESTOREs
+func migrateSend(mc *migrateConn, db int, timeout time.Duration, opts migrateOptions, keys []migratedKey) ([]Value, error) {
+	commands := [][]string{}
+	if len(opts.auth) > 0 {
+		commands = append(commands, append([]string{"AUTH"}, opts.auth...))
+	}
+	selecting := mc.db != db
+	if selecting {
+		commands = append(commands, []string{"SELECT", strconv.Itoa(db)})
+	}
+	for _, k := range keys {
+		restore := []string{"RESTORE", k.key, strconv.FormatInt(k.ttl, 10), k.payload}
+		if opts.replace {
+			restore = append(restore, "REPLACE")
+		}
+		commands = append(commands, restore)
+	}
+
+	// the whole batch is written at once and the replies read in order
+	out := []byte{}
+	for _, command := range commands {
+		value := Value{typ: "array"}
+		for _, arg := range command {
+			value.array = append(value.array, Value{typ: "bulk", bulk: arg})
+		}
+		out = append(out, value.Marshal()...)
+	}
+
+	mc.conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := mc.conn.Write(out); err != nil {
+		return nil, &migrateIOError{op: "writing to target instance", err: err}
+	}
+
+	replies := make([]Value, 0, len(commands))
+	for range commands {
+		reply, err := mc.resp.Read()
+		if err != nil {
+			return nil, &migrateIOError{op: "reading to target instance", err: err}
+		}
+		replies = append(replies, reply)
+	}
+
+	// a failed AUTH or SELECT fails the whole batch
+	for _, reply := range replies[:len(commands)-len(keys)] {
+		if reply.typ == "error" {
+			mc.db = -1
+			return []Value{reply}, nil
+		}
+	}
+	if selecting {
+		mc.db = db
+	}
+
+	return replies[len(commands)-len(keys):], nil
+}
+
+// MIGRATE command: moves keys to another server, deleting them here once the
+// other end restored them unless COPY is given, caller must hold the
+// commandsMu write lock, which is kept while the other end answers
+func migrate(args []Value) Value {
+	host, port, key := args[0].bulk, args[1].bulk, args[2].bulk
+
+	target, err := strconv.Atoi(args[3].bulk)
+	if err != nil {
+		return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+	}
+	timeoutMs, err := strconv.ParseInt(args[4].bulk, 10, 64)
+	if err != nil {
+		return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+	}
+	if timeoutMs <= 0 {
+		timeoutMs = 1000
+	}
+	timeout := time.Duration(timeoutMs) * time.Millisecond
+
+	opts, errMsg := parseMigrateOptions(args[5:])
+	if errMsg != "" {
+		return Value{typ: "error", str: errMsg}
+	}
+
+	names := []string{key}
+	if opts.keys != nil {
+		if key != "" {
+			return Value{typ: "error", str: "ERR When using MIGRATE KEYS option, the key argument must be set to the empty string"}
+		}
+		names = opts.keys
+	}
+
+	db := databases[selectedDB]
+	now := time.Now().UnixMilli()
+
+	keys := []migratedKey{}
+	for _, name := range names {
+		v, ok := db.lookup(name)
+		if !ok {
+			continue
+		}
+
+		ttl := int64(0)
+		if v.bit == keyString && v.str.expiresAt > 0 {
+			// string deadlines are reached once their second is over
+			ttl = max((v.str.expiresAt+1)*1000-now, 1)
+		}
+		keys = append(keys, migratedKey{key: name, ttl: ttl, payload: string(dumpPayload(v))})
+	}
+	if len(keys) == 0 {
+		return Value{typ: "string", str: "NOKEY"}
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: MIGRATE %s:%s (%d keys)", host, port, len(keys)))
+
+	migrateConnsMu.Lock()
+	defer migrateConnsMu.Unlock()
+
+	addr := net.JoinHostPort(host, port)
+	var replies []Value
+	for {
+		mc, cached, err := migrateConnect(addr, timeout)
+		if err != nil {
+			return Value{typ: "error", str: "IOERR error or timeout connecting to the client"}
+		}
+
+		replies, err = migrateSend(mc, target, timeout, opts, keys)
+		mc.lastUse = time.Now()
+		if err == nil {
+			break
+		}
+		migrateDrop(addr, mc)
+
+		// the other end may have closed a connection that sat in the cache,
+		// a new one is tried once unless the time ran out
+		if ioErr := err.(*migrateIOError); !cached || errors.Is(err, os.ErrDeadlineExceeded) {
+			return Value{typ: "error", str: "IOERR error or timeout " + ioErr.op}
+		}
+	}
+
+	failed := ""
+	for i, reply := range replies {
+		if reply.typ == "error" {
+			if failed == "" {
+				failed = reply.str
+			}
+			continue
+		}
+		if opts.copy {
+			continue
+		}
+
+		db.remove(keys[i].key)
+		propagate("DEL", keys[i].key)
+		notifyKeyspaceEvent(notifyGeneric, "del", keys[i].key)
+	}
+
+	if failed != "" {
+		return Value{typ: "error", str: "ERR Target instance replied with error: " + failed}
+	}
+	return Value{typ: "string", str: "OK"}
+}
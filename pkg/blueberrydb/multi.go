@@ -0,0 +1,444 @@
+// transactions: MULTI queues commands of a connection and EXEC runs them as one unit
+package blueberrydb
+
+import (
+	"blueberrydb/internal/logger"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// commands run with the read lock held, EXEC takes the write lock so
+// no other command interleaves with a transaction
+var commandsMu = sync.RWMutex{}
+
+// set while EXEC or a script runs, blocking commands return right away instead of waiting, guarded by commandsMu
+var blockingDisabled = false
+
+// set while the effects of EXEC or a script are wrapped in MULTI and EXEC, guarded by commandsMu
+var propagatingAtomically = false
+
+// commands that take the commandsMu write lock, nothing runs alongside them
+var exclusiveCommands = map[string]bool{
+	"EVAL":     true,
+	"EVALSHA":  true,
+	"FUNCTION": true,
+	"FCALL":    true,
+	"FCALL_RO": true,
+
+	// touch databases other than the selected one
+	"MOVE":     true,
+	"SWAPDB":   true,
+	"FLUSHDB":  true,
+	"FLUSHALL": true,
+	"RENAME":   true,
+	"RENAMENX": true,
+	"COPY":     true,
+	"RESTORE":  true,
+
+	// keeps the keys it moves from changing until the other end has them
+	"MIGRATE": true,
+}
+
+// commands that run without commandsMu, SCRIPT KILL has to reach a running script
+var unlockedCommands = map[string]bool{
+	"SCRIPT": true,
+}
+
+// commands a connection in a transaction runs right away instead of queueing them
+var transactionControl = map[string]bool{
+	"MULTI":   true,
+	"EXEC":    true,
+	"DISCARD": true,
+	"WATCH":   true,
+}
+
+// a key of a database
+type watchedKey struct {
+	db  int
+	key string
+}
+
+// clients watching each key
+var watchedKeys = map[watchedKey]map[*Client]bool{}
+var watchMu = sync.Mutex{}
+
+// runs a command of a client and persists it when it modifies the dataset
+func Execute(c *Client, value Value) Value {
+	command := strings.ToUpper(value.array[0].bulk)
+	if unlockedCommands[command] {
+		return execute(c, value)
+	}
+
+	evictIfNeeded()
+
+	exclusive := exclusiveCommands[command]
+	if !lockDatabase(c.db, exclusive) {
+		return busyError
+	}
+	if exclusive {
+		defer commandsMu.Unlock()
+	} else {
+		defer commandsMu.RUnlock()
+	}
+
+	return execute(c, value)
+}
+
+var busyError = Value{typ: "error", str: "BUSY blueberrydb is busy running a script. You can only call SCRIPT KILL."}
+
+// takes commandsMu, giving up once a script holding it runs past the time limit
+func lockCommands(exclusive bool) bool {
+	lock, unlock := commandsMu.RLock, commandsMu.RUnlock
+	if exclusive {
+		lock, unlock = commandsMu.Lock, commandsMu.Unlock
+	}
+
+	if exclusive && commandsMu.TryLock() || !exclusive && commandsMu.TryRLock() {
+		return true
+	}
+
+	// the signal is taken before the check so a timeout in between is not missed
+	busy := scriptBusySignal()
+	if scriptTimedOut() {
+		return false
+	}
+
+	locked := make(chan struct{})
+	go func() {
+		lock()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		return true
+	case <-busy:
+		// the lock is still taken once the script is done, and given back right away
+		go func() {
+			<-locked
+			unlock()
+		}()
+		return false
+	}
+}
+
+// runs fn with the commands it persists wrapped in MULTI and EXEC, MULTI is only
+// written once something is persisted, caller must hold the commandsMu write lock
+func propagateAtomically(fn func()) {
+	persist := propagator
+	if persist == nil || propagatingAtomically {
+		fn()
+		return
+	}
+
+	wrapped := false
+	propagator = func(value Value) {
+		if !wrapped {
+			wrapped = true
+			persist(Value{typ: "array", array: []Value{{typ: "bulk", bulk: "MULTI"}}})
+		}
+		persist(value)
+	}
+	propagatingAtomically = true
+
+	fn()
+
+	propagatingAtomically = false
+	propagator = persist
+	if wrapped {
+		persist(Value{typ: "array", array: []Value{{typ: "bulk", bulk: "EXEC"}}})
+	}
+}
+
+// runs a command, caller must hold commandsMu, c is nil for commands called from scripts
+func execute(c *Client, value Value) Value {
+	command := strings.ToUpper(value.array[0].bulk)
+
+	// rejected before anything is persisted
+	if reply, wrong := arityError(value); wrong {
+		return reply
+	}
+	unlockKeys := lockKeyTypes(value)
+	defer unlockKeys()
+	if reply, wrong := typeError(value); wrong {
+		return reply
+	}
+	if reply, denied := oomError(value); denied {
+		return reply
+	}
+	touchKeys(value)
+
+	if IsWriteCommand(command) && propagator != nil {
+		propagator(value)
+	}
+
+	var reply Value
+	if cmd, ok := registeredCommands[command]; ok {
+		reply = cmd.call(c, value.array[1:])
+	} else {
+		reply = Handlers[command](value.array[1:])
+	}
+
+	updateKeysMemory(value)
+	return reply
+}
+
+// reports whether the client is between MULTI and EXEC or DISCARD
+func (c *Client) InTransaction() bool {
+	return c.multi
+}
+
+// queues a command of a client in a transaction, returns false if the command has to run right away
+func (c *Client) Queue(value Value) bool {
+	command := strings.ToUpper(value.array[0].bulk)
+	if !c.multi || transactionControl[command] {
+		return false
+	}
+
+	// errors while queueing make EXEC discard the whole transaction
+	if reply, ok := CheckCommand(value); !ok {
+		c.dirty = true
+		c.Write(reply)
+		return true
+	}
+	if _, ok := ClientHandlers[command]; ok && command != "UNWATCH" && command != "SELECT" {
+		c.dirty = true
+		c.Write(Value{typ: "error", str: fmt.Sprintf("ERR Command '%s' not allowed inside a transaction", strings.ToLower(command))})
+		return true
+	}
+	evictIfNeeded()
+	if reply, denied := oomError(value); denied {
+		c.dirty = true
+		c.Write(reply)
+		return true
+	}
+
+	c.queue = append(c.queue, value)
+	c.Write(Value{typ: "string", str: "QUEUED"})
+
+	return true
+}
+
+func (c *Client) resetTransaction() {
+	c.multi = false
+	c.dirty = false
+	c.queue = nil
+}
+
+// marks the transactions of the clients watching a key as failed
+func touchWatchedKey(db int, key string) {
+	watchMu.Lock()
+	defer watchMu.Unlock()
+
+	for c := range watchedKeys[watchedKey{db, key}] {
+		c.watchDirty = true
+	}
+}
+
+// marks the transactions of the clients watching any key of the databases as failed
+func touchWatchedDatabases(indexes ...int) {
+	watchMu.Lock()
+	defer watchMu.Unlock()
+
+	for _, db := range indexes {
+		for key, clients := range watchedKeys {
+			if key.db != db {
+				continue
+			}
+			for c := range clients {
+				c.watchDirty = true
+			}
+		}
+	}
+}
+
+// reports whether a watched key changed or expired since WATCH, caller must hold commandsMu
+func (c *Client) watchedKeysChanged() bool {
+	// same lock order as the writers that touch watched keys
+	SETsMu.RLock()
+	defer SETsMu.RUnlock()
+	watchMu.Lock()
+	defer watchMu.Unlock()
+
+	if c.watchDirty {
+		return true
+	}
+
+	// keys that expired without anyone touching them are still gone
+	now := time.Now().Unix()
+	for key, expiredBefore := range c.watched {
+		if entry, ok := databases[key.db].strings[key.key]; ok && entry.expired(now) && !expiredBefore {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Client) unwatchAll() {
+	watchMu.Lock()
+	defer watchMu.Unlock()
+
+	for key := range c.watched {
+		delete(watchedKeys[key], c)
+		if len(watchedKeys[key]) == 0 {
+			delete(watchedKeys, key)
+		}
+	}
+
+	c.watched = map[watchedKey]bool{}
+	c.watchDirty = false
+}
+
+// WATCH command: EXEC fails if one of the keys changes before it
+func watch(c *Client, args []Value) {
+	if c.multi {
+		c.Write(Value{typ: "error", str: "ERR WATCH inside MULTI is not allowed"})
+		return
+	}
+
+	now := time.Now().Unix()
+
+	// the keys are those of the database of the connection
+	if !lockDatabase(c.db, false) {
+		c.Write(busyError)
+		return
+	}
+	defer commandsMu.RUnlock()
+
+	SETsMu.RLock()
+	watchMu.Lock()
+	for _, arg := range args {
+		key := watchedKey{c.db, arg.bulk}
+		if _, ok := c.watched[key]; ok {
+			continue
+		}
+
+		entry, exists := SETs[key.key]
+		c.watched[key] = exists && entry.expired(now)
+
+		if watchedKeys[key] == nil {
+			watchedKeys[key] = map[*Client]bool{}
+		}
+		watchedKeys[key][c] = true
+	}
+	watchMu.Unlock()
+	SETsMu.RUnlock()
+
+	c.Write(Value{typ: "string", str: "OK"})
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: WATCH (%d keys)", len(args)))
+}
+
+// UNWATCH command
+func unwatch(c *Client, args []Value) {
+	c.unwatchAll()
+	c.Write(Value{typ: "string", str: "OK"})
+
+	// debug
+	logger.Debug("command executed: UNWATCH")
+}
+
+// MULTI command
+func multi(c *Client, args []Value) {
+	if c.multi {
+		c.Write(Value{typ: "error", str: "ERR MULTI calls can not be nested"})
+		return
+	}
+
+	c.multi = true
+	c.Write(Value{typ: "string", str: "OK"})
+
+	// debug
+	logger.Debug("command executed: MULTI")
+}
+
+// DISCARD command
+func discard(c *Client, args []Value) {
+	if !c.multi {
+		c.Write(Value{typ: "error", str: "ERR DISCARD without MULTI"})
+		return
+	}
+
+	c.resetTransaction()
+	c.unwatchAll()
+	c.Write(Value{typ: "string", str: "OK"})
+
+	// debug
+	logger.Debug("command executed: DISCARD")
+}
+
+// EXEC command: runs the queued commands with no other command in between, the
+// ones that modify the dataset reach the aof wrapped in MULTI and EXEC, a null
+// array is returned instead when a watched key changed
+func exec(c *Client, args []Value) {
+	if !c.multi {
+		c.Write(Value{typ: "error", str: "ERR EXEC without MULTI"})
+		return
+	}
+
+	queue, dirty := c.queue, c.dirty
+	c.resetTransaction()
+
+	if dirty {
+		c.unwatchAll()
+		c.Write(Value{typ: "error", str: "EXECABORT Transaction discarded because of previous errors."})
+		return
+	}
+
+	// nothing runs once a command that needs memory cannot have it
+	evictIfNeeded()
+	for _, value := range queue {
+		if reply, denied := oomError(value); denied {
+			c.unwatchAll()
+			c.Write(reply)
+			return
+		}
+	}
+
+	if !lockDatabase(c.db, true) {
+		c.Write(busyError)
+		return
+	}
+	defer commandsMu.Unlock()
+
+	// checked with every writer locked out, the transaction's own writes must not count
+	changed := c.watchedKeysChanged()
+	c.unwatchAll()
+	if changed {
+		c.Write(Value{typ: "nullarray"})
+
+		// debug
+		logger.Debug("command executed: EXEC aborted, a watched key changed")
+		return
+	}
+
+	results := make([]Value, 0, len(queue))
+	propagateAtomically(func() {
+		blockingDisabled = true
+
+		for _, value := range queue {
+			// EXEC unwatches everything anyway, nothing is left for a queued UNWATCH
+			switch strings.ToUpper(value.array[0].bulk) {
+			case "UNWATCH":
+				results = append(results, Value{typ: "string", str: "OK"})
+				continue
+			case "SELECT":
+				results = append(results, c.selectInTransaction(value.array[1]))
+				continue
+			}
+
+			results = append(results, execute(c, RewriteCommand(value)))
+		}
+
+		blockingDisabled = false
+	})
+
+	c.Write(Value{typ: "array", array: results})
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: EXEC (%d commands)", len(queue)))
+}
@@ -0,0 +1,125 @@
+// keyspace notifications: key changes published to __keyspace@<db>__ and __keyevent@<db>__ channels
+package blueberrydb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// event classes and channel types of notify-keyspace-events, same letters as redis
+const (
+	notifyKeyspace = 1 << iota // K
+	notifyKeyevent             // E
+	notifyGeneric              // g
+	notifyString               // $
+	notifyList                 // l
+	notifySet                  // s
+	notifyHash                 // h
+	notifyZset                 // z
+	notifyExpired              // x
+	notifyEvicted              // e
+	notifyStream               // t
+	notifyKeyMiss              // m, not part of A
+	notifyModule               // d
+	notifyNew                  // n, not part of A
+
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet | notifyHash |
+		notifyZset | notifyExpired | notifyEvicted | notifyStream | notifyModule
+)
+
+var notifyClassChars = []struct {
+	char  byte
+	class int
+}{
+	{'g', notifyGeneric}, {'$', notifyString}, {'l', notifyList}, {'s', notifySet},
+	{'h', notifyHash}, {'z', notifyZset}, {'x', notifyExpired}, {'e', notifyEvicted},
+	{'t', notifyStream}, {'d', notifyModule}, {'K', notifyKeyspace}, {'E', notifyKeyevent},
+	{'m', notifyKeyMiss}, {'n', notifyNew},
+}
+
+// enabled event classes, 0 disables notifications
+var keyspaceEvents atomic.Int64
+
+var errInvalidEventClass = errors.New("Invalid event class character. Use 'Ag$lshzxeKEtmdn'.")
+
+// parses a notify-keyspace-events string such as "Ex" or "KA"
+func parseKeyspaceEvents(flags string) (int, error) {
+	classes := 0
+
+flag:
+	for i := 0; i < len(flags); i++ {
+		if flags[i] == 'A' {
+			classes |= notifyAll
+			continue
+		}
+
+		for _, c := range notifyClassChars {
+			if c.char == flags[i] {
+				classes |= c.class
+				continue flag
+			}
+		}
+
+		return 0, errInvalidEventClass
+	}
+
+	return classes, nil
+}
+
+// formats event classes back into a notify-keyspace-events string
+func keyspaceEventsString(classes int) string {
+	var flags strings.Builder
+
+	if classes&notifyAll == notifyAll {
+		flags.WriteByte('A')
+	}
+
+	for _, c := range notifyClassChars {
+		if classes&notifyAll == notifyAll && c.class&notifyAll != 0 {
+			continue
+		}
+		if classes&c.class != 0 {
+			flags.WriteByte(c.char)
+		}
+	}
+
+	return flags.String()
+}
+
+// sets the notify-keyspace-events flags, from the config file or CONFIG SET
+func SetKeyspaceEvents(flags string) error {
+	classes, err := parseKeyspaceEvents(flags)
+	if err != nil {
+		return err
+	}
+
+	keyspaceEvents.Store(int64(classes))
+
+	return nil
+}
+
+// called by every command that changes a key: touches the watchers of the key
+// and publishes the event if its class is enabled, safe to call while holding data locks
+func notifyKeyspaceEvent(class int, event string, key string) {
+	notifyDatabaseEvent(selectedDB, class, event, key)
+}
+
+// same as notifyKeyspaceEvent for a key of database db
+func notifyDatabaseEvent(db int, class int, event string, key string) {
+	touchWatchedKey(db, key)
+
+	classes := int(keyspaceEvents.Load())
+	if classes&class == 0 {
+		return
+	}
+
+	if classes&notifyKeyspace != 0 {
+		publishMessage(fmt.Sprintf("__keyspace@%d__:%s", db, key), event)
+	}
+
+	if classes&notifyKeyevent != 0 {
+		publishMessage(fmt.Sprintf("__keyevent@%d__:%s", db, event), key)
+	}
+}
@@ -2,9 +2,12 @@
 package blueberrydb 
 
 import (
+	"blueberrydb/internal/logger"
 	"bufio"
+	"fmt"
 	"io"
 	"os"
+	"strings"
 	"sync"
 	"time"
 )
@@ -79,18 +82,69 @@ func (aof *Aof) Read (fn func(value Value)) error {
 	// construct Resp object
 	reader := NewResp(aof.file);
 
+	// commands of a transaction are applied once its EXEC is read,
+	// so a transaction cut short by a crash is left out entirely
+	var transaction []Value;
+	inTransaction := false;
+
+	// complete is the end of the last command applied or of the last EXEC,
+	// anything after it was cut short by a crash
+	offset, complete := int64(0), int64(0);
+
 	for {
 		value, err := reader.Read();
 		if err != nil {
-			if err == io.EOF {
-				break;
+			if err != io.EOF {
+				logger.Info(fmt.Sprintf("aof ends in an incomplete or invalid command: %s", err.Error()));
 			}
+			break;
+		}
+		if value.typ != "array" || len(value.array) == 0 {
+			logger.Info("aof holds something that is not a command");
+			break;
+		}
 
-			return err;
+		// the aof holds exactly what Write marshalled
+		offset += int64(len(value.Marshal()));
+
+		command := strings.ToUpper(value.array[0].bulk);
+
+		switch {
+		case command == "MULTI":
+			inTransaction = true;
+			transaction = nil;
+		case command == "EXEC":
+			for _, queued := range transaction {
+				fn(queued);
+			}
+			inTransaction = false;
+			transaction = nil;
+			complete = offset;
+		case inTransaction:
+			transaction = append(transaction, value);
+		default:
+			fn(value);
+			complete = offset;
 		}
+	}
 
-		fn(value);
+	info, err := aof.file.Stat();
+	if err != nil {
+		return err;
+	}
+
+	// cut it off the file too, or later writes would be read as part of it
+	if complete < info.Size() {
+		if inTransaction {
+			logger.Info(fmt.Sprintf("discarded an incomplete transaction of %d commands at the end of the aof", len(transaction)));
+		}
+		logger.Info(fmt.Sprintf("truncating the aof from %d to %d bytes", info.Size(), complete));
+
+		if err := aof.file.Truncate(complete); err != nil {
+			return err;
+		}
 	}
+	aof.file.Seek(complete, io.SeekStart);
 
 	return nil;
 }
@@ -0,0 +1,390 @@
+// pub/sub: channel and pattern subscriptions with messages pushed to subscribers
+package blueberrydb
+
+import (
+	"blueberrydb/internal/logger"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// subscribers of each channel and pattern, shard channels are grouped by hash slot
+var pubsubChannels = map[string]map[*Client]bool{}
+var pubsubPatterns = map[string]map[*Client]bool{}
+var pubsubShardChannels = [hashSlots]map[string]map[*Client]bool{}
+var pubsubMu = sync.RWMutex{}
+
+// a kind of subscription: channels, patterns or shard channels
+type pubsubKind struct {
+	subscribe   string
+	unsubscribe string
+	registry    func(name string) map[string]map[*Client]bool // subscribers of the names sharing a registry
+	own         func(c *Client) map[string]bool
+	count       func(c *Client) int // the subscription count reported in replies
+}
+
+var channelKind = pubsubKind{
+	subscribe:   "subscribe",
+	unsubscribe: "unsubscribe",
+	registry:    func(string) map[string]map[*Client]bool { return pubsubChannels },
+	own:         func(c *Client) map[string]bool { return c.channels },
+	count:       func(c *Client) int { return len(c.channels) + len(c.patterns) },
+}
+
+var patternKind = pubsubKind{
+	subscribe:   "psubscribe",
+	unsubscribe: "punsubscribe",
+	registry:    func(string) map[string]map[*Client]bool { return pubsubPatterns },
+	own:         func(c *Client) map[string]bool { return c.patterns },
+	count:       func(c *Client) int { return len(c.channels) + len(c.patterns) },
+}
+
+// shard channels are counted on their own, as in redis
+var shardKind = pubsubKind{
+	subscribe:   "ssubscribe",
+	unsubscribe: "sunsubscribe",
+	registry:    shardRegistry,
+	own:         func(c *Client) map[string]bool { return c.shardChannels },
+	count:       func(c *Client) int { return len(c.shardChannels) },
+}
+
+// subscribers of the shard channels in the slot of a channel, caller must hold the pubsubMu write lock
+func shardRegistry(channel string) map[string]map[*Client]bool {
+	slot := keyHashSlot(channel)
+	if pubsubShardChannels[slot] == nil {
+		pubsubShardChannels[slot] = map[string]map[*Client]bool{}
+	}
+	return pubsubShardChannels[slot]
+}
+
+// reports whether the client has any subscription, which limits it to subscription commands
+func (c *Client) Subscribed() bool {
+	pubsubMu.RLock()
+	defer pubsubMu.RUnlock()
+
+	return len(c.channels)+len(c.patterns)+len(c.shardChannels) > 0
+}
+
+// reports whether a command may run on a subscribed connection
+func AllowedWhileSubscribed(command string) bool {
+	switch command {
+	case "SUBSCRIBE", "UNSUBSCRIBE", "PSUBSCRIBE", "PUNSUBSCRIBE", "SSUBSCRIBE", "SUNSUBSCRIBE", "PING", "QUIT":
+		return true
+	}
+
+	return false
+}
+
+// reply to a subscription change: kind, channel or pattern, and the remaining subscription count
+func subscriptionReply(kind string, name Value, count int) Value {
+	return Value{typ: "array", array: []Value{
+		{typ: "bulk", bulk: kind},
+		name,
+		{typ: "integer", num: count},
+	}}
+}
+
+// adds subscriptions, one reply per name
+func subscribeGeneric(c *Client, args []Value, kind pubsubKind) {
+	pubsubMu.Lock()
+	defer pubsubMu.Unlock()
+
+	own := kind.own(c)
+	for _, arg := range args {
+		name := arg.bulk
+
+		if !own[name] {
+			own[name] = true
+			registry := kind.registry(name)
+			if registry[name] == nil {
+				registry[name] = map[*Client]bool{}
+			}
+			registry[name][c] = true
+		}
+
+		c.Write(subscriptionReply(kind.subscribe, Value{typ: "bulk", bulk: name}, kind.count(c)))
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: %s %d", strings.ToUpper(kind.subscribe), len(args)))
+}
+
+// removes subscriptions, all of them when no names are given, caller must hold the pubsubMu write lock
+func unsubscribeNames(c *Client, names []string, kind pubsubKind) {
+	own := kind.own(c)
+	if len(names) == 0 {
+		for name := range own {
+			names = append(names, name)
+		}
+	}
+
+	for _, name := range names {
+		if !own[name] {
+			continue
+		}
+
+		registry := kind.registry(name)
+		delete(own, name)
+		delete(registry[name], c)
+		if len(registry[name]) == 0 {
+			delete(registry, name)
+		}
+	}
+}
+
+func unsubscribeGeneric(c *Client, args []Value, kind pubsubKind) {
+	pubsubMu.Lock()
+	defer pubsubMu.Unlock()
+
+	names := make([]string, 0, len(args))
+	for _, arg := range args {
+		names = append(names, arg.bulk)
+	}
+
+	if len(names) == 0 {
+		for name := range kind.own(c) {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	if len(names) == 0 {
+		c.Write(subscriptionReply(kind.unsubscribe, Value{typ: "null"}, kind.count(c)))
+		return
+	}
+
+	// counts in the replies go down one name at a time
+	for _, name := range names {
+		unsubscribeNames(c, []string{name}, kind)
+		c.Write(subscriptionReply(kind.unsubscribe, Value{typ: "bulk", bulk: name}, kind.count(c)))
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: %s %d", strings.ToUpper(kind.unsubscribe), len(names)))
+}
+
+// SUBSCRIBE command
+func subscribe(c *Client, args []Value) {
+	subscribeGeneric(c, args, channelKind)
+}
+
+// UNSUBSCRIBE command
+func unsubscribe(c *Client, args []Value) {
+	unsubscribeGeneric(c, args, channelKind)
+}
+
+// PSUBSCRIBE command
+func psubscribe(c *Client, args []Value) {
+	subscribeGeneric(c, args, patternKind)
+}
+
+// PUNSUBSCRIBE command
+func punsubscribe(c *Client, args []Value) {
+	unsubscribeGeneric(c, args, patternKind)
+}
+
+// SSUBSCRIBE command
+func ssubscribe(c *Client, args []Value) {
+	if !sameSlot(args) {
+		c.Write(Value{typ: "error", str: "CROSSSLOT Keys in request don't hash to the same slot"})
+		return
+	}
+
+	subscribeGeneric(c, args, shardKind)
+}
+
+// SUNSUBSCRIBE command
+func sunsubscribe(c *Client, args []Value) {
+	if !sameSlot(args) {
+		c.Write(Value{typ: "error", str: "CROSSSLOT Keys in request don't hash to the same slot"})
+		return
+	}
+
+	unsubscribeGeneric(c, args, shardKind)
+}
+
+// reports whether all the arguments hash to one slot, so a command never spans shards
+func sameSlot(args []Value) bool {
+	for _, arg := range args {
+		if keyHashSlot(arg.bulk) != keyHashSlot(args[0].bulk) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// PING command, subscribed clients get the reply in the push format
+func pingClient(c *Client, args []Value) {
+	if !c.Subscribed() {
+		c.Write(ping(args))
+		return
+	}
+
+	if len(args) > 1 {
+		c.Write(Value{typ: "error", str: "ERR wrong number of arguments for 'ping' command"})
+		return
+	}
+
+	message := ""
+	if len(args) == 1 {
+		message = args[0].bulk
+	}
+
+	c.Write(Value{typ: "array", array: []Value{{typ: "bulk", bulk: "pong"}, {typ: "bulk", bulk: message}}})
+}
+
+// drops every subscription of a disconnecting client
+func pubsubUnsubscribeAll(c *Client) {
+	pubsubMu.Lock()
+	defer pubsubMu.Unlock()
+
+	unsubscribeNames(c, nil, channelKind)
+	unsubscribeNames(c, nil, patternKind)
+	unsubscribeNames(c, nil, shardKind)
+}
+
+// PUBLISH command: returns the number of clients that received the message
+func publish(args []Value) Value {
+	channel := args[0].bulk
+	receivers := publishMessage(channel, args[1].bulk)
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: PUBLISH %s (%d receivers)", channel, receivers))
+
+	return Value{typ: "integer", num: receivers}
+}
+
+// pushes a message to the subscribers of a channel and the matching patterns, returns the number of receivers
+func publishMessage(channel string, payload string) int {
+	pubsubMu.RLock()
+	defer pubsubMu.RUnlock()
+
+	receivers := 0
+
+	if subscribers := pubsubChannels[channel]; len(subscribers) > 0 {
+		message := Value{typ: "array", array: []Value{
+			{typ: "bulk", bulk: "message"},
+			{typ: "bulk", bulk: channel},
+			{typ: "bulk", bulk: payload},
+		}}.Marshal()
+
+		for c := range subscribers {
+			c.push(message)
+			receivers++
+		}
+	}
+
+	for pattern, subscribers := range pubsubPatterns {
+		if !globMatch(pattern, channel) {
+			continue
+		}
+
+		message := Value{typ: "array", array: []Value{
+			{typ: "bulk", bulk: "pmessage"},
+			{typ: "bulk", bulk: pattern},
+			{typ: "bulk", bulk: channel},
+			{typ: "bulk", bulk: payload},
+		}}.Marshal()
+
+		for c := range subscribers {
+			c.push(message)
+			receivers++
+		}
+	}
+
+	return receivers
+}
+
+// SPUBLISH command: like PUBLISH but only for the subscribers of the shard channel in its slot
+func spublish(args []Value) Value {
+	channel := args[0].bulk
+	payload := args[1].bulk
+
+	pubsubMu.RLock()
+	defer pubsubMu.RUnlock()
+
+	subscribers := pubsubShardChannels[keyHashSlot(channel)][channel]
+	if len(subscribers) > 0 {
+		message := Value{typ: "array", array: []Value{
+			{typ: "bulk", bulk: "smessage"},
+			{typ: "bulk", bulk: channel},
+			{typ: "bulk", bulk: payload},
+		}}.Marshal()
+
+		for c := range subscribers {
+			c.push(message)
+		}
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: SPUBLISH %s (%d receivers)", channel, len(subscribers)))
+
+	return Value{typ: "integer", num: len(subscribers)}
+}
+
+// names matching an optional pattern, sorted
+func pubsubNames(registry map[string]map[*Client]bool, args []Value, names []string) []string {
+	for name := range registry {
+		if len(args) == 1 || globMatch(args[1].bulk, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// array reply of the names, sorted
+func sortedBulkArray(names []string) Value {
+	sort.Strings(names)
+
+	values := make([]Value, 0, len(names))
+	for _, name := range names {
+		values = append(values, Value{typ: "bulk", bulk: name})
+	}
+	return Value{typ: "array", array: values}
+}
+
+// PUBSUB command: CHANNELS [pattern], NUMSUB [channel ...], NUMPAT,
+// SHARDCHANNELS [pattern] and SHARDNUMSUB [channel ...]
+func pubsub(args []Value) Value {
+	subcommand := strings.ToUpper(args[0].bulk)
+
+	pubsubMu.RLock()
+	defer pubsubMu.RUnlock()
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: PUBSUB %s", subcommand))
+
+	switch {
+	case subcommand == "CHANNELS" && len(args) <= 2:
+		return sortedBulkArray(pubsubNames(pubsubChannels, args, []string{}))
+
+	case subcommand == "SHARDCHANNELS" && len(args) <= 2:
+		channels := []string{}
+		for _, registry := range pubsubShardChannels {
+			channels = pubsubNames(registry, args, channels)
+		}
+		return sortedBulkArray(channels)
+
+	case subcommand == "NUMSUB":
+		values := make([]Value, 0, 2*(len(args)-1))
+		for _, arg := range args[1:] {
+			values = append(values, Value{typ: "bulk", bulk: arg.bulk}, Value{typ: "integer", num: len(pubsubChannels[arg.bulk])})
+		}
+		return Value{typ: "array", array: values}
+
+	case subcommand == "SHARDNUMSUB":
+		values := make([]Value, 0, 2*(len(args)-1))
+		for _, arg := range args[1:] {
+			subscribers := pubsubShardChannels[keyHashSlot(arg.bulk)][arg.bulk]
+			values = append(values, Value{typ: "bulk", bulk: arg.bulk}, Value{typ: "integer", num: len(subscribers)})
+		}
+		return Value{typ: "array", array: values}
+
+	case subcommand == "NUMPAT" && len(args) == 1:
+		return Value{typ: "integer", num: len(pubsubPatterns)}
+	}
+
+	return Value{typ: "error", str: fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try PUBSUB HELP.", args[0].bulk)}
+}
@@ -0,0 +1,265 @@
+// compressed radix tree over byte string keys, iterable in key order
+package blueberrydb
+
+import (
+	"bytes"
+	"sort"
+)
+
+type radixNode[V any] struct {
+	prefix   []byte          // edge label leading to this node
+	children []*radixNode[V] // ordered by the first byte of their prefix
+	value    V
+	isKey    bool
+}
+
+type radixTree[V any] struct {
+	root  *radixNode[V]
+	size  int // number of keys
+	nodes int // number of nodes, including the root
+}
+
+func newRadixTree[V any]() *radixTree[V] {
+	return &radixTree[V]{root: &radixNode[V]{}, nodes: 1}
+}
+
+// position of the child whose prefix starts with b, or where it would be inserted
+func (n *radixNode[V]) childIndex(b byte) (int, bool) {
+	i := sort.Search(len(n.children), func(i int) bool { return n.children[i].prefix[0] >= b })
+	return i, i < len(n.children) && n.children[i].prefix[0] == b
+}
+
+func commonPrefixLen(a []byte, b []byte) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+
+	return i
+}
+
+// stores a value under key, returns true when the key is new
+func (t *radixTree[V]) insert(key []byte, value V) bool {
+	n := t.root
+	for {
+		if len(key) == 0 {
+			added := !n.isKey
+			n.value = value
+			n.isKey = true
+			if added {
+				t.size++
+			}
+			return added
+		}
+
+		i, found := n.childIndex(key[0])
+		if !found {
+			child := &radixNode[V]{prefix: bytes.Clone(key), value: value, isKey: true}
+			n.children = append(n.children, nil)
+			copy(n.children[i+1:], n.children[i:])
+			n.children[i] = child
+			t.nodes++
+			t.size++
+			return true
+		}
+
+		child := n.children[i]
+		common := commonPrefixLen(child.prefix, key)
+		if common < len(child.prefix) {
+			// split the edge where the key leaves it
+			split := &radixNode[V]{prefix: child.prefix[:common:common], children: []*radixNode[V]{child}}
+			child.prefix = child.prefix[common:]
+			n.children[i] = split
+			t.nodes++
+			child = split
+		}
+
+		n = child
+		key = key[common:]
+	}
+}
+
+// value stored under key
+func (t *radixTree[V]) find(key []byte) (V, bool) {
+	n := t.root
+	for len(key) > 0 {
+		i, found := n.childIndex(key[0])
+		if !found || !bytes.HasPrefix(key, n.children[i].prefix) {
+			var zero V
+			return zero, false
+		}
+
+		key = key[len(n.children[i].prefix):]
+		n = n.children[i]
+	}
+
+	return n.value, n.isKey
+}
+
+// removes key, reports whether it was present
+func (t *radixTree[V]) remove(key []byte) bool {
+	var parent *radixNode[V]
+	n := t.root
+	for len(key) > 0 {
+		i, found := n.childIndex(key[0])
+		if !found || !bytes.HasPrefix(key, n.children[i].prefix) {
+			return false
+		}
+
+		key = key[len(n.children[i].prefix):]
+		parent = n
+		n = n.children[i]
+	}
+
+	if !n.isKey {
+		return false
+	}
+
+	var zero V
+	n.value = zero
+	n.isKey = false
+	t.size--
+
+	// drop the empty leaf, its parent may be left with a single child
+	if len(n.children) == 0 && parent != nil {
+		i, _ := parent.childIndex(n.prefix[0])
+		parent.children = append(parent.children[:i], parent.children[i+1:]...)
+		t.nodes--
+		n = parent
+	}
+
+	t.compress(n)
+	return true
+}
+
+// merges a node that holds no key with its only child
+func (t *radixTree[V]) compress(n *radixNode[V]) {
+	if n == t.root || n.isKey || len(n.children) != 1 {
+		return
+	}
+
+	child := n.children[0]
+	n.prefix = append(bytes.Clone(n.prefix), child.prefix...)
+	n.children = child.children
+	n.value = child.value
+	n.isKey = child.isKey
+	t.nodes--
+}
+
+// smallest key in the subtree, path is the key of n
+func (n *radixNode[V]) first(path []byte) ([]byte, V, bool) {
+	for !n.isKey {
+		if len(n.children) == 0 {
+			var zero V
+			return nil, zero, false
+		}
+		n = n.children[0]
+		path = append(path, n.prefix...)
+	}
+
+	return path, n.value, true
+}
+
+// largest key in the subtree, path is the key of n
+func (n *radixNode[V]) last(path []byte) ([]byte, V, bool) {
+	for len(n.children) > 0 {
+		n = n.children[len(n.children)-1]
+		path = append(path, n.prefix...)
+	}
+
+	if !n.isKey {
+		var zero V
+		return nil, zero, false
+	}
+
+	return path, n.value, true
+}
+
+func (n *radixNode[V]) seekGE(key []byte, path []byte) ([]byte, V, bool) {
+	if len(key) == 0 {
+		return n.first(path)
+	}
+
+	// a key at n is a proper prefix of key, so it sorts before it
+	for _, child := range n.children {
+		m := min(len(child.prefix), len(key))
+		cmp := bytes.Compare(child.prefix[:m], key[:m])
+		if cmp < 0 {
+			continue
+		}
+
+		childPath := append(path[:len(path):len(path)], child.prefix...)
+		if cmp > 0 || len(child.prefix) >= len(key) {
+			return child.first(childPath)
+		}
+
+		if k, v, ok := child.seekGE(key[len(child.prefix):], childPath); ok {
+			return k, v, ok
+		}
+	}
+
+	var zero V
+	return nil, zero, false
+}
+
+func (n *radixNode[V]) seekLE(key []byte, path []byte) ([]byte, V, bool) {
+	if len(key) == 0 {
+		// every descendant is longer and sorts after key
+		if n.isKey {
+			return path, n.value, true
+		}
+
+		var zero V
+		return nil, zero, false
+	}
+
+	for i := len(n.children) - 1; i >= 0; i-- {
+		child := n.children[i]
+		m := min(len(child.prefix), len(key))
+		cmp := bytes.Compare(child.prefix[:m], key[:m])
+		if cmp > 0 || (cmp == 0 && len(child.prefix) > len(key)) {
+			continue
+		}
+
+		childPath := append(path[:len(path):len(path)], child.prefix...)
+		if cmp < 0 {
+			return child.last(childPath)
+		}
+
+		if k, v, ok := child.seekLE(key[len(child.prefix):], childPath); ok {
+			return k, v, ok
+		}
+	}
+
+	if n.isKey {
+		return path, n.value, true
+	}
+
+	var zero V
+	return nil, zero, false
+}
+
+// smallest key that is greater than or equal to key
+func (t *radixTree[V]) seekGE(key []byte) ([]byte, V, bool) {
+	return t.root.seekGE(key, nil)
+}
+
+// largest key that is smaller than or equal to key
+func (t *radixTree[V]) seekLE(key []byte) ([]byte, V, bool) {
+	return t.root.seekLE(key, nil)
+}
+
+// calls fn for every key in order
+func (t *radixTree[V]) walk(fn func(key []byte, value V)) {
+	t.root.walk(nil, fn)
+}
+
+func (n *radixNode[V]) walk(path []byte, fn func(key []byte, value V)) {
+	if n.isKey {
+		fn(path, n.value)
+	}
+
+	for _, child := range n.children {
+		child.walk(append(path[:len(path):len(path)], child.prefix...), fn)
+	}
+}
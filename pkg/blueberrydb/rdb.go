@@ -0,0 +1,947 @@
+// values in the rdb encoding of redis, the body of DUMP payloads
+package blueberrydb
+
+import (
+	"encoding/binary"
+	"hash/crc64"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// value types of the rdb format, the ones written plus the compact encodings
+// redis dumps small hashes and sorted sets in
+const (
+	rdbTypeString          = 0
+	rdbTypeHash            = 4
+	rdbTypeZset2           = 5
+	rdbTypeStreamListpacks = 15
+	rdbTypeHashListpack    = 16
+	rdbTypeZsetListpack    = 17
+	rdbTypeStream2         = 19
+	rdbTypeStream3         = 21
+	rdbTypeHashMetadata    = 22 // hash with field ttls
+)
+
+// the newest rdb version whose values can be read, payloads carry the
+// oldest version that knows their type so older servers take them too
+const (
+	rdbVersion       = 12
+	rdbVersionBase   = 9 // strings, hashes and sorted sets
+	rdbVersionStream = 11
+)
+
+// crc64 with the jones polynomial, no initial value and no final xor, as redis computes it
+var rdbCRCTable = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+func rdbChecksum(p []byte) uint64 {
+	return ^crc64.Update(^uint64(0), rdbCRCTable, p)
+}
+
+// length prefixes and the special string encodings that share their first byte
+const (
+	rdbLen6     = 0
+	rdbLen14    = 1
+	rdbLen32    = 0x80
+	rdbLen64    = 0x81
+	rdbEncoded  = 3
+	rdbEncInt8  = 0
+	rdbEncInt16 = 1
+	rdbEncInt32 = 2
+	rdbEncLZF   = 3
+)
+
+// builds rdb encoded data
+type rdbWriter struct {
+	buf []byte
+}
+
+func (w *rdbWriter) length(n uint64) {
+	switch {
+	case n < 1<<6:
+		w.buf = append(w.buf, byte(n))
+	case n < 1<<14:
+		w.buf = append(w.buf, byte(n>>8)|rdbLen14<<6, byte(n))
+	case n <= math.MaxUint32:
+		w.buf = append(w.buf, rdbLen32)
+		w.buf = binary.BigEndian.AppendUint32(w.buf, uint32(n))
+	default:
+		w.buf = append(w.buf, rdbLen64)
+		w.buf = binary.BigEndian.AppendUint64(w.buf, n)
+	}
+}
+
+// strings holding a 32 bit integer are stored as the integer, like redis does
+func (w *rdbWriter) string(s string) {
+	if n, ok := parseCanonicalInt(s); ok && n >= math.MinInt32 && n <= math.MaxInt32 {
+		switch {
+		case n >= math.MinInt8 && n <= math.MaxInt8:
+			w.buf = append(w.buf, rdbEncoded<<6|rdbEncInt8, byte(n))
+		case n >= math.MinInt16 && n <= math.MaxInt16:
+			w.buf = append(w.buf, rdbEncoded<<6|rdbEncInt16)
+			w.buf = binary.LittleEndian.AppendUint16(w.buf, uint16(n))
+		default:
+			w.buf = append(w.buf, rdbEncoded<<6|rdbEncInt32)
+			w.buf = binary.LittleEndian.AppendUint32(w.buf, uint32(n))
+		}
+		return
+	}
+
+	w.length(uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+func (w *rdbWriter) millis(ms int64) {
+	w.buf = binary.LittleEndian.AppendUint64(w.buf, uint64(ms))
+}
+
+func (w *rdbWriter) double(f float64) {
+	w.buf = binary.LittleEndian.AppendUint64(w.buf, math.Float64bits(f))
+}
+
+func (w *rdbWriter) streamID(id streamID) {
+	w.length(id.ms)
+	w.length(id.seq)
+}
+
+// reads rdb encoded data, the first malformed read marks the reader bad and
+// every later read returns zero values
+type rdbReader struct {
+	buf []byte
+	pos int
+	bad bool
+}
+
+func (r *rdbReader) bytes(n uint64) []byte {
+	if r.bad || n > uint64(len(r.buf)-r.pos) {
+		r.bad = true
+		return nil
+	}
+
+	p := r.buf[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return p
+}
+
+func (r *rdbReader) byte() byte {
+	p := r.bytes(1)
+	if p == nil {
+		return 0
+	}
+	return p[0]
+}
+
+// a length or, when encoded is set, the kind of special string encoding that follows
+func (r *rdbReader) lengthOrEncoding() (n uint64, encoded bool) {
+	first := r.byte()
+
+	switch first >> 6 {
+	case rdbLen6:
+		return uint64(first & 0x3f), false
+	case rdbLen14:
+		return uint64(first&0x3f)<<8 | uint64(r.byte()), false
+	case rdbEncoded:
+		return uint64(first & 0x3f), true
+	}
+
+	switch first {
+	case rdbLen32:
+		if p := r.bytes(4); p != nil {
+			return uint64(binary.BigEndian.Uint32(p)), false
+		}
+	case rdbLen64:
+		if p := r.bytes(8); p != nil {
+			return binary.BigEndian.Uint64(p), false
+		}
+	default:
+		r.bad = true
+	}
+	return 0, false
+}
+
+func (r *rdbReader) length() uint64 {
+	n, encoded := r.lengthOrEncoding()
+	if encoded {
+		r.bad = true
+	}
+	return n
+}
+
+// a count of items that each take at least a byte, so a corrupt count cannot
+// make the reader allocate more than the payload holds
+func (r *rdbReader) count() int {
+	n := r.length()
+	if n > uint64(len(r.buf)-r.pos) {
+		r.bad = true
+		return 0
+	}
+	return int(n)
+}
+
+func (r *rdbReader) string() string {
+	n, encoded := r.lengthOrEncoding()
+	if !encoded {
+		return string(r.bytes(n))
+	}
+
+	switch n {
+	case rdbEncInt8:
+		return strconv.Itoa(int(int8(r.byte())))
+	case rdbEncInt16:
+		if p := r.bytes(2); p != nil {
+			return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(p))))
+		}
+	case rdbEncInt32:
+		if p := r.bytes(4); p != nil {
+			return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(p))))
+		}
+	case rdbEncLZF:
+		compressed, size := r.length(), r.length()
+		// lzf output is at most 264 times its input
+		if r.bad || compressed > uint64(len(r.buf)-r.pos) || size > compressed*264 {
+			r.bad = true
+			return ""
+		}
+		out, ok := lzfDecompress(r.bytes(compressed), int(size))
+		if !ok {
+			r.bad = true
+		}
+		return string(out)
+	default:
+		r.bad = true
+	}
+	return ""
+}
+
+func (r *rdbReader) millis() int64 {
+	if p := r.bytes(8); p != nil {
+		return int64(binary.LittleEndian.Uint64(p))
+	}
+	return 0
+}
+
+func (r *rdbReader) double() float64 {
+	if p := r.bytes(8); p != nil {
+		return math.Float64frombits(binary.LittleEndian.Uint64(p))
+	}
+	return 0
+}
+
+func (r *rdbReader) streamID() streamID {
+	return streamID{ms: r.length(), seq: r.length()}
+}
+
+// expands lzf compressed data of a known size
+func lzfDecompress(in []byte, size int) ([]byte, bool) {
+	out := make([]byte, 0, size)
+
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+
+		// a literal run of ctrl+1 bytes
+		if ctrl < 1<<5 {
+			n := ctrl + 1
+			if i+n > len(in) || len(out)+n > size {
+				return nil, false
+			}
+			out = append(out, in[i:i+n]...)
+			i += n
+			continue
+		}
+
+		// a back reference, the length in the top bits and the offset in the rest
+		n := ctrl >> 5
+		if n == 7 {
+			if i == len(in) {
+				return nil, false
+			}
+			n += int(in[i])
+			i++
+		}
+		if i == len(in) {
+			return nil, false
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+		i++
+
+		n += 2
+		if ref < 0 || len(out)+n > size {
+			return nil, false
+		}
+		// the reference may overlap the bytes being written
+		for j := 0; j < n; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+
+	return out, len(out) == size
+}
+
+// listpack element encodings
+const (
+	lpEncUint7    = 0x00
+	lpEncStr6     = 0x80
+	lpEncInt13    = 0xc0
+	lpEncStr12    = 0xe0
+	lpEncStr32    = 0xf0
+	lpEncInt16    = 0xf1
+	lpEncInt24    = 0xf2
+	lpEncInt32    = 0xf3
+	lpEncInt64    = 0xf4
+	lpEOF         = 0xff
+	lpHeaderSize  = 6
+	lpUnknownSize = math.MaxUint16
+)
+
+// builds a listpack, the serialized list redis keeps stream entries in
+type listpack struct {
+	buf   []byte
+	count int
+}
+
+func (lp *listpack) appendInt(n int64) {
+	var enc []byte
+	switch {
+	case n >= 0 && n < 1<<7:
+		enc = []byte{byte(n)}
+	case n >= -1<<12 && n < 1<<12:
+		u := uint16(n) & 0x1fff
+		enc = []byte{lpEncInt13 | byte(u>>8), byte(u)}
+	case n >= math.MinInt16 && n <= math.MaxInt16:
+		enc = binary.LittleEndian.AppendUint16([]byte{lpEncInt16}, uint16(n))
+	case n >= -1<<23 && n < 1<<23:
+		u := uint32(n)
+		enc = []byte{lpEncInt24, byte(u), byte(u >> 8), byte(u >> 16)}
+	case n >= math.MinInt32 && n <= math.MaxInt32:
+		enc = binary.LittleEndian.AppendUint32([]byte{lpEncInt32}, uint32(n))
+	default:
+		enc = binary.LittleEndian.AppendUint64([]byte{lpEncInt64}, uint64(n))
+	}
+	lp.appendElement(enc)
+}
+
+// strings holding an integer are stored as the integer, like redis does
+func (lp *listpack) appendString(s string) {
+	if n, ok := parseCanonicalInt(s); ok {
+		lp.appendInt(n)
+		return
+	}
+
+	var enc []byte
+	switch {
+	case len(s) < 1<<6:
+		enc = []byte{lpEncStr6 | byte(len(s))}
+	case len(s) < 1<<12:
+		enc = []byte{lpEncStr12 | byte(len(s)>>8), byte(len(s))}
+	default:
+		enc = binary.LittleEndian.AppendUint32([]byte{lpEncStr32}, uint32(len(s)))
+	}
+	lp.appendElement(append(enc, s...))
+}
+
+// an element followed by its length, written so it can be read backwards
+func (lp *listpack) appendElement(enc []byte) {
+	lp.buf = append(lp.buf, enc...)
+
+	size := uint64(len(enc))
+	n := lpBacklenSize(size)
+	for i := n - 1; i >= 0; i-- {
+		b := byte(size>>(7*i)) & 0x7f
+		if i < n-1 {
+			b |= 0x80
+		}
+		lp.buf = append(lp.buf, b)
+	}
+	lp.count++
+}
+
+func lpBacklenSize(size uint64) int {
+	n := 1
+	for size >= 1<<(7*n) && n < 5 {
+		n++
+	}
+	return n
+}
+
+// the listpack with its header and terminator
+func (lp *listpack) bytes() []byte {
+	count := min(lp.count, lpUnknownSize)
+
+	out := make([]byte, 0, lpHeaderSize+len(lp.buf)+1)
+	out = binary.LittleEndian.AppendUint32(out, uint32(lpHeaderSize+len(lp.buf)+1))
+	out = binary.LittleEndian.AppendUint16(out, uint16(count))
+	out = append(out, lp.buf...)
+	return append(out, lpEOF)
+}
+
+// the elements of a listpack, integers in their decimal form
+func parseListpack(p []byte) ([]string, bool) {
+	if len(p) < lpHeaderSize+1 || binary.LittleEndian.Uint32(p) != uint32(len(p)) || p[len(p)-1] != lpEOF {
+		return nil, false
+	}
+	count := int(binary.LittleEndian.Uint16(p[4:]))
+
+	elements := []string{}
+	body := p[lpHeaderSize : len(p)-1]
+	for i := 0; i < len(body); {
+		element, size, ok := lpElement(body[i:])
+		if !ok {
+			return nil, false
+		}
+		i += size + lpBacklenSize(uint64(size))
+		if i > len(body) {
+			return nil, false
+		}
+		elements = append(elements, element)
+	}
+
+	if count != lpUnknownSize && count != len(elements) {
+		return nil, false
+	}
+	return elements, true
+}
+
+// the element at the start of p and the size of its encoding
+func lpElement(p []byte) (string, int, bool) {
+	// bytes of the encoding and payload after the first one
+	need := func(n int) bool { return len(p) >= 1+n }
+	signed := func(u uint64, bits uint) string {
+		return strconv.FormatInt(int64(u<<(64-bits))>>(64-bits), 10)
+	}
+
+	enc := p[0]
+	switch {
+	case enc&0x80 == lpEncUint7:
+		return strconv.Itoa(int(enc)), 1, true
+	case enc&0xc0 == lpEncStr6:
+		n := int(enc & 0x3f)
+		if !need(n) {
+			return "", 0, false
+		}
+		return string(p[1 : 1+n]), 1 + n, true
+	case enc&0xe0 == lpEncInt13:
+		if !need(1) {
+			return "", 0, false
+		}
+		return signed(uint64(enc&0x1f)<<8|uint64(p[1]), 13), 2, true
+	case enc&0xf0 == lpEncStr12:
+		if !need(1) {
+			return "", 0, false
+		}
+		n := int(enc&0x0f)<<8 | int(p[1])
+		if !need(1 + n) {
+			return "", 0, false
+		}
+		return string(p[2 : 2+n]), 2 + n, true
+	}
+
+	switch enc {
+	case lpEncStr32:
+		if !need(4) {
+			return "", 0, false
+		}
+		n := int(binary.LittleEndian.Uint32(p[1:]))
+		if n < 0 || !need(4+n) {
+			return "", 0, false
+		}
+		return string(p[5 : 5+n]), 5 + n, true
+	case lpEncInt16:
+		if need(2) {
+			return signed(uint64(binary.LittleEndian.Uint16(p[1:])), 16), 3, true
+		}
+	case lpEncInt24:
+		if need(3) {
+			return signed(uint64(p[1])|uint64(p[2])<<8|uint64(p[3])<<16, 24), 4, true
+		}
+	case lpEncInt32:
+		if need(4) {
+			return signed(uint64(binary.LittleEndian.Uint32(p[1:])), 32), 5, true
+		}
+	case lpEncInt64:
+		if need(8) {
+			return strconv.FormatInt(int64(binary.LittleEndian.Uint64(p[1:])), 10), 9, true
+		}
+	}
+	return "", 0, false
+}
+
+// stream entry flags in a listpack
+const (
+	streamItemDeleted    = 1
+	streamItemSameFields = 2
+)
+
+// the rdb type and encoding of a value, and the rdb version that introduced the type
+func encodeValue(v keyValue) (byte, []byte, uint16) {
+	w := &rdbWriter{}
+
+	switch v.bit {
+	case keyString:
+		w.string(v.str.stringValue())
+		return rdbTypeString, w.buf, rdbVersionBase
+
+	case keyHash:
+		now := time.Now().UnixMilli()
+		minExpire := int64(0)
+		live := 0
+		for _, entry := range v.hash {
+			if entry.expired(now) {
+				continue
+			}
+			live++
+			if entry.expiresAt > 0 && (minExpire == 0 || entry.expiresAt < minExpire) {
+				minExpire = entry.expiresAt
+			}
+		}
+
+		typ, version := byte(rdbTypeHash), uint16(rdbVersionBase)
+		if minExpire > 0 {
+			// field ttls are stored as the distance from the earliest one plus one, 0 for none
+			typ, version = rdbTypeHashMetadata, rdbVersion
+			w.millis(minExpire)
+		}
+
+		w.length(uint64(live))
+		for field, entry := range v.hash {
+			if entry.expired(now) {
+				continue
+			}
+			if minExpire > 0 {
+				ttl := uint64(0)
+				if entry.expiresAt > 0 {
+					ttl = uint64(entry.expiresAt-minExpire) + 1
+				}
+				w.length(ttl)
+			}
+			w.string(field)
+			w.string(entry.value)
+		}
+		return typ, w.buf, version
+
+	case keyZset:
+		w.length(uint64(v.zset.length()))
+		v.zset.each(func(member string, score float64) bool {
+			w.string(member)
+			w.double(score)
+			return true
+		})
+		return rdbTypeZset2, w.buf, rdbVersionBase
+
+	case keyStream:
+		encodeStream(w, v.stream)
+		return rdbTypeStream3, w.buf, rdbVersionStream
+	}
+
+	return 0, nil, 0
+}
+
+// a stream as its blocks in listpacks, the same layout redis keeps stream nodes in
+func encodeStream(w *rdbWriter, s *Stream) {
+	w.length(uint64(s.blocks.size))
+	s.blocks.walk(func(key []byte, block *streamBlock) {
+		w.string(string(key))
+		w.string(string(encodeStreamBlock(block)))
+	})
+
+	first := streamID{}
+	if entry := s.edgeEntry(false); entry != nil {
+		first = entry.id
+	}
+
+	w.length(uint64(s.length))
+	w.streamID(s.lastID)
+	w.streamID(first)
+	w.streamID(s.maxDeletedID)
+	w.length(uint64(s.entriesAdded))
+
+	names := make([]string, 0, len(s.groups))
+	for name := range s.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w.length(uint64(len(names)))
+	for _, name := range names {
+		g := s.groups[name]
+		w.string(name)
+		w.streamID(g.lastID)
+		w.length(uint64(g.entriesRead))
+
+		w.length(uint64(g.pel.size))
+		g.pel.walk(func(key []byte, nack *streamNACK) {
+			w.buf = append(w.buf, key...)
+			w.millis(nack.deliveryTime)
+			w.length(uint64(nack.deliveryCount))
+		})
+
+		consumers := make([]string, 0, len(g.consumers))
+		for consumer := range g.consumers {
+			consumers = append(consumers, consumer)
+		}
+		sort.Strings(consumers)
+
+		w.length(uint64(len(consumers)))
+		for _, consumerName := range consumers {
+			consumer := g.consumers[consumerName]
+			w.string(consumer.name)
+			w.millis(consumer.seenTime)
+			w.millis(consumer.activeTime)
+
+			w.length(uint64(consumer.pel.size))
+			consumer.pel.walk(func(key []byte, nack *streamNACK) {
+				w.buf = append(w.buf, key...)
+			})
+		}
+	}
+}
+
+// the entries of a block relative to its first one, entries with the fields
+// of the first one only store their values
+func encodeStreamBlock(block *streamBlock) []byte {
+	master := block.entries[0]
+	masterFields := streamFieldNames(master.fields)
+
+	lp := &listpack{}
+	lp.appendInt(int64(block.live))
+	lp.appendInt(int64(len(block.entries) - block.live))
+	lp.appendInt(int64(len(masterFields)))
+	for _, field := range masterFields {
+		lp.appendString(field)
+	}
+	lp.appendInt(0)
+
+	for _, entry := range block.entries {
+		fields := streamFieldNames(entry.fields)
+		same := equalStrings(fields, masterFields)
+
+		flags := int64(0)
+		if entry.deleted {
+			flags |= streamItemDeleted
+		}
+		if same {
+			flags |= streamItemSameFields
+		}
+
+		lp.appendInt(flags)
+		lp.appendInt(int64(entry.id.ms - master.id.ms))
+		lp.appendInt(int64(entry.id.seq - master.id.seq))
+
+		if same {
+			for i := 1; i < len(entry.fields); i += 2 {
+				lp.appendString(entry.fields[i])
+			}
+			lp.appendInt(int64(len(fields) + 3))
+			continue
+		}
+
+		lp.appendInt(int64(len(fields)))
+		for _, s := range entry.fields {
+			lp.appendString(s)
+		}
+		lp.appendInt(int64(2*len(fields) + 4))
+	}
+
+	return lp.bytes()
+}
+
+func streamFieldNames(pairs []string) []string {
+	names := make([]string, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		names = append(names, pairs[i])
+	}
+	return names
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// the value of an rdb type, false when the data is malformed, of a type
+// blueberrydb does not have or a hash or sorted set left without elements
+func decodeValue(typ byte, r *rdbReader) (keyValue, bool) {
+	switch typ {
+	case rdbTypeString:
+		return keyValue{bit: keyString, str: newStringEntry(r.string())}, !r.bad
+
+	case rdbTypeHash, rdbTypeHashMetadata:
+		now := time.Now().UnixMilli()
+		minExpire := int64(0)
+		if typ == rdbTypeHashMetadata {
+			minExpire = r.millis()
+		}
+
+		n := r.count()
+		hash := make(map[string]HashFieldStruct, n)
+		for i := 0; i < n && !r.bad; i++ {
+			entry := HashFieldStruct{}
+			if typ == rdbTypeHashMetadata {
+				if ttl := r.length(); ttl > 0 {
+					entry.expiresAt = minExpire + int64(ttl) - 1
+				}
+			}
+			field := r.string()
+			entry.value = r.string()
+			if !entry.expired(now) {
+				hash[field] = entry
+			}
+		}
+		return keyValue{bit: keyHash, hash: hash}, len(hash) > 0 && !r.bad
+
+	case rdbTypeHashListpack:
+		elements, ok := parseListpack([]byte(r.string()))
+		if !ok || len(elements)%2 != 0 {
+			return keyValue{}, false
+		}
+
+		hash := make(map[string]HashFieldStruct, len(elements)/2)
+		for i := 0; i < len(elements); i += 2 {
+			hash[elements[i]] = HashFieldStruct{value: elements[i+1]}
+		}
+		return keyValue{bit: keyHash, hash: hash}, len(hash) > 0
+
+	case rdbTypeZset2:
+		zset := newSortedSet()
+		n := r.count()
+		for i := 0; i < n && !r.bad; i++ {
+			member := r.string()
+			score := r.double()
+			if math.IsNaN(score) {
+				return keyValue{}, false
+			}
+			zset.set(member, score)
+		}
+		return keyValue{bit: keyZset, zset: zset}, zset.length() > 0 && !r.bad
+
+	case rdbTypeZsetListpack:
+		elements, ok := parseListpack([]byte(r.string()))
+		if !ok || len(elements)%2 != 0 {
+			return keyValue{}, false
+		}
+
+		zset := newSortedSet()
+		for i := 0; i < len(elements); i += 2 {
+			score, err := strconv.ParseFloat(elements[i+1], 64)
+			if err != nil || math.IsNaN(score) {
+				return keyValue{}, false
+			}
+			zset.set(elements[i], score)
+		}
+		return keyValue{bit: keyZset, zset: zset}, zset.length() > 0
+
+	case rdbTypeStreamListpacks, rdbTypeStream2, rdbTypeStream3:
+		stream, ok := decodeStream(typ, r)
+		return keyValue{bit: keyStream, stream: stream}, ok && !r.bad
+	}
+
+	return keyValue{}, false
+}
+
+// a stream of any of the three rdb stream versions, the older ones lack the
+// deletion counters, the read counters of groups and the active time of consumers
+func decodeStream(typ byte, r *rdbReader) (*Stream, bool) {
+	s := newStream()
+
+	last := streamID{}
+	first := true
+	nodes := r.count()
+	for i := 0; i < nodes && !r.bad; i++ {
+		key := r.string()
+		block, ok := decodeStreamBlock([]byte(key), []byte(r.string()))
+		if !ok || r.bad {
+			return nil, false
+		}
+
+		// entries have to go up across blocks as well
+		if !first && !last.less(block.entries[0].id) {
+			return nil, false
+		}
+		last, first = block.entries[len(block.entries)-1].id, false
+
+		s.blocks.insert([]byte(key), block)
+		s.length += block.live
+	}
+
+	if length := r.length(); length != uint64(s.length) {
+		return nil, false
+	}
+	s.lastID = r.streamID()
+	s.entriesAdded = int64(s.length)
+	if typ != rdbTypeStreamListpacks {
+		r.streamID() // the first entry, known from the blocks
+		s.maxDeletedID = r.streamID()
+		s.entriesAdded = int64(r.length())
+	}
+	if !first && s.lastID.less(last) {
+		return nil, false
+	}
+
+	groups := r.count()
+	for i := 0; i < groups && !r.bad; i++ {
+		name := r.string()
+		lastID := r.streamID()
+		entriesRead := int64(-1)
+		if typ != rdbTypeStreamListpacks {
+			entriesRead = int64(r.length())
+		}
+
+		g := newStreamGroup(lastID, entriesRead)
+		if _, exists := s.groups[name]; exists {
+			return nil, false
+		}
+		s.groups[name] = g
+
+		pending := r.count()
+		for j := 0; j < pending && !r.bad; j++ {
+			key := r.bytes(16)
+			nack := &streamNACK{deliveryTime: r.millis(), deliveryCount: int64(r.length())}
+			if !r.bad && !g.pel.insert(key, nack) {
+				return nil, false
+			}
+		}
+
+		consumers := r.count()
+		for j := 0; j < consumers && !r.bad; j++ {
+			consumer := &streamConsumer{name: r.string(), seenTime: r.millis(), pel: newRadixTree[*streamNACK]()}
+			consumer.activeTime = consumer.seenTime
+			if typ == rdbTypeStream3 {
+				consumer.activeTime = r.millis()
+			}
+			if _, exists := g.consumers[consumer.name]; exists {
+				return nil, false
+			}
+			g.consumers[consumer.name] = consumer
+
+			// the entries of a consumer are those of the group it was delivered to
+			owned := r.count()
+			for k := 0; k < owned && !r.bad; k++ {
+				key := r.bytes(16)
+				nack, ok := g.pel.find(key)
+				if r.bad || !ok || nack.consumer != nil {
+					return nil, false
+				}
+				nack.consumer = consumer
+				consumer.pel.insert(key, nack)
+			}
+		}
+
+		// every pending entry belongs to a consumer
+		orphaned := false
+		g.pel.walk(func(key []byte, nack *streamNACK) {
+			orphaned = orphaned || nack.consumer == nil
+		})
+		if orphaned {
+			return nil, false
+		}
+	}
+
+	return s, !r.bad
+}
+
+// a block from the listpack of a stream node, the key is the id of its first entry
+func decodeStreamBlock(key []byte, p []byte) (*streamBlock, bool) {
+	elements, ok := parseListpack(p)
+	if len(key) != 16 || !ok {
+		return nil, false
+	}
+	master := streamIDFromKey(key)
+
+	pos := 0
+	next := func() (string, bool) {
+		if pos == len(elements) {
+			return "", false
+		}
+		pos++
+		return elements[pos-1], true
+	}
+	nextInt := func() (int64, bool) {
+		s, ok := next()
+		if !ok {
+			return 0, false
+		}
+		n, err := strconv.ParseInt(s, 10, 64)
+		return n, err == nil
+	}
+
+	live, ok1 := nextInt()
+	deleted, ok2 := nextInt()
+	numFields, ok3 := nextInt()
+	if !ok1 || !ok2 || !ok3 || numFields < 0 || numFields > int64(len(elements)) {
+		return nil, false
+	}
+	masterFields := make([]string, numFields)
+	for i := range masterFields {
+		if masterFields[i], ok = next(); !ok {
+			return nil, false
+		}
+	}
+	if terminator, ok := nextInt(); !ok || terminator != 0 {
+		return nil, false
+	}
+
+	block := &streamBlock{}
+	for pos < len(elements) {
+		flags, ok1 := nextInt()
+		msDiff, ok2 := nextInt()
+		seqDiff, ok3 := nextInt()
+		if !ok1 || !ok2 || !ok3 {
+			return nil, false
+		}
+
+		entry := streamEntry{
+			id:      streamID{ms: master.ms + uint64(msDiff), seq: master.seq + uint64(seqDiff)},
+			deleted: flags&streamItemDeleted != 0,
+		}
+
+		var names []string
+		if flags&streamItemSameFields != 0 {
+			names = masterFields
+		} else {
+			n, ok := nextInt()
+			if !ok || n < 0 || n > int64(len(elements)) {
+				return nil, false
+			}
+			names = make([]string, n)
+		}
+
+		entry.fields = make([]string, 0, 2*len(names))
+		for _, name := range names {
+			if flags&streamItemSameFields == 0 {
+				if name, ok = next(); !ok {
+					return nil, false
+				}
+			}
+			value, ok := next()
+			if !ok {
+				return nil, false
+			}
+			entry.fields = append(entry.fields, name, value)
+		}
+
+		if _, ok := nextInt(); !ok {
+			return nil, false
+		}
+
+		if n := len(block.entries); n > 0 && !block.entries[n-1].id.less(entry.id) || n == 0 && entry.id != master {
+			return nil, false
+		}
+		block.entries = append(block.entries, entry)
+		if !entry.deleted {
+			block.live++
+		}
+	}
+
+	if block.live == 0 || int64(block.live) != live || int64(len(block.entries)-block.live) != deleted {
+		return nil, false
+	}
+	return block, true
+}
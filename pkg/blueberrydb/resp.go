@@ -48,6 +48,15 @@ func (v *Value) GetBulk() string {
 	return v.bulk;
 }
 
+// the text of simple string and error replies
+func (v *Value) GetString() string {
+	return v.str;
+}
+
+func (v *Value) GetInteger() int {
+	return v.num;
+}
+
 func (r *Resp) readLine() (line []byte, n int, err error) {
 	// loop and read
 	for {
@@ -119,14 +128,26 @@ func (r *Resp) readBulk() (Value, error) {
 		return v, err;
 	}
 
+	// $-1 is a null reply
+	if len < 0 {
+		v.typ = "null";
+		return v, nil;
+	}
+
 	bulk := make([]byte, len);
 
-	r.reader.Read(bulk);
+	// a single Read may return less than len bytes for large values
+	_, err = io.ReadFull(r.reader, bulk);
+	if err != nil {
+		return v, err;
+	}
 
 	v.bulk = string(bulk);
 
 	// read the trailing CRLF
-	r.readLine();
+	if _, _, err := r.readLine(); err != nil {
+		return v, err;
+	}
 
 	return v, nil;
 }
@@ -148,12 +169,31 @@ func (r *Resp) Read() (Value, error) {
 		return r.readArray();
 	case BULK:
 		return r.readBulk();
+	// replies, read when blueberrydb talks to another server
+	case STRING, ERROR:
+		return r.readSimple(_type);
+	case INTEGER:
+		num, _, err := r.readInteger();
+		return Value{typ: "integer", num: num}, err;
 	default:
 		logger.Error(fmt.Sprintf("Error Unknown type: %v", string(_type)))
 		return Value{}, nil;
 	}
 }
 
+// simple string and error replies
+func (r *Resp) readSimple(_type byte) (Value, error) {
+	line, _, err := r.readLine();
+	if err != nil {
+		return Value{}, err;
+	}
+
+	if _type == ERROR {
+		return Value{typ: "error", str: string(line)}, nil;
+	}
+	return Value{typ: "string", str: string(line)}, nil;
+}
+
 // RESP: SERIALIZER
 type Writer struct {
 	writer io.Writer;
@@ -198,8 +238,12 @@ func (v Value) Marshal() []byte {
 		return v.marshalString();
 	case "null":
 		return v.marshalNull();
+	case "nullarray":
+		return v.marshalNullArray();
 	case "error":
 		return v.marshalError();
+	case "integer":
+		return v.marshalInteger();
 	default:
 		// return empty byte array
 		return []byte{}
@@ -250,6 +294,22 @@ func (v Value) marshalNull() []byte {
 	return []byte("$-1\r\n");
 }
 
+// marshal null array, the reply of an aborted EXEC
+func (v Value) marshalNullArray() []byte {
+	return []byte("*-1\r\n");
+}
+
+// marshal integer
+func (v Value) marshalInteger() []byte {
+	var bytes []byte;
+
+	bytes = append(bytes, INTEGER);
+	bytes = append(bytes, strconv.Itoa(v.num)...);
+	bytes = append(bytes, '\r', '\n');
+
+	return bytes;
+}
+
 // marshal error
 func (v Value) marshalError() []byte {
 	var bytes []byte;
@@ -0,0 +1,395 @@
+// keyspace iteration: KEYS, SCAN, HSCAN, SSCAN and ZSCAN
+package blueberrydb
+
+import (
+	"blueberrydb/internal/logger"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the maps a key can be held in, a bit each
+const (
+	keyString = 1 << iota
+	keyHash
+	keyZset
+	keyStream
+)
+
+// TYPE names of the bits, in the order SCAN TYPE checks them
+var keyTypeNames = []struct {
+	bit  int
+	name string
+}{
+	{keyString, "string"},
+	{keyHash, "hash"},
+	{keyZset, "zset"},
+	{keyStream, "stream"},
+}
+
+// keys of a database ordered by a hash of the key, with the maps holding them
+// and their access times, SCAN resumes from a hash so a key that stays for the
+// whole iteration is returned however many keys are added or removed meanwhile
+type keyIndex struct {
+	mu     sync.Mutex // taken last, with the lock of the map being changed held
+	tree   *radixTree[*keyMeta]
+	memory int64 // bytes taken by the keys, the sum of their estimates
+}
+
+func newKeyIndex() *keyIndex {
+	return &keyIndex{tree: newRadixTree[*keyMeta]()}
+}
+
+func scanHash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// the hash in big endian followed by the key, so the tree orders entries by hash
+func indexEntry(key string) []byte {
+	entry := binary.BigEndian.AppendUint64(make([]byte, 0, 8+len(key)), scanHash(key))
+	return append(entry, key...)
+}
+
+func (idx *keyIndex) add(key string, bit int) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	entry := indexEntry(key)
+	if meta, ok := idx.tree.find(entry); ok {
+		meta.types |= bit
+		return
+	}
+	idx.tree.insert(entry, newKeyMeta(bit))
+}
+
+func (idx *keyIndex) remove(key string, bit int) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	entry := indexEntry(key)
+	meta, ok := idx.tree.find(entry)
+	if !ok {
+		return
+	}
+
+	if meta.types &^= bit; meta.types == 0 {
+		idx.tree.remove(entry)
+		idx.memory -= meta.memory
+		datasetMemory.Add(-meta.memory)
+	}
+}
+
+// the access data of a key, nil when the index does not have it
+func (idx *keyIndex) meta(key string) *keyMeta {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	meta, _ := idx.tree.find(indexEntry(key))
+	return meta
+}
+
+// a key of the index with the maps holding it
+type indexedKey struct {
+	key   string
+	types int
+}
+
+// at least count keys with a hash from cursor on, all keys sharing the hash
+// of the last one included, and the cursor to go on from, 0 once done
+func (idx *keyIndex) scan(cursor uint64, count int) ([]indexedKey, uint64) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	keys := []indexedKey{}
+	last := uint64(0)
+
+	entry, meta, ok := idx.tree.seekGE(binary.BigEndian.AppendUint64(nil, cursor))
+	for ok {
+		hash := binary.BigEndian.Uint64(entry[:8])
+		if len(keys) >= count && hash != last {
+			return keys, hash
+		}
+
+		keys = append(keys, indexedKey{key: string(entry[8:]), types: meta.types})
+		last = hash
+		entry, meta, ok = idx.tree.seekGE(append(entry, 0))
+	}
+
+	return keys, 0
+}
+
+// records that key is held in the map of bit in the selected database,
+// caller must hold the write lock of that map
+func keyspaceAdd(key string, bit int) {
+	databases[selectedDB].index.add(key, bit)
+}
+
+// records that key is no longer held in the map of bit in the selected
+// database, caller must hold the write lock of that map
+func keyspaceRemove(key string, bit int) {
+	databases[selectedDB].index.remove(key, bit)
+}
+
+// keeps the keys that are still there, not expired, match the pattern and hold the type
+func liveKeys(keys []indexedKey, pattern string, typeName string) []Value {
+	now := time.Now().Unix()
+
+	SETsMu.RLock()
+	defer SETsMu.RUnlock()
+
+	live := []Value{}
+	for _, k := range keys {
+		if k.types&keyString != 0 {
+			if entry, ok := SETs[k.key]; !ok || entry.expired(now) {
+				k.types &^= keyString
+			}
+		}
+		if k.types == 0 {
+			continue
+		}
+		if pattern != "" && !globMatch(pattern, k.key) {
+			continue
+		}
+		if typeName != "" && !holdsType(k.types, typeName) {
+			continue
+		}
+
+		live = append(live, Value{typ: "bulk", bulk: k.key})
+	}
+
+	return live
+}
+
+func holdsType(types int, name string) bool {
+	for _, t := range keyTypeNames {
+		if t.name == name {
+			return types&t.bit != 0
+		}
+	}
+	return false
+}
+
+// options of the SCAN family after the cursor
+type scanOptions struct {
+	cursor   uint64
+	pattern  string
+	count    int
+	typeName string
+	noValues bool
+}
+
+// parses "cursor [MATCH pattern] [COUNT count]" followed by the options in extra
+func parseScanArgs(args []Value, extra ...string) (scanOptions, string) {
+	opts := scanOptions{count: 10}
+
+	cursor, err := strconv.ParseUint(args[0].bulk, 10, 64)
+	if err != nil {
+		return opts, "ERR invalid cursor"
+	}
+	opts.cursor = cursor
+
+	allowed := func(option string) bool {
+		for _, e := range extra {
+			if e == option {
+				return true
+			}
+		}
+		return false
+	}
+
+	for i := 1; i < len(args); i++ {
+		option := strings.ToUpper(args[i].bulk)
+		switch {
+		case option == "MATCH" && i+1 < len(args):
+			i++
+			opts.pattern = args[i].bulk
+			if opts.pattern == "*" {
+				opts.pattern = ""
+			}
+		case option == "COUNT" && i+1 < len(args):
+			i++
+			count, err := strconv.Atoi(args[i].bulk)
+			if err != nil {
+				return opts, "ERR value is not an integer or out of range"
+			}
+			if count < 1 {
+				return opts, "ERR syntax error"
+			}
+			opts.count = count
+		case option == "TYPE" && i+1 < len(args) && allowed("TYPE"):
+			i++
+			opts.typeName = strings.ToLower(args[i].bulk)
+		case option == "NOVALUES" && allowed("NOVALUES"):
+			opts.noValues = true
+		default:
+			return opts, "ERR syntax error"
+		}
+	}
+
+	return opts, ""
+}
+
+func scanReply(cursor uint64, elements []Value) Value {
+	return Value{typ: "array", array: []Value{
+		{typ: "bulk", bulk: strconv.FormatUint(cursor, 10)},
+		{typ: "array", array: elements},
+	}}
+}
+
+// KEYS command: every key matching a glob pattern, walks the whole keyspace
+func keys(args []Value) Value {
+	pattern := args[0].bulk
+	if pattern == "*" {
+		pattern = ""
+	}
+
+	indexed, _ := databases[selectedDB].index.scan(0, math.MaxInt)
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: KEYS %s", args[0].bulk))
+
+	return Value{typ: "array", array: liveKeys(indexed, pattern, "")}
+}
+
+// SCAN command: the keys from a cursor on, a few at a time
+func scan(args []Value) Value {
+	opts, errMsg := parseScanArgs(args, "TYPE")
+	if errMsg != "" {
+		return Value{typ: "error", str: errMsg}
+	}
+
+	indexed, next := databases[selectedDB].index.scan(opts.cursor, opts.count)
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: SCAN %d", opts.cursor))
+
+	return scanReply(next, liveKeys(indexed, opts.pattern, opts.typeName))
+}
+
+// the elements of a collection with a hash from cursor on, at least count of
+// them with all those sharing the hash of the last one, in hash order
+func scanElements(elements []string, cursor uint64, count int) ([]string, uint64) {
+	type hashed struct {
+		hash    uint64
+		element string
+	}
+
+	candidates := make([]hashed, 0, len(elements))
+	for _, element := range elements {
+		if hash := scanHash(element); hash >= cursor {
+			candidates = append(candidates, hashed{hash, element})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].hash != candidates[j].hash {
+			return candidates[i].hash < candidates[j].hash
+		}
+		return candidates[i].element < candidates[j].element
+	})
+
+	picked := []string{}
+	for i, c := range candidates {
+		if len(picked) >= count && c.hash != candidates[i-1].hash {
+			return picked, c.hash
+		}
+		picked = append(picked, c.element)
+	}
+
+	return picked, 0
+}
+
+// HSCAN command: fields and values of a hash from a cursor on
+func hscan(args []Value) Value {
+	hash := args[0].bulk
+	opts, errMsg := parseScanArgs(args[1:], "NOVALUES")
+	if errMsg != "" {
+		return Value{typ: "error", str: errMsg}
+	}
+
+	HSETsMu.RLock()
+	fields := hashLiveFields(hash)
+	HSETsMu.RUnlock()
+
+	names := make([]string, 0, len(fields))
+	for field := range fields {
+		names = append(names, field)
+	}
+	picked, next := scanElements(names, opts.cursor, opts.count)
+
+	elements := []Value{}
+	for _, field := range picked {
+		if opts.pattern != "" && !globMatch(opts.pattern, field) {
+			continue
+		}
+
+		elements = append(elements, Value{typ: "bulk", bulk: field})
+		if !opts.noValues {
+			elements = append(elements, Value{typ: "bulk", bulk: fields[field]})
+		}
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: HSCAN %s %d", hash, opts.cursor))
+
+	return scanReply(next, elements)
+}
+
+// ZSCAN command: members and scores of a sorted set from a cursor on
+func zscan(args []Value) Value {
+	key := args[0].bulk
+	opts, errMsg := parseScanArgs(args[1:])
+	if errMsg != "" {
+		return Value{typ: "error", str: errMsg}
+	}
+
+	ZSETsMu.RLock()
+	scores := map[string]float64{}
+	if zset, ok := ZSETs[key]; ok {
+		for member, score := range zset.dict {
+			scores[member] = score
+		}
+	}
+	ZSETsMu.RUnlock()
+
+	members := make([]string, 0, len(scores))
+	for member := range scores {
+		members = append(members, member)
+	}
+	picked, next := scanElements(members, opts.cursor, opts.count)
+
+	elements := []Value{}
+	for _, member := range picked {
+		if opts.pattern != "" && !globMatch(opts.pattern, member) {
+			continue
+		}
+
+		elements = append(elements, Value{typ: "bulk", bulk: member}, Value{typ: "bulk", bulk: formatScore(scores[member])})
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: ZSCAN %s %d", key, opts.cursor))
+
+	return scanReply(next, elements)
+}
+
+// SSCAN command: there is no set type, so a key is either missing or holds
+// another type and gets a WRONGTYPE error before this runs
+func sscan(args []Value) Value {
+	_, errMsg := parseScanArgs(args[1:])
+	if errMsg != "" {
+		return Value{typ: "error", str: errMsg}
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: SSCAN %s", args[0].bulk))
+
+	return scanReply(0, []Value{})
+}
@@ -0,0 +1,596 @@
+// scripting: EVAL and EVALSHA run lua scripts atomically in a sandboxed interpreter
+package blueberrydb
+
+import (
+	"blueberrydb/internal/logger"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// registered here as scripts call back into Handlers
+func init() {
+	Handlers["EVAL"] = eval
+	Handlers["EVALSHA"] = evalsha
+	Handlers["SCRIPT"] = scriptCommand
+}
+
+// cached scripts by sha1, compiled once
+type script struct {
+	body  string
+	proto *lua.FunctionProto
+}
+
+var scripts = map[string]*script{}
+var scriptsMu = sync.RWMutex{}
+
+// the interpreter is shared by all scripts and functions, only one runs at a
+// time as they hold the commandsMu write lock, created on first use
+var luaState *lua.LState
+
+// set while a function that declared no-writes runs, guarded by commandsMu
+var scriptReadOnly = false
+
+// how long a script runs before other commands get BUSY replies, same default as redis
+var scriptTimeLimit = 5 * time.Second
+
+func SetScriptTimeLimit(limit time.Duration) {
+	scriptTimeLimit = limit
+}
+
+// the running script, guarded by runningScriptMu as SCRIPT KILL does not wait for commandsMu
+type runningScript struct {
+	start  time.Time
+	wrote  bool
+	killed bool
+	cancel context.CancelFunc
+}
+
+var running *runningScript
+var runningScriptMu = sync.Mutex{}
+
+// closed and replaced each time a script runs past the time limit, so commands
+// waiting for commandsMu can give up with BUSY, guarded by runningScriptMu
+var scriptBusy = make(chan struct{})
+
+// the channel closed when the next script runs past the time limit
+func scriptBusySignal() <-chan struct{} {
+	runningScriptMu.Lock()
+	defer runningScriptMu.Unlock()
+
+	return scriptBusy
+}
+
+// reports whether a script has been running past the time limit
+func scriptTimedOut() bool {
+	runningScriptMu.Lock()
+	defer runningScriptMu.Unlock()
+
+	return running != nil && time.Since(running.start) > scriptTimeLimit
+}
+
+func scriptSHA(body string) string {
+	sum := sha1.Sum([]byte(body))
+	return hex.EncodeToString(sum[:])
+}
+
+// compiles and caches a script, returns its sha1
+func loadScript(body string) (string, error) {
+	sha := scriptSHA(body)
+
+	scriptsMu.RLock()
+	_, ok := scripts[sha]
+	scriptsMu.RUnlock()
+	if ok {
+		return sha, nil
+	}
+
+	chunk, err := parse.Parse(strings.NewReader(body), "user_script")
+	if err != nil {
+		return "", err
+	}
+
+	proto, err := lua.Compile(chunk, "@user_script")
+	if err != nil {
+		return "", err
+	}
+
+	scriptsMu.Lock()
+	scripts[sha] = &script{body: body, proto: proto}
+	scriptsMu.Unlock()
+
+	return sha, nil
+}
+
+// creates the interpreter with only the safe libraries and a read only global table
+func newLuaState() *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+
+	// nothing may reach the file system or load code from elsewhere
+	for _, name := range []string{"dofile", "loadfile", "load", "loadstring", "require", "module"} {
+		L.G.Global.RawSetString(name, lua.LNil)
+	}
+
+	redis := L.NewTable()
+	L.SetFuncs(redis, map[string]lua.LGFunction{
+		"call":         luaRedisCall,
+		"pcall":        luaRedisPCall,
+		"error_reply":  luaErrorReply,
+		"status_reply": luaStatusReply,
+		"sha1hex":      luaSHA1Hex,
+		"log":          luaLog,
+
+		"register_function": luaRegisterFunction,
+	})
+	for name, level := range map[string]int{"LOG_DEBUG": 0, "LOG_VERBOSE": 1, "LOG_NOTICE": 2, "LOG_WARNING": 3} {
+		redis.RawSetString(name, lua.LNumber(level))
+	}
+	L.G.Global.RawSetString("redis", redis)
+
+	// the interpreter is shared by every script, so none may leave anything behind:
+	// scripts get their data through KEYS and ARGV only, the globals and the
+	// library tables are read only and the functions that get around that are gone
+	if err := L.DoString(`
+		local G, setmetatable, error, tostring, pairs = _G, setmetatable, error, tostring, pairs
+
+		local function readonly(t)
+			return setmetatable({}, {
+				__index = t,
+				__newindex = function() error("Attempt to modify a readonly table", 0) end,
+				__metatable = false,
+			})
+		end
+
+		for _, name in pairs({"rawset", "rawget", "setmetatable", "getmetatable", "setfenv", "getfenv"}) do
+			G[name] = nil
+		end
+		for _, name in pairs({"redis", "string", "table", "math"}) do
+			G[name] = readonly(G[name])
+		end
+
+		local globals = {}
+		for name, value in pairs(G) do
+			globals[name] = value
+		end
+		for name in pairs(globals) do
+			G[name] = nil
+		end
+
+		setmetatable(G, {
+			__newindex = function(t, name)
+				if globals[name] ~= nil then
+					error("Attempt to modify a readonly table", 0)
+				end
+				error("Script attempted to create global variable '" .. tostring(name) .. "'", 0)
+			end,
+			__index = function(t, name)
+				local value = globals[name]
+				if value == nil then
+					error("Script attempted to access nonexistent global variable '" .. tostring(name) .. "'", 0)
+				end
+				return value
+			end,
+			__metatable = false,
+		})
+	`); err != nil {
+		panic(err)
+	}
+
+	return L
+}
+
+// converts a reply into the lua value scripts see, errors become {err=...}
+func replyToLua(L *lua.LState, v Value) lua.LValue {
+	switch v.typ {
+	case "integer":
+		return lua.LNumber(v.num)
+	case "bulk":
+		return lua.LString(v.bulk)
+	case "string":
+		table := L.NewTable()
+		table.RawSetString("ok", lua.LString(v.str))
+		return table
+	case "error":
+		table := L.NewTable()
+		table.RawSetString("err", lua.LString(v.str))
+		return table
+	case "array":
+		table := L.NewTable()
+		for _, item := range v.array {
+			table.Append(replyToLua(L, item))
+		}
+		return table
+	}
+
+	// nil replies are false, a lua nil would end arrays early
+	return lua.LFalse
+}
+
+// converts what a script returns into a reply, arrays stop at the first nil like in redis
+func luaToReply(lv lua.LValue) Value {
+	switch lv := lv.(type) {
+	case lua.LNumber:
+		return Value{typ: "integer", num: int(lv)}
+	case lua.LString:
+		return Value{typ: "bulk", bulk: string(lv)}
+	case lua.LBool:
+		if lv {
+			return Value{typ: "integer", num: 1}
+		}
+	case *lua.LTable:
+		if errMsg, ok := lv.RawGetString("err").(lua.LString); ok {
+			return Value{typ: "error", str: string(errMsg)}
+		}
+		if status, ok := lv.RawGetString("ok").(lua.LString); ok {
+			return Value{typ: "string", str: string(status)}
+		}
+
+		values := []Value{}
+		for i := 1; ; i++ {
+			item := lv.RawGetInt(i)
+			if item == lua.LNil {
+				break
+			}
+			values = append(values, luaToReply(item))
+		}
+		return Value{typ: "array", array: values}
+	}
+
+	return Value{typ: "null"}
+}
+
+// runs a command for redis.call and redis.pcall, errors are returned as replies
+func luaCommand(L *lua.LState) Value {
+	if loadingLibrary != nil {
+		return Value{typ: "error", str: "ERR redis.call can only be called inside a script invocation"}
+	}
+	if L.GetTop() == 0 {
+		return Value{typ: "error", str: "ERR Please specify at least one argument for this redis lib call"}
+	}
+
+	args := make([]Value, 0, L.GetTop())
+	for i := 1; i <= L.GetTop(); i++ {
+		switch arg := L.Get(i).(type) {
+		case lua.LString:
+			args = append(args, Value{typ: "bulk", bulk: string(arg)})
+		case lua.LNumber:
+			args = append(args, Value{typ: "bulk", bulk: arg.String()})
+		default:
+			return Value{typ: "error", str: "ERR Lua redis lib command arguments must be strings or integers"}
+		}
+	}
+
+	command := strings.ToUpper(args[0].bulk)
+	if _, ok := Handlers[command]; !ok {
+		return Value{typ: "error", str: "ERR Unknown Redis command called from script"}
+	}
+	if cmd, ok := lookupCommand(command); ok && cmd.hasFlag("noscript") {
+		return Value{typ: "error", str: "ERR This Redis command is not allowed from script"}
+	}
+	if scriptReadOnly && modifiesDataset(command) {
+		return Value{typ: "error", str: "ERR Write commands are not allowed from read-only scripts."}
+	}
+
+	return execute(nil, RewriteCommand(Value{typ: "array", array: args}))
+}
+
+// redis.call: errors of the command abort the script
+func luaRedisCall(L *lua.LState) int {
+	reply := luaCommand(L)
+	if reply.typ == "error" {
+		L.Error(replyToLua(L, reply), 1)
+		return 0
+	}
+
+	L.Push(replyToLua(L, reply))
+	return 1
+}
+
+// redis.pcall: errors of the command are returned to the script as {err=...}
+func luaRedisPCall(L *lua.LState) int {
+	L.Push(replyToLua(L, luaCommand(L)))
+	return 1
+}
+
+func luaErrorReply(L *lua.LState) int {
+	table := L.NewTable()
+	table.RawSetString("err", lua.LString(L.CheckString(1)))
+	L.Push(table)
+	return 1
+}
+
+func luaStatusReply(L *lua.LState) int {
+	table := L.NewTable()
+	table.RawSetString("ok", lua.LString(L.CheckString(1)))
+	L.Push(table)
+	return 1
+}
+
+func luaSHA1Hex(L *lua.LState) int {
+	L.Push(lua.LString(scriptSHA(L.CheckString(1))))
+	return 1
+}
+
+func luaLog(L *lua.LState) int {
+	level := L.CheckInt(1)
+
+	parts := []string{}
+	for i := 2; i <= L.GetTop(); i++ {
+		parts = append(parts, L.ToStringMeta(L.Get(i)).String())
+	}
+	message := "script: " + strings.Join(parts, " ")
+
+	if level >= 3 {
+		logger.Info(message)
+	} else {
+		logger.Debug(message)
+	}
+	return 0
+}
+
+// returns the shared interpreter, caller must hold the commandsMu write lock
+func scriptState() *lua.LState {
+	if luaState == nil {
+		luaState = newLuaState()
+	}
+	return luaState
+}
+
+func luaStrings(L *lua.LState, values []Value) *lua.LTable {
+	table := L.NewTable()
+	for _, v := range values {
+		table.Append(lua.LString(v.bulk))
+	}
+	return table
+}
+
+// runs a cached script with its keys and arguments, caller must hold the commandsMu write lock
+func runScript(sha string, s *script, keys []Value, argv []Value) Value {
+	L := scriptState()
+	L.G.Global.RawSetString("KEYS", luaStrings(L, keys))
+	L.G.Global.RawSetString("ARGV", luaStrings(L, argv))
+
+	return runLua(L, "f_"+sha, L.NewFunctionFromProto(s.proto), false)
+}
+
+// calls fn as a script that can be killed and whose effects are persisted
+// atomically, name identifies it in error replies, caller must hold the commandsMu write lock
+func runLua(L *lua.LState, name string, fn *lua.LFunction, readOnly bool, args ...lua.LValue) Value {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	state := &runningScript{start: time.Now(), cancel: cancel}
+	runningScriptMu.Lock()
+	running = state
+	runningScriptMu.Unlock()
+
+	timer := time.AfterFunc(scriptTimeLimit, func() {
+		runningScriptMu.Lock()
+		defer runningScriptMu.Unlock()
+
+		if running == state {
+			close(scriptBusy)
+			scriptBusy = make(chan struct{})
+		}
+	})
+	defer timer.Stop()
+
+	var err error
+	propagateAtomically(func() {
+		// once a script writes, killing it would leave half of its effects behind
+		persist := propagator
+		propagator = func(value Value) {
+			runningScriptMu.Lock()
+			state.wrote = true
+			runningScriptMu.Unlock()
+
+			if persist != nil {
+				persist(value)
+			}
+		}
+		blocking := blockingDisabled
+		blockingDisabled = true
+		scriptReadOnly = readOnly
+
+		L.SetContext(ctx)
+		L.Push(fn)
+		for _, arg := range args {
+			L.Push(arg)
+		}
+		err = L.PCall(len(args), 1, nil)
+		L.RemoveContext()
+
+		scriptReadOnly = false
+		blockingDisabled = blocking
+		propagator = persist
+	})
+
+	runningScriptMu.Lock()
+	running = nil
+	killed := state.killed
+	runningScriptMu.Unlock()
+
+	if err != nil {
+		if killed {
+			return Value{typ: "error", str: "ERR Script killed by user with SCRIPT KILL..."}
+		}
+
+		// errors raised by redis.call are returned as the command replied them
+		if errMsg, ok := raisedReply(err); ok {
+			return Value{typ: "error", str: errMsg}
+		}
+		if apiErr, ok := err.(*lua.ApiError); ok {
+			return Value{typ: "error", str: fmt.Sprintf("ERR Error running script (call to %s): %s", name, singleLine(apiErr.Object.String()))}
+		}
+
+		return Value{typ: "error", str: fmt.Sprintf("ERR Error running script (call to %s): %s", name, singleLine(err.Error()))}
+	}
+
+	result := L.Get(-1)
+	L.Pop(1)
+
+	return luaToReply(result)
+}
+
+// the error reply a failed redis.call raised as {err=...}, if that is what err is
+func raisedReply(err error) (string, bool) {
+	apiErr, ok := err.(*lua.ApiError)
+	if !ok {
+		return "", false
+	}
+	table, ok := apiErr.Object.(*lua.LTable)
+	if !ok {
+		return "", false
+	}
+	errMsg, ok := table.RawGetString("err").(lua.LString)
+	return string(errMsg), ok
+}
+
+// error replies are a single line, lua errors can span several
+func singleLine(message string) string {
+	return strings.Join(strings.Fields(message), " ")
+}
+
+// splits "numkeys key [key ...] arg [arg ...]"
+func parseScriptKeys(args []Value) ([]Value, []Value, string) {
+	numkeys, err := strconv.Atoi(args[0].bulk)
+	if err != nil {
+		return nil, nil, "ERR value is not an integer or out of range"
+	}
+	if numkeys < 0 {
+		return nil, nil, "ERR Number of keys can't be negative"
+	}
+	if numkeys > len(args)-1 {
+		return nil, nil, "ERR Number of keys can't be greater than number of args"
+	}
+
+	return args[1 : 1+numkeys], args[1+numkeys:], ""
+}
+
+// EVAL command
+func eval(args []Value) Value {
+	keys, argv, errMsg := parseScriptKeys(args[1:])
+	if errMsg != "" {
+		return Value{typ: "error", str: errMsg}
+	}
+
+	sha, err := loadScript(args[0].bulk)
+	if err != nil {
+		return Value{typ: "error", str: fmt.Sprintf("ERR Error compiling script (new function): %s", singleLine(err.Error()))}
+	}
+
+	scriptsMu.RLock()
+	s := scripts[sha]
+	scriptsMu.RUnlock()
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: EVAL %s (%d keys)", sha, len(keys)))
+
+	return runScript(sha, s, keys, argv)
+}
+
+// EVALSHA command
+func evalsha(args []Value) Value {
+	keys, argv, errMsg := parseScriptKeys(args[1:])
+	if errMsg != "" {
+		return Value{typ: "error", str: errMsg}
+	}
+
+	sha := strings.ToLower(args[0].bulk)
+
+	scriptsMu.RLock()
+	s, ok := scripts[sha]
+	scriptsMu.RUnlock()
+
+	if !ok {
+		return Value{typ: "error", str: "NOSCRIPT No matching script. Please use EVAL."}
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: EVALSHA %s (%d keys)", sha, len(keys)))
+
+	return runScript(sha, s, keys, argv)
+}
+
+// SCRIPT command: LOAD, EXISTS, FLUSH and KILL, runs without commandsMu so it can kill a running script
+func scriptCommand(args []Value) Value {
+	subcommand := strings.ToUpper(args[0].bulk)
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: SCRIPT %s", subcommand))
+
+	switch {
+	case subcommand == "LOAD" && len(args) == 2:
+		sha, err := loadScript(args[1].bulk)
+		if err != nil {
+			return Value{typ: "error", str: fmt.Sprintf("ERR Error compiling script (new function): %s", singleLine(err.Error()))}
+		}
+		return Value{typ: "bulk", bulk: sha}
+
+	case subcommand == "EXISTS" && len(args) >= 2:
+		scriptsMu.RLock()
+		defer scriptsMu.RUnlock()
+
+		values := make([]Value, 0, len(args)-1)
+		for _, arg := range args[1:] {
+			exists := 0
+			if _, ok := scripts[strings.ToLower(arg.bulk)]; ok {
+				exists = 1
+			}
+			values = append(values, Value{typ: "integer", num: exists})
+		}
+		return Value{typ: "array", array: values}
+
+	case subcommand == "FLUSH" && len(args) <= 2:
+		if len(args) == 2 {
+			mode := strings.ToUpper(args[1].bulk)
+			if mode != "ASYNC" && mode != "SYNC" {
+				return Value{typ: "error", str: "ERR SCRIPT FLUSH only support SYNC|ASYNC option"}
+			}
+		}
+
+		scriptsMu.Lock()
+		scripts = map[string]*script{}
+		scriptsMu.Unlock()
+		return Value{typ: "string", str: "OK"}
+
+	case subcommand == "KILL" && len(args) == 1:
+		runningScriptMu.Lock()
+		defer runningScriptMu.Unlock()
+
+		if running == nil {
+			return Value{typ: "error", str: "NOTBUSY No scripts in execution right now."}
+		}
+		if running.wrote {
+			return Value{typ: "error", str: "UNKILLABLE Sorry the script already executed write commands against the dataset. You can either wait the script termination or kill the server in a hard way using the SHUTDOWN NOSAVE command."}
+		}
+
+		running.killed = true
+		running.cancel()
+		return Value{typ: "string", str: "OK"}
+	}
+
+	return Value{typ: "error", str: fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try SCRIPT HELP.", args[0].bulk)}
+}
@@ -0,0 +1,205 @@
+// skiplist ordering sorted set members by score, then by member, with spans for rank lookups
+package blueberrydb
+
+import (
+	"math/rand"
+)
+
+const (
+	zskiplistMaxLevel = 32
+	zskiplistP        = 0.25
+)
+
+type zskiplistLevel struct {
+	forward *zskiplistNode
+	span    int // number of nodes skipped by following forward
+}
+
+type zskiplistNode struct {
+	member   string
+	score    float64
+	backward *zskiplistNode
+	level    []zskiplistLevel
+}
+
+type zskiplist struct {
+	header *zskiplistNode
+	tail   *zskiplistNode
+	length int
+	level  int
+}
+
+func newSkiplist() *zskiplist {
+	return &zskiplist{
+		header: &zskiplistNode{level: make([]zskiplistLevel, zskiplistMaxLevel)},
+		level:  1,
+	}
+}
+
+// random level with a power law distribution, higher levels are less likely
+func zslRandomLevel() int {
+	level := 1
+	for level < zskiplistMaxLevel && rand.Float64() < zskiplistP {
+		level++
+	}
+
+	return level
+}
+
+// reports whether the node sorts before the given score and member
+func (n *zskiplistNode) before(score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+// reports whether the node sorts after the given score and member
+func (n *zskiplistNode) after(score float64, member string) bool {
+	return n.score > score || (n.score == score && n.member > member)
+}
+
+// inserts a new node, the member must not be in the list yet
+func (zsl *zskiplist) insert(score float64, member string) *zskiplistNode {
+	update := make([]*zskiplistNode, zskiplistMaxLevel)
+	rank := make([]int, zskiplistMaxLevel)
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		if i < zsl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && x.level[i].forward.before(score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	level := zslRandomLevel()
+	if level > zsl.level {
+		for i := zsl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = zsl.header
+			update[i].level[i].span = zsl.length
+		}
+		zsl.level = level
+	}
+
+	x = &zskiplistNode{member: member, score: score, level: make([]zskiplistLevel, level)}
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+
+	// untouched levels now span one more node
+	for i := level; i < zsl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != zsl.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		zsl.tail = x
+	}
+	zsl.length++
+
+	return x
+}
+
+// unlinks a node given the update vector of its predecessors
+func (zsl *zskiplist) deleteNode(x *zskiplistNode, update []*zskiplistNode) {
+	for i := 0; i < zsl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		zsl.tail = x.backward
+	}
+
+	for zsl.level > 1 && zsl.header.level[zsl.level-1].forward == nil {
+		zsl.level--
+	}
+	zsl.length--
+}
+
+// deletes the node with the given score and member, reports whether it was found
+func (zsl *zskiplist) delete(score float64, member string) bool {
+	update := make([]*zskiplistNode, zskiplistMaxLevel)
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.before(score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	x = x.level[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+
+	zsl.deleteNode(x, update)
+	return true
+}
+
+// 1 based rank of a member, 0 when it is not in the list
+func (zsl *zskiplist) rank(score float64, member string) int {
+	rank := 0
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !x.level[i].forward.after(score, member) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+
+		if x != zsl.header && x.score == score && x.member == member {
+			return rank
+		}
+	}
+
+	return 0
+}
+
+// node at a 1 based rank, nil when out of range
+func (zsl *zskiplist) byRank(rank int) *zskiplistNode {
+	traversed := 0
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+
+		if traversed == rank {
+			return x
+		}
+	}
+
+	return nil
+}
+
+// first node with a score of at least min, or above min when exclusive
+func (zsl *zskiplist) firstFrom(min float64, exclusive bool) *zskiplistNode {
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && (x.level[i].forward.score < min || (exclusive && x.level[i].forward.score == min)) {
+			x = x.level[i].forward
+		}
+	}
+
+	return x.level[0].forward
+}
@@ -0,0 +1,50 @@
+// hash slots: keys and shard channels map to one of 16384 slots like in redis cluster
+package blueberrydb
+
+const hashSlots = 16384
+
+// crc16 lookup table, CCITT polynomial 0x1021 (XMODEM) as used by redis cluster
+var crc16Table = func() [256]uint16 {
+	table := [256]uint16{}
+	for i := range table {
+		crc := uint16(i) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+func crc16(data string) uint16 {
+	crc := uint16(0)
+	for i := 0; i < len(data); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^data[i]]
+	}
+	return crc
+}
+
+// slot of a key, only the part inside the first non empty {...} is hashed when there is one
+func keyHashSlot(key string) int {
+	for i := 0; i < len(key); i++ {
+		if key[i] != '{' {
+			continue
+		}
+
+		for j := i + 1; j < len(key); j++ {
+			if key[j] == '}' {
+				if j > i+1 {
+					key = key[i+1 : j]
+				}
+				return int(crc16(key)) & (hashSlots - 1)
+			}
+		}
+		break
+	}
+
+	return int(crc16(key)) & (hashSlots - 1)
+}
@@ -0,0 +1,256 @@
+// SORT and SORT_RO: the members of a sorted set ordered by their own value or
+// by weights read from other keys, lists and sets do not exist here so sorted
+// sets are the only values there are to sort
+package blueberrydb
+
+import (
+	"blueberrydb/internal/logger"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// options of SORT after the key
+type sortOptions struct {
+	by     string
+	noSort bool // BY with a pattern without *, the elements keep their order
+	gets   []string
+	offset int
+	count  int // -1 for all elements from offset on
+	desc   bool
+	alpha  bool
+}
+
+func parseSortOptions(args []Value, readOnly bool) (sortOptions, string) {
+	opts := sortOptions{count: -1}
+
+	for i := 0; i < len(args); i++ {
+		option := strings.ToUpper(args[i].bulk)
+		switch {
+		case option == "ASC":
+			opts.desc = false
+		case option == "DESC":
+			opts.desc = true
+		case option == "ALPHA":
+			opts.alpha = true
+		case option == "LIMIT" && i+2 < len(args):
+			offset, err1 := strconv.Atoi(args[i+1].bulk)
+			count, err2 := strconv.Atoi(args[i+2].bulk)
+			if err1 != nil || err2 != nil {
+				return opts, "ERR value is not an integer or out of range"
+			}
+			opts.offset, opts.count = offset, count
+			i += 2
+		case option == "BY" && i+1 < len(args):
+			i++
+			opts.by = args[i].bulk
+			opts.noSort = !strings.Contains(opts.by, "*")
+		case option == "GET" && i+1 < len(args):
+			i++
+			opts.gets = append(opts.gets, args[i].bulk)
+		case option == "STORE" && i+1 < len(args) && !readOnly:
+			// open in the backlog as user-048-store with sorting lists and
+			// sets, SORT gets its write and denyoom flags back along with it
+			return opts, "ERR SORT STORE is not supported, there is no list type to store the result in"
+		default:
+			return opts, "ERR syntax error"
+		}
+	}
+
+	return opts, ""
+}
+
+// the value a BY or GET pattern points to for an element: the first * is
+// replaced by the element and a "->field" suffix reads a hash field, # is
+// the element itself, caller must hold SETsMu and HSETsMu
+func sortLookup(pattern string, element string) (string, bool) {
+	if pattern == "#" {
+		return element, true
+	}
+
+	star := strings.IndexByte(pattern, '*')
+	if star < 0 {
+		return "", false
+	}
+
+	keyPattern, field := pattern, ""
+	if arrow := strings.Index(pattern[star+1:], "->"); arrow >= 0 && star+1+arrow+2 < len(pattern) {
+		keyPattern, field = pattern[:star+1+arrow], pattern[star+1+arrow+2:]
+	}
+	key := keyPattern[:star] + element + keyPattern[star+1:]
+
+	if field != "" {
+		entry, ok := hashLiveField(key, field)
+		return entry.value, ok
+	}
+
+	entry, ok := SETs[key]
+	if !ok || entry.expired(time.Now().Unix()) {
+		return "", false
+	}
+	return entry.stringValue(), true
+}
+
+// an element with what it is ordered by
+type sortItem struct {
+	element string
+	score   float64
+	value   string // compared with ALPHA
+	missing bool   // the BY pattern pointed to nothing
+}
+
+// the elements of a sorted set in the order SORT returns them, caller must
+// hold SETsMu, HSETsMu and ZSETsMu
+func sortElements(zset *SortedSet, opts sortOptions) ([]string, string) {
+	items := []sortItem{}
+	zset.each(func(member string, _ float64) bool {
+		items = append(items, sortItem{element: member})
+		return true
+	})
+
+	if opts.noSort {
+		if opts.desc {
+			for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+				items[i], items[j] = items[j], items[i]
+			}
+		}
+	} else {
+		for i := range items {
+			item := &items[i]
+
+			item.value = item.element
+			if opts.by != "" {
+				value, ok := sortLookup(opts.by, item.element)
+				item.value, item.missing = value, !ok
+			}
+			if opts.alpha || item.missing {
+				continue
+			}
+
+			score, err := strconv.ParseFloat(strings.TrimSpace(item.value), 64)
+			if err != nil || math.IsNaN(score) {
+				return nil, "ERR One or more scores can't be converted into double"
+			}
+			item.score = score
+		}
+
+		sort.SliceStable(items, func(i, j int) bool {
+			cmp := compareSortItems(items[i], items[j], opts.alpha)
+			if opts.desc {
+				return cmp > 0
+			}
+			return cmp < 0
+		})
+	}
+
+	elements := make([]string, len(items))
+	for i, item := range items {
+		elements[i] = item.element
+	}
+	return elements, ""
+}
+
+// orders by score, or by value with ALPHA where missing values come first,
+// and by the elements themselves on ties
+func compareSortItems(a sortItem, b sortItem, alpha bool) int {
+	cmp := 0
+	switch {
+	case !alpha && a.score != b.score:
+		if a.score < b.score {
+			cmp = -1
+		} else {
+			cmp = 1
+		}
+	case alpha && a.missing != b.missing:
+		if a.missing {
+			cmp = -1
+		} else {
+			cmp = 1
+		}
+	case alpha:
+		cmp = strings.Compare(a.value, b.value)
+	}
+
+	if cmp == 0 {
+		cmp = strings.Compare(a.element, b.element)
+	}
+	return cmp
+}
+
+// the part of the sorted elements LIMIT asks for
+func sortLimit(elements []string, offset int, count int) []string {
+	start := max(offset, 0)
+	if start >= len(elements) {
+		return nil
+	}
+
+	end := len(elements)
+	if count >= 0 && start+count < end {
+		end = start + count
+	}
+	return elements[start:end]
+}
+
+func sortGeneric(name string, args []Value, readOnly bool) Value {
+	key := args[0].bulk
+
+	opts, errMsg := parseSortOptions(args[1:], readOnly)
+	if errMsg != "" {
+		return Value{typ: "error", str: errMsg}
+	}
+
+	switch keyType(key) {
+	case "string", "hash", "stream":
+		return Value{typ: "error", str: wrongTypeError}
+	}
+
+	SETsMu.RLock()
+	HSETsMu.RLock()
+	ZSETsMu.RLock()
+	defer SETsMu.RUnlock()
+	defer HSETsMu.RUnlock()
+	defer ZSETsMu.RUnlock()
+
+	elements := []string{}
+	if zset, ok := ZSETs[key]; ok {
+		elements, errMsg = sortElements(zset, opts)
+		if errMsg != "" {
+			return Value{typ: "error", str: errMsg}
+		}
+	}
+	elements = sortLimit(elements, opts.offset, opts.count)
+
+	reply := Value{typ: "array", array: []Value{}}
+	for _, element := range elements {
+		if len(opts.gets) == 0 {
+			reply.array = append(reply.array, Value{typ: "bulk", bulk: element})
+			continue
+		}
+
+		for _, pattern := range opts.gets {
+			if value, ok := sortLookup(pattern, element); ok {
+				reply.array = append(reply.array, Value{typ: "bulk", bulk: value})
+			} else {
+				reply.array = append(reply.array, Value{typ: "null"})
+			}
+		}
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: %s %s", name, key))
+
+	return reply
+}
+
+// SORT command
+func sortCommand(args []Value) Value {
+	return sortGeneric("SORT", args, false)
+}
+
+// SORT_RO command: SORT without STORE
+func sortRO(args []Value) Value {
+	return sortGeneric("SORT_RO", args, true)
+}
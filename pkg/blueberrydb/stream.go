@@ -0,0 +1,979 @@
+// stream commands: append only logs of field value entries ordered by id
+package blueberrydb
+
+import (
+	"blueberrydb/internal/logger"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	streamNodeMaxEntries = 100 // entries per block before a new block is started
+	errInvalidStreamID   = "ERR Invalid stream ID specified as stream command argument"
+)
+
+// milliseconds and a sequence number within the millisecond
+type streamID struct {
+	ms  uint64
+	seq uint64
+}
+
+var maxStreamID = streamID{ms: math.MaxUint64, seq: math.MaxUint64}
+
+func (id streamID) String() string {
+	return fmt.Sprintf("%d-%d", id.ms, id.seq)
+}
+
+func (id streamID) compare(other streamID) int {
+	switch {
+	case id.ms < other.ms:
+		return -1
+	case id.ms > other.ms:
+		return 1
+	case id.seq < other.seq:
+		return -1
+	case id.seq > other.seq:
+		return 1
+	}
+
+	return 0
+}
+
+func (id streamID) less(other streamID) bool {
+	return id.compare(other) < 0
+}
+
+// big endian, so keys in the radix tree sort like the ids
+func (id streamID) key() []byte {
+	buf := make([]byte, 16)
+	binary.BigEndian.PutUint64(buf, id.ms)
+	binary.BigEndian.PutUint64(buf[8:], id.seq)
+	return buf
+}
+
+func streamIDFromKey(key []byte) streamID {
+	return streamID{ms: binary.BigEndian.Uint64(key), seq: binary.BigEndian.Uint64(key[8:])}
+}
+
+// following id, false when id is already the largest one
+func (id streamID) next() (streamID, bool) {
+	if id.seq < math.MaxUint64 {
+		return streamID{ms: id.ms, seq: id.seq + 1}, true
+	}
+	if id.ms < math.MaxUint64 {
+		return streamID{ms: id.ms + 1}, true
+	}
+
+	return id, false
+}
+
+// preceding id, false when id is 0-0
+func (id streamID) prev() (streamID, bool) {
+	if id.seq > 0 {
+		return streamID{ms: id.ms, seq: id.seq - 1}, true
+	}
+	if id.ms > 0 {
+		return streamID{ms: id.ms - 1, seq: math.MaxUint64}, true
+	}
+
+	return id, false
+}
+
+// parses ms-seq, a missing sequence is set to missingSeq, - and + are the smallest and largest ids
+func parseStreamID(arg string, missingSeq uint64) (streamID, bool) {
+	switch arg {
+	case "-":
+		return streamID{}, true
+	case "+":
+		return maxStreamID, true
+	}
+
+	msPart, seqPart, hasSeq := strings.Cut(arg, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return streamID{}, false
+	}
+
+	seq := missingSeq
+	if hasSeq {
+		seq, err = strconv.ParseUint(seqPart, 10, 64)
+		if err != nil {
+			return streamID{}, false
+		}
+	}
+
+	return streamID{ms: ms, seq: seq}, true
+}
+
+// parses an id that has to name a single entry
+func parseStrictStreamID(arg string) (streamID, bool) {
+	if arg == "-" || arg == "+" {
+		return streamID{}, false
+	}
+
+	return parseStreamID(arg, 0)
+}
+
+type streamEntry struct {
+	id      streamID
+	fields  []string // field value pairs
+	deleted bool
+}
+
+// consecutive entries, stored in the radix tree under the id of the first one
+type streamBlock struct {
+	entries []streamEntry
+	live    int // entries not deleted yet, the block is dropped at zero
+}
+
+type Stream struct {
+	blocks       *radixTree[*streamBlock]
+	length       int
+	lastID       streamID // last id handed out, even if that entry is gone
+	maxDeletedID streamID // largest id removed with XDEL
+	entriesAdded int64    // entries ever added
+	groups       map[string]*streamGroup
+}
+
+var STREAMs = map[string]*Stream{}
+var STREAMsMu = sync.RWMutex{}
+
+func newStream() *Stream {
+	return &Stream{
+		blocks: newRadixTree[*streamBlock](),
+		groups: map[string]*streamGroup{},
+	}
+}
+
+// appends an entry, id must be greater than lastID
+func (s *Stream) append(id streamID, fields []string) {
+	_, block, ok := s.blocks.seekLE(maxStreamID.key())
+	if !ok || len(block.entries) >= streamNodeMaxEntries {
+		block = &streamBlock{}
+		s.blocks.insert(id.key(), block)
+	}
+
+	block.entries = append(block.entries, streamEntry{id: id, fields: fields})
+	block.live++
+
+	s.length++
+	s.lastID = id
+	s.entriesAdded++
+}
+
+// block holding id and the position of id in it
+func (s *Stream) lookup(id streamID) (*streamBlock, int, bool) {
+	_, block, ok := s.blocks.seekLE(id.key())
+	if !ok {
+		return nil, 0, false
+	}
+
+	i := sort.Search(len(block.entries), func(i int) bool { return !block.entries[i].id.less(id) })
+	if i == len(block.entries) || block.entries[i].id != id || block.entries[i].deleted {
+		return nil, 0, false
+	}
+
+	return block, i, true
+}
+
+// live entry with the given id, nil when there is none
+func (s *Stream) entry(id streamID) *streamEntry {
+	block, i, ok := s.lookup(id)
+	if !ok {
+		return nil
+	}
+
+	return &block.entries[i]
+}
+
+// marks an entry deleted, blocks without live entries are dropped
+func (s *Stream) deleteEntry(block *streamBlock, i int) {
+	block.entries[i].deleted = true
+	block.live--
+	s.length--
+
+	if block.live == 0 {
+		s.blocks.remove(block.entries[0].id.key())
+	}
+}
+
+// deletes a single entry, reports whether it existed
+func (s *Stream) remove(id streamID) bool {
+	block, i, ok := s.lookup(id)
+	if !ok {
+		return false
+	}
+
+	s.deleteEntry(block, i)
+	if s.maxDeletedID.less(id) {
+		s.maxDeletedID = id
+	}
+
+	return true
+}
+
+// calls fn for live entries with start <= id <= end, in reverse order when rev is set,
+// until fn returns false
+func (s *Stream) rangeEntries(start streamID, end streamID, rev bool, fn func(e *streamEntry) bool) {
+	if end.less(start) {
+		return
+	}
+
+	if rev {
+		key, block, ok := s.blocks.seekLE(end.key())
+		for ok {
+			for i := len(block.entries) - 1; i >= 0; i-- {
+				e := &block.entries[i]
+				if e.deleted || end.less(e.id) {
+					continue
+				}
+				if e.id.less(start) || !fn(e) {
+					return
+				}
+			}
+
+			prev, more := streamIDFromKey(key).prev()
+			if !more {
+				return
+			}
+			key, block, ok = s.blocks.seekLE(prev.key())
+		}
+		return
+	}
+
+	// the block holding start may begin before it
+	key, block, ok := s.blocks.seekLE(start.key())
+	if !ok {
+		key, block, ok = s.blocks.seekGE(start.key())
+	}
+
+	for ok {
+		for i := range block.entries {
+			e := &block.entries[i]
+			if e.deleted || e.id.less(start) {
+				continue
+			}
+			if end.less(e.id) || !fn(e) {
+				return
+			}
+		}
+
+		next, more := streamIDFromKey(key).next()
+		if !more {
+			return
+		}
+		key, block, ok = s.blocks.seekGE(next.key())
+	}
+}
+
+// first or last live entry
+func (s *Stream) edgeEntry(last bool) *streamEntry {
+	var found *streamEntry
+	s.rangeEntries(streamID{}, maxStreamID, last, func(e *streamEntry) bool {
+		found = e
+		return false
+	})
+
+	return found
+}
+
+// trimming requested by XADD and XTRIM
+type streamTrimArgs struct {
+	strategy   string // MAXLEN, MINID or empty for no trimming
+	approx     bool   // ~ only removes whole blocks
+	maxlen     int64
+	minid      streamID
+	limit      int64 // most entries removed at once, 0 for no limit
+	limitGiven bool
+}
+
+// parses a MAXLEN, MINID or LIMIT option at the start of args, returns how many arguments it used
+func parseStreamTrimOption(args []Value, trim *streamTrimArgs) (int, string) {
+	option := strings.ToUpper(args[0].bulk)
+
+	if option == "LIMIT" {
+		if len(args) < 2 {
+			return 0, "ERR syntax error"
+		}
+
+		limit, err := strconv.ParseInt(args[1].bulk, 10, 64)
+		if err != nil {
+			return 0, "ERR value is not an integer or out of range"
+		}
+		if limit < 0 {
+			return 0, "ERR The LIMIT argument must be >= 0."
+		}
+
+		trim.limit = limit
+		trim.limitGiven = true
+		return 2, ""
+	}
+
+	if trim.strategy != "" && trim.strategy != option {
+		return 0, "ERR syntax error, MAXLEN and MINID options at the same time are not compatible"
+	}
+
+	used := 1
+	if len(args) > 2 && (args[1].bulk == "~" || args[1].bulk == "=") {
+		trim.approx = args[1].bulk == "~"
+		used++
+	}
+
+	if len(args) <= used {
+		return 0, "ERR syntax error"
+	}
+
+	if option == "MAXLEN" {
+		maxlen, err := strconv.ParseInt(args[used].bulk, 10, 64)
+		if err != nil {
+			return 0, "ERR value is not an integer or out of range"
+		}
+		if maxlen < 0 {
+			return 0, "ERR The MAXLEN argument must be >= 0."
+		}
+		trim.maxlen = maxlen
+	} else {
+		minid, ok := parseStreamID(args[used].bulk, 0)
+		if !ok {
+			return 0, errInvalidStreamID
+		}
+		trim.minid = minid
+	}
+
+	trim.strategy = option
+	return used + 1, ""
+}
+
+// checks the combination of trimming options and applies the default limit
+func (t *streamTrimArgs) validate() string {
+	if t.limitGiven && !t.approx {
+		return "ERR syntax error, LIMIT cannot be used without the special ~ option"
+	}
+
+	if t.approx && !t.limitGiven {
+		t.limit = 100 * streamNodeMaxEntries
+	}
+
+	return ""
+}
+
+// removes entries from the head of the stream, returns how many were removed
+func (s *Stream) trim(args streamTrimArgs) int64 {
+	byLength := args.strategy == "MAXLEN"
+	removed := int64(0)
+
+	for s.length > 0 {
+		if byLength && int64(s.length) <= args.maxlen {
+			break
+		}
+
+		key, block, _ := s.blocks.seekGE(nil)
+		lastID := block.entries[len(block.entries)-1].id
+
+		whole := (byLength && int64(s.length-block.live) >= args.maxlen) || (!byLength && lastID.less(args.minid))
+		if whole {
+			if args.limit > 0 && removed+int64(block.live) > args.limit {
+				break
+			}
+
+			removed += int64(block.live)
+			s.length -= block.live
+			s.blocks.remove(key)
+			continue
+		}
+
+		if args.approx {
+			break
+		}
+
+		// exact trimming ends inside the first block
+		for i := range block.entries {
+			e := &block.entries[i]
+			if e.deleted {
+				continue
+			}
+			if (byLength && int64(s.length) <= args.maxlen) || (!byLength && !e.id.less(args.minid)) {
+				break
+			}
+
+			s.deleteEntry(block, i)
+			removed++
+		}
+		break
+	}
+
+	return removed
+}
+
+// id for XADD given as *, ms-* or ms-seq
+func (s *Stream) nextID(arg string) (streamID, string) {
+	if arg == "*" {
+		ms := uint64(time.Now().UnixMilli())
+		if ms > s.lastID.ms {
+			return streamID{ms: ms}, ""
+		}
+
+		id, ok := s.lastID.next()
+		if !ok {
+			return streamID{}, "ERR The stream has exhausted the last possible ID, unable to add more items"
+		}
+		return id, ""
+	}
+
+	var id streamID
+	msPart, seqPart, hasSeq := strings.Cut(arg, "-")
+	if hasSeq && seqPart == "*" {
+		ms, err := strconv.ParseUint(msPart, 10, 64)
+		if err != nil {
+			return streamID{}, errInvalidStreamID
+		}
+
+		id = streamID{ms: ms}
+		if ms == s.lastID.ms {
+			if s.lastID.seq == math.MaxUint64 {
+				return streamID{}, "ERR The ID specified in XADD is equal or smaller than the target stream top item"
+			}
+			id.seq = s.lastID.seq + 1
+		}
+	} else {
+		var ok bool
+		id, ok = parseStrictStreamID(arg)
+		if !ok {
+			return streamID{}, errInvalidStreamID
+		}
+	}
+
+	if id == (streamID{}) {
+		return streamID{}, "ERR The ID specified in XADD must be greater than 0-0"
+	}
+
+	if !s.lastID.less(id) {
+		return streamID{}, "ERR The ID specified in XADD is equal or smaller than the target stream top item"
+	}
+
+	return id, ""
+}
+
+// reply for a single entry: id followed by the field value pairs
+func streamEntryValue(e *streamEntry) Value {
+	fields := make([]Value, 0, len(e.fields))
+	for _, field := range e.fields {
+		fields = append(fields, Value{typ: "bulk", bulk: field})
+	}
+
+	return Value{typ: "array", array: []Value{
+		{typ: "bulk", bulk: e.id.String()},
+		{typ: "array", array: fields},
+	}}
+}
+
+// string arguments of a command, for propagating it unchanged
+func commandStrings(command string, args []Value) []string {
+	strs := []string{command}
+	for _, arg := range args {
+		strs = append(strs, arg.bulk)
+	}
+
+	return strs
+}
+
+// clients blocked in XREAD and XREADGROUP, woken up by writes to the key
+var streamWaiters = map[string][]chan struct{}{}
+
+// wakes up clients blocked on key, caller must hold the STREAMsMu write lock
+func signalStreamWaiters(key string) {
+	for _, ch := range streamWaiters[key] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// runs read with the STREAMsMu write lock held until it has a reply, waiting
+// for writes to keys in between when blocking, a zero timeout waits forever
+func streamBlockingRead(keys []string, block bool, timeout time.Duration, read func() (Value, bool)) Value {
+	db := selectedDB
+	if !block || blockingDisabled {
+		STREAMsMu.Lock()
+		reply, _ := read()
+		STREAMsMu.Unlock()
+		return reply
+	}
+
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	ch := make(chan struct{}, 1)
+	unregister := func() {
+		STREAMsMu.Lock()
+		for _, key := range keys {
+			waiters := streamWaiters[key]
+			for i, waiter := range waiters {
+				if waiter == ch {
+					waiters = append(waiters[:i], waiters[i+1:]...)
+					break
+				}
+			}
+
+			if len(waiters) == 0 {
+				delete(streamWaiters, key)
+			} else {
+				streamWaiters[key] = waiters
+			}
+		}
+		STREAMsMu.Unlock()
+	}
+
+	for {
+		// reading and registering under one lock so no write slips in between
+		STREAMsMu.Lock()
+		reply, ok := read()
+		if ok {
+			STREAMsMu.Unlock()
+			return reply
+		}
+
+		for _, key := range keys {
+			streamWaiters[key] = append(streamWaiters[key], ch)
+		}
+		STREAMsMu.Unlock()
+
+		// other commands, transactions included, run while this one waits
+		commandsMu.RUnlock()
+		select {
+		case <-ch:
+			unregister()
+			rlockDatabase(db)
+		case <-deadline:
+			unregister()
+			rlockDatabase(db)
+			return Value{typ: "null"}
+		}
+	}
+}
+
+// XADD command: appends an entry, optionally trimming the stream
+func xadd(args []Value) Value {
+	key := args[0].bulk
+	noMkStream := false
+	trim := streamTrimArgs{}
+
+	i := 1
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i].bulk) {
+		case "NOMKSTREAM":
+			noMkStream = true
+		case "MAXLEN", "MINID", "LIMIT":
+			used, errMsg := parseStreamTrimOption(args[i:], &trim)
+			if errMsg != "" {
+				return Value{typ: "error", str: errMsg}
+			}
+			i += used - 1
+		default:
+			break options
+		}
+	}
+
+	if errMsg := trim.validate(); errMsg != "" {
+		return Value{typ: "error", str: errMsg}
+	}
+
+	if i >= len(args) || (len(args)-i-1) == 0 || (len(args)-i-1)%2 != 0 {
+		return Value{typ: "error", str: "ERR wrong number of arguments for 'xadd' command"}
+	}
+
+	idArg := args[i].bulk
+	fields := make([]string, 0, len(args)-i-1)
+	for _, arg := range args[i+1:] {
+		fields = append(fields, arg.bulk)
+	}
+
+	STREAMsMu.Lock()
+	defer STREAMsMu.Unlock()
+
+	s, exists := STREAMs[key]
+	if !exists {
+		if noMkStream {
+			return Value{typ: "null"}
+		}
+		s = newStream()
+	}
+
+	id, errMsg := s.nextID(idArg)
+	if errMsg != "" {
+		return Value{typ: "error", str: errMsg}
+	}
+
+	STREAMs[key] = s
+	keyspaceAdd(key, keyStream)
+	s.append(id, fields)
+
+	removed := int64(0)
+	if trim.strategy != "" {
+		removed = s.trim(trim)
+	}
+
+	// persisted with the id it got, trimming as the exact length it left
+	propagate(append([]string{"XADD", key, id.String()}, fields...)...)
+	notifyKeyspaceEvent(notifyStream, "xadd", key)
+	if removed > 0 {
+		propagate("XTRIM", key, "MAXLEN", "=", strconv.Itoa(s.length))
+		notifyKeyspaceEvent(notifyStream, "xtrim", key)
+	}
+
+	signalStreamWaiters(key)
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: XADD %s %s", key, id))
+
+	return Value{typ: "bulk", bulk: id.String()}
+}
+
+// parses a range bound, an incomplete id covers every sequence of its millisecond
+// and a leading ( leaves out the id itself
+func parseRangeID(arg string, isEnd bool) (streamID, string) {
+	exclusive := len(arg) > 1 && arg[0] == '('
+	if exclusive {
+		arg = arg[1:]
+	}
+
+	missingSeq := uint64(0)
+	if isEnd {
+		missingSeq = math.MaxUint64
+	}
+
+	id, ok := parseStreamID(arg, missingSeq)
+	if !ok {
+		return streamID{}, errInvalidStreamID
+	}
+
+	if exclusive {
+		if isEnd {
+			if id, ok = id.prev(); !ok {
+				return streamID{}, "ERR invalid end ID for the interval"
+			}
+		} else if id, ok = id.next(); !ok {
+			return streamID{}, "ERR invalid start ID for the interval"
+		}
+	}
+
+	return id, ""
+}
+
+// XRANGE and XREVRANGE, the reverse form takes the end before the start
+func xrangeGeneric(name string, args []Value, rev bool) Value {
+	if len(args) != 3 && len(args) != 5 {
+		return Value{typ: "error", str: fmt.Sprintf("ERR wrong number of arguments for '%s' command", name)}
+	}
+
+	key := args[0].bulk
+	startArg, endArg := args[1].bulk, args[2].bulk
+	if rev {
+		startArg, endArg = endArg, startArg
+	}
+
+	start, errMsg := parseRangeID(startArg, false)
+	if errMsg != "" {
+		return Value{typ: "error", str: errMsg}
+	}
+
+	end, errMsg := parseRangeID(endArg, true)
+	if errMsg != "" {
+		return Value{typ: "error", str: errMsg}
+	}
+
+	count := int64(-1)
+	if len(args) == 5 {
+		if strings.ToUpper(args[3].bulk) != "COUNT" {
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+
+		var err error
+		count, err = strconv.ParseInt(args[4].bulk, 10, 64)
+		if err != nil {
+			return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+		}
+		count = max(count, 0)
+	}
+
+	STREAMsMu.RLock()
+	defer STREAMsMu.RUnlock()
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: %s %s %s %s", strings.ToUpper(name), key, startArg, endArg))
+
+	values := []Value{}
+	s, ok := STREAMs[key]
+	if !ok || count == 0 {
+		return Value{typ: "array", array: values}
+	}
+
+	s.rangeEntries(start, end, rev, func(e *streamEntry) bool {
+		values = append(values, streamEntryValue(e))
+		return count < 0 || int64(len(values)) < count
+	})
+
+	return Value{typ: "array", array: values}
+}
+
+// XRANGE command
+func xrange(args []Value) Value {
+	return xrangeGeneric("xrange", args, false)
+}
+
+// XREVRANGE command
+func xrevrange(args []Value) Value {
+	return xrangeGeneric("xrevrange", args, true)
+}
+
+// XLEN command
+func xlen(args []Value) Value {
+	key := args[0].bulk
+
+	STREAMsMu.RLock()
+	length := 0
+	if s, ok := STREAMs[key]; ok {
+		length = s.length
+	}
+	STREAMsMu.RUnlock()
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: XLEN %s", key))
+
+	return Value{typ: "integer", num: length}
+}
+
+// XDEL command: removes entries by id, the stream itself stays even when empty
+func xdel(args []Value) Value {
+	key := args[0].bulk
+
+	ids := make([]streamID, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		id, ok := parseStrictStreamID(arg.bulk)
+		if !ok {
+			return Value{typ: "error", str: errInvalidStreamID}
+		}
+		ids = append(ids, id)
+	}
+
+	STREAMsMu.Lock()
+	defer STREAMsMu.Unlock()
+
+	deleted := 0
+	if s, ok := STREAMs[key]; ok {
+		for _, id := range ids {
+			if s.remove(id) {
+				deleted++
+			}
+		}
+	}
+
+	if deleted > 0 {
+		propagate(commandStrings("XDEL", args)...)
+		notifyKeyspaceEvent(notifyStream, "xdel", key)
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: XDEL %s (%d deleted)", key, deleted))
+
+	return Value{typ: "integer", num: deleted}
+}
+
+// XTRIM command
+func xtrim(args []Value) Value {
+	key := args[0].bulk
+	trim := streamTrimArgs{}
+
+	strategy := strings.ToUpper(args[1].bulk)
+	if strategy != "MAXLEN" && strategy != "MINID" {
+		return Value{typ: "error", str: "ERR syntax error"}
+	}
+
+	for i := 1; i < len(args); {
+		option := strings.ToUpper(args[i].bulk)
+		if option != "MAXLEN" && option != "MINID" && option != "LIMIT" {
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+
+		used, errMsg := parseStreamTrimOption(args[i:], &trim)
+		if errMsg != "" {
+			return Value{typ: "error", str: errMsg}
+		}
+		i += used
+	}
+
+	if errMsg := trim.validate(); errMsg != "" {
+		return Value{typ: "error", str: errMsg}
+	}
+
+	STREAMsMu.Lock()
+	defer STREAMsMu.Unlock()
+
+	removed := int64(0)
+	if s, ok := STREAMs[key]; ok {
+		removed = s.trim(trim)
+
+		if removed > 0 {
+			propagate("XTRIM", key, "MAXLEN", "=", strconv.Itoa(s.length))
+			notifyKeyspaceEvent(notifyStream, "xtrim", key)
+		}
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: XTRIM %s (%d removed)", key, removed))
+
+	return Value{typ: "integer", num: int(removed)}
+}
+
+// options of XREAD and XREADGROUP
+type streamReadArgs struct {
+	group    string
+	consumer string
+	count    int // 0 for no limit
+	block    bool
+	timeout  time.Duration
+	noack    bool
+	keys     []string
+	ids      []string
+}
+
+func parseStreamRead(args []Value, group bool) (streamReadArgs, string) {
+	opts := streamReadArgs{}
+
+	for i := 0; i < len(args); i++ {
+		option := strings.ToUpper(args[i].bulk)
+		remaining := len(args) - i - 1
+
+		switch {
+		case option == "COUNT" && remaining >= 1:
+			count, err := strconv.Atoi(args[i+1].bulk)
+			if err != nil {
+				return opts, "ERR value is not an integer or out of range"
+			}
+			opts.count = max(count, 0)
+			i++
+
+		case option == "BLOCK" && remaining >= 1:
+			ms, err := strconv.ParseInt(args[i+1].bulk, 10, 64)
+			if err != nil {
+				return opts, "ERR timeout is not an integer or out of range"
+			}
+			if ms < 0 {
+				return opts, "ERR timeout is negative"
+			}
+			opts.block = true
+			opts.timeout = time.Duration(ms) * time.Millisecond
+			i++
+
+		case option == "NOACK" && group:
+			opts.noack = true
+
+		case option == "GROUP" && group && remaining >= 2:
+			opts.group = args[i+1].bulk
+			opts.consumer = args[i+2].bulk
+			i += 2
+
+		case option == "STREAMS" && remaining >= 1:
+			streams := args[i+1:]
+			if len(streams)%2 != 0 {
+				if group {
+					return opts, "ERR Unbalanced 'xreadgroup' list of streams: for each stream key an ID or '>' must be specified."
+				}
+				return opts, "ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified."
+			}
+
+			half := len(streams) / 2
+			for j := 0; j < half; j++ {
+				opts.keys = append(opts.keys, streams[j].bulk)
+				opts.ids = append(opts.ids, streams[half+j].bulk)
+			}
+
+			if group && opts.consumer == "" {
+				return opts, "ERR Missing GROUP option for XREADGROUP"
+			}
+			return opts, ""
+
+		default:
+			return opts, "ERR syntax error"
+		}
+	}
+
+	return opts, "ERR syntax error"
+}
+
+// XREAD command: entries after the given ids, optionally waiting for new ones
+func xread(args []Value) Value {
+	opts, errMsg := parseStreamRead(args, false)
+	if errMsg != "" {
+		return Value{typ: "error", str: errMsg}
+	}
+
+	// $ only sees entries added after the call, so it is resolved once up front
+	after := make([]streamID, len(opts.keys))
+	STREAMsMu.RLock()
+	for i, arg := range opts.ids {
+		if arg == "$" {
+			if s, ok := STREAMs[opts.keys[i]]; ok {
+				after[i] = s.lastID
+			}
+			continue
+		}
+
+		id, ok := parseStreamID(arg, 0)
+		if !ok {
+			STREAMsMu.RUnlock()
+			return Value{typ: "error", str: errInvalidStreamID}
+		}
+		after[i] = id
+	}
+	STREAMsMu.RUnlock()
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: XREAD %s", strings.Join(opts.keys, " ")))
+
+	return streamBlockingRead(opts.keys, opts.block, opts.timeout, func() (Value, bool) {
+		values := []Value{}
+		for i, key := range opts.keys {
+			s, ok := STREAMs[key]
+			if !ok {
+				continue
+			}
+
+			start, more := after[i].next()
+			if !more {
+				continue
+			}
+
+			entries := []Value{}
+			s.rangeEntries(start, maxStreamID, false, func(e *streamEntry) bool {
+				entries = append(entries, streamEntryValue(e))
+				return opts.count == 0 || len(entries) < opts.count
+			})
+
+			if len(entries) > 0 {
+				values = append(values, Value{typ: "array", array: []Value{
+					{typ: "bulk", bulk: key},
+					{typ: "array", array: entries},
+				}})
+			}
+		}
+
+		if len(values) == 0 {
+			return Value{typ: "null"}, false
+		}
+
+		return Value{typ: "array", array: values}, true
+	})
+}
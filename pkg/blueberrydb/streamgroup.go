@@ -0,0 +1,1052 @@
+// stream consumer groups: shared delivery with pending entry lists per group and consumer
+package blueberrydb
+
+import (
+	"blueberrydb/internal/logger"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// delivered entry waiting for an acknowledgement
+type streamNACK struct {
+	deliveryTime  int64 // unix ms of the last delivery
+	deliveryCount int64
+	consumer      *streamConsumer
+}
+
+type streamConsumer struct {
+	name       string
+	seenTime   int64 // last interaction, unix ms
+	activeTime int64 // last successful read or claim, -1 when never
+	pel        *radixTree[*streamNACK]
+}
+
+type streamGroup struct {
+	lastID      streamID // last entry delivered with >
+	entriesRead int64    // entries before and including lastID, -1 when unknown
+	pel         *radixTree[*streamNACK]
+	consumers   map[string]*streamConsumer
+}
+
+func newStreamGroup(lastID streamID, entriesRead int64) *streamGroup {
+	return &streamGroup{
+		lastID:      lastID,
+		entriesRead: entriesRead,
+		pel:         newRadixTree[*streamNACK](),
+		consumers:   map[string]*streamConsumer{},
+	}
+}
+
+// stream and group, nil when either does not exist, caller must hold STREAMsMu
+func streamLookupGroup(key string, name string) (*Stream, *streamGroup) {
+	s, ok := STREAMs[key]
+	if !ok {
+		return nil, nil
+	}
+
+	return s, s.groups[name]
+}
+
+// consumer by name, reports whether it had to be created
+func (g *streamGroup) consumer(name string, create bool) (*streamConsumer, bool) {
+	if c, ok := g.consumers[name]; ok || !create {
+		return c, false
+	}
+
+	now := time.Now().UnixMilli()
+	c := &streamConsumer{name: name, seenTime: now, activeTime: -1, pel: newRadixTree[*streamNACK]()}
+	g.consumers[name] = c
+
+	return c, true
+}
+
+// moves a pending entry into the list of consumer
+func (g *streamGroup) assign(id streamID, nack *streamNACK, consumer *streamConsumer) {
+	if nack.consumer != nil && nack.consumer != consumer {
+		nack.consumer.pel.remove(id.key())
+	}
+
+	nack.consumer = consumer
+	g.pel.insert(id.key(), nack)
+	consumer.pel.insert(id.key(), nack)
+}
+
+// drops a pending entry from the group and its consumer
+func (g *streamGroup) removePending(id streamID, nack *streamNACK) {
+	g.pel.remove(id.key())
+	if nack.consumer != nil {
+		nack.consumer.pel.remove(id.key())
+	}
+}
+
+// moves lastID to a delivered entry, the read counter stays known
+// only as long as no deleted entries could have been skipped
+func (g *streamGroup) advance(s *Stream, id streamID) {
+	if g.entriesRead >= 0 && !g.lastID.less(s.maxDeletedID) {
+		g.entriesRead++
+	} else {
+		g.entriesRead = -1
+	}
+
+	g.lastID = id
+	if id == s.lastID {
+		g.entriesRead = s.entriesAdded
+	}
+}
+
+// read counter of a group positioned at id, -1 when it cannot be known
+func (s *Stream) entriesReadAt(id streamID) int64 {
+	if !id.less(s.lastID) {
+		return s.entriesAdded
+	}
+
+	if id == (streamID{}) {
+		return 0
+	}
+
+	return -1
+}
+
+// entries the group has not been delivered yet, false when deletions make it unknown
+func (s *Stream) groupLag(g *streamGroup) (int64, bool) {
+	if !g.lastID.less(s.lastID) {
+		return 0, true
+	}
+
+	if g.entriesRead < 0 || g.lastID.less(s.maxDeletedID) {
+		return 0, false
+	}
+
+	return s.entriesAdded - g.entriesRead, true
+}
+
+// calls fn for pending entries with start <= id <= end until fn returns false, fn may remove the entry
+func pelRange(pel *radixTree[*streamNACK], start streamID, end streamID, fn func(id streamID, nack *streamNACK) bool) {
+	key, nack, ok := pel.seekGE(start.key())
+	for ok {
+		id := streamIDFromKey(key)
+		if end.less(id) || !fn(id, nack) {
+			return
+		}
+
+		next, more := id.next()
+		if !more {
+			return
+		}
+		key, nack, ok = pel.seekGE(next.key())
+	}
+}
+
+// persists a delivery or claim in a form that replays to the same pending entry
+func propagateClaim(key string, group string, consumer string, id streamID, nack *streamNACK) {
+	propagate("XCLAIM", key, group, consumer, "0", id.String(),
+		"TIME", strconv.FormatInt(nack.deliveryTime, 10),
+		"RETRYCOUNT", strconv.FormatInt(nack.deliveryCount, 10),
+		"FORCE", "JUSTID")
+}
+
+func propagateGroupID(key string, name string, g *streamGroup) {
+	propagate("XGROUP", "SETID", key, name, g.lastID.String(), "ENTRIESREAD", strconv.FormatInt(g.entriesRead, 10))
+}
+
+// reply value for a counter that may be unknown
+func optionalInteger(n int64, known bool) Value {
+	if !known {
+		return Value{typ: "null"}
+	}
+
+	return Value{typ: "integer", num: int(n)}
+}
+
+// XGROUP command: CREATE, SETID, DESTROY, CREATECONSUMER and DELCONSUMER
+func xgroup(args []Value) Value {
+	subcommand := strings.ToUpper(args[0].bulk)
+	arity := map[string][2]int{
+		"CREATE":         {4, 7},
+		"SETID":          {4, 6},
+		"DESTROY":        {3, 3},
+		"CREATECONSUMER": {4, 4},
+		"DELCONSUMER":    {4, 4},
+	}
+
+	bounds, ok := arity[subcommand]
+	if !ok {
+		return Value{typ: "error", str: fmt.Sprintf("ERR unknown subcommand '%s'. Try XGROUP HELP.", args[0].bulk)}
+	}
+
+	if len(args) < bounds[0] || len(args) > bounds[1] {
+		return Value{typ: "error", str: fmt.Sprintf("ERR wrong number of arguments for 'xgroup|%s' command", strings.ToLower(subcommand))}
+	}
+
+	key := args[1].bulk
+	name := args[2].bulk
+
+	// options of CREATE and SETID
+	mkStream := false
+	entriesRead := int64(-2) // not given
+	if subcommand == "CREATE" || subcommand == "SETID" {
+		for i := 4; i < len(args); i++ {
+			option := strings.ToUpper(args[i].bulk)
+			switch {
+			case option == "MKSTREAM" && subcommand == "CREATE":
+				mkStream = true
+			case option == "ENTRIESREAD" && i+1 < len(args):
+				n, err := strconv.ParseInt(args[i+1].bulk, 10, 64)
+				if err != nil {
+					return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+				}
+				if n < -1 {
+					return Value{typ: "error", str: "ERR value for ENTRIESREAD must be positive or -1"}
+				}
+				entriesRead = n
+				i++
+			default:
+				return Value{typ: "error", str: "ERR syntax error"}
+			}
+		}
+
+		if args[3].bulk != "$" {
+			if _, ok := parseStreamID(args[3].bulk, 0); !ok {
+				return Value{typ: "error", str: errInvalidStreamID}
+			}
+		}
+	}
+
+	STREAMsMu.Lock()
+	defer STREAMsMu.Unlock()
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: XGROUP %s %s %s", subcommand, key, name))
+
+	s, exists := STREAMs[key]
+	if !exists {
+		if !mkStream {
+			return Value{typ: "error", str: "ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically."}
+		}
+		s = newStream()
+		STREAMs[key] = s
+		keyspaceAdd(key, keyStream)
+	}
+
+	g := s.groups[name]
+	if g == nil && subcommand != "CREATE" && subcommand != "DESTROY" {
+		return Value{typ: "error", str: fmt.Sprintf("NOGROUP No such consumer group '%s' for key name '%s'", name, key)}
+	}
+
+	switch subcommand {
+	case "CREATE", "SETID":
+		id := s.lastID
+		if args[3].bulk != "$" {
+			id, _ = parseStreamID(args[3].bulk, 0)
+		}
+
+		if entriesRead == -2 {
+			entriesRead = s.entriesReadAt(id)
+		}
+
+		if subcommand == "CREATE" {
+			if g != nil {
+				return Value{typ: "error", str: "BUSYGROUP Consumer Group name already exists"}
+			}
+			g = newStreamGroup(id, entriesRead)
+			s.groups[name] = g
+
+			propagate("XGROUP", "CREATE", key, name, id.String(), "MKSTREAM", "ENTRIESREAD", strconv.FormatInt(entriesRead, 10))
+			notifyKeyspaceEvent(notifyStream, "xgroup-create", key)
+		} else {
+			g.lastID = id
+			g.entriesRead = entriesRead
+
+			propagateGroupID(key, name, g)
+			notifyKeyspaceEvent(notifyStream, "xgroup-setid", key)
+		}
+
+		return Value{typ: "string", str: "OK"}
+
+	case "DESTROY":
+		if g == nil {
+			return Value{typ: "integer", num: 0}
+		}
+
+		delete(s.groups, name)
+		propagate(commandStrings("XGROUP", args)...)
+		notifyKeyspaceEvent(notifyStream, "xgroup-destroy", key)
+
+		return Value{typ: "integer", num: 1}
+
+	case "CREATECONSUMER":
+		_, created := g.consumer(args[3].bulk, true)
+		if !created {
+			return Value{typ: "integer", num: 0}
+		}
+
+		propagate(commandStrings("XGROUP", args)...)
+		notifyKeyspaceEvent(notifyStream, "xgroup-createconsumer", key)
+		return Value{typ: "integer", num: 1}
+	}
+
+	// DELCONSUMER drops the consumer together with its pending entries
+	c, _ := g.consumer(args[3].bulk, false)
+	if c == nil {
+		return Value{typ: "integer", num: 0}
+	}
+
+	pending := c.pel.size
+	pelRange(c.pel, streamID{}, maxStreamID, func(id streamID, nack *streamNACK) bool {
+		g.removePending(id, nack)
+		return true
+	})
+	delete(g.consumers, c.name)
+
+	propagate(commandStrings("XGROUP", args)...)
+	notifyKeyspaceEvent(notifyStream, "xgroup-delconsumer", key)
+
+	return Value{typ: "integer", num: pending}
+}
+
+// delivers entries after the group's last id, adding them to the pending lists unless noack
+func (g *streamGroup) deliver(s *Stream, key string, name string, consumer *streamConsumer, count int, noack bool, now int64) []Value {
+	entries := []Value{}
+
+	start, more := g.lastID.next()
+	if !more {
+		return entries
+	}
+
+	s.rangeEntries(start, maxStreamID, false, func(e *streamEntry) bool {
+		g.advance(s, e.id)
+
+		if !noack {
+			nack, ok := g.pel.find(e.id.key())
+			if !ok {
+				nack = &streamNACK{}
+			}
+			nack.deliveryTime = now
+			nack.deliveryCount = 1
+			g.assign(e.id, nack, consumer)
+
+			propagateClaim(key, name, consumer.name, e.id, nack)
+		}
+
+		entries = append(entries, streamEntryValue(e))
+		return count == 0 || len(entries) < count
+	})
+
+	if len(entries) > 0 {
+		consumer.activeTime = now
+		propagateGroupID(key, name, g)
+	}
+
+	return entries
+}
+
+// XREADGROUP command: new entries for a consumer with >, its pending entries otherwise
+func xreadgroup(args []Value) Value {
+	opts, errMsg := parseStreamRead(args, true)
+	if errMsg != "" {
+		return Value{typ: "error", str: errMsg}
+	}
+
+	after := make([]streamID, len(opts.keys))
+	for i, arg := range opts.ids {
+		if arg == ">" {
+			continue
+		}
+
+		id, ok := parseStreamID(arg, 0)
+		if !ok {
+			return Value{typ: "error", str: errInvalidStreamID}
+		}
+		after[i] = id
+
+		// history is answered right away, even when empty
+		opts.block = false
+	}
+
+	noGroup := func(key string) Value {
+		return Value{typ: "error", str: fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s' in XREADGROUP with GROUP option", key, opts.group)}
+	}
+
+	STREAMsMu.RLock()
+	for _, key := range opts.keys {
+		if _, g := streamLookupGroup(key, opts.group); g == nil {
+			STREAMsMu.RUnlock()
+			return noGroup(key)
+		}
+	}
+	STREAMsMu.RUnlock()
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: XREADGROUP %s %s %s", opts.group, opts.consumer, strings.Join(opts.keys, " ")))
+
+	return streamBlockingRead(opts.keys, opts.block, opts.timeout, func() (Value, bool) {
+		values := []Value{}
+		now := time.Now().UnixMilli()
+
+		for i, key := range opts.keys {
+			// the group may have been destroyed while blocked
+			s, g := streamLookupGroup(key, opts.group)
+			if g == nil {
+				return noGroup(key), true
+			}
+
+			consumer, created := g.consumer(opts.consumer, true)
+			if created {
+				propagate("XGROUP", "CREATECONSUMER", key, opts.group, opts.consumer)
+				notifyKeyspaceEvent(notifyStream, "xgroup-createconsumer", key)
+			}
+			consumer.seenTime = now
+
+			if opts.ids[i] == ">" {
+				entries := g.deliver(s, key, opts.group, consumer, opts.count, opts.noack, now)
+				if len(entries) > 0 {
+					values = append(values, Value{typ: "array", array: []Value{
+						{typ: "bulk", bulk: key},
+						{typ: "array", array: entries},
+					}})
+				}
+				continue
+			}
+
+			entries := []Value{}
+			if start, more := after[i].next(); more {
+				pelRange(consumer.pel, start, maxStreamID, func(id streamID, nack *streamNACK) bool {
+					// entries deleted since the delivery are reported without fields
+					if e := s.entry(id); e != nil {
+						entries = append(entries, streamEntryValue(e))
+					} else {
+						entries = append(entries, Value{typ: "array", array: []Value{{typ: "bulk", bulk: id.String()}, {typ: "null"}}})
+					}
+					return opts.count == 0 || len(entries) < opts.count
+				})
+			}
+
+			values = append(values, Value{typ: "array", array: []Value{
+				{typ: "bulk", bulk: key},
+				{typ: "array", array: entries},
+			}})
+		}
+
+		if len(values) == 0 {
+			return Value{typ: "null"}, false
+		}
+
+		return Value{typ: "array", array: values}, true
+	})
+}
+
+// XACK command: removes entries from the pending lists of a group
+func xack(args []Value) Value {
+	key := args[0].bulk
+	name := args[1].bulk
+
+	ids := make([]streamID, 0, len(args)-2)
+	for _, arg := range args[2:] {
+		id, ok := parseStrictStreamID(arg.bulk)
+		if !ok {
+			return Value{typ: "error", str: errInvalidStreamID}
+		}
+		ids = append(ids, id)
+	}
+
+	STREAMsMu.Lock()
+	defer STREAMsMu.Unlock()
+
+	acked := 0
+	if _, g := streamLookupGroup(key, name); g != nil {
+		for _, id := range ids {
+			if nack, ok := g.pel.find(id.key()); ok {
+				g.removePending(id, nack)
+				acked++
+			}
+		}
+	}
+
+	if acked > 0 {
+		propagate(commandStrings("XACK", args)...)
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: XACK %s %s (%d acked)", key, name, acked))
+
+	return Value{typ: "integer", num: acked}
+}
+
+// consumer names in lexicographic order
+func (g *streamGroup) consumerNames() []string {
+	names := make([]string, 0, len(g.consumers))
+	for name := range g.consumers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// XPENDING command: summary of a group's pending entries, or the entries themselves in a range
+func xpending(args []Value) Value {
+	key := args[0].bulk
+	name := args[1].bulk
+
+	// extended form: [IDLE min-idle-time] start end count [consumer]
+	extended := len(args) > 2
+	minIdle := int64(0)
+	var start, end streamID
+	count := 0
+	consumerName := ""
+
+	if extended {
+		i := 2
+		if strings.ToUpper(args[i].bulk) == "IDLE" && len(args) > 3 {
+			var err error
+			minIdle, err = strconv.ParseInt(args[3].bulk, 10, 64)
+			if err != nil {
+				return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+			}
+			i += 2
+		}
+
+		if len(args)-i != 3 && len(args)-i != 4 {
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+
+		var errMsg string
+		if start, errMsg = parseRangeID(args[i].bulk, false); errMsg != "" {
+			return Value{typ: "error", str: errMsg}
+		}
+		if end, errMsg = parseRangeID(args[i+1].bulk, true); errMsg != "" {
+			return Value{typ: "error", str: errMsg}
+		}
+
+		var err error
+		count, err = strconv.Atoi(args[i+2].bulk)
+		if err != nil {
+			return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+		}
+		count = max(count, 0)
+
+		if len(args)-i == 4 {
+			consumerName = args[i+3].bulk
+		}
+	}
+
+	STREAMsMu.RLock()
+	defer STREAMsMu.RUnlock()
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: XPENDING %s %s", key, name))
+
+	_, g := streamLookupGroup(key, name)
+	if g == nil {
+		return Value{typ: "error", str: fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s'", key, name)}
+	}
+
+	if !extended {
+		if g.pel.size == 0 {
+			return Value{typ: "array", array: []Value{{typ: "integer", num: 0}, {typ: "null"}, {typ: "null"}, {typ: "null"}}}
+		}
+
+		firstKey, _, _ := g.pel.seekGE(nil)
+		lastKey, _, _ := g.pel.seekLE(maxStreamID.key())
+
+		consumers := []Value{}
+		for _, consumerName := range g.consumerNames() {
+			if pending := g.consumers[consumerName].pel.size; pending > 0 {
+				consumers = append(consumers, Value{typ: "array", array: []Value{
+					{typ: "bulk", bulk: consumerName},
+					{typ: "bulk", bulk: strconv.Itoa(pending)},
+				}})
+			}
+		}
+
+		return Value{typ: "array", array: []Value{
+			{typ: "integer", num: g.pel.size},
+			{typ: "bulk", bulk: streamIDFromKey(firstKey).String()},
+			{typ: "bulk", bulk: streamIDFromKey(lastKey).String()},
+			{typ: "array", array: consumers},
+		}}
+	}
+
+	values := []Value{}
+
+	pel := g.pel
+	if consumerName != "" {
+		c, _ := g.consumer(consumerName, false)
+		if c == nil {
+			return Value{typ: "array", array: values}
+		}
+		pel = c.pel
+	}
+
+	if count == 0 {
+		return Value{typ: "array", array: values}
+	}
+
+	now := time.Now().UnixMilli()
+	pelRange(pel, start, end, func(id streamID, nack *streamNACK) bool {
+		idle := now - nack.deliveryTime
+		if idle < minIdle {
+			return true
+		}
+
+		values = append(values, Value{typ: "array", array: []Value{
+			{typ: "bulk", bulk: id.String()},
+			{typ: "bulk", bulk: nack.consumer.name},
+			{typ: "integer", num: int(idle)},
+			{typ: "integer", num: int(nack.deliveryCount)},
+		}})
+		return len(values) < count
+	})
+
+	return Value{typ: "array", array: values}
+}
+
+// hands a pending entry to consumer, as a claim or a forced delivery
+func (g *streamGroup) claim(id streamID, nack *streamNACK, consumer *streamConsumer, deliveryTime int64, retryCount int64, justID bool) {
+	g.assign(id, nack, consumer)
+
+	nack.deliveryTime = deliveryTime
+	if retryCount >= 0 {
+		nack.deliveryCount = retryCount
+	} else if !justID {
+		nack.deliveryCount++
+	}
+}
+
+// XCLAIM command: takes over pending entries idle for at least min-idle-time
+func xclaim(args []Value) Value {
+	key := args[0].bulk
+	name := args[1].bulk
+	consumerName := args[2].bulk
+
+	minIdle, err := strconv.ParseInt(args[3].bulk, 10, 64)
+	if err != nil {
+		return Value{typ: "error", str: "ERR Invalid min-idle-time argument for XCLAIM"}
+	}
+
+	// ids come first, the options start at the first argument that is not an id
+	i := 4
+	ids := []streamID{}
+	for ; i < len(args); i++ {
+		id, ok := parseStrictStreamID(args[i].bulk)
+		if !ok {
+			break
+		}
+		ids = append(ids, id)
+	}
+
+	now := time.Now().UnixMilli()
+	deliveryTime := now
+	retryCount := int64(-1)
+	force, justID := false, false
+	var lastID streamID
+	lastIDGiven := false
+
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(args[i].bulk)
+		remaining := len(args) - i - 1
+
+		switch {
+		case option == "FORCE":
+			force = true
+		case option == "JUSTID":
+			justID = true
+		case option == "IDLE" && remaining >= 1:
+			idle, err := strconv.ParseInt(args[i+1].bulk, 10, 64)
+			if err != nil {
+				return Value{typ: "error", str: "ERR Invalid IDLE option argument for XCLAIM"}
+			}
+			deliveryTime = now - idle
+			i++
+		case option == "TIME" && remaining >= 1:
+			ms, err := strconv.ParseInt(args[i+1].bulk, 10, 64)
+			if err != nil {
+				return Value{typ: "error", str: "ERR Invalid TIME option argument for XCLAIM"}
+			}
+			deliveryTime = ms
+			i++
+		case option == "RETRYCOUNT" && remaining >= 1:
+			retryCount, err = strconv.ParseInt(args[i+1].bulk, 10, 64)
+			if err != nil {
+				return Value{typ: "error", str: "ERR Invalid RETRYCOUNT option argument for XCLAIM"}
+			}
+			i++
+		case option == "LASTID" && remaining >= 1:
+			var ok bool
+			if lastID, ok = parseStrictStreamID(args[i+1].bulk); !ok {
+				return Value{typ: "error", str: errInvalidStreamID}
+			}
+			lastIDGiven = true
+			i++
+		default:
+			return Value{typ: "error", str: fmt.Sprintf("ERR Unrecognized XCLAIM option '%s'", args[i].bulk)}
+		}
+	}
+
+	// delivery times in the future are clamped to now
+	if deliveryTime < 0 || deliveryTime > now {
+		deliveryTime = now
+	}
+
+	STREAMsMu.Lock()
+	defer STREAMsMu.Unlock()
+
+	s, g := streamLookupGroup(key, name)
+	if g == nil {
+		return Value{typ: "error", str: fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s'", key, name)}
+	}
+
+	if lastIDGiven && g.lastID.less(lastID) {
+		g.lastID = lastID
+		propagateGroupID(key, name, g)
+	}
+
+	values := []Value{}
+	for _, id := range ids {
+		e := s.entry(id)
+
+		nack, pending := g.pel.find(id.key())
+		if !pending {
+			// FORCE creates the pending entry, as long as the entry still exists
+			if !force || e == nil {
+				continue
+			}
+			nack = &streamNACK{}
+		}
+
+		// entries deleted from the stream can no longer be claimed
+		if e == nil {
+			g.removePending(id, nack)
+			propagate("XACK", key, name, id.String())
+			continue
+		}
+
+		if pending && minIdle > 0 && now-nack.deliveryTime < minIdle {
+			continue
+		}
+
+		consumer, created := g.consumer(consumerName, true)
+		if created {
+			propagate("XGROUP", "CREATECONSUMER", key, name, consumerName)
+			notifyKeyspaceEvent(notifyStream, "xgroup-createconsumer", key)
+		}
+		consumer.seenTime = now
+		consumer.activeTime = now
+
+		g.claim(id, nack, consumer, deliveryTime, retryCount, justID)
+		propagateClaim(key, name, consumerName, id, nack)
+
+		if justID {
+			values = append(values, Value{typ: "bulk", bulk: id.String()})
+		} else {
+			values = append(values, streamEntryValue(e))
+		}
+	}
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: XCLAIM %s %s %s (%d claimed)", key, name, consumerName, len(values)))
+
+	return Value{typ: "array", array: values}
+}
+
+// XAUTOCLAIM command: scans the pending entries from start and claims the idle ones
+func xautoclaim(args []Value) Value {
+	key := args[0].bulk
+	name := args[1].bulk
+	consumerName := args[2].bulk
+
+	minIdle, err := strconv.ParseInt(args[3].bulk, 10, 64)
+	if err != nil {
+		return Value{typ: "error", str: "ERR Invalid min-idle-time argument for XAUTOCLAIM"}
+	}
+	minIdle = max(minIdle, 0)
+
+	start, errMsg := parseRangeID(args[4].bulk, false)
+	if errMsg != "" {
+		return Value{typ: "error", str: errMsg}
+	}
+
+	count := 100
+	justID := false
+	for i := 5; i < len(args); i++ {
+		option := strings.ToUpper(args[i].bulk)
+
+		switch {
+		case option == "COUNT" && i+1 < len(args):
+			count, err = strconv.Atoi(args[i+1].bulk)
+			if err != nil || count < 1 {
+				return Value{typ: "error", str: "ERR COUNT must be > 0"}
+			}
+			i++
+		case option == "JUSTID":
+			justID = true
+		default:
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+	}
+
+	STREAMsMu.Lock()
+	defer STREAMsMu.Unlock()
+
+	s, g := streamLookupGroup(key, name)
+	if g == nil {
+		return Value{typ: "error", str: fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s'", key, name)}
+	}
+
+	now := time.Now().UnixMilli()
+	claimed := []Value{}
+	deleted := []Value{}
+	cursor := streamID{}
+
+	// every examined entry uses up an attempt, so one call does bounded work
+	attempts := count * 10
+	pelRange(g.pel, start, maxStreamID, func(id streamID, nack *streamNACK) bool {
+		if attempts == 0 || len(claimed) == count {
+			cursor = id
+			return false
+		}
+		attempts--
+
+		e := s.entry(id)
+		if e == nil {
+			g.removePending(id, nack)
+			propagate("XACK", key, name, id.String())
+			deleted = append(deleted, Value{typ: "bulk", bulk: id.String()})
+			return true
+		}
+
+		if now-nack.deliveryTime < minIdle {
+			return true
+		}
+
+		consumer, created := g.consumer(consumerName, true)
+		if created {
+			propagate("XGROUP", "CREATECONSUMER", key, name, consumerName)
+			notifyKeyspaceEvent(notifyStream, "xgroup-createconsumer", key)
+		}
+		consumer.seenTime = now
+		consumer.activeTime = now
+
+		g.claim(id, nack, consumer, now, -1, justID)
+		propagateClaim(key, name, consumerName, id, nack)
+
+		if justID {
+			claimed = append(claimed, Value{typ: "bulk", bulk: id.String()})
+		} else {
+			claimed = append(claimed, streamEntryValue(e))
+		}
+		return true
+	})
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: XAUTOCLAIM %s %s %s (%d claimed)", key, name, consumerName, len(claimed)))
+
+	return Value{typ: "array", array: []Value{
+		{typ: "bulk", bulk: cursor.String()},
+		{typ: "array", array: claimed},
+		{typ: "array", array: deleted},
+	}}
+}
+
+// group names in lexicographic order
+func (s *Stream) groupNames() []string {
+	names := make([]string, 0, len(s.groups))
+	for name := range s.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// XINFO command: STREAM [FULL [COUNT count]], GROUPS and CONSUMERS
+func xinfo(args []Value) Value {
+	subcommand := strings.ToUpper(args[0].bulk)
+	key := args[1].bulk
+
+	if subcommand != "STREAM" && subcommand != "GROUPS" && subcommand != "CONSUMERS" {
+		return Value{typ: "error", str: fmt.Sprintf("ERR unknown subcommand '%s'. Try XINFO HELP.", args[0].bulk)}
+	}
+
+	STREAMsMu.RLock()
+	defer STREAMsMu.RUnlock()
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: XINFO %s %s", subcommand, key))
+
+	s, ok := STREAMs[key]
+	if !ok {
+		return Value{typ: "error", str: "ERR no such key"}
+	}
+
+	now := time.Now().UnixMilli()
+
+	switch subcommand {
+	case "GROUPS":
+		if len(args) != 2 {
+			return Value{typ: "error", str: "ERR wrong number of arguments for 'xinfo|groups' command"}
+		}
+
+		groups := []Value{}
+		for _, name := range s.groupNames() {
+			g := s.groups[name]
+			lag, known := s.groupLag(g)
+
+			groups = append(groups, Value{typ: "array", array: []Value{
+				{typ: "bulk", bulk: "name"}, {typ: "bulk", bulk: name},
+				{typ: "bulk", bulk: "consumers"}, {typ: "integer", num: len(g.consumers)},
+				{typ: "bulk", bulk: "pending"}, {typ: "integer", num: g.pel.size},
+				{typ: "bulk", bulk: "last-delivered-id"}, {typ: "bulk", bulk: g.lastID.String()},
+				{typ: "bulk", bulk: "entries-read"}, optionalInteger(g.entriesRead, g.entriesRead >= 0),
+				{typ: "bulk", bulk: "lag"}, optionalInteger(lag, known),
+			}})
+		}
+
+		return Value{typ: "array", array: groups}
+
+	case "CONSUMERS":
+		if len(args) != 3 {
+			return Value{typ: "error", str: "ERR wrong number of arguments for 'xinfo|consumers' command"}
+		}
+
+		g := s.groups[args[2].bulk]
+		if g == nil {
+			return Value{typ: "error", str: fmt.Sprintf("NOGROUP No such consumer group '%s' for key name '%s'", args[2].bulk, key)}
+		}
+
+		consumers := []Value{}
+		for _, name := range g.consumerNames() {
+			c := g.consumers[name]
+
+			inactive := int64(-1)
+			if c.activeTime >= 0 {
+				inactive = now - c.activeTime
+			}
+
+			consumers = append(consumers, Value{typ: "array", array: []Value{
+				{typ: "bulk", bulk: "name"}, {typ: "bulk", bulk: name},
+				{typ: "bulk", bulk: "pending"}, {typ: "integer", num: c.pel.size},
+				{typ: "bulk", bulk: "idle"}, {typ: "integer", num: int(now - c.seenTime)},
+				{typ: "bulk", bulk: "inactive"}, {typ: "integer", num: int(inactive)},
+			}})
+		}
+
+		return Value{typ: "array", array: consumers}
+	}
+
+	// STREAM
+	full := false
+	count := 10
+	for i := 2; i < len(args); i++ {
+		option := strings.ToUpper(args[i].bulk)
+
+		switch {
+		case option == "FULL":
+			full = true
+		case option == "COUNT" && full && i+1 < len(args):
+			n, err := strconv.Atoi(args[i+1].bulk)
+			if err != nil {
+				return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+			}
+			count = max(n, 0)
+			i++
+		default:
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+	}
+
+	firstID := streamID{}
+	if first := s.edgeEntry(false); first != nil {
+		firstID = first.id
+	}
+
+	values := []Value{
+		{typ: "bulk", bulk: "length"}, {typ: "integer", num: s.length},
+		{typ: "bulk", bulk: "radix-tree-keys"}, {typ: "integer", num: s.blocks.size},
+		{typ: "bulk", bulk: "radix-tree-nodes"}, {typ: "integer", num: s.blocks.nodes},
+		{typ: "bulk", bulk: "last-generated-id"}, {typ: "bulk", bulk: s.lastID.String()},
+		{typ: "bulk", bulk: "max-deleted-entry-id"}, {typ: "bulk", bulk: s.maxDeletedID.String()},
+		{typ: "bulk", bulk: "entries-added"}, {typ: "integer", num: int(s.entriesAdded)},
+		{typ: "bulk", bulk: "recorded-first-entry-id"}, {typ: "bulk", bulk: firstID.String()},
+	}
+
+	if !full {
+		edge := func(last bool) Value {
+			if e := s.edgeEntry(last); e != nil {
+				return streamEntryValue(e)
+			}
+			return Value{typ: "null"}
+		}
+
+		return Value{typ: "array", array: append(values,
+			Value{typ: "bulk", bulk: "groups"}, Value{typ: "integer", num: len(s.groups)},
+			Value{typ: "bulk", bulk: "first-entry"}, edge(false),
+			Value{typ: "bulk", bulk: "last-entry"}, edge(true),
+		)}
+	}
+
+	// a count of 0 lists everything
+	entries := []Value{}
+	s.rangeEntries(streamID{}, maxStreamID, false, func(e *streamEntry) bool {
+		entries = append(entries, streamEntryValue(e))
+		return count == 0 || len(entries) < count
+	})
+
+	groups := []Value{}
+	for _, name := range s.groupNames() {
+		g := s.groups[name]
+		lag, known := s.groupLag(g)
+
+		pending := []Value{}
+		pelRange(g.pel, streamID{}, maxStreamID, func(id streamID, nack *streamNACK) bool {
+			pending = append(pending, Value{typ: "array", array: []Value{
+				{typ: "bulk", bulk: id.String()},
+				{typ: "bulk", bulk: nack.consumer.name},
+				{typ: "integer", num: int(nack.deliveryTime)},
+				{typ: "integer", num: int(nack.deliveryCount)},
+			}})
+			return count == 0 || len(pending) < count
+		})
+
+		consumers := []Value{}
+		for _, consumerName := range g.consumerNames() {
+			c := g.consumers[consumerName]
+
+			consumerPending := []Value{}
+			pelRange(c.pel, streamID{}, maxStreamID, func(id streamID, nack *streamNACK) bool {
+				consumerPending = append(consumerPending, Value{typ: "array", array: []Value{
+					{typ: "bulk", bulk: id.String()},
+					{typ: "integer", num: int(nack.deliveryTime)},
+					{typ: "integer", num: int(nack.deliveryCount)},
+				}})
+				return count == 0 || len(consumerPending) < count
+			})
+
+			consumers = append(consumers, Value{typ: "array", array: []Value{
+				{typ: "bulk", bulk: "name"}, {typ: "bulk", bulk: consumerName},
+				{typ: "bulk", bulk: "seen-time"}, {typ: "integer", num: int(c.seenTime)},
+				{typ: "bulk", bulk: "active-time"}, {typ: "integer", num: int(c.activeTime)},
+				{typ: "bulk", bulk: "pel-count"}, {typ: "integer", num: c.pel.size},
+				{typ: "bulk", bulk: "pending"}, {typ: "array", array: consumerPending},
+			}})
+		}
+
+		groups = append(groups, Value{typ: "array", array: []Value{
+			{typ: "bulk", bulk: "name"}, {typ: "bulk", bulk: name},
+			{typ: "bulk", bulk: "last-delivered-id"}, {typ: "bulk", bulk: g.lastID.String()},
+			{typ: "bulk", bulk: "entries-read"}, optionalInteger(g.entriesRead, g.entriesRead >= 0),
+			{typ: "bulk", bulk: "lag"}, optionalInteger(lag, known),
+			{typ: "bulk", bulk: "pel-count"}, {typ: "integer", num: g.pel.size},
+			{typ: "bulk", bulk: "pending"}, {typ: "array", array: pending},
+			{typ: "bulk", bulk: "consumers"}, {typ: "array", array: consumers},
+		}})
+	}
+
+	return Value{typ: "array", array: append(values,
+		Value{typ: "bulk", bulk: "entries"}, Value{typ: "array", array: entries},
+		Value{typ: "bulk", bulk: "groups"}, Value{typ: "array", array: groups},
+	)}
+}
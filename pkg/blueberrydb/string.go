@@ -0,0 +1,507 @@
+// string commands: counters, appends, ranges and longest common subsequence
+package blueberrydb
+
+import (
+	"blueberrydb/internal/logger"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// string encodings, numeric strings are kept as int64 so counters skip strconv
+const (
+	encodingRaw = iota
+	encodingInt
+)
+
+// largest string SETRANGE is allowed to create, same as redis proto-max-bulk-len
+const maxStringSize = 512 * 1024 * 1024
+
+// parses strings that print back exactly the same, so "007" or "+5" stay strings
+func parseCanonicalInt(value string) (int64, bool) {
+	if len(value) == 0 || len(value) > 20 {
+		return 0, false
+	}
+
+	num, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || strconv.FormatInt(num, 10) != value {
+		return 0, false
+	}
+
+	return num, true
+}
+
+// builds a string entry, canonical integers are stored int encoded
+func newStringEntry(value string) SetValStruct {
+	if num, ok := parseCanonicalInt(value); ok {
+		return SetValStruct{num: num, encoding: encodingInt}
+	}
+
+	return SetValStruct{value: value, encoding: encodingRaw}
+}
+
+// returns the string form of an entry regardless of its encoding
+func (e SetValStruct) stringValue() string {
+	if e.encoding == encodingInt {
+		return strconv.FormatInt(e.num, 10)
+	}
+
+	return e.value
+}
+
+// reports whether the key is past its expiration time
+func (e SetValStruct) expired(now int64) bool {
+	return e.expiresAt > 0 && now > e.expiresAt
+}
+
+// keys that were given an expiration, guarded by SETsMu, entries are dropped
+// lazily once the key is gone or no longer expires
+var volatileStrings = map[string]struct{}{}
+
+// one active expire pass over a sample of volatile keys, returns the number of sampled and expired keys
+func activeExpireStrings(sample int) (int, int) {
+	now := time.Now().Unix()
+	sampled, expired := 0, 0
+
+	SETsMu.Lock()
+	defer SETsMu.Unlock()
+
+	// map iteration order is random which makes this a random sample
+	for key := range volatileStrings {
+		if sampled == sample {
+			break
+		}
+		sampled++
+
+		entry, ok := SETs[key]
+		if !ok || entry.expiresAt == 0 {
+			delete(volatileStrings, key)
+			continue
+		}
+
+		if entry.expired(now) {
+			delete(SETs, key)
+			delete(volatileStrings, key)
+			keyspaceRemove(key, keyString)
+			notifyKeyspaceEvent(notifyExpired, "expired", key)
+			expired++
+
+			// debug
+			logger.Debug(fmt.Sprintf("active expire: reclaimed key %s", key))
+		}
+	}
+
+	return sampled, expired
+}
+
+// returns the entry of a key, deleting it if it is expired, caller must hold the SETsMu write lock
+func stringLiveEntry(key string) (SetValStruct, bool) {
+	entry, ok := SETs[key]
+	if !ok {
+		return SetValStruct{}, false
+	}
+
+	if entry.expired(time.Now().Unix()) {
+		delete(SETs, key)
+		delete(volatileStrings, key)
+		keyspaceRemove(key, keyString)
+		notifyKeyspaceEvent(notifyExpired, "expired", key)
+		return SetValStruct{}, false
+	}
+
+	return entry, true
+}
+
+// INCR command
+func incr(args []Value) Value {
+	return incrDecrGeneric("INCR", args[0].bulk, 1)
+}
+
+// DECR command
+func decr(args []Value) Value {
+	return incrDecrGeneric("DECR", args[0].bulk, -1)
+}
+
+// INCRBY command
+func incrby(args []Value) Value {
+	increment, err := strconv.ParseInt(args[1].bulk, 10, 64)
+	if err != nil {
+		return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+	}
+
+	return incrDecrGeneric("INCRBY", args[0].bulk, increment)
+}
+
+// DECRBY command
+func decrby(args []Value) Value {
+	decrement, err := strconv.ParseInt(args[1].bulk, 10, 64)
+	if err != nil || decrement == math.MinInt64 {
+		return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+	}
+
+	return incrDecrGeneric("DECRBY", args[0].bulk, -decrement)
+}
+
+// shared implementation of the integer counter commands, the key keeps its expiration
+func incrDecrGeneric(command string, key string, increment int64) Value {
+	SETsMu.Lock()
+	defer SETsMu.Unlock()
+
+	entry, ok := stringLiveEntry(key)
+
+	var current int64
+	if ok {
+		// raw strings can still hold an integer after APPEND or SETRANGE
+		if entry.encoding != encodingInt {
+			num, ok := parseCanonicalInt(entry.value)
+			if !ok {
+				return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+			}
+			entry.num = num
+		}
+		current = entry.num
+	}
+
+	if (increment > 0 && current > math.MaxInt64-increment) || (increment < 0 && current < math.MinInt64-increment) {
+		return Value{typ: "error", str: "ERR increment or decrement would overflow"}
+	}
+	current += increment
+
+	entry.value = ""
+	entry.num = current
+	entry.encoding = encodingInt
+	SETs[key] = entry
+	keyspaceAdd(key, keyString)
+	notifyKeyspaceEvent(notifyString, "incrby", key)
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: %s %s %d", command, key, increment))
+
+	return Value{typ: "integer", num: int(current)}
+}
+
+// INCRBYFLOAT command
+func incrbyfloat(args []Value) Value {
+	key := args[0].bulk
+
+	increment, err := strconv.ParseFloat(args[1].bulk, 64)
+	if err != nil || math.IsNaN(increment) || math.IsInf(increment, 0) {
+		return Value{typ: "error", str: "ERR value is not a valid float"}
+	}
+
+	SETsMu.Lock()
+	defer SETsMu.Unlock()
+
+	entry, ok := stringLiveEntry(key)
+
+	var current float64
+	if ok {
+		current, err = strconv.ParseFloat(entry.stringValue(), 64)
+		if err != nil {
+			return Value{typ: "error", str: "ERR value is not a valid float"}
+		}
+	}
+
+	current += increment
+	if math.IsNaN(current) || math.IsInf(current, 0) {
+		return Value{typ: "error", str: "ERR increment would produce NaN or Infinity"}
+	}
+
+	result := strconv.FormatFloat(current, 'f', -1, 64)
+
+	updated := newStringEntry(result)
+	updated.expiresAt = entry.expiresAt
+	SETs[key] = updated
+	keyspaceAdd(key, keyString)
+	notifyKeyspaceEvent(notifyString, "incrbyfloat", key)
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: INCRBYFLOAT %s %s", key, args[1].bulk))
+
+	return Value{typ: "bulk", bulk: result}
+}
+
+// APPEND command: returns the length of the string after the append
+func appendCommand(args []Value) Value {
+	key := args[0].bulk
+
+	SETsMu.Lock()
+	defer SETsMu.Unlock()
+
+	entry, _ := stringLiveEntry(key)
+
+	// appending always leaves a raw string behind
+	entry.value = entry.stringValue() + args[1].bulk
+	entry.num = 0
+	entry.encoding = encodingRaw
+	SETs[key] = entry
+	keyspaceAdd(key, keyString)
+	notifyKeyspaceEvent(notifyString, "append", key)
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: APPEND %s", key))
+
+	return Value{typ: "integer", num: len(entry.value)}
+}
+
+// STRLEN command
+func strlen(args []Value) Value {
+	key := args[0].bulk
+
+	SETsMu.RLock()
+	entry, ok := SETs[key]
+	SETsMu.RUnlock()
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: STRLEN %s", key))
+
+	if !ok || entry.expired(time.Now().Unix()) {
+		return Value{typ: "integer", num: 0}
+	}
+
+	return Value{typ: "integer", num: len(entry.stringValue())}
+}
+
+// GETRANGE command: substring with inclusive, possibly negative offsets
+func getrange(args []Value) Value {
+	key := args[0].bulk
+
+	start, err1 := strconv.Atoi(args[1].bulk)
+	end, err2 := strconv.Atoi(args[2].bulk)
+	if err1 != nil || err2 != nil {
+		return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+	}
+
+	SETsMu.RLock()
+	entry, ok := SETs[key]
+	SETsMu.RUnlock()
+
+	if !ok || entry.expired(time.Now().Unix()) {
+		return Value{typ: "bulk", bulk: ""}
+	}
+
+	value := entry.stringValue()
+	length := len(value)
+
+	// both offsets negative and start after end can never select anything
+	if start < 0 && end < 0 && start > end {
+		return Value{typ: "bulk", bulk: ""}
+	}
+
+	if start < 0 {
+		start = max(length+start, 0)
+	}
+	if end < 0 {
+		end = max(length+end, 0)
+	}
+	end = min(end, length-1)
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: GETRANGE %s %d %d", key, start, end))
+
+	if start > end || length == 0 {
+		return Value{typ: "bulk", bulk: ""}
+	}
+
+	return Value{typ: "bulk", bulk: value[start : end+1]}
+}
+
+// SETRANGE command: overwrite part of a string, padding with zero bytes when needed
+func setrange(args []Value) Value {
+	key := args[0].bulk
+	patch := args[2].bulk
+
+	offset, err := strconv.Atoi(args[1].bulk)
+	if err != nil {
+		return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+	}
+
+	if offset < 0 {
+		return Value{typ: "error", str: "ERR offset is out of range"}
+	}
+
+	if offset+len(patch) > maxStringSize {
+		return Value{typ: "error", str: "ERR string exceeds maximum allowed size (proto-max-bulk-len)"}
+	}
+
+	SETsMu.Lock()
+	defer SETsMu.Unlock()
+
+	entry, ok := stringLiveEntry(key)
+	value := entry.stringValue()
+
+	// an empty patch never creates or grows the key
+	if len(patch) == 0 {
+		if !ok {
+			return Value{typ: "integer", num: 0}
+		}
+		return Value{typ: "integer", num: len(value)}
+	}
+
+	buf := []byte(value)
+	if offset+len(patch) > len(buf) {
+		buf = append(buf, make([]byte, offset+len(patch)-len(buf))...)
+	}
+	copy(buf[offset:], patch)
+
+	entry.value = string(buf)
+	entry.num = 0
+	entry.encoding = encodingRaw
+	SETs[key] = entry
+	keyspaceAdd(key, keyString)
+	notifyKeyspaceEvent(notifyString, "setrange", key)
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: SETRANGE %s %d", key, offset))
+
+	return Value{typ: "integer", num: len(buf)}
+}
+
+// LCS command: longest common subsequence of two strings
+func lcs(args []Value) Value {
+	var getLen, getIdx, withMatchLen bool
+	minMatchLen := 0
+
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i].bulk) {
+		case "LEN":
+			getLen = true
+		case "IDX":
+			getIdx = true
+		case "WITHMATCHLEN":
+			withMatchLen = true
+		case "MINMATCHLEN":
+			if i+1 >= len(args) {
+				return Value{typ: "error", str: "ERR syntax error"}
+			}
+			n, err := strconv.Atoi(args[i+1].bulk)
+			if err != nil {
+				return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+			}
+			minMatchLen = max(n, 0)
+			i++
+		default:
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+	}
+
+	if getLen && getIdx {
+		return Value{typ: "error", str: "ERR If you want both the length and indexes, please just use IDX."}
+	}
+
+	// missing keys behave like empty strings
+	now := time.Now().Unix()
+	SETsMu.RLock()
+	entryA, okA := SETs[args[0].bulk]
+	entryB, okB := SETs[args[1].bulk]
+	SETsMu.RUnlock()
+
+	var a, b string
+	if okA && !entryA.expired(now) {
+		a = entryA.stringValue()
+	}
+	if okB && !entryB.expired(now) {
+		b = entryB.stringValue()
+	}
+
+	// the table takes a uint32 cell for every pair of prefixes, refused as in
+	// redis when that would not fit in proto-max-bulk-len
+	cells := uint64(len(a)+1) * uint64(len(b)+1)
+	if cells >= math.MaxUint32 || cells*4 > maxStringSize {
+		return Value{typ: "error", str: "ERR Insufficient memory, transient memory for LCS exceeds proto-max-bulk-len"}
+	}
+
+	// dp[i][j] is the lcs length of a[:i] and b[:j]
+	table := make([]uint32, cells)
+	dp := make([][]uint32, len(a)+1)
+	for i := range dp {
+		dp[i] = table[i*(len(b)+1) : (i+1)*(len(b)+1)]
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			if a[i-1] == b[j-1] {
+				dp[i][j] = dp[i-1][j-1] + 1
+			} else {
+				dp[i][j] = max(dp[i-1][j], dp[i][j-1])
+			}
+		}
+	}
+
+	length := int(dp[len(a)][len(b)])
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: LCS %s %s", args[0].bulk, args[1].bulk))
+
+	if getLen {
+		return Value{typ: "integer", num: length}
+	}
+
+	// walk back from the end, collecting the subsequence and the matching ranges
+	result := make([]byte, length)
+	matches := []Value{}
+	idx := length
+	i, j := len(a), len(b)
+	aStart, aEnd, bStart, bEnd := len(a), 0, 0, 0
+
+	for i > 0 && j > 0 {
+		emit := false
+		if a[i-1] == b[j-1] {
+			result[idx-1] = a[i-1]
+
+			if aStart == len(a) {
+				aStart, aEnd = i-1, i-1
+				bStart, bEnd = j-1, j-1
+			} else if aStart == i && bStart == j {
+				// the range is contiguous, extend it backwards
+				aStart--
+				bStart--
+			} else {
+				emit = true
+			}
+
+			if aStart == 0 || bStart == 0 {
+				emit = true
+			}
+			idx--
+			i--
+			j--
+		} else {
+			if dp[i-1][j] > dp[i][j-1] {
+				i--
+			} else {
+				j--
+			}
+			if aStart != len(a) {
+				emit = true
+			}
+		}
+
+		matchLen := aEnd - aStart + 1
+		if emit {
+			if getIdx && (minMatchLen == 0 || matchLen >= minMatchLen) {
+				match := []Value{
+					{typ: "array", array: []Value{{typ: "integer", num: aStart}, {typ: "integer", num: aEnd}}},
+					{typ: "array", array: []Value{{typ: "integer", num: bStart}, {typ: "integer", num: bEnd}}},
+				}
+				if withMatchLen {
+					match = append(match, Value{typ: "integer", num: matchLen})
+				}
+				matches = append(matches, Value{typ: "array", array: match})
+			}
+			aStart = len(a)
+		}
+	}
+
+	if getIdx {
+		return Value{typ: "array", array: []Value{
+			{typ: "bulk", bulk: "matches"},
+			{typ: "array", array: matches},
+			{typ: "bulk", bulk: "len"},
+			{typ: "integer", num: length},
+		}}
+	}
+
+	return Value{typ: "bulk", bulk: string(result)}
+}
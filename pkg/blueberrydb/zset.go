@@ -0,0 +1,403 @@
+// sorted set commands: members with a score, ordered by score then by member
+package blueberrydb
+
+import (
+	"blueberrydb/internal/logger"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// dict for score lookups by member, skiplist for ordered access
+type SortedSet struct {
+	dict map[string]float64
+	zsl  *zskiplist
+}
+
+var ZSETs = map[string]*SortedSet{}
+var ZSETsMu = sync.RWMutex{}
+
+func newSortedSet() *SortedSet {
+	return &SortedSet{
+		dict: map[string]float64{},
+		zsl:  newSkiplist(),
+	}
+}
+
+func (z *SortedSet) length() int {
+	return len(z.dict)
+}
+
+func (z *SortedSet) score(member string) (float64, bool) {
+	score, ok := z.dict[member]
+	return score, ok
+}
+
+// adds a member or moves it to a new score, returns true when the member is new
+func (z *SortedSet) set(member string, score float64) bool {
+	current, exists := z.dict[member]
+	if exists {
+		if current == score {
+			return false
+		}
+		z.zsl.delete(current, member)
+	}
+
+	z.zsl.insert(score, member)
+	z.dict[member] = score
+
+	return !exists
+}
+
+// removes a member, reports whether it was present
+func (z *SortedSet) remove(member string) bool {
+	score, exists := z.dict[member]
+	if !exists {
+		return false
+	}
+
+	z.zsl.delete(score, member)
+	delete(z.dict, member)
+
+	return true
+}
+
+// calls fn for members with min <= score < max in order until fn returns false
+func (z *SortedSet) scoreRange(min float64, max float64, fn func(member string, score float64) bool) {
+	for node := z.zsl.firstFrom(min, false); node != nil && node.score < max; node = node.level[0].forward {
+		if !fn(node.member, node.score) {
+			return
+		}
+	}
+}
+
+// calls fn for every member in order until fn returns false, scoreRange
+// leaves out the members scored +inf
+func (z *SortedSet) each(fn func(member string, score float64) bool) {
+	for node := z.zsl.header.level[0].forward; node != nil; node = node.level[0].forward {
+		if !fn(node.member, node.score) {
+			return
+		}
+	}
+}
+
+// formats a score the way redis replies with doubles
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	}
+
+	abs := math.Abs(score)
+	if abs != 0 && (abs < 1e-4 || abs >= 1e17) {
+		return strconv.FormatFloat(score, 'g', -1, 64)
+	}
+
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
+
+// parses a score, NaN is not a valid score
+func parseScore(arg string) (float64, bool) {
+	score, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, false
+	}
+
+	return score, true
+}
+
+// options of ZADD, shared with GEOADD
+type zaddFlags struct {
+	nx, xx, gt, lt, ch, incr bool
+}
+
+// parses the leading ZADD options, returns the index of the first score
+func parseZaddFlags(args []Value, allowed string) (zaddFlags, int) {
+	flags := zaddFlags{}
+
+	i := 0
+	for ; i < len(args); i++ {
+		option := strings.ToUpper(args[i].bulk)
+		if !strings.Contains(" "+allowed+" ", " "+option+" ") {
+			break
+		}
+
+		switch option {
+		case "NX":
+			flags.nx = true
+		case "XX":
+			flags.xx = true
+		case "GT":
+			flags.gt = true
+		case "LT":
+			flags.lt = true
+		case "CH":
+			flags.ch = true
+		case "INCR":
+			flags.incr = true
+		}
+	}
+
+	return flags, i
+}
+
+// validates option combinations of ZADD and GEOADD
+func (f zaddFlags) validate() string {
+	if f.nx && f.xx {
+		return "ERR XX and NX options at the same time are not compatible"
+	}
+
+	if (f.gt && f.nx) || (f.lt && f.nx) || (f.gt && f.lt) {
+		return "ERR GT, LT, and/or NX options at the same time are not compatible"
+	}
+
+	return ""
+}
+
+// applies scores to members according to the flags, returns the added and changed counts,
+// for INCR also the new score and whether the update went through, caller must hold the ZSETsMu write lock
+func zaddGeneric(key string, flags zaddFlags, scores []float64, members []string) (int, int, float64, bool) {
+	zset, ok := ZSETs[key]
+	if !ok {
+		if flags.xx {
+			return 0, 0, 0, false
+		}
+		zset = newSortedSet()
+		ZSETs[key] = zset
+		keyspaceAdd(key, keyZset)
+	}
+
+	added, changed := 0, 0
+	var result float64
+	updated := false
+
+	for i, member := range members {
+		score := scores[i]
+		current, exists := zset.score(member)
+
+		if (flags.nx && exists) || (flags.xx && !exists) {
+			continue
+		}
+
+		if flags.incr && exists {
+			score += current
+			if math.IsNaN(score) {
+				continue
+			}
+		}
+
+		if exists && ((flags.gt && score <= current) || (flags.lt && score >= current)) {
+			continue
+		}
+
+		if zset.set(member, score) {
+			added++
+		} else if exists && current != score {
+			changed++
+		}
+		result = score
+		updated = true
+	}
+
+	// an XX update that touched nothing must not leave an empty set behind
+	if zset.length() == 0 {
+		delete(ZSETs, key)
+		keyspaceRemove(key, keyZset)
+	}
+
+	if updated && flags.incr {
+		notifyKeyspaceEvent(notifyZset, "zincr", key)
+	} else if updated {
+		notifyKeyspaceEvent(notifyZset, "zadd", key)
+	}
+
+	return added, changed, result, updated
+}
+
+// ZADD command
+func zadd(args []Value) Value {
+	key := args[0].bulk
+	flags, start := parseZaddFlags(args[1:], "NX XX GT LT CH INCR")
+	pairs := args[1+start:]
+
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return Value{typ: "error", str: "ERR syntax error"}
+	}
+
+	if errMsg := flags.validate(); errMsg != "" {
+		return Value{typ: "error", str: errMsg}
+	}
+
+	if flags.incr && len(pairs) != 2 {
+		return Value{typ: "error", str: "ERR INCR option supports a single increment-element pair"}
+	}
+
+	scores := make([]float64, 0, len(pairs)/2)
+	members := make([]string, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		score, ok := parseScore(pairs[i].bulk)
+		if !ok {
+			return Value{typ: "error", str: "ERR value is not a valid float"}
+		}
+		scores = append(scores, score)
+		members = append(members, pairs[i+1].bulk)
+	}
+
+	ZSETsMu.Lock()
+	added, changed, result, updated := zaddGeneric(key, flags, scores, members)
+	ZSETsMu.Unlock()
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: ZADD %s (%d members)", key, len(members)))
+
+	if flags.incr {
+		if !updated {
+			return Value{typ: "null"}
+		}
+		return Value{typ: "bulk", bulk: formatScore(result)}
+	}
+
+	if flags.ch {
+		return Value{typ: "integer", num: added + changed}
+	}
+
+	return Value{typ: "integer", num: added}
+}
+
+// ZREM command: removes members, the key is removed once empty
+func zrem(args []Value) Value {
+	key := args[0].bulk
+	removed := 0
+
+	ZSETsMu.Lock()
+	if zset, ok := ZSETs[key]; ok {
+		for _, arg := range args[1:] {
+			if zset.remove(arg.bulk) {
+				removed++
+			}
+		}
+
+		if removed > 0 {
+			notifyKeyspaceEvent(notifyZset, "zrem", key)
+		}
+
+		if zset.length() == 0 {
+			delete(ZSETs, key)
+			keyspaceRemove(key, keyZset)
+			notifyKeyspaceEvent(notifyGeneric, "del", key)
+		}
+	}
+	ZSETsMu.Unlock()
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: ZREM %s (%d removed)", key, removed))
+
+	return Value{typ: "integer", num: removed}
+}
+
+// ZSCORE command
+func zscore(args []Value) Value {
+	key := args[0].bulk
+	member := args[1].bulk
+
+	ZSETsMu.RLock()
+	var score float64
+	ok := false
+	if zset, exists := ZSETs[key]; exists {
+		score, ok = zset.score(member)
+	}
+	ZSETsMu.RUnlock()
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: ZSCORE %s %s", key, member))
+
+	if !ok {
+		return Value{typ: "null"}
+	}
+
+	return Value{typ: "bulk", bulk: formatScore(score)}
+}
+
+// ZCARD command
+func zcard(args []Value) Value {
+	key := args[0].bulk
+
+	ZSETsMu.RLock()
+	length := 0
+	if zset, ok := ZSETs[key]; ok {
+		length = zset.length()
+	}
+	ZSETsMu.RUnlock()
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: ZCARD %s", key))
+
+	return Value{typ: "integer", num: length}
+}
+
+// ZRANGE command: members by rank, optionally reversed and with scores
+func zrange(args []Value) Value {
+	key := args[0].bulk
+
+	start, err1 := strconv.Atoi(args[1].bulk)
+	stop, err2 := strconv.Atoi(args[2].bulk)
+	if err1 != nil || err2 != nil {
+		return Value{typ: "error", str: "ERR value is not an integer or out of range"}
+	}
+
+	reverse, withScores := false, false
+	for _, arg := range args[3:] {
+		switch strings.ToUpper(arg.bulk) {
+		case "REV":
+			reverse = true
+		case "WITHSCORES":
+			withScores = true
+		default:
+			return Value{typ: "error", str: "ERR syntax error"}
+		}
+	}
+
+	ZSETsMu.RLock()
+	defer ZSETsMu.RUnlock()
+
+	// debug
+	logger.Debug(fmt.Sprintf("command executed: ZRANGE %s %d %d", key, start, stop))
+
+	zset, ok := ZSETs[key]
+	if !ok {
+		return Value{typ: "array", array: []Value{}}
+	}
+
+	from, to, ok := normalizeRange(int64(start), int64(stop), int64(zset.length()))
+	if !ok {
+		return Value{typ: "array", array: []Value{}}
+	}
+
+	values := []Value{}
+
+	// walk from the rank of the first element in the requested direction
+	rank := int(from) + 1
+	if reverse {
+		rank = zset.length() - int(from)
+	}
+
+	node := zset.zsl.byRank(rank)
+	for i := from; i <= to && node != nil; i++ {
+		values = append(values, Value{typ: "bulk", bulk: node.member})
+		if withScores {
+			values = append(values, Value{typ: "bulk", bulk: formatScore(node.score)})
+		}
+
+		if reverse {
+			node = node.backward
+		} else {
+			node = node.level[0].forward
+		}
+	}
+
+	return Value{typ: "array", array: values}
+}
@@ -0,0 +1,61 @@
+// example extension module: shows how a Go package adds commands to the server,
+// linked in with a blank import in cmd/server
+package example
+
+import (
+	"blueberrydb/pkg/blueberrydb"
+	"fmt"
+)
+
+func init() {
+	for _, cmd := range []blueberrydb.Command{
+		{
+			Name:     "EXAMPLE.SWAP",
+			Arity:    3,
+			Flags:    []string{"write"},
+			FirstKey: 1,
+			LastKey:  2,
+			KeyStep:  1,
+			Module:   "example",
+			Summary:  "Exchanges the values of two string keys.",
+			Handler:  swap,
+		},
+		{
+			Name:    "EXAMPLE.WHOAMI",
+			Arity:   1,
+			Flags:   []string{"readonly", "fast"},
+			Module:  "example",
+			Summary: "Returns the id and address of the connection.",
+			Handler: whoami,
+		},
+	} {
+		if err := blueberrydb.RegisterCommand(cmd); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// EXAMPLE.SWAP key1 key2: exchanges the values of two string keys
+func swap(ctx *blueberrydb.CommandContext) blueberrydb.Value {
+	keys := ctx.Keys()
+
+	first, second := ctx.Call("GET", keys[0]), ctx.Call("GET", keys[1])
+	if first.GetType() != "bulk" || second.GetType() != "bulk" {
+		return *blueberrydb.NewValue("error", "ERR both keys must hold a string", 0, "", nil)
+	}
+
+	ctx.Call("SET", keys[0], second.GetBulk())
+	ctx.Call("SET", keys[1], first.GetBulk())
+
+	return *blueberrydb.NewValue("string", "OK", 0, "", nil)
+}
+
+// EXAMPLE.WHOAMI: the id and address of the calling connection
+func whoami(ctx *blueberrydb.CommandContext) blueberrydb.Value {
+	client, ok := ctx.Client()
+	if !ok {
+		return *blueberrydb.NewValue("null", "", 0, "", nil)
+	}
+
+	return *blueberrydb.NewValue("bulk", "", 0, fmt.Sprintf("id=%d addr=%s", client.ID, client.Addr), nil)
+}
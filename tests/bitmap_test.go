@@ -0,0 +1,133 @@
+// tests for bitmap commands
+package tests
+
+import (
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetbitGetbit(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("DEL", "dau")
+
+	previous, err := redis.Int(c.Do("SETBIT", "dau", "7", "1"))
+	if err != nil {
+		t.Fatalf("failed to setbit: %v", err)
+	}
+	assert.Equal(t, 0, previous)
+
+	bit, _ := redis.Int(c.Do("GETBIT", "dau", "7"))
+	assert.Equal(t, 1, bit)
+
+	// setting a bit beyond the end extends the string with zero bytes
+	c.Do("SETBIT", "dau", "23", "1")
+	value, _ := redis.String(c.Do("GET", "dau"))
+	assert.Equal(t, "\x01\x00\x01", value)
+
+	bit, _ = redis.Int(c.Do("GETBIT", "dau", "1000"))
+	assert.Equal(t, 0, bit)
+}
+
+func TestBitcountBitpos(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("SET", "bits_foobar", "foobar")
+
+	count, err := redis.Int(c.Do("BITCOUNT", "bits_foobar"))
+	if err != nil {
+		t.Fatalf("failed to bitcount: %v", err)
+	}
+	assert.Equal(t, 26, count)
+
+	count, _ = redis.Int(c.Do("BITCOUNT", "bits_foobar", "1", "1", "BYTE"))
+	assert.Equal(t, 6, count)
+
+	count, _ = redis.Int(c.Do("BITCOUNT", "bits_foobar", "5", "30", "BIT"))
+	assert.Equal(t, 17, count)
+
+	c.Do("SET", "bits_pos", "\xff\xf0\x00")
+	pos, _ := redis.Int(c.Do("BITPOS", "bits_pos", "0"))
+	assert.Equal(t, 12, pos)
+
+	c.Do("SET", "bits_pos", "\x00\xff\xf0")
+	pos, _ = redis.Int(c.Do("BITPOS", "bits_pos", "1", "2", "-1", "BYTE"))
+	assert.Equal(t, 16, pos)
+
+	pos, _ = redis.Int(c.Do("BITPOS", "bits_pos", "1", "7", "15", "BIT"))
+	assert.Equal(t, 8, pos)
+
+	// no clear bit inside an explicit range
+	c.Do("SET", "bits_pos", "\xff\xff")
+	pos, _ = redis.Int(c.Do("BITPOS", "bits_pos", "0", "0", "1"))
+	assert.Equal(t, -1, pos)
+}
+
+func TestBitop(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("SET", "bitop_a", "foobar")
+	c.Do("SET", "bitop_b", "abcdef")
+
+	length, err := redis.Int(c.Do("BITOP", "AND", "bitop_dest", "bitop_a", "bitop_b"))
+	if err != nil {
+		t.Fatalf("failed to bitop: %v", err)
+	}
+	assert.Equal(t, 6, length)
+
+	value, _ := redis.String(c.Do("GET", "bitop_dest"))
+	assert.Equal(t, "`bc`ab", value)
+
+	c.Do("BITOP", "NOT", "bitop_dest", "bitop_a")
+	value, _ = redis.String(c.Do("GET", "bitop_dest"))
+	assert.Equal(t, "\x99\x90\x90\x9d\x9e\x8d", value)
+}
+
+func TestBitfield(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("DEL", "bitfield_key")
+
+	values, err := redis.Ints(c.Do("BITFIELD", "bitfield_key", "INCRBY", "i5", "100", "1", "GET", "u4", "0"))
+	if err != nil {
+		t.Fatalf("failed to bitfield: %v", err)
+	}
+	assert.Equal(t, []int{1, 0}, values)
+
+	// WRAP on the first field, SAT on the second
+	c.Do("DEL", "bitfield_overflow")
+	expected := [][]int{{1, 1}, {2, 2}, {3, 3}, {0, 3}}
+	for _, want := range expected {
+		values, _ = redis.Ints(c.Do("BITFIELD", "bitfield_overflow", "INCRBY", "u2", "102", "1", "OVERFLOW", "SAT", "INCRBY", "u2", "104", "1"))
+		assert.Equal(t, want, values)
+	}
+
+	// FAIL returns null and leaves the field untouched
+	reply, _ := redis.Values(c.Do("BITFIELD", "bitfield_overflow", "OVERFLOW", "FAIL", "INCRBY", "u2", "104", "1"))
+	assert.Equal(t, []interface{}{nil}, reply)
+
+	// signed fields use two's complement
+	values, _ = redis.Ints(c.Do("BITFIELD", "bitfield_key", "SET", "i8", "#2", "-100", "GET", "i8", "#2"))
+	assert.Equal(t, []int{0, -100}, values)
+
+	_, err = c.Do("BITFIELD_RO", "bitfield_key", "SET", "i8", "0", "1")
+	assert.Error(t, err, "Expected BITFIELD_RO to reject SET")
+}
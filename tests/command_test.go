@@ -0,0 +1,100 @@
+// tests for COMMAND and arity checks
+package tests
+
+import (
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandInfo(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	count, _ := redis.Int(c.Do("COMMAND", "COUNT"))
+	all, _ := redis.Values(c.Do("COMMAND"))
+	assert.Greater(t, count, 90)
+	assert.Len(t, all, count)
+
+	infos, _ := redis.Values(c.Do("COMMAND", "INFO", "get", "nosuchcommand"))
+	assert.Len(t, infos, 2)
+	assert.Nil(t, infos[1])
+
+	get, _ := redis.Values(infos[0], nil)
+	assert.Equal(t, []byte("get"), get[0])
+	assert.Equal(t, int64(2), get[1])
+	flags, _ := redis.Strings(get[2], nil)
+	assert.Equal(t, []string{"readonly", "fast"}, flags)
+	assert.Equal(t, []interface{}{int64(1), int64(1), int64(1)}, get[3:6])
+	categories, _ := redis.Strings(get[6], nil)
+	assert.Contains(t, categories, "@read")
+	assert.Contains(t, categories, "@string")
+
+	docs, _ := redis.Values(c.Do("COMMAND", "DOCS", "hset"))
+	assert.Equal(t, []byte("hset"), docs[0])
+	fields, _ := redis.StringMap(docs[1], nil)
+	assert.Equal(t, "hash", fields["group"])
+	assert.NotEmpty(t, fields["summary"])
+}
+
+func TestCommandListAndGetKeys(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	names, _ := redis.Strings(c.Do("COMMAND", "LIST", "FILTERBY", "PATTERN", "pf*"))
+	assert.Equal(t, []string{"pfadd", "pfcount", "pfdebug", "pfmerge"}, names)
+
+	names, _ = redis.Strings(c.Do("COMMAND", "LIST", "FILTERBY", "ACLCAT", "scripting"))
+	assert.Contains(t, names, "eval")
+	assert.Contains(t, names, "fcall")
+
+	names, _ = redis.Strings(c.Do("COMMAND", "LIST", "FILTERBY", "MODULE", "example"))
+	assert.Equal(t, []string{"example.swap", "example.whoami"}, names)
+
+	keys, _ := redis.Strings(c.Do("COMMAND", "GETKEYS", "BITOP", "AND", "dest", "src1", "src2"))
+	assert.Equal(t, []string{"dest", "src1", "src2"}, keys)
+
+	// keys that move with the arguments
+	keys, _ = redis.Strings(c.Do("COMMAND", "GETKEYS", "EVAL", "return 1", "2", "k1", "k2", "arg"))
+	assert.Equal(t, []string{"k1", "k2"}, keys)
+
+	keys, _ = redis.Strings(c.Do("COMMAND", "GETKEYS", "XREAD", "COUNT", "1", "STREAMS", "s1", "s2", "0", "0"))
+	assert.Equal(t, []string{"s1", "s2"}, keys)
+
+	_, err = c.Do("COMMAND", "GETKEYS", "PING")
+	assert.ErrorContains(t, err, "no key arguments")
+
+	_, err = c.Do("COMMAND", "GETKEYS", "NOSUCHCOMMAND", "key")
+	assert.ErrorContains(t, err, "Invalid command specified")
+}
+
+func TestArity(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	_, err = c.Do("GET")
+	assert.ErrorContains(t, err, "wrong number of arguments for 'get' command")
+
+	_, err = c.Do("HGET", "arity:hash")
+	assert.ErrorContains(t, err, "wrong number of arguments for 'hget' command")
+
+	// rejected while queueing, EXEC then discards the transaction
+	c.Do("MULTI")
+	_, err = c.Do("SET", "arity:key")
+	assert.ErrorContains(t, err, "wrong number of arguments for 'set' command")
+	_, err = c.Do("EXEC")
+	assert.ErrorContains(t, err, "EXECABORT")
+
+	_, err = c.Do("EVAL", "return redis.call('INCR')", "0")
+	assert.ErrorContains(t, err, "wrong number of arguments for 'incr' command")
+}
@@ -87,10 +87,15 @@ func TestExpire(t *testing.T) {
 	}
 
 	// set an expiration of 3 seconds
-	_, err = c.Do("EXPIRE", "key_1", "3")
+	set, err := redis.Int(c.Do("EXPIRE", "key_1", "3"))
 	if err != nil {
 		t.Fatalf("failed to expire key: %v", err)
 	}
+	assert.Equal(t, 1, set)
+
+	// a missing key has no expire to set
+	set, _ = redis.Int(c.Do("EXPIRE", "key_missing", "3"))
+	assert.Equal(t, 0, set)
 
 	// sleep for 4 seconds and check for key
 	time.Sleep(time.Second * 4)
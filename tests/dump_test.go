@@ -0,0 +1,143 @@
+// tests for DUMP and RESTORE
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDumpRestore(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("SELECT", 13)
+	c.Do("FLUSHDB")
+	c.Do("SET", "dump:string", "hello")
+	c.Do("SET", "dump:int", 12345)
+	c.Do("HSET", "dump:hash", "a", "1", "b", "two")
+	c.Do("ZADD", "dump:zset", 1.5, "one", -2, "two", "+inf", "top")
+	c.Do("XADD", "dump:stream", "1-1", "field", "value")
+	c.Do("XADD", "dump:stream", "1-2", "field", "other")
+	c.Do("XADD", "dump:stream", "2-1", "name", "x", "age", "40")
+	c.Do("XDEL", "dump:stream", "1-2")
+	c.Do("XGROUP", "CREATE", "dump:stream", "group", "0")
+	c.Do("XREADGROUP", "GROUP", "group", "alice", "COUNT", 1, "STREAMS", "dump:stream", ">")
+
+	for _, key := range []string{"dump:string", "dump:int", "dump:hash", "dump:zset", "dump:stream"} {
+		payload, err := redis.Bytes(c.Do("DUMP", key))
+		assert.NoError(t, err, key)
+
+		ok, err := redis.String(c.Do("RESTORE", key+":copy", 0, payload))
+		assert.NoError(t, err, key)
+		assert.Equal(t, "OK", ok, key)
+	}
+
+	value, _ := redis.String(c.Do("GET", "dump:string:copy"))
+	assert.Equal(t, "hello", value)
+	encoding, _ := redis.String(c.Do("OBJECT", "ENCODING", "dump:int:copy"))
+	assert.Equal(t, "int", encoding)
+	hash, _ := redis.StringMap(c.Do("HGETALL", "dump:hash:copy"))
+	assert.Equal(t, map[string]string{"a": "1", "b": "two"}, hash)
+	zset, _ := redis.Strings(c.Do("ZRANGE", "dump:zset:copy", 0, -1, "WITHSCORES"))
+	assert.Equal(t, []string{"two", "-2", "one", "1.5", "top", "inf"}, zset)
+
+	entries, _ := redis.Values(c.Do("XRANGE", "dump:stream:copy", "-", "+"))
+	assert.Len(t, entries, 2)
+	pending, _ := redis.Values(c.Do("XPENDING", "dump:stream:copy", "group"))
+	assert.Equal(t, int64(1), pending[0])
+	read, _ := redis.Values(c.Do("XREADGROUP", "GROUP", "group", "bob", "STREAMS", "dump:stream:copy", ">"))
+	assert.Len(t, read, 1)
+
+	// the target has to be missing unless REPLACE is given
+	payload, _ := redis.Bytes(c.Do("DUMP", "dump:zset"))
+	_, err = c.Do("RESTORE", "dump:string", 0, payload)
+	assert.EqualError(t, err, "BUSYKEY Target key name already exists.")
+	ok, _ := redis.String(c.Do("RESTORE", "dump:string", 0, payload, "REPLACE"))
+	assert.Equal(t, "OK", ok)
+	typ, _ := redis.String(c.Do("TYPE", "dump:string"))
+	assert.Equal(t, "zset", typ)
+
+	missing, err := c.Do("DUMP", "dump:missing")
+	assert.NoError(t, err)
+	assert.Nil(t, missing)
+}
+
+func TestRestorePayloadChecks(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("SELECT", 13)
+	c.Do("DEL", "restore:redis")
+	c.Do("DEL", "restore:corrupt")
+
+	// a payload dumped by redis for the integer 10
+	ok, err := redis.String(c.Do("RESTORE", "restore:redis", 0, "\x00\xc0\n\t\x00\xbem\x06\x89Z(\x00\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "OK", ok)
+	value, _ := redis.String(c.Do("GET", "restore:redis"))
+	assert.Equal(t, "10", value)
+
+	c.Do("SET", "restore:source", "some value")
+	payload, _ := redis.Bytes(c.Do("DUMP", "restore:source"))
+
+	corrupt := append([]byte{}, payload...)
+	corrupt[3] ^= 0xff
+	_, err = c.Do("RESTORE", "restore:corrupt", 0, corrupt)
+	assert.EqualError(t, err, "ERR DUMP payload version or checksum are wrong")
+
+	_, err = c.Do("RESTORE", "restore:corrupt", 0, payload[:len(payload)-1])
+	assert.EqualError(t, err, "ERR DUMP payload version or checksum are wrong")
+
+	_, err = c.Do("RESTORE", "restore:corrupt", 0, "short")
+	assert.EqualError(t, err, "ERR DUMP payload version or checksum are wrong")
+
+	typ, _ := redis.String(c.Do("TYPE", "restore:corrupt"))
+	assert.Equal(t, "none", typ)
+}
+
+func TestRestoreOptions(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("SELECT", 13)
+	c.Do("SET", "options:source", "value")
+	payload, _ := redis.Bytes(c.Do("DUMP", "options:source"))
+
+	// a deadline in the past restores nothing
+	past := time.Now().Add(-time.Minute).UnixMilli()
+	ok, _ := redis.String(c.Do("RESTORE", "options:expired", past, payload, "ABSTTL", "REPLACE"))
+	assert.Equal(t, "OK", ok)
+	typ, _ := redis.String(c.Do("TYPE", "options:expired"))
+	assert.Equal(t, "none", typ)
+
+	c.Do("RESTORE", "options:ttl", 60000, payload, "REPLACE")
+	value, _ := redis.String(c.Do("GET", "options:ttl"))
+	assert.Equal(t, "value", value)
+
+	c.Do("RESTORE", "options:idle", 0, payload, "REPLACE", "IDLETIME", 1000)
+	idle, _ := redis.Int(c.Do("OBJECT", "IDLETIME", "options:idle"))
+	assert.GreaterOrEqual(t, idle, 1000)
+
+	c.Do("RESTORE", "options:freq", 0, payload, "REPLACE", "FREQ", 100)
+	freq, _ := redis.Int(c.Do("OBJECT", "FREQ", "options:freq"))
+	assert.Equal(t, 100, freq)
+
+	_, err = c.Do("RESTORE", "options:bad", -1, payload)
+	assert.EqualError(t, err, "ERR Invalid TTL value, must be >= 0")
+	_, err = c.Do("RESTORE", "options:bad", 0, payload, "FREQ", 256)
+	assert.EqualError(t, err, "ERR Invalid FREQ value, must be >= 0 and <= 255")
+	_, err = c.Do("RESTORE", "options:bad", 0, payload, "IDLETIME", 1, "FREQ", 1)
+	assert.EqualError(t, err, "ERR syntax error")
+}
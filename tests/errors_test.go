@@ -0,0 +1,183 @@
+// tests for error replies of unknown commands, wrong arity and wrong types, and
+// for the commands that replace a key of any type
+package tests
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnknownCommand(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	_, err = c.Do("NOSUCHCMD", "a", "b c")
+	assert.EqualError(t, err, "ERR unknown command 'NOSUCHCMD', with args beginning with: 'a' 'b c' ")
+
+	_, err = c.Do("nosuchcmd")
+	assert.EqualError(t, err, "ERR unknown command 'nosuchcmd', with args beginning with: ")
+
+	// the connection is still usable afterwards
+	pong, _ := redis.String(c.Do("PING"))
+	assert.Equal(t, "PONG", pong)
+}
+
+func TestWrongType(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("SET", "errors:string", "value")
+	c.Do("HSET", "errors:hash", "field", "value")
+
+	_, err = c.Do("HSET", "errors:string", "field", "value")
+	assert.EqualError(t, err, "WRONGTYPE Operation against a key holding the wrong kind of value")
+	_, err = c.Do("ZADD", "errors:hash", 1, "member")
+	assert.EqualError(t, err, "WRONGTYPE Operation against a key holding the wrong kind of value")
+	_, err = c.Do("GET", "errors:hash")
+	assert.EqualError(t, err, "WRONGTYPE Operation against a key holding the wrong kind of value")
+
+	// nothing was written to the other types
+	exists, _ := redis.Int(c.Do("HEXISTS", "errors:hash", "field"))
+	assert.Equal(t, 1, exists)
+	value, _ := redis.String(c.Do("GET", "errors:string"))
+	assert.Equal(t, "value", value)
+
+	c.Do("DEL", "errors:string")
+	c.Do("HDEL", "errors:hash", "field")
+}
+
+func TestOverwriteOtherTypes(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("SELECT", 8)
+	c.Do("FLUSHDB")
+
+	// SET replaces whatever the key held
+	c.Do("HSET", "overwrite", "field", "value")
+	c.Do("SET", "overwrite", "string")
+	typ, _ := redis.String(c.Do("TYPE", "overwrite"))
+	assert.Equal(t, "string", typ)
+	size, _ := redis.Int(c.Do("DBSIZE"))
+	assert.Equal(t, 1, size)
+	_, err = c.Do("HGET", "overwrite", "field")
+	assert.EqualError(t, err, "WRONGTYPE Operation against a key holding the wrong kind of value")
+
+	// so does BITOP, and an empty result removes the destination
+	c.Do("SET", "overwrite:src", "\xff")
+	c.Do("DEL", "overwrite")
+	c.Do("ZADD", "overwrite", 1, "member")
+	c.Do("BITOP", "NOT", "overwrite", "overwrite:src")
+	typ, _ = redis.String(c.Do("TYPE", "overwrite"))
+	assert.Equal(t, "string", typ)
+	_, err = c.Do("ZSCORE", "overwrite", "member")
+	assert.EqualError(t, err, "WRONGTYPE Operation against a key holding the wrong kind of value")
+
+	c.Do("HSET", "overwrite:empty", "field", "value")
+	length, _ := redis.Int(c.Do("BITOP", "AND", "overwrite:empty", "overwrite:missing"))
+	assert.Equal(t, 0, length)
+	typ, _ = redis.String(c.Do("TYPE", "overwrite:empty"))
+	assert.Equal(t, "none", typ)
+
+	// and GEOSEARCHSTORE
+	c.Do("GEOADD", "overwrite:geo", "13.361389", "38.115556", "Palermo")
+	c.Do("HSET", "overwrite:near", "field", "value")
+	stored, _ := redis.Int(c.Do("GEOSEARCHSTORE", "overwrite:near", "overwrite:geo", "FROMLONLAT", "13", "38", "BYRADIUS", "100", "km"))
+	assert.Equal(t, 1, stored)
+	typ, _ = redis.String(c.Do("TYPE", "overwrite:near"))
+	assert.Equal(t, "zset", typ)
+	_, err = c.Do("HGET", "overwrite:near", "field")
+	assert.EqualError(t, err, "WRONGTYPE Operation against a key holding the wrong kind of value")
+
+	size, _ = redis.Int(c.Do("DBSIZE"))
+	assert.Equal(t, 4, size)
+}
+
+func TestWrongTypeConcurrent(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("SELECT", 7)
+	c.Do("FLUSHDB")
+
+	// pairs of clients create the same keys with different types at the same
+	// time, each key ends up with exactly one of them
+	const pairs, keys = 8, 500
+	wg := sync.WaitGroup{}
+	for pair := 0; pair < pairs; pair++ {
+		barriers := make([]sync.WaitGroup, keys)
+		for i := range barriers {
+			barriers[i].Add(2)
+		}
+
+		for _, args := range [][]interface{}{{"HSET", "field", "value"}, {"SET", "value"}} {
+			wg.Add(1)
+			go func(pair int, command string, args []interface{}) {
+				defer wg.Done()
+
+				conn, err := redis.Dial("tcp", ":6379")
+				if err != nil {
+					t.Errorf("failed to connect to database server: %v", err)
+					return
+				}
+				defer conn.Close()
+
+				conn.Do("SELECT", 7)
+				for i := 0; i < keys; i++ {
+					barriers[i].Done()
+					barriers[i].Wait()
+					conn.Do(command, append([]interface{}{fmt.Sprintf("race:%d:%d", pair, i)}, args...)...)
+				}
+			}(pair, args[0].(string), args[1:])
+		}
+	}
+	wg.Wait()
+
+	size, _ := redis.Int(c.Do("DBSIZE"))
+	assert.Equal(t, pairs*keys, size)
+	for pair := 0; pair < pairs; pair++ {
+		for i := 0; i < keys; i++ {
+			key := fmt.Sprintf("race:%d:%d", pair, i)
+			typ, _ := redis.String(c.Do("TYPE", key))
+			if typ == "string" {
+				_, err = c.Do("HGET", key, "field")
+			} else {
+				_, err = c.Do("GET", key)
+			}
+			assert.EqualError(t, err, "WRONGTYPE Operation against a key holding the wrong kind of value", key)
+		}
+	}
+}
+
+func TestErrorPrefixes(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	_, err = c.Do("GET")
+	assert.EqualError(t, err, "ERR wrong number of arguments for 'get' command")
+
+	_, err = c.Do("EXPIRE", "errors:key", "soon")
+	assert.EqualError(t, err, "ERR value is not an integer or out of range")
+
+	_, err = c.Do("AUTH", "secret")
+	assert.EqualError(t, err, "ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+}
@@ -0,0 +1,135 @@
+// tests for FUNCTION and FCALL
+package tests
+
+import (
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+const counterLibrary = `#!lua name=counterlib
+local function incrby(keys, args)
+  return redis.call('INCRBY', keys[1], args[1])
+end
+
+redis.register_function('counter_incrby', incrby)
+redis.register_function{
+  function_name = 'counter_get',
+  callback = function(keys) return redis.call('GET', keys[1]) end,
+  flags = {'no-writes'},
+}
+redis.register_function{
+  function_name = 'counter_reset',
+  callback = function(keys) return redis.call('SET', keys[1], '0') end,
+  flags = {'no-writes'},
+}`
+
+func loadCounterLibrary(t *testing.T, c redis.Conn) {
+	name, err := redis.String(c.Do("FUNCTION", "LOAD", "REPLACE", counterLibrary))
+	if err != nil {
+		t.Fatalf("failed to load library: %v", err)
+	}
+	assert.Equal(t, "counterlib", name)
+
+	t.Cleanup(func() {
+		c.Do("FUNCTION", "DELETE", "counterlib")
+	})
+}
+
+func TestFunctionLoad(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	loadCounterLibrary(t, c)
+	c.Do("DEL", "function:counter")
+
+	value, _ := redis.Int(c.Do("FCALL", "counter_incrby", "1", "function:counter", "5"))
+	assert.Equal(t, 5, value)
+
+	_, err = c.Do("FUNCTION", "LOAD", counterLibrary)
+	assert.ErrorContains(t, err, "already exists")
+
+	// function names are shared by all libraries
+	_, err = c.Do("FUNCTION", "LOAD", "#!lua name=otherlib\nredis.register_function('counter_get', function() return 1 end)")
+	assert.ErrorContains(t, err, "Function counter_get already exists")
+
+	_, err = c.Do("FUNCTION", "LOAD", "return 1")
+	assert.ErrorContains(t, err, "Missing library metadata")
+
+	_, err = c.Do("FCALL", "no_such_function", "0")
+	assert.ErrorContains(t, err, "Function not found")
+
+	libraries, _ := redis.Values(c.Do("FUNCTION", "LIST", "LIBRARYNAME", "counter*"))
+	assert.Len(t, libraries, 1)
+
+	ok, _ := redis.String(c.Do("FUNCTION", "DELETE", "counterlib"))
+	assert.Equal(t, "OK", ok)
+
+	_, err = c.Do("FCALL", "counter_incrby", "1", "function:counter", "5")
+	assert.ErrorContains(t, err, "Function not found")
+}
+
+func TestFunctionReadOnly(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	loadCounterLibrary(t, c)
+	c.Do("SET", "function:readonly", "7")
+
+	value, _ := redis.String(c.Do("FCALL_RO", "counter_get", "1", "function:readonly"))
+	assert.Equal(t, "7", value)
+
+	_, err = c.Do("FCALL_RO", "counter_incrby", "1", "function:readonly", "1")
+	assert.ErrorContains(t, err, "write flag")
+
+	// no-writes functions can not write even when called with FCALL
+	_, err = c.Do("FCALL", "counter_reset", "1", "function:readonly")
+	assert.ErrorContains(t, err, "not allowed from read-only scripts")
+
+	value, _ = redis.String(c.Do("GET", "function:readonly"))
+	assert.Equal(t, "7", value)
+}
+
+func TestFunctionDumpRestore(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	loadCounterLibrary(t, c)
+
+	payload, err := redis.Bytes(c.Do("FUNCTION", "DUMP"))
+	if err != nil {
+		t.Fatalf("failed to dump functions: %v", err)
+	}
+
+	_, err = c.Do("FUNCTION", "RESTORE", payload)
+	assert.ErrorContains(t, err, "already exists")
+
+	ok, _ := redis.String(c.Do("FUNCTION", "FLUSH"))
+	assert.Equal(t, "OK", ok)
+
+	libraries, _ := redis.Values(c.Do("FUNCTION", "LIST"))
+	assert.Len(t, libraries, 0)
+
+	ok, _ = redis.String(c.Do("FUNCTION", "RESTORE", payload))
+	assert.Equal(t, "OK", ok)
+
+	ok, _ = redis.String(c.Do("FUNCTION", "RESTORE", payload, "REPLACE"))
+	assert.Equal(t, "OK", ok)
+
+	c.Do("SET", "function:restored", "1")
+	value, _ := redis.Int(c.Do("FCALL", "counter_incrby", "1", "function:restored", "2"))
+	assert.Equal(t, 3, value)
+
+	_, err = c.Do("FUNCTION", "RESTORE", "garbage")
+	assert.ErrorContains(t, err, "payload")
+}
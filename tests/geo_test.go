@@ -0,0 +1,140 @@
+// tests for geospatial commands, fixtures match the redis documentation
+package tests
+
+import (
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+// palermo and catania, plus two points at the edges of a 400km box around 15,37
+func addSicily(t *testing.T, c redis.Conn, key string) {
+	c.Do("DEL", key)
+
+	_, err := c.Do("GEOADD", key,
+		"13.361389", "38.115556", "Palermo",
+		"15.087269", "37.502669", "Catania",
+		"12.758489", "38.788135", "edge1",
+		"17.241510", "38.788135", "edge2")
+	if err != nil {
+		t.Fatalf("failed to geoadd: %v", err)
+	}
+}
+
+func TestGeoaddGeopos(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	addSicily(t, c, "sicily")
+
+	positions, err := redis.Values(c.Do("GEOPOS", "sicily", "Palermo", "missing"))
+	if err != nil {
+		t.Fatalf("failed to geopos: %v", err)
+	}
+	palermo, _ := redis.Float64s(positions[0], nil)
+	assert.InDelta(t, 13.361389, palermo[0], 0.00001)
+	assert.InDelta(t, 38.115556, palermo[1], 0.00001)
+	assert.Nil(t, positions[1])
+
+	// members are stored as 52 bit geohash scores
+	score, _ := redis.Int64(c.Do("ZSCORE", "sicily", "Palermo"))
+	assert.Equal(t, int64(3479099956230698), score)
+
+	_, err = c.Do("GEOADD", "sicily", "13", "86", "pole")
+	assert.Error(t, err, "Expected invalid longitude,latitude pair error")
+}
+
+func TestGeodistGeohash(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	addSicily(t, c, "sicily")
+
+	dist, err := redis.String(c.Do("GEODIST", "sicily", "Palermo", "Catania"))
+	if err != nil {
+		t.Fatalf("failed to geodist: %v", err)
+	}
+	assert.Equal(t, "166274.1516", dist)
+
+	dist, _ = redis.String(c.Do("GEODIST", "sicily", "Palermo", "Catania", "km"))
+	assert.Equal(t, "166.2742", dist)
+
+	reply, _ := c.Do("GEODIST", "sicily", "Palermo", "missing")
+	assert.Nil(t, reply)
+
+	hashes, err := redis.Strings(c.Do("GEOHASH", "sicily", "Palermo", "Catania"))
+	if err != nil {
+		t.Fatalf("failed to geohash: %v", err)
+	}
+	assert.Equal(t, []string{"sqc8b49rny0", "sqdtr74hyu0"}, hashes)
+}
+
+func TestGeosearch(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	addSicily(t, c, "sicily")
+
+	members, err := redis.Strings(c.Do("GEOSEARCH", "sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "ASC"))
+	if err != nil {
+		t.Fatalf("failed to geosearch: %v", err)
+	}
+	assert.Equal(t, []string{"Catania", "Palermo"}, members)
+
+	// the box reaches the edge points that the circle misses
+	results, err := redis.Values(c.Do("GEOSEARCH", "sicily", "FROMLONLAT", "15", "37", "BYBOX", "400", "400", "km", "ASC", "WITHDIST"))
+	if err != nil {
+		t.Fatalf("failed to geosearch by box: %v", err)
+	}
+	expected := [][]string{{"Catania", "56.4413"}, {"Palermo", "190.4424"}, {"edge2", "279.7403"}, {"edge1", "279.7405"}}
+	assert.Len(t, results, len(expected))
+	for i, result := range results {
+		pair, _ := redis.Strings(result, nil)
+		assert.Equal(t, expected[i], pair)
+	}
+
+	members, _ = redis.Strings(c.Do("GEOSEARCH", "sicily", "FROMMEMBER", "Palermo", "BYRADIUS", "200", "km", "DESC", "COUNT", "1"))
+	assert.Equal(t, []string{"Catania"}, members)
+
+	members, _ = redis.Strings(c.Do("GEOSEARCH", "sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "500", "km", "COUNT", "2", "ANY"))
+	assert.Len(t, members, 2)
+
+	_, err = c.Do("GEOSEARCH", "sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "BYBOX", "1", "1", "km")
+	assert.Error(t, err, "Expected only one of BYRADIUS and BYBOX")
+}
+
+func TestGeosearchstore(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	addSicily(t, c, "sicily")
+	c.Do("DEL", "sicily_near")
+
+	stored, err := redis.Int(c.Do("GEOSEARCHSTORE", "sicily_near", "sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "ASC", "STOREDIST"))
+	if err != nil {
+		t.Fatalf("failed to geosearchstore: %v", err)
+	}
+	assert.Equal(t, 2, stored)
+
+	// with STOREDIST the scores are distances in the requested unit
+	results, _ := redis.Strings(c.Do("ZRANGE", "sicily_near", "0", "-1", "WITHSCORES"))
+	assert.Len(t, results, 4)
+	assert.Equal(t, "Catania", results[0])
+	assert.Equal(t, "Palermo", results[2])
+
+	score, _ := redis.Float64(c.Do("ZSCORE", "sicily_near", "Catania"))
+	assert.InDelta(t, 56.4413, score, 0.0001)
+}
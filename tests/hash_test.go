@@ -0,0 +1,173 @@
+// tests for hash commands
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+// sets multiple fields, reads them back and deletes them until the hash is gone
+func TestHashFields(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("HDEL", "profile:1", "name", "city", "age")
+
+	// HSET returns the number of new fields
+	added, err := redis.Int(c.Do("HSET", "profile:1", "name", "alice", "city", "paris"))
+	if err != nil {
+		t.Fatalf("failed to hset key: %v", err)
+	}
+	assert.Equal(t, 2, added, "Expected 2 new fields")
+
+	added, err = redis.Int(c.Do("HSET", "profile:1", "name", "bob", "age", "30"))
+	if err != nil {
+		t.Fatalf("failed to hset key: %v", err)
+	}
+	assert.Equal(t, 1, added, "Expected 1 new field")
+
+	all, err := redis.StringMap(c.Do("HGETALL", "profile:1"))
+	if err != nil {
+		t.Fatalf("failed to hgetall key: %v", err)
+	}
+	assert.Equal(t, map[string]string{"name": "bob", "city": "paris", "age": "30"}, all)
+
+	values, err := redis.Values(c.Do("HMGET", "profile:1", "name", "missing"))
+	if err != nil {
+		t.Fatalf("failed to hmget key: %v", err)
+	}
+	assert.Equal(t, []interface{}{[]byte("bob"), nil}, values)
+
+	length, _ := redis.Int(c.Do("HLEN", "profile:1"))
+	assert.Equal(t, 3, length, "Expected hash length to be 3")
+
+	exists, _ := redis.Int(c.Do("HEXISTS", "profile:1", "city"))
+	assert.Equal(t, 1, exists, "Expected field 'city' to exist")
+
+	strlen, _ := redis.Int(c.Do("HSTRLEN", "profile:1", "city"))
+	assert.Equal(t, 5, strlen, "Expected length of 'paris'")
+
+	// deleting every field removes the hash
+	deleted, err := redis.Int(c.Do("HDEL", "profile:1", "name", "city", "age", "missing"))
+	if err != nil {
+		t.Fatalf("failed to hdel key: %v", err)
+	}
+	assert.Equal(t, 3, deleted, "Expected 3 deleted fields")
+
+	keys, _ := redis.Strings(c.Do("HKEYS", "profile:1"))
+	assert.Empty(t, keys, "Expected hash to be removed")
+}
+
+func TestHashIncrements(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("HDEL", "counters:1", "visits", "score", "name")
+
+	visits, err := redis.Int(c.Do("HINCRBY", "counters:1", "visits", "5"))
+	if err != nil {
+		t.Fatalf("failed to hincrby: %v", err)
+	}
+	assert.Equal(t, 5, visits)
+
+	visits, _ = redis.Int(c.Do("HINCRBY", "counters:1", "visits", "-2"))
+	assert.Equal(t, 3, visits)
+
+	score, err := redis.String(c.Do("HINCRBYFLOAT", "counters:1", "score", "10.5"))
+	if err != nil {
+		t.Fatalf("failed to hincrbyfloat: %v", err)
+	}
+	assert.Equal(t, "10.5", score)
+
+	// incrementing a non numeric value is an error
+	c.Do("HSET", "counters:1", "name", "alice")
+	_, err = c.Do("HINCRBY", "counters:1", "name", "1")
+	assert.Error(t, err, "Expected error for non integer field")
+
+	// HSETNX only sets missing fields
+	set, _ := redis.Int(c.Do("HSETNX", "counters:1", "name", "bob"))
+	assert.Equal(t, 0, set)
+
+	name, _ := redis.String(c.Do("HGET", "counters:1", "name"))
+	assert.Equal(t, "alice", name)
+}
+
+func TestHrandfield(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("HSET", "colors", "red", "1", "green", "2", "blue", "3")
+
+	// positive count returns distinct fields
+	fields, err := redis.Strings(c.Do("HRANDFIELD", "colors", "10"))
+	if err != nil {
+		t.Fatalf("failed to hrandfield: %v", err)
+	}
+	assert.ElementsMatch(t, []string{"red", "green", "blue"}, fields)
+
+	// negative count may repeat fields
+	fields, _ = redis.Strings(c.Do("HRANDFIELD", "colors", "-5"))
+	assert.Len(t, fields, 5)
+
+	pairs, _ := redis.StringMap(c.Do("HRANDFIELD", "colors", "1", "WITHVALUES"))
+	assert.Len(t, pairs, 1)
+}
+
+// sets ttls on single fields of a hash and checks they expire independently
+func TestHashFieldExpire(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("HSET", "devices:1", "phone", "tok1", "laptop", "tok2", "tablet", "tok3")
+
+	codes, err := redis.Ints(c.Do("HEXPIRE", "devices:1", "2", "FIELDS", "2", "phone", "missing"))
+	if err != nil {
+		t.Fatalf("failed to hexpire: %v", err)
+	}
+	assert.Equal(t, []int{1, -2}, codes)
+
+	// NX does not overwrite an existing ttl
+	codes, _ = redis.Ints(c.Do("HPEXPIRE", "devices:1", "50000", "NX", "FIELDS", "1", "phone"))
+	assert.Equal(t, []int{0}, codes)
+
+	ttls, err := redis.Ints(c.Do("HTTL", "devices:1", "FIELDS", "2", "phone", "laptop"))
+	if err != nil {
+		t.Fatalf("failed to httl: %v", err)
+	}
+	assert.Equal(t, []int{2, -1}, ttls)
+
+	// persist removes the ttl again
+	c.Do("HEXPIRE", "devices:1", "100", "FIELDS", "1", "tablet")
+	codes, _ = redis.Ints(c.Do("HPERSIST", "devices:1", "FIELDS", "1", "tablet"))
+	assert.Equal(t, []int{1}, codes)
+
+	// a deadline in the past deletes the field
+	codes, _ = redis.Ints(c.Do("HPEXPIREAT", "devices:1", "1000", "FIELDS", "1", "laptop"))
+	assert.Equal(t, []int{2}, codes)
+
+	time.Sleep(time.Millisecond * 2500)
+
+	reply, err := c.Do("HGET", "devices:1", "phone")
+	if err != nil {
+		t.Fatalf("failed to hget: %v", err)
+	}
+	assert.Equal(t, nil, reply, "Expected field 'phone' to be expired")
+
+	all, _ := redis.StringMap(c.Do("HGETALL", "devices:1"))
+	assert.Equal(t, map[string]string{"tablet": "tok3"}, all)
+}
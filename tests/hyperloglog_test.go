@@ -0,0 +1,150 @@
+// tests for hyperloglog commands
+package tests
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+// adds elements in batches of 1000 with prefix:0, prefix:1, ...
+func pfaddElements(t *testing.T, c redis.Conn, key string, prefix string, count int) {
+	for start := 0; start < count; start += 1000 {
+		args := []interface{}{key}
+		for i := start; i < min(start+1000, count); i++ {
+			args = append(args, fmt.Sprintf("%s:%d", prefix, i))
+		}
+
+		if _, err := c.Do("PFADD", args...); err != nil {
+			t.Fatalf("failed to pfadd: %v", err)
+		}
+	}
+}
+
+func TestPfaddPfcount(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("DEL", "visitors")
+
+	changed, err := redis.Int(c.Do("PFADD", "visitors", "a", "b", "c", "d", "e", "f", "g"))
+	if err != nil {
+		t.Fatalf("failed to pfadd: %v", err)
+	}
+	assert.Equal(t, 1, changed)
+
+	// adding the same elements again does not change any register
+	changed, _ = redis.Int(c.Do("PFADD", "visitors", "a", "b"))
+	assert.Equal(t, 0, changed)
+
+	count, err := redis.Int(c.Do("PFCOUNT", "visitors"))
+	if err != nil {
+		t.Fatalf("failed to pfcount: %v", err)
+	}
+	assert.Equal(t, 7, count)
+
+	encoding, _ := redis.String(c.Do("PFDEBUG", "ENCODING", "visitors"))
+	assert.Equal(t, "sparse", encoding)
+
+	// plain strings are not hyperloglogs
+	c.Do("SET", "not_an_hll", "hello")
+	_, err = c.Do("PFADD", "not_an_hll", "a")
+	assert.Error(t, err, "Expected WRONGTYPE error")
+}
+
+// the estimate stays within the 0.81% standard error of 16384 registers
+func TestPfcountAccuracy(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("DEL", "hll_accuracy")
+
+	const elements = 100000
+	pfaddElements(t, c, "hll_accuracy", "user", elements)
+
+	count, err := redis.Int(c.Do("PFCOUNT", "hll_accuracy"))
+	if err != nil {
+		t.Fatalf("failed to pfcount: %v", err)
+	}
+
+	relativeError := math.Abs(float64(count)-elements) / elements
+	assert.Less(t, relativeError, 0.0081, "Expected estimate %d to be within 0.81%% of %d", count, elements)
+
+	// this many elements no longer fit the sparse encoding
+	encoding, _ := redis.String(c.Do("PFDEBUG", "ENCODING", "hll_accuracy"))
+	assert.Equal(t, "dense", encoding)
+}
+
+func TestPfmerge(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("DEL", "hll_day1")
+	c.Do("DEL", "hll_day2")
+	c.Do("DEL", "hll_week")
+
+	// half of the visitors of the second day also came on the first day
+	pfaddElements(t, c, "hll_day1", "visitor", 20000)
+	pfaddElements(t, c, "hll_day2", "visitor", 10000)
+	pfaddElements(t, c, "hll_day2", "newcomer", 10000)
+
+	union, err := redis.Int(c.Do("PFCOUNT", "hll_day1", "hll_day2"))
+	if err != nil {
+		t.Fatalf("failed to pfcount union: %v", err)
+	}
+	assert.Less(t, math.Abs(float64(union)-30000)/30000, 0.0081)
+
+	reply, err := redis.String(c.Do("PFMERGE", "hll_week", "hll_day1", "hll_day2"))
+	if err != nil {
+		t.Fatalf("failed to pfmerge: %v", err)
+	}
+	assert.Equal(t, "OK", reply)
+
+	merged, _ := redis.Int(c.Do("PFCOUNT", "hll_week"))
+	assert.Equal(t, union, merged)
+}
+
+// a sparse value built byte by byte the way redis lays it out
+func TestHllRedisSparseFormat(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	// header with a stale cardinality cache, then XZERO:100 VAL:3,2 XZERO:16282
+	value := "HYLL\x01\x00\x00\x00" + "\x00\x00\x00\x00\x00\x00\x00\x80" + "\x40\x63" + "\x89" + "\x7f\x99"
+	c.Do("SET", "hll_raw", value)
+
+	decoded, err := redis.String(c.Do("PFDEBUG", "DECODE", "hll_raw"))
+	if err != nil {
+		t.Fatalf("failed to pfdebug decode: %v", err)
+	}
+	assert.Equal(t, "XZ:100 v:3,2 XZ:16282", decoded)
+
+	count, _ := redis.Int(c.Do("PFCOUNT", "hll_raw"))
+	assert.Equal(t, 2, count)
+
+	registers, err := redis.Ints(c.Do("PFDEBUG", "GETREG", "hll_raw"))
+	if err != nil {
+		t.Fatalf("failed to pfdebug getreg: %v", err)
+	}
+	assert.Len(t, registers, 16384)
+	assert.Equal(t, []int{0, 3, 3, 0}, registers[99:103])
+
+	// GETREG promoted the value to the dense encoding
+	encoding, _ := redis.String(c.Do("PFDEBUG", "ENCODING", "hll_raw"))
+	assert.Equal(t, "dense", encoding)
+}
@@ -0,0 +1,175 @@
+// tests for generic key commands: DEL, TYPE, RENAME, COPY, RANDOMKEY, TOUCH and OBJECT
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTypeAndRename(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("SELECT", 15)
+	c.Do("FLUSHDB")
+	c.Do("SET", "rename:string", "value")
+	c.Do("EXPIRE", "rename:string", 100)
+	c.Do("HSET", "rename:hash", "field", "value")
+	c.Do("XADD", "rename:stream", "*", "field", "value")
+
+	for key, typ := range map[string]string{"rename:string": "string", "rename:hash": "hash", "rename:stream": "stream", "rename:missing": "none"} {
+		got, _ := redis.String(c.Do("TYPE", key))
+		assert.Equal(t, typ, got, key)
+	}
+
+	// the destination is replaced whatever it held, the expiration moves along
+	ok, _ := redis.String(c.Do("RENAME", "rename:string", "rename:hash"))
+	assert.Equal(t, "OK", ok)
+	value, _ := redis.String(c.Do("GET", "rename:hash"))
+	assert.Equal(t, "value", value)
+	typ, _ := redis.String(c.Do("TYPE", "rename:string"))
+	assert.Equal(t, "none", typ)
+	keys, _ := redis.Strings(c.Do("KEYS", "rename:*"))
+	assert.ElementsMatch(t, []string{"rename:hash", "rename:stream"}, keys)
+
+	_, err = c.Do("RENAME", "rename:missing", "rename:other")
+	assert.EqualError(t, err, "ERR no such key")
+
+	renamed, _ := redis.Int(c.Do("RENAMENX", "rename:stream", "rename:hash"))
+	assert.Equal(t, 0, renamed)
+	renamed, _ = redis.Int(c.Do("RENAMENX", "rename:stream", "rename:log"))
+	assert.Equal(t, 1, renamed)
+	length, _ := redis.Int(c.Do("XLEN", "rename:log"))
+	assert.Equal(t, 1, length)
+}
+
+func TestCopy(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("SELECT", 14)
+	c.Do("DEL", "copy:hash")
+	c.Do("SELECT", 15)
+	c.Do("FLUSHDB")
+	c.Do("HSET", "copy:hash", "a", "1", "b", "2")
+	c.Do("ZADD", "copy:zset", 1, "one")
+
+	copied, _ := redis.Int(c.Do("COPY", "copy:hash", "copy:hash2"))
+	assert.Equal(t, 1, copied)
+
+	// the copy is independent of the source
+	c.Do("HSET", "copy:hash2", "a", "changed")
+	value, _ := redis.String(c.Do("HGET", "copy:hash", "a"))
+	assert.Equal(t, "1", value)
+
+	copied, _ = redis.Int(c.Do("COPY", "copy:zset", "copy:hash2"))
+	assert.Equal(t, 0, copied)
+	copied, _ = redis.Int(c.Do("COPY", "copy:zset", "copy:hash2", "REPLACE"))
+	assert.Equal(t, 1, copied)
+	card, _ := redis.Int(c.Do("ZCARD", "copy:hash2"))
+	assert.Equal(t, 1, card)
+
+	copied, _ = redis.Int(c.Do("COPY", "copy:hash", "copy:hash", "DB", 14))
+	assert.Equal(t, 1, copied)
+	c.Do("SELECT", 14)
+	fields, _ := redis.StringMap(c.Do("HGETALL", "copy:hash"))
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, fields)
+
+	_, err = c.Do("COPY", "copy:hash", "copy:hash")
+	assert.EqualError(t, err, "ERR source and destination objects are the same")
+}
+
+func TestObjectAndTouch(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("SELECT", 15)
+	c.Do("FLUSHDB")
+
+	key, err := c.Do("RANDOMKEY")
+	assert.NoError(t, err)
+	assert.Nil(t, key)
+
+	c.Do("SET", "object:int", "12345")
+	c.Do("SET", "object:raw", "hello")
+	c.Do("HSET", "object:hash", "field", "value")
+
+	encoding, _ := redis.String(c.Do("OBJECT", "ENCODING", "object:int"))
+	assert.Equal(t, "int", encoding)
+	encoding, _ = redis.String(c.Do("OBJECT", "ENCODING", "object:raw"))
+	assert.Equal(t, "raw", encoding)
+	encoding, _ = redis.String(c.Do("OBJECT", "ENCODING", "object:hash"))
+	assert.Equal(t, "hashtable", encoding)
+	refcount, _ := redis.Int(c.Do("OBJECT", "REFCOUNT", "object:raw"))
+	assert.Equal(t, 1, refcount)
+	missing, _ := c.Do("OBJECT", "ENCODING", "object:missing")
+	assert.Nil(t, missing)
+
+	random, _ := redis.String(c.Do("RANDOMKEY"))
+	assert.Contains(t, []string{"object:int", "object:raw", "object:hash"}, random)
+
+	// OBJECT itself is no access, TOUCH is
+	time.Sleep(1100 * time.Millisecond)
+	idle, _ := redis.Int(c.Do("OBJECT", "IDLETIME", "object:raw"))
+	assert.GreaterOrEqual(t, idle, 1)
+
+	touched, _ := redis.Int(c.Do("TOUCH", "object:raw", "object:hash", "object:missing"))
+	assert.Equal(t, 2, touched)
+	idle, _ = redis.Int(c.Do("OBJECT", "IDLETIME", "object:raw"))
+	assert.Equal(t, 0, idle)
+
+	freq, _ := redis.Int(c.Do("OBJECT", "FREQ", "object:raw"))
+	assert.GreaterOrEqual(t, freq, 5)
+}
+
+func TestDel(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("SELECT", 15)
+	c.Do("FLUSHDB")
+	c.Do("SET", "del:string", "value")
+	c.Do("HSET", "del:hash", "field", "value")
+	c.Do("ZADD", "del:zset", 1, "member")
+	c.Do("XADD", "del:stream", "1-1", "field", "value")
+
+	// keys of every type go, missing ones are not counted
+	deleted, err := redis.Int(c.Do("DEL", "del:string", "del:hash", "del:zset", "del:stream", "del:missing"))
+	assert.NoError(t, err)
+	assert.Equal(t, 4, deleted)
+
+	size, _ := redis.Int(c.Do("DBSIZE"))
+	assert.Equal(t, 0, size)
+	value, err := c.Do("HGET", "del:hash", "field")
+	assert.NoError(t, err)
+	assert.Nil(t, value)
+	typ, _ := redis.String(c.Do("TYPE", "del:stream"))
+	assert.Equal(t, "none", typ)
+
+	deleted, _ = redis.Int(c.Do("DEL", "del:missing"))
+	assert.Equal(t, 0, deleted)
+
+	// deleting a key that does not exist leaves a watched key untouched
+	c.Do("WATCH", "del:missing")
+	c.Do("DEL", "del:missing")
+	c.Do("MULTI")
+	c.Do("SET", "del:string", "again")
+	replies, err := redis.Values(c.Do("EXEC"))
+	assert.NoError(t, err)
+	assert.Len(t, replies, 1)
+}
@@ -0,0 +1,210 @@
+// tests for maxmemory and the eviction policies
+package tests
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+// puts the memory settings back the way the other tests expect them
+func resetMaxMemory(c redis.Conn) {
+	c.Do("CONFIG", "SET", "maxmemory", 0)
+	c.Do("CONFIG", "SET", "maxmemory-policy", "noeviction")
+	c.Do("CONFIG", "SET", "maxmemory-samples", 5)
+}
+
+func TestMaxMemoryConfig(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+	defer resetMaxMemory(c)
+
+	ok, _ := redis.String(c.Do("CONFIG", "SET", "maxmemory", "1mb"))
+	assert.Equal(t, "OK", ok)
+	values, _ := redis.Strings(c.Do("CONFIG", "GET", "maxmemory"))
+	assert.Equal(t, []string{"maxmemory", "1048576"}, values)
+
+	c.Do("CONFIG", "SET", "maxmemory-policy", "ALLKEYS-LFU")
+	values, _ = redis.Strings(c.Do("CONFIG", "GET", "maxmemory-policy"))
+	assert.Equal(t, []string{"maxmemory-policy", "allkeys-lfu"}, values)
+
+	_, err = c.Do("CONFIG", "SET", "maxmemory", "lots")
+	assert.EqualError(t, err, "ERR CONFIG SET failed (possibly related to argument 'maxmemory') - argument must be a memory value")
+	_, err = c.Do("CONFIG", "SET", "maxmemory-policy", "oldest-first")
+	assert.ErrorContains(t, err, "argument(s) must be one of the following: noeviction, allkeys-lru")
+	_, err = c.Do("CONFIG", "SET", "maxmemory-samples", 0)
+	assert.ErrorContains(t, err, "argument must be between 1 and 64 inclusive")
+
+	info, _ := redis.String(c.Do("INFO"))
+	assert.Contains(t, info, "maxmemory: 1048576")
+	assert.Contains(t, info, "maxmemory_policy: allkeys-lfu")
+}
+
+func TestMaxMemoryNoEviction(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+	defer resetMaxMemory(c)
+
+	c.Do("FLUSHALL")
+	c.Do("SELECT", 10)
+	c.Do("SET", "oom:first", strings.Repeat("x", 1000))
+	c.Do("CONFIG", "SET", "maxmemory", infoInt(c, "used_memory")-500)
+
+	_, err = c.Do("SET", "oom:second", "value")
+	assert.EqualError(t, err, "OOM command not allowed when used memory > 'maxmemory'.")
+	value, _ := redis.String(c.Do("GET", "oom:first"))
+	assert.Len(t, value, 1000)
+
+	// queueing a command that needs memory fails the transaction
+	c.Do("MULTI")
+	_, err = c.Do("SET", "oom:second", "value")
+	assert.EqualError(t, err, "OOM command not allowed when used memory > 'maxmemory'.")
+	_, err = c.Do("EXEC")
+	assert.EqualError(t, err, "EXECABORT Transaction discarded because of previous errors.")
+
+	// commands that free memory still run, and make room for more
+	_, err = c.Do("DEL", "oom:first")
+	assert.NoError(t, err)
+	ok, err := redis.String(c.Do("SET", "oom:second", "value"))
+	assert.NoError(t, err)
+	assert.Equal(t, "OK", ok)
+}
+
+func TestMaxMemoryEviction(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+	defer resetMaxMemory(c)
+
+	c.Do("FLUSHALL")
+	c.Do("SELECT", 10)
+	value := strings.Repeat("x", 1000)
+
+	// the keys written last are the ones used most recently
+	for i := 0; i < 100; i++ {
+		c.Do("SET", fmt.Sprintf("lru:cold:%d", i), value)
+	}
+	time.Sleep(50 * time.Millisecond)
+	for i := 0; i < 10; i++ {
+		c.Do("SET", fmt.Sprintf("lru:hot:%d", i), value)
+	}
+
+	c.Do("CONFIG", "SET", "maxmemory-policy", "allkeys-lru")
+	c.Do("CONFIG", "SET", "maxmemory", 60000)
+	c.Do("PING")
+
+	size, _ := redis.Int(c.Do("DBSIZE"))
+	assert.Less(t, size, 60)
+	for i := 0; i < 10; i++ {
+		typ, _ := redis.String(c.Do("TYPE", fmt.Sprintf("lru:hot:%d", i)))
+		assert.Equal(t, "string", typ, i)
+	}
+	info, _ := redis.String(c.Do("INFO"))
+	assert.NotContains(t, info, "evicted_keys: 0\n")
+
+	// volatile-ttl only evicts keys with a ttl, the ones expiring first go first
+	c.Do("FLUSHALL")
+	c.Do("CONFIG", "SET", "maxmemory", 0)
+	for i := 0; i < 20; i++ {
+		c.Do("SET", fmt.Sprintf("ttl:kept:%d", i), value)
+		c.Do("SET", fmt.Sprintf("ttl:volatile:%d", i), value)
+		c.Do("EXPIRE", fmt.Sprintf("ttl:volatile:%d", i), 1000+i)
+	}
+
+	c.Do("CONFIG", "SET", "maxmemory-policy", "volatile-ttl")
+	c.Do("CONFIG", "SET", "maxmemory", 35000)
+	c.Do("PING")
+
+	typ, _ := redis.String(c.Do("TYPE", "ttl:volatile:0"))
+	assert.Equal(t, "none", typ)
+	typ, _ = redis.String(c.Do("TYPE", "ttl:volatile:19"))
+	assert.Equal(t, "string", typ)
+
+	// once only keys without a ttl are left nothing can be evicted
+	c.Do("CONFIG", "SET", "maxmemory", 10000)
+	_, err = c.Do("SET", "ttl:new", "value")
+	assert.EqualError(t, err, "OOM command not allowed when used memory > 'maxmemory'.")
+	keys, _ := redis.Strings(c.Do("KEYS", "ttl:*"))
+	assert.Len(t, keys, 20)
+}
+
+func TestMaxMemoryEvictionRestart(t *testing.T) {
+	dir := t.TempDir()
+	stop := startServerIn(t, dir, ":6385")
+
+	c, err := redis.Dial("tcp", ":6385")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	value := strings.Repeat("x", 1000)
+	for i := 0; i < 50; i++ {
+		c.Do("HSET", fmt.Sprintf("evict:hash:%d", i), "field", value)
+		c.Do("ZADD", fmt.Sprintf("evict:zset:%d", i), 1, value)
+	}
+
+	c.Do("CONFIG", "SET", "maxmemory-policy", "allkeys-random")
+	c.Do("CONFIG", "SET", "maxmemory", 60000)
+	c.Do("PING")
+
+	before, _ := redis.Strings(c.Do("KEYS", "evict:*"))
+	assert.Less(t, len(before), 100)
+
+	// evicted keys of every type stay gone once the append only file is replayed
+	stop()
+	startServerIn(t, dir, ":6385")
+	c, err = redis.Dial("tcp", ":6385")
+	if err != nil {
+		t.Fatalf("failed to connect to the restarted server: %v", err)
+	}
+	defer c.Close()
+
+	after, _ := redis.Strings(c.Do("KEYS", "evict:*"))
+	assert.ElementsMatch(t, before, after)
+}
+
+func TestMaxMemoryEvictionExpiredKey(t *testing.T) {
+	startServerIn(t, t.TempDir(), ":6388")
+
+	// a read timeout so an eviction loop that never ends fails the test
+	c, err := redis.Dial("tcp", ":6388", redis.DialReadTimeout(5*time.Second))
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("CONFIG", "SET", "maxmemory-policy", "volatile-random")
+	c.Do("CONFIG", "SET", "maxmemory", infoInt(c, "used_memory")+100000)
+
+	// the key expires lazily, which used to leave it behind as a volatile key,
+	// GET has to get there before active expire does
+	c.Do("SET", "evict:volatile", "value")
+	c.Do("EXPIRE", "evict:volatile", 1)
+	for i := 0; i < 500; i++ {
+		if value, _ := c.Do("GET", "evict:volatile"); value == nil {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	c.Do("SET", "evict:large", strings.Repeat("x", 200000))
+
+	// nothing is left to evict, so commands needing memory are refused
+	pong, err := redis.String(c.Do("PING"))
+	assert.NoError(t, err)
+	assert.Equal(t, "PONG", pong)
+	_, err = c.Do("SET", "evict:more", "value")
+	assert.EqualError(t, err, "OOM command not allowed when used memory > 'maxmemory'.")
+}
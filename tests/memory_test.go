@@ -0,0 +1,131 @@
+// tests for MEMORY USAGE, MEMORY STATS and MEMORY DOCTOR
+package tests
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+// a number from the INFO output
+func infoInt(c redis.Conn, name string) int {
+	info, _ := redis.String(c.Do("INFO"))
+	for _, line := range strings.Split(info, "\n") {
+		if value, ok := strings.CutPrefix(line, name+": "); ok {
+			n, _ := strconv.Atoi(strings.TrimSpace(value))
+			return n
+		}
+	}
+	return -1
+}
+
+func TestMemoryUsage(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("SELECT", 9)
+	c.Do("FLUSHDB")
+	c.Do("SET", "memory:small", "x")
+	c.Do("SET", "memory:large", strings.Repeat("x", 10000))
+	for i := 0; i < 100; i++ {
+		c.Do("HSET", "memory:hash", "field:"+strconv.Itoa(i), strings.Repeat("v", 100))
+		c.Do("ZADD", "memory:zset", i, "member:"+strconv.Itoa(i))
+	}
+	c.Do("XADD", "memory:stream", "*", "field", "value")
+
+	small, _ := redis.Int(c.Do("MEMORY", "USAGE", "memory:small"))
+	large, _ := redis.Int(c.Do("MEMORY", "USAGE", "memory:large"))
+	assert.Greater(t, small, 0)
+	assert.Greater(t, large, 10000)
+	assert.Greater(t, large-small, 9000)
+
+	// the fields are all of the same size so sampling them all changes little
+	sampled, _ := redis.Int(c.Do("MEMORY", "USAGE", "memory:hash"))
+	all, _ := redis.Int(c.Do("MEMORY", "USAGE", "memory:hash", "SAMPLES", 0))
+	assert.Greater(t, all, 100*100)
+	assert.InDelta(t, all, sampled, float64(all)/20)
+
+	for _, key := range []string{"memory:zset", "memory:stream"} {
+		usage, err := redis.Int(c.Do("MEMORY", "USAGE", key))
+		assert.NoError(t, err, key)
+		assert.Greater(t, usage, 0, key)
+	}
+
+	missing, err := c.Do("MEMORY", "USAGE", "memory:missing")
+	assert.NoError(t, err)
+	assert.Nil(t, missing)
+
+	_, err = c.Do("MEMORY", "USAGE", "memory:small", "SAMPLES", "many")
+	assert.EqualError(t, err, "ERR value is not an integer or out of range")
+	_, err = c.Do("MEMORY", "USAGE", "memory:small", "COUNT", 1)
+	assert.EqualError(t, err, "ERR syntax error")
+}
+
+func TestMemoryStats(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("SELECT", 9)
+	c.Do("FLUSHDB")
+	c.Do("SET", "stats:key", strings.Repeat("x", 5000))
+
+	values, err := redis.Values(c.Do("MEMORY", "STATS"))
+	assert.NoError(t, err)
+
+	stats := map[string]interface{}{}
+	for i := 0; i+1 < len(values); i += 2 {
+		name, _ := redis.String(values[i], nil)
+		stats[name] = values[i+1]
+	}
+
+	for _, name := range []string{"peak.allocated", "total.allocated", "replication.backlog", "clients.normal", "aof.buffer", "overhead.total", "keys.count", "dataset.bytes", "db.9"} {
+		assert.Contains(t, stats, name)
+	}
+	assert.Greater(t, stats["clients.normal"], int64(0))
+	assert.Greater(t, stats["dataset.bytes"], int64(5000))
+	assert.GreaterOrEqual(t, stats["peak.allocated"], stats["total.allocated"])
+
+	// INFO reports the same numbers instead of a fixed value
+	used := infoInt(c, "used_memory")
+	assert.Greater(t, used, 5000)
+	assert.GreaterOrEqual(t, infoInt(c, "used_memory_peak"), used)
+	assert.Greater(t, infoInt(c, "used_memory_dataset"), 5000)
+	assert.Greater(t, infoInt(c, "used_memory_overhead"), 0)
+}
+
+func TestMemoryDoctor(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+	defer c.Do("CONFIG", "SET", "maxmemory", 0)
+
+	c.Do("FLUSHALL")
+	report, _ := redis.String(c.Do("MEMORY", "DOCTOR"))
+	assert.Contains(t, report, "using very little memory")
+
+	// near the limit with nothing to evict
+	c.Do("SELECT", 9)
+	c.Do("SET", "doctor:big", strings.Repeat("x", 6<<20))
+	c.Do("CONFIG", "SET", "maxmemory", infoInt(c, "used_memory")+(100<<10))
+	report, _ = redis.String(c.Do("MEMORY", "DOCTOR"))
+	assert.Contains(t, report, "Close to maxmemory")
+
+	c.Do("CONFIG", "SET", "maxmemory", 0)
+	report, _ = redis.String(c.Do("MEMORY", "DOCTOR"))
+	assert.NotContains(t, report, "Close to maxmemory")
+
+	_, err = c.Do("MEMORY", "MALLOC")
+	assert.EqualError(t, err, "ERR unknown subcommand or wrong number of arguments for 'MALLOC'. Try MEMORY HELP.")
+	c.Do("FLUSHDB")
+}
@@ -0,0 +1,184 @@
+// tests for MIGRATE, against a second server started by the tests
+package tests
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+// builds and starts another server listening on port, stopped when the test ends
+func startServer(t *testing.T, port string) {
+	startServerIn(t, t.TempDir(), port)
+}
+
+// builds and starts a server in dir, with extra sections appended to its
+// config, returns a function that stops it so it can be started again on the
+// same append only file
+func startServerIn(t *testing.T, dir string, port string, extra ...string) func() {
+	binary := filepath.Join(dir, "blueberrydb")
+
+	if _, err := os.Stat(binary); err != nil {
+		if out, err := exec.Command("go", "build", "-tags", "examplemodule", "-o", binary, "../cmd/server").CombinedOutput(); err != nil {
+			t.Fatalf("failed to build the server: %v\n%s", err, out)
+		}
+	}
+
+	config := fmt.Sprintf("[server]\nport=\"%s\"\n\n[persistence]\nenabled=true\nfile_path=\"./database.aof\"\n\n[logging]\nlevel=\"error\"\n", port)
+	for _, section := range extra {
+		config += "\n" + section + "\n"
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.toml"), []byte(config), 0o644); err != nil {
+		t.Fatalf("failed to write the config: %v", err)
+	}
+
+	server := exec.Command(binary)
+	server.Dir = dir
+	if err := server.Start(); err != nil {
+		t.Fatalf("failed to start the server: %v", err)
+	}
+	stopped := false
+	stop := func() {
+		if !stopped {
+			stopped = true
+			server.Process.Kill()
+			server.Wait()
+		}
+	}
+	t.Cleanup(stop)
+
+	for i := 0; i < 100; i++ {
+		if c, err := redis.Dial("tcp", port); err == nil {
+			c.Close()
+			return stop
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("server on %s did not start", port)
+	return stop
+}
+
+func TestMigrate(t *testing.T) {
+	startServer(t, ":6380")
+
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	target, err := redis.Dial("tcp", ":6380")
+	if err != nil {
+		t.Fatalf("failed to connect to the target server: %v", err)
+	}
+	defer target.Close()
+
+	c.Do("SELECT", 12)
+	c.Do("FLUSHDB")
+	c.Do("SET", "migrate:string", "value")
+	c.Do("EXPIRE", "migrate:string", 100)
+	c.Do("HSET", "migrate:hash", "field", "value")
+	c.Do("XADD", "migrate:stream", "1-1", "field", "value")
+
+	ok, err := redis.String(c.Do("MIGRATE", "127.0.0.1", "6380", "migrate:string", 3, 1000))
+	assert.NoError(t, err)
+	assert.Equal(t, "OK", ok)
+
+	typ, _ := redis.String(c.Do("TYPE", "migrate:string"))
+	assert.Equal(t, "none", typ)
+	target.Do("SELECT", 3)
+	value, _ := redis.String(target.Do("GET", "migrate:string"))
+	assert.Equal(t, "value", value)
+
+	// COPY leaves the keys here, missing keys are skipped
+	ok, err = redis.String(c.Do("MIGRATE", "127.0.0.1", "6380", "", 3, 1000, "COPY", "KEYS", "migrate:hash", "migrate:stream", "migrate:missing"))
+	assert.NoError(t, err)
+	assert.Equal(t, "OK", ok)
+
+	keys, _ := redis.Strings(c.Do("KEYS", "migrate:*"))
+	assert.ElementsMatch(t, []string{"migrate:hash", "migrate:stream"}, keys)
+	keys, _ = redis.Strings(target.Do("KEYS", "migrate:*"))
+	assert.ElementsMatch(t, []string{"migrate:string", "migrate:hash", "migrate:stream"}, keys)
+	length, _ := redis.Int(target.Do("XLEN", "migrate:stream"))
+	assert.Equal(t, 1, length)
+
+	// existing keys on the other end are only replaced with REPLACE
+	c.Do("HSET", "migrate:hash", "field", "changed")
+	_, err = c.Do("MIGRATE", "127.0.0.1", "6380", "migrate:hash", 3, 1000)
+	assert.EqualError(t, err, "ERR Target instance replied with error: BUSYKEY Target key name already exists.")
+	typ, _ = redis.String(c.Do("TYPE", "migrate:hash"))
+	assert.Equal(t, "hash", typ)
+
+	ok, _ = redis.String(c.Do("MIGRATE", "127.0.0.1", "6380", "migrate:hash", 3, 1000, "REPLACE"))
+	assert.Equal(t, "OK", ok)
+	value, _ = redis.String(target.Do("HGET", "migrate:hash", "field"))
+	assert.Equal(t, "changed", value)
+
+	nokey, _ := redis.String(c.Do("MIGRATE", "127.0.0.1", "6380", "migrate:missing", 3, 1000))
+	assert.Equal(t, "NOKEY", nokey)
+}
+
+func TestMigrateRestart(t *testing.T) {
+	dir := t.TempDir()
+	stop := startServerIn(t, dir, ":6383")
+	startServer(t, ":6384")
+
+	c, err := redis.Dial("tcp", ":6383")
+	if err != nil {
+		t.Fatalf("failed to connect to the source server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("HSET", "migrate:hash", "field", "value")
+	c.Do("ZADD", "migrate:zset", 1, "member")
+	ok, err := redis.String(c.Do("MIGRATE", "127.0.0.1", "6384", "", 0, 1000, "KEYS", "migrate:hash", "migrate:zset"))
+	assert.NoError(t, err)
+	assert.Equal(t, "OK", ok)
+
+	// the keys that moved stay gone once the source replays its append only file
+	stop()
+	startServerIn(t, dir, ":6383")
+	c, err = redis.Dial("tcp", ":6383")
+	if err != nil {
+		t.Fatalf("failed to connect to the restarted server: %v", err)
+	}
+	defer c.Close()
+
+	keys, _ := redis.Strings(c.Do("KEYS", "migrate:*"))
+	assert.Empty(t, keys)
+}
+
+func TestMigrateErrors(t *testing.T) {
+	startServer(t, ":6381")
+
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("SELECT", 12)
+	c.Do("SET", "migrate:kept", "value")
+
+	_, err = c.Do("MIGRATE", "127.0.0.1", "6381", "migrate:kept", 99, 1000)
+	assert.EqualError(t, err, "ERR Target instance replied with error: ERR DB index is out of range")
+
+	// a server without a password rejects AUTH
+	_, err = c.Do("MIGRATE", "127.0.0.1", "6381", "migrate:kept", 0, 1000, "AUTH", "secret")
+	assert.ErrorContains(t, err, "ERR Target instance replied with error: ERR AUTH")
+
+	_, err = c.Do("MIGRATE", "127.0.0.1", "6382", "migrate:kept", 0, 100)
+	assert.EqualError(t, err, "IOERR error or timeout connecting to the client")
+
+	_, err = c.Do("MIGRATE", "127.0.0.1", "6381", "migrate:kept", 0, 1000, "KEYS", "migrate:kept")
+	assert.EqualError(t, err, "ERR When using MIGRATE KEYS option, the key argument must be set to the empty string")
+
+	value, _ := redis.String(c.Do("GET", "migrate:kept"))
+	assert.Equal(t, "value", value)
+}
@@ -0,0 +1,82 @@
+// tests for commands registered by extension modules, using the example module
+// the server is built with under -tags examplemodule
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModuleCommand(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("SET", "module:first", "one")
+	c.Do("SET", "module:second", "two")
+
+	ok, _ := redis.String(c.Do("EXAMPLE.SWAP", "module:first", "module:second"))
+	assert.Equal(t, "OK", ok)
+
+	first, _ := redis.String(c.Do("GET", "module:first"))
+	second, _ := redis.String(c.Do("GET", "module:second"))
+	assert.Equal(t, "two", first)
+	assert.Equal(t, "one", second)
+
+	// registered commands work inside transactions and scripts like the built in ones
+	c.Do("MULTI")
+	c.Do("EXAMPLE.SWAP", "module:first", "module:second")
+	c.Do("GET", "module:first")
+	results, _ := redis.Values(c.Do("EXEC"))
+	assert.Equal(t, []byte("one"), results[1])
+
+	ok, _ = redis.String(c.Do("EVAL", "return redis.call('example.swap', KEYS[1], KEYS[2])", "2", "module:first", "module:second"))
+	assert.Equal(t, "OK", ok)
+
+	first, _ = redis.String(c.Do("GET", "module:first"))
+	assert.Equal(t, "two", first)
+}
+
+func TestModuleArity(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	_, err = c.Do("EXAMPLE.SWAP", "module:first")
+	assert.ErrorContains(t, err, "wrong number of arguments for 'example.swap' command")
+
+	_, err = c.Do("EXAMPLE.WHOAMI", "extra")
+	assert.ErrorContains(t, err, "wrong number of arguments")
+
+	c.Do("DEL", "module:missing")
+	_, err = c.Do("EXAMPLE.SWAP", "module:first", "module:missing")
+	assert.ErrorContains(t, err, "must hold a string")
+}
+
+func TestModuleClientInfo(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	other, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer other.Close()
+
+	me, _ := redis.String(c.Do("EXAMPLE.WHOAMI"))
+	them, _ := redis.String(other.Do("EXAMPLE.WHOAMI"))
+
+	assert.True(t, strings.HasPrefix(me, "id="))
+	assert.Contains(t, me, "addr=127.0.0.1:")
+	assert.NotEqual(t, me, them)
+}
@@ -0,0 +1,93 @@
+// tests for MULTI, EXEC and DISCARD
+package tests
+
+import (
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiExec(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("SET", "multi:counter", "1")
+	c.Do("SET", "multi:text", "abc")
+
+	ok, _ := redis.String(c.Do("MULTI"))
+	assert.Equal(t, "OK", ok)
+
+	for _, args := range [][]interface{}{
+		{"INCR", "multi:counter"},
+		{"INCR", "multi:text"},
+		{"INCRBY", "multi:counter", "10"},
+	} {
+		queued, _ := redis.String(c.Do(args[0].(string), args[1:]...))
+		assert.Equal(t, "QUEUED", queued)
+	}
+
+	// runtime errors are returned in place and do not stop the other commands
+	results, err := redis.Values(c.Do("EXEC"))
+	if err != nil {
+		t.Fatalf("failed to exec: %v", err)
+	}
+	assert.Len(t, results, 3)
+	assert.Equal(t, int64(2), results[0])
+	assert.IsType(t, redis.Error(""), results[1])
+	assert.Equal(t, int64(12), results[2])
+
+	_, err = c.Do("EXEC")
+	assert.ErrorContains(t, err, "EXEC without MULTI")
+}
+
+// errors while queueing discard the whole transaction
+func TestExecAbort(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("SET", "multi:abort", "before")
+
+	c.Do("MULTI")
+	c.Do("SET", "multi:abort", "after")
+
+	_, err = c.Do("NOSUCHCOMMAND")
+	assert.ErrorContains(t, err, "unknown command")
+
+	_, err = c.Do("EXEC")
+	assert.ErrorContains(t, err, "EXECABORT")
+
+	value, _ := redis.String(c.Do("GET", "multi:abort"))
+	assert.Equal(t, "before", value)
+}
+
+func TestDiscard(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("SET", "multi:discard", "before")
+
+	c.Do("MULTI")
+	c.Do("SET", "multi:discard", "after")
+
+	_, err = c.Do("MULTI")
+	assert.ErrorContains(t, err, "can not be nested")
+
+	ok, _ := redis.String(c.Do("DISCARD"))
+	assert.Equal(t, "OK", ok)
+
+	value, _ := redis.String(c.Do("GET", "multi:discard"))
+	assert.Equal(t, "before", value)
+
+	_, err = c.Do("DISCARD")
+	assert.ErrorContains(t, err, "DISCARD without MULTI")
+}
@@ -0,0 +1,109 @@
+// tests for keyspace notifications
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+// enables notifications for the duration of a test
+func notifyKeyspaceEvents(t *testing.T, c redis.Conn, flags string) {
+	if _, err := c.Do("CONFIG", "SET", "notify-keyspace-events", flags); err != nil {
+		t.Fatalf("failed to enable notifications: %v", err)
+	}
+	t.Cleanup(func() {
+		c.Do("CONFIG", "SET", "notify-keyspace-events", "")
+	})
+}
+
+func TestNotifyConfig(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	notifyKeyspaceEvents(t, c, "EKgxh$zlsetd")
+
+	flags, _ := redis.Strings(c.Do("CONFIG", "GET", "notify-keyspace-events"))
+	assert.Equal(t, []string{"notify-keyspace-events", "AKE"}, flags)
+
+	_, err = c.Do("CONFIG", "SET", "notify-keyspace-events", "KEq")
+	assert.ErrorContains(t, err, "Invalid event class character")
+}
+
+func TestKeyspaceEvents(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	subscriber, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	psc := redis.PubSubConn{Conn: subscriber}
+	defer psc.Close()
+
+	notifyKeyspaceEvents(t, c, "KEA")
+
+	psc.PSubscribe("__keyspace@0__:notify:*", "__keyevent@0__:*")
+	for i := 0; i < 2; i++ {
+		psc.Receive()
+	}
+
+	c.Do("SET", "notify:string", "value")
+	c.Do("HSET", "notify:hash", "field", "value")
+	c.Do("DEL", "notify:string")
+
+	expected := []struct{ channel, data string }{
+		{"__keyspace@0__:notify:string", "set"},
+		{"__keyevent@0__:set", "notify:string"},
+		{"__keyspace@0__:notify:hash", "hset"},
+		{"__keyevent@0__:hset", "notify:hash"},
+		{"__keyspace@0__:notify:string", "del"},
+		{"__keyevent@0__:del", "notify:string"},
+	}
+	for _, event := range expected {
+		message, ok := psc.Receive().(redis.Message)
+		assert.True(t, ok, "Expected a message")
+		assert.Equal(t, event.channel, message.Channel)
+		assert.Equal(t, event.data, string(message.Data))
+	}
+
+	c.Do("HDEL", "notify:hash", "field")
+}
+
+// keys nobody reads anymore still report their expiration through active expiry
+func TestExpiredEvents(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	subscriber, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	psc := redis.PubSubConn{Conn: subscriber}
+	defer psc.Close()
+
+	notifyKeyspaceEvents(t, c, "Ex")
+
+	psc.Subscribe("__keyevent@0__:expired")
+	psc.Receive()
+
+	c.Do("SET", "notify:expiring", "value")
+	c.Do("EXPIRE", "notify:expiring", "1")
+
+	start := time.Now()
+	message, ok := psc.ReceiveWithTimeout(5 * time.Second).(redis.Message)
+	assert.True(t, ok, "Expected an expired event")
+	assert.Equal(t, "notify:expiring", string(message.Data))
+	assert.Less(t, time.Since(start), 3*time.Second)
+}
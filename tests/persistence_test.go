@@ -0,0 +1,48 @@
+// tests for restoring the append only file after a crash
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAofTornTail(t *testing.T) {
+	dir := t.TempDir()
+
+	// a crash in the middle of a transaction, while its last command was written
+	aof := "*3\r\n$3\r\nSET\r\n$4\r\nkept\r\n$1\r\n1\r\n" +
+		"*1\r\n$5\r\nMULTI\r\n" +
+		"*3\r\n$3\r\nSET\r\n$4\r\nlost\r\n$1\r\n2\r\n" +
+		"*3\r\n$3\r\nSET\r\n$4\r\ntorn\r\n$5\r\nval"
+	if err := os.WriteFile(filepath.Join(dir, "database.aof"), []byte(aof), 0o644); err != nil {
+		t.Fatalf("failed to write the aof: %v", err)
+	}
+
+	stop := startServerIn(t, dir, ":6386")
+	c, err := redis.Dial("tcp", ":6386")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	keys, _ := redis.Strings(c.Do("KEYS", "*"))
+	assert.Equal(t, []string{"kept"}, keys)
+	c.Do("SET", "new", "value")
+	c.Do("SET", "new2", "value")
+
+	// the writes after the torn tail survive the next restart
+	stop()
+	startServerIn(t, dir, ":6386")
+	c, err = redis.Dial("tcp", ":6386")
+	if err != nil {
+		t.Fatalf("failed to connect to the restarted server: %v", err)
+	}
+	defer c.Close()
+
+	keys, _ = redis.Strings(c.Do("KEYS", "*"))
+	assert.ElementsMatch(t, []string{"kept", "new", "new2"}, keys)
+}
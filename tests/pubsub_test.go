@@ -0,0 +1,190 @@
+// tests for pub/sub commands
+package tests
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscribePublish(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	subscriber, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	psc := redis.PubSubConn{Conn: subscriber}
+	defer psc.Close()
+
+	psc.Subscribe("news")
+	psc.PSubscribe("news.*")
+
+	// the subscription confirmations come first
+	for i := 1; i <= 2; i++ {
+		subscription, ok := psc.Receive().(redis.Subscription)
+		assert.True(t, ok, "Expected a subscription reply")
+		assert.Equal(t, i, subscription.Count)
+	}
+
+	receivers, _ := redis.Int(c.Do("PUBLISH", "news", "hello"))
+	assert.Equal(t, 1, receivers)
+
+	receivers, _ = redis.Int(c.Do("PUBLISH", "news.sports", "goal"))
+	assert.Equal(t, 1, receivers)
+
+	receivers, _ = redis.Int(c.Do("PUBLISH", "weather", "rain"))
+	assert.Equal(t, 0, receivers)
+
+	message, ok := psc.Receive().(redis.Message)
+	assert.True(t, ok, "Expected a message")
+	assert.Equal(t, "news", message.Channel)
+	assert.Equal(t, "hello", string(message.Data))
+
+	message, ok = psc.Receive().(redis.Message)
+	assert.True(t, ok, "Expected a pattern message")
+	assert.Equal(t, "news.*", message.Pattern)
+	assert.Equal(t, "news.sports", message.Channel)
+	assert.Equal(t, "goal", string(message.Data))
+
+	channels, _ := redis.Strings(c.Do("PUBSUB", "CHANNELS", "n*"))
+	assert.Equal(t, []string{"news"}, channels)
+
+	numsub, _ := redis.Values(c.Do("PUBSUB", "NUMSUB", "news", "weather"))
+	assert.Equal(t, []interface{}{[]byte("news"), int64(1), []byte("weather"), int64(0)}, numsub)
+
+	numpat, _ := redis.Int(c.Do("PUBSUB", "NUMPAT"))
+	assert.Equal(t, 1, numpat)
+}
+
+func TestSubscriberMode(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Send("SUBSCRIBE", "alerts", "metrics")
+	c.Flush()
+	for i := 0; i < 2; i++ {
+		if _, err := c.Receive(); err != nil {
+			t.Fatalf("failed to subscribe: %v", err)
+		}
+	}
+
+	_, err = c.Do("GET", "key")
+	assert.Error(t, err, "Expected only subscription commands to be accepted")
+
+	pong, _ := redis.Strings(c.Do("PING"))
+	assert.Equal(t, []string{"pong", ""}, pong)
+
+	// leaving every channel returns the connection to normal mode
+	c.Send("UNSUBSCRIBE")
+	c.Flush()
+	for i := 1; i >= 0; i-- {
+		reply, _ := redis.Values(c.Receive())
+		assert.Equal(t, int64(i), reply[2])
+	}
+
+	reply, err := redis.String(c.Do("PING"))
+	if err != nil {
+		t.Fatalf("failed to ping: %v", err)
+	}
+	assert.Equal(t, "PONG", reply)
+}
+
+// a subscriber that stops reading is disconnected instead of slowing down publishers
+func TestSlowSubscriberDisconnected(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	conn, err := net.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("*2\r\n$9\r\nSUBSCRIBE\r\n$4\r\nslow\r\n"))
+	assert.Eventually(t, func() bool {
+		numsub, _ := redis.Values(c.Do("PUBSUB", "NUMSUB", "slow"))
+		return numsub[1] == int64(1)
+	}, time.Second, 10*time.Millisecond)
+
+	// well past the 32mb hard limit, the subscriber never reads any of it
+	payload := strings.Repeat("x", 1<<20)
+	start := time.Now()
+	for i := 0; i < 64; i++ {
+		if _, err := c.Do("PUBLISH", "slow", payload); err != nil {
+			t.Fatalf("failed to publish: %v", err)
+		}
+	}
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	assert.Eventually(t, func() bool {
+		numsub, _ := redis.Values(c.Do("PUBSUB", "NUMSUB", "slow"))
+		return numsub[1] == int64(0)
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestShardedPubSub(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	subscriber, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer subscriber.Close()
+
+	// channels with the same hash tag share a slot
+	subscriber.Send("SSUBSCRIBE", "{orders}.created", "{orders}.paid")
+	subscriber.Flush()
+	for i := 1; i <= 2; i++ {
+		reply, _ := redis.Values(subscriber.Receive())
+		assert.Equal(t, []byte("ssubscribe"), reply[0])
+		assert.Equal(t, int64(i), reply[2])
+	}
+
+	receivers, _ := redis.Int(c.Do("SPUBLISH", "{orders}.created", "42"))
+	assert.Equal(t, 1, receivers)
+
+	// classic subscribers and channels do not see shard messages
+	receivers, _ = redis.Int(c.Do("PUBLISH", "{orders}.created", "42"))
+	assert.Equal(t, 0, receivers)
+
+	message, _ := redis.Strings(subscriber.Receive())
+	assert.Equal(t, []string{"smessage", "{orders}.created", "42"}, message)
+
+	channels, _ := redis.Strings(c.Do("PUBSUB", "SHARDCHANNELS", "{orders}*"))
+	assert.Equal(t, []string{"{orders}.created", "{orders}.paid"}, channels)
+
+	numsub, _ := redis.Values(c.Do("PUBSUB", "SHARDNUMSUB", "{orders}.paid", "other"))
+	assert.Equal(t, []interface{}{[]byte("{orders}.paid"), int64(1), []byte("other"), int64(0)}, numsub)
+
+	_, err = c.Do("SSUBSCRIBE", "a", "b")
+	assert.ErrorContains(t, err, "CROSSSLOT")
+
+	subscriber.Send("SUNSUBSCRIBE")
+	subscriber.Flush()
+	for i := 1; i >= 0; i-- {
+		reply, _ := redis.Values(subscriber.Receive())
+		assert.Equal(t, int64(i), reply[2])
+	}
+
+	pong, _ := redis.String(subscriber.Do("PING"))
+	assert.Equal(t, "PONG", pong)
+}
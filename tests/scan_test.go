@@ -0,0 +1,153 @@
+// tests for KEYS and the SCAN family
+package tests
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+// runs a SCAN style command until the cursor is back to 0, key is "" for SCAN itself
+func scanAll(t *testing.T, c redis.Conn, command string, key string, args ...interface{}) []string {
+	elements := []string{}
+	cursor := "0"
+	for {
+		call := []interface{}{cursor}
+		if key != "" {
+			call = []interface{}{key, cursor}
+		}
+
+		reply, err := redis.Values(c.Do(command, append(call, args...)...))
+		if err != nil {
+			t.Fatalf("%s failed: %v", command, err)
+		}
+
+		cursor, _ = redis.String(reply[0], nil)
+		batch, _ := redis.Strings(reply[1], nil)
+		elements = append(elements, batch...)
+		if cursor == "0" {
+			return elements
+		}
+	}
+}
+
+func TestKeys(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("SELECT", 14)
+	c.Do("FLUSHDB")
+	c.Do("SET", "user:1", "a")
+	c.Do("SET", "user:2", "b")
+	c.Do("HSET", "user:hash", "field", "value")
+	c.Do("SET", "order:1", "c")
+
+	keys, _ := redis.Strings(c.Do("KEYS", "user:?"))
+	sort.Strings(keys)
+	assert.Equal(t, []string{"user:1", "user:2"}, keys)
+
+	keys, _ = redis.Strings(c.Do("KEYS", "*"))
+	assert.Len(t, keys, 4)
+
+	keys, _ = redis.Strings(c.Do("KEYS", "[uo]*:1"))
+	sort.Strings(keys)
+	assert.Equal(t, []string{"order:1", "user:1"}, keys)
+
+	// deleted keys are gone from the listing
+	c.Do("DEL", "user:1")
+	keys, _ = redis.Strings(c.Do("KEYS", "user:*"))
+	sort.Strings(keys)
+	assert.Equal(t, []string{"user:2", "user:hash"}, keys)
+}
+
+func TestScan(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("SELECT", 14)
+	c.Do("FLUSHDB")
+	for i := 0; i < 200; i++ {
+		c.Do("SET", fmt.Sprintf("scan:%d", i), i)
+	}
+	c.Do("ZADD", "scan:zset", 1, "member")
+
+	// keys added and removed while iterating do not make stable keys go missing
+	seen := map[string]bool{}
+	cursor := "0"
+	for step := 0; ; step++ {
+		reply, err := redis.Values(c.Do("SCAN", cursor, "COUNT", 7))
+		assert.NoError(t, err)
+		cursor, _ = redis.String(reply[0], nil)
+		batch, _ := redis.Strings(reply[1], nil)
+		for _, key := range batch {
+			seen[key] = true
+		}
+
+		c.Do("SET", fmt.Sprintf("scan:new:%d", step), step)
+		c.Do("DEL", fmt.Sprintf("scan:new:%d", step-3))
+		if cursor == "0" {
+			break
+		}
+	}
+	for i := 0; i < 200; i++ {
+		assert.True(t, seen[fmt.Sprintf("scan:%d", i)], "scan:%d was not returned", i)
+	}
+
+	zsets := scanAll(t, c, "SCAN", "", "TYPE", "zset")
+	assert.Equal(t, []string{"scan:zset"}, zsets)
+	matched := scanAll(t, c, "SCAN", "", "MATCH", "scan:1?", "COUNT", 50)
+	assert.Len(t, matched, 10)
+
+	_, err = c.Do("SCAN", "abc")
+	assert.EqualError(t, err, "ERR invalid cursor")
+	_, err = c.Do("SCAN", 0, "COUNT", 0)
+	assert.EqualError(t, err, "ERR syntax error")
+}
+
+func TestCollectionScans(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("SELECT", 14)
+	c.Do("FLUSHDB")
+	for i := 0; i < 50; i++ {
+		c.Do("HSET", "scan:hash", fmt.Sprintf("field:%d", i), i)
+		c.Do("ZADD", "scan:zset", i, fmt.Sprintf("member:%d", i))
+	}
+
+	pairs := scanAll(t, c, "HSCAN", "scan:hash", "COUNT", 5)
+	assert.Len(t, pairs, 100)
+	values := map[string]string{}
+	for i := 0; i < len(pairs); i += 2 {
+		values[pairs[i]] = pairs[i+1]
+	}
+	assert.Equal(t, "42", values["field:42"])
+
+	fields := scanAll(t, c, "HSCAN", "scan:hash", "MATCH", "field:1*", "NOVALUES")
+	assert.Len(t, fields, 11)
+
+	members := scanAll(t, c, "ZSCAN", "scan:zset", "COUNT", 3)
+	assert.Len(t, members, 100)
+	scores := map[string]string{}
+	for i := 0; i < len(members); i += 2 {
+		scores[members[i]] = members[i+1]
+	}
+	assert.Equal(t, "7", scores["member:7"])
+
+	// there is no set type, SSCAN only sees missing keys or other types
+	assert.Empty(t, scanAll(t, c, "SSCAN", "scan:missing"))
+	_, err = c.Do("SSCAN", "scan:hash", 0)
+	assert.EqualError(t, err, "WRONGTYPE Operation against a key holding the wrong kind of value")
+}
@@ -0,0 +1,183 @@
+// tests for EVAL, EVALSHA and SCRIPT
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEval(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("DEL", "script:key")
+
+	value, _ := redis.String(c.Do("EVAL", "redis.call('SET', KEYS[1], ARGV[1]) return redis.call('GET', KEYS[1])", "1", "script:key", "hello"))
+	assert.Equal(t, "hello", value)
+
+	// lua numbers become integers, nil cuts an array short and false becomes nil
+	values, _ := redis.Values(c.Do("EVAL", "return {1, 2.9, 'three', nil, 5}", "0"))
+	assert.Equal(t, []interface{}{int64(1), int64(2), []byte("three")}, values)
+
+	reply, err := c.Do("EVAL", "return false", "0")
+	assert.NoError(t, err)
+	assert.Nil(t, reply)
+
+	status, _ := redis.String(c.Do("EVAL", "return redis.status_reply('DONE')", "0"))
+	assert.Equal(t, "DONE", status)
+
+	// redis.pcall hands the error to the script, redis.call raises it
+	_, err = c.Do("EVAL", "return redis.pcall('INCR', KEYS[1])", "1", "script:key")
+	assert.ErrorContains(t, err, "not an integer")
+
+	_, err = c.Do("EVAL", "redis.call('INCR', KEYS[1]) return 'unreachable'", "1", "script:key")
+	assert.ErrorContains(t, err, "not an integer")
+
+	_, err = c.Do("EVAL", "return 1", "2", "script:key")
+	assert.ErrorContains(t, err, "greater than number of args")
+}
+
+func TestEvalSha(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	sha, _ := redis.String(c.Do("SCRIPT", "LOAD", "return ARGV[1] .. ARGV[2]"))
+	assert.Len(t, sha, 40)
+
+	value, _ := redis.String(c.Do("EVALSHA", sha, "0", "foo", "bar"))
+	assert.Equal(t, "foobar", value)
+
+	exists, _ := redis.Ints(c.Do("SCRIPT", "EXISTS", sha, "0000000000000000000000000000000000000000"))
+	assert.Equal(t, []int{1, 0}, exists)
+
+	_, err = c.Do("EVALSHA", "0000000000000000000000000000000000000000", "0")
+	assert.ErrorContains(t, err, "NOSCRIPT")
+
+	// scripts can not create globals or reach outside the sandbox
+	_, err = c.Do("EVAL", "counter = 1", "0")
+	assert.ErrorContains(t, err, "global variable")
+
+	_, err = c.Do("EVAL", "return os.time()", "0")
+	assert.ErrorContains(t, err, "global variable")
+
+	_, err = c.Do("EVAL", "return (", "0")
+	assert.ErrorContains(t, err, "Error compiling script")
+
+	_, err = c.Do("EVAL", "return redis.call('EVAL', 'return 1', '0')", "0")
+	assert.ErrorContains(t, err, "not allowed from script")
+}
+
+func TestScriptSandbox(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	// the interpreter is shared, so the globals and library tables are read only
+	_, err = c.Do("EVAL", "redis.call = function() return 'hijacked' end", "0")
+	assert.ErrorContains(t, err, "Attempt to modify a readonly table")
+	_, err = c.Do("EVAL", "string.rep = nil", "0")
+	assert.ErrorContains(t, err, "Attempt to modify a readonly table")
+	_, err = c.Do("EVAL", "tostring = nil", "0")
+	assert.ErrorContains(t, err, "Attempt to modify a readonly table")
+
+	pong, _ := redis.String(c.Do("EVAL", "return redis.call('PING')", "0"))
+	assert.Equal(t, "PONG", pong)
+	value, _ := redis.String(c.Do("EVAL", "return tostring(string.rep('a', 2))", "0"))
+	assert.Equal(t, "aa", value)
+
+	// and nothing is left to get around that
+	for _, name := range []string{"rawset", "rawget", "setmetatable", "getmetatable", "setfenv", "getfenv"} {
+		_, err = c.Do("EVAL", "return "+name+"(_G, 'leak', 'yes')", "0")
+		assert.ErrorContains(t, err, "nonexistent global variable '"+name+"'")
+	}
+	_, err = c.Do("EVAL", "return leak", "0")
+	assert.ErrorContains(t, err, "nonexistent global variable 'leak'")
+}
+
+func TestScriptBusy(t *testing.T) {
+	startServerIn(t, t.TempDir(), ":6387", "[scripting]\nbusy_reply_threshold=200")
+
+	c, err := redis.Dial("tcp", ":6387")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	other, err := redis.Dial("tcp", ":6387")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer other.Close()
+
+	done := make(chan error)
+	go func() {
+		_, err := c.Do("EVAL", "while true do end", "0")
+		done <- err
+	}()
+
+	// a command waiting for the script gets BUSY once it passes the time limit
+	time.Sleep(50 * time.Millisecond)
+	_, err = other.Do("SET", "busy:key", "value")
+	assert.ErrorContains(t, err, "BUSY")
+
+	ok, _ := redis.String(other.Do("SCRIPT", "KILL"))
+	assert.Equal(t, "OK", ok)
+	assert.ErrorContains(t, <-done, "killed by user")
+
+	ok, err = redis.String(other.Do("SET", "busy:key", "value"))
+	assert.NoError(t, err)
+	assert.Equal(t, "OK", ok)
+}
+
+func TestScriptKill(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	killer, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer killer.Close()
+
+	_, err = killer.Do("SCRIPT", "KILL")
+	assert.ErrorContains(t, err, "NOTBUSY")
+
+	done := make(chan error)
+	go func() {
+		_, err := c.Do("EVAL", "while true do end", "0")
+		done <- err
+	}()
+
+	// the script may not have started yet
+	killed := false
+	for i := 0; i < 100 && !killed; i++ {
+		reply, _ := redis.String(killer.Do("SCRIPT", "KILL"))
+		killed = reply == "OK"
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, killed)
+
+	select {
+	case err := <-done:
+		assert.ErrorContains(t, err, "killed by user")
+	case <-time.After(time.Second):
+		t.Fatal("script still running after SCRIPT KILL")
+	}
+
+	pong, _ := redis.String(killer.Do("PING"))
+	assert.Equal(t, "PONG", pong)
+}
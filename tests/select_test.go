@@ -0,0 +1,124 @@
+// tests for logical databases: SELECT, MOVE, SWAPDB, FLUSHDB and DBSIZE
+package tests
+
+import (
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelect(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	other, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer other.Close()
+
+	c.Do("SELECT", 9)
+	c.Do("FLUSHDB")
+	c.Do("SET", "select:key", "nine")
+	c.Do("HSET", "select:hash", "field", "value")
+
+	size, _ := redis.Int(c.Do("DBSIZE"))
+	assert.Equal(t, 2, size)
+
+	// other connections stay on database 0
+	value, err := other.Do("GET", "select:key")
+	assert.NoError(t, err)
+	assert.Nil(t, value)
+
+	other.Do("SELECT", 9)
+	nine, _ := redis.String(other.Do("GET", "select:key"))
+	assert.Equal(t, "nine", nine)
+
+	info, _ := redis.String(c.Do("INFO"))
+	assert.Contains(t, info, "db9:keys=2,expires=0")
+
+	_, err = c.Do("SELECT", 16)
+	assert.EqualError(t, err, "ERR DB index is out of range")
+	_, err = c.Do("SELECT", "one")
+	assert.EqualError(t, err, "ERR value is not an integer or out of range")
+
+	databases, _ := redis.Strings(c.Do("CONFIG", "GET", "databases"))
+	assert.Equal(t, []string{"databases", "16"}, databases)
+}
+
+func TestMoveAndSwapdb(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("SELECT", 11)
+	c.Do("FLUSHDB")
+	c.Do("SELECT", 10)
+	c.Do("FLUSHDB")
+	c.Do("SET", "move:key", "value")
+	c.Do("ZADD", "move:zset", 1, "member")
+
+	moved, _ := redis.Int(c.Do("MOVE", "move:key", 11))
+	assert.Equal(t, 1, moved)
+	moved, _ = redis.Int(c.Do("MOVE", "move:missing", 11))
+	assert.Equal(t, 0, moved)
+	_, err = c.Do("MOVE", "move:zset", 10)
+	assert.EqualError(t, err, "ERR source and destination objects are the same")
+
+	// not moved when the key exists in the target database
+	c.Do("SET", "move:key", "again")
+	moved, _ = redis.Int(c.Do("MOVE", "move:key", 11))
+	assert.Equal(t, 0, moved)
+
+	c.Do("SELECT", 11)
+	value, _ := redis.String(c.Do("GET", "move:key"))
+	assert.Equal(t, "value", value)
+
+	// the connection stays on 11 and sees the data of 10
+	ok, _ := redis.String(c.Do("SWAPDB", 10, 11))
+	assert.Equal(t, "OK", ok)
+	value, _ = redis.String(c.Do("GET", "move:key"))
+	assert.Equal(t, "again", value)
+	card, _ := redis.Int(c.Do("ZCARD", "move:zset"))
+	assert.Equal(t, 1, card)
+
+	_, err = c.Do("SWAPDB", 0, 16)
+	assert.EqualError(t, err, "ERR DB index is out of range")
+}
+
+func TestFlushdbAndTransactions(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("SELECT", 13)
+	c.Do("SET", "flush:kept", "value")
+	c.Do("SELECT", 12)
+	c.Do("SET", "flush:key", "value")
+	c.Do("XADD", "flush:stream", "*", "field", "value")
+
+	ok, _ := redis.String(c.Do("FLUSHDB", "ASYNC"))
+	assert.Equal(t, "OK", ok)
+	size, _ := redis.Int(c.Do("DBSIZE"))
+	assert.Equal(t, 0, size)
+	_, err = c.Do("FLUSHDB", "LATER")
+	assert.EqualError(t, err, "ERR syntax error")
+
+	// SELECT inside a transaction switches the database of the commands after it
+	c.Do("MULTI")
+	c.Do("SELECT", 13)
+	c.Do("GET", "flush:kept")
+	replies, _ := redis.Values(c.Do("EXEC"))
+	assert.Equal(t, []interface{}{"OK", []byte("value")}, replies)
+
+	value, _ := redis.String(c.Do("GET", "flush:kept"))
+	assert.Equal(t, "value", value)
+}
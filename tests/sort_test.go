@@ -0,0 +1,98 @@
+// tests for SORT and SORT_RO
+package tests
+
+import (
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSort(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("SELECT", 11)
+	c.Do("FLUSHDB")
+	c.Do("ZADD", "sort:numbers", 1, "10", 2, "9", 3, "100", 4, "-1.5")
+
+	sorted, _ := redis.Strings(c.Do("SORT", "sort:numbers"))
+	assert.Equal(t, []string{"-1.5", "9", "10", "100"}, sorted)
+	sorted, _ = redis.Strings(c.Do("SORT", "sort:numbers", "DESC", "LIMIT", 1, 2))
+	assert.Equal(t, []string{"10", "9"}, sorted)
+	sorted, _ = redis.Strings(c.Do("SORT", "sort:numbers", "ALPHA"))
+	assert.Equal(t, []string{"-1.5", "10", "100", "9"}, sorted)
+	sorted, _ = redis.Strings(c.Do("SORT_RO", "sort:numbers", "LIMIT", 3, -1))
+	assert.Equal(t, []string{"100"}, sorted)
+
+	c.Do("ZADD", "sort:words", 1, "pear", 2, "apple")
+	_, err = c.Do("SORT", "sort:words")
+	assert.EqualError(t, err, "ERR One or more scores can't be converted into double")
+	sorted, _ = redis.Strings(c.Do("SORT", "sort:words", "ALPHA"))
+	assert.Equal(t, []string{"apple", "pear"}, sorted)
+
+	c.Do("ZADD", "sort:inf", 1, "a", "+inf", "b")
+	sorted, _ = redis.Strings(c.Do("SORT", "sort:inf", "ALPHA"))
+	assert.Equal(t, []string{"a", "b"}, sorted)
+
+	empty, _ := redis.Strings(c.Do("SORT", "sort:missing"))
+	assert.Empty(t, empty)
+}
+
+func TestSortByAndGet(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("SELECT", 11)
+	c.Do("FLUSHDB")
+	c.Do("ZADD", "sort:users", 1, "1", 2, "2", 3, "3")
+	c.Do("SET", "weight_1", 30)
+	c.Do("SET", "weight_2", 10)
+	c.Do("SET", "weight_3", 20)
+	c.Do("HSET", "user:1", "name", "carol", "age", 41)
+	c.Do("HSET", "user:2", "name", "alice", "age", 29)
+
+	// weights from string keys, values from hash fields, missing ones are null
+	values, _ := redis.Values(c.Do("SORT", "sort:users", "BY", "weight_*", "GET", "#", "GET", "user:*->name"))
+	assert.Equal(t, []interface{}{[]byte("2"), []byte("alice"), []byte("3"), nil, []byte("1"), []byte("carol")}, values)
+
+	sorted, _ := redis.Strings(c.Do("SORT", "sort:users", "BY", "user:*->name", "ALPHA"))
+	assert.Equal(t, []string{"3", "2", "1"}, sorted)
+
+	// a pattern without * leaves the elements in the order of the sorted set
+	sorted, _ = redis.Strings(c.Do("SORT", "sort:users", "BY", "nosort", "DESC"))
+	assert.Equal(t, []string{"3", "2", "1"}, sorted)
+}
+
+func TestSortErrors(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("SELECT", 11)
+	c.Do("SET", "sort:string", "value")
+	c.Do("ZADD", "sort:set", 1, "1")
+
+	_, err = c.Do("SORT", "sort:string")
+	assert.EqualError(t, err, "WRONGTYPE Operation against a key holding the wrong kind of value")
+	_, err = c.Do("SORT_RO", "sort:set", "STORE", "sort:dst")
+	assert.EqualError(t, err, "ERR syntax error")
+	_, err = c.Do("SORT", "sort:set", "STORE", "sort:dst")
+	assert.ErrorContains(t, err, "STORE is not supported")
+
+	// without STORE nothing is written, so SORT runs wherever SORT_RO does
+	infos, _ := redis.Values(c.Do("COMMAND", "INFO", "sort"))
+	info, _ := redis.Values(infos[0], nil)
+	flags, _ := redis.Strings(info[2], nil)
+	assert.Equal(t, []string{"readonly"}, flags)
+	_, err = c.Do("SORT", "sort:set", "LIMIT", "one", 2)
+	assert.EqualError(t, err, "ERR value is not an integer or out of range")
+}
@@ -0,0 +1,226 @@
+// tests for stream commands
+package tests
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+// ids of the entries in an XRANGE or XREADGROUP reply
+func streamIDs(t *testing.T, reply interface{}) []string {
+	entries, err := redis.Values(reply, nil)
+	if err != nil {
+		t.Fatalf("failed to read stream entries: %v", err)
+	}
+
+	ids := []string{}
+	for _, entry := range entries {
+		fields, _ := redis.Values(entry, nil)
+		id, _ := redis.String(fields[0], nil)
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+func TestXaddXrange(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	// entries spread over several blocks
+	for i := 1; i <= 250; i++ {
+		if _, err := c.Do("XADD", "events", fmt.Sprintf("%d-%d", i/10, i%10), "n", i); err != nil {
+			t.Fatalf("failed to xadd: %v", err)
+		}
+	}
+
+	length, _ := redis.Int(c.Do("XLEN", "events"))
+	assert.Equal(t, 250, length)
+
+	_, err = c.Do("XADD", "events", "3-0", "n", "old")
+	assert.Error(t, err, "Expected an id smaller than the top item to be rejected")
+
+	// an incomplete id covers the whole millisecond, ( leaves the bound out
+	ids := streamIDs(t, must(c.Do("XRANGE", "events", "12", "(13-3")))
+	assert.Equal(t, []string{"12-0", "12-1", "12-2", "12-3", "12-4", "12-5", "12-6", "12-7", "12-8", "12-9", "13-0", "13-1", "13-2"}, ids)
+
+	ids = streamIDs(t, must(c.Do("XREVRANGE", "events", "+", "-", "COUNT", "3")))
+	assert.Equal(t, []string{"25-0", "24-9", "24-8"}, ids)
+
+	entries, _ := redis.Values(c.Do("XRANGE", "events", "0-1", "0-1"))
+	fields, _ := redis.Strings(entries[0].([]interface{})[1], nil)
+	assert.Equal(t, []string{"n", "1"}, fields)
+
+	// generated ids keep increasing
+	id, err := redis.String(c.Do("XADD", "events", "*", "n", "auto"))
+	if err != nil {
+		t.Fatalf("failed to xadd with a generated id: %v", err)
+	}
+	last := streamIDs(t, must(c.Do("XREVRANGE", "events", "+", "-", "COUNT", "1")))
+	assert.Equal(t, []string{id}, last)
+
+	reply, _ := c.Do("XADD", "missing_stream", "NOMKSTREAM", "*", "n", "1")
+	assert.Nil(t, reply)
+}
+
+func TestXtrimXdel(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	for i := 1; i <= 300; i++ {
+		c.Do("XADD", "trimmed", fmt.Sprintf("%d-0", i), "n", i)
+	}
+
+	// approximate trimming only drops whole blocks of 100 entries
+	removed, _ := redis.Int(c.Do("XTRIM", "trimmed", "MAXLEN", "~", "150"))
+	assert.Equal(t, 100, removed)
+
+	removed, _ = redis.Int(c.Do("XTRIM", "trimmed", "MAXLEN", "=", "150"))
+	assert.Equal(t, 50, removed)
+
+	removed, _ = redis.Int(c.Do("XTRIM", "trimmed", "MINID", "200"))
+	assert.Equal(t, 49, removed)
+
+	first := streamIDs(t, must(c.Do("XRANGE", "trimmed", "-", "+", "COUNT", "1")))
+	assert.Equal(t, []string{"200-0"}, first)
+
+	deleted, _ := redis.Int(c.Do("XDEL", "trimmed", "200-0", "201-0", "1-0"))
+	assert.Equal(t, 2, deleted)
+
+	length, _ := redis.Int(c.Do("XLEN", "trimmed"))
+	assert.Equal(t, 99, length)
+
+	// XADD trims after appending
+	c.Do("XADD", "trimmed", "MAXLEN", "10", "301-0", "n", "301")
+	ids := streamIDs(t, must(c.Do("XRANGE", "trimmed", "-", "+")))
+	assert.Len(t, ids, 10)
+	assert.Equal(t, "292-0", ids[0])
+
+	info, _ := redis.Values(c.Do("XINFO", "STREAM", "trimmed"))
+	assert.Equal(t, "max-deleted-entry-id", string(info[8].([]byte)))
+	assert.Equal(t, "201-0", string(info[9].([]byte)))
+}
+
+func TestXreadBlocking(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	writer, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer writer.Close()
+
+	c.Do("XADD", "feed", "1-0", "n", "1")
+
+	streams, _ := redis.Values(c.Do("XREAD", "COUNT", "10", "STREAMS", "feed", "0"))
+	assert.Len(t, streams, 1)
+
+	// nothing after the last id yet
+	reply, _ := c.Do("XREAD", "BLOCK", "50", "STREAMS", "feed", "$")
+	assert.Nil(t, reply)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		writer.Do("XADD", "feed", "2-0", "n", "2")
+	}()
+
+	start := time.Now()
+	streams, err = redis.Values(c.Do("XREAD", "BLOCK", "2000", "STREAMS", "feed", "$"))
+	if err != nil {
+		t.Fatalf("failed to xread: %v", err)
+	}
+	assert.Less(t, time.Since(start), time.Second)
+
+	stream, _ := redis.Values(streams[0], nil)
+	assert.Equal(t, "feed", string(stream[0].([]byte)))
+	assert.Equal(t, []string{"2-0"}, streamIDs(t, stream[1]))
+}
+
+func TestConsumerGroups(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	reply, err := redis.String(c.Do("XGROUP", "CREATE", "jobs", "workers", "$", "MKSTREAM"))
+	if err != nil {
+		t.Fatalf("failed to create group: %v", err)
+	}
+	assert.Equal(t, "OK", reply)
+
+	_, err = c.Do("XGROUP", "CREATE", "jobs", "workers", "$")
+	assert.Error(t, err, "Expected BUSYGROUP error")
+
+	for i := 1; i <= 5; i++ {
+		c.Do("XADD", "jobs", fmt.Sprintf("%d-0", i), "job", i)
+	}
+
+	streams, _ := redis.Values(c.Do("XREADGROUP", "GROUP", "workers", "alice", "COUNT", "3", "STREAMS", "jobs", ">"))
+	stream, _ := redis.Values(streams[0], nil)
+	assert.Equal(t, []string{"1-0", "2-0", "3-0"}, streamIDs(t, stream[1]))
+
+	streams, _ = redis.Values(c.Do("XREADGROUP", "GROUP", "workers", "bob", "STREAMS", "jobs", ">"))
+	stream, _ = redis.Values(streams[0], nil)
+	assert.Equal(t, []string{"4-0", "5-0"}, streamIDs(t, stream[1]))
+
+	summary, _ := redis.Values(c.Do("XPENDING", "jobs", "workers"))
+	assert.Equal(t, int64(5), summary[0])
+	assert.Equal(t, "1-0", string(summary[1].([]byte)))
+	assert.Equal(t, "5-0", string(summary[2].([]byte)))
+
+	acked, _ := redis.Int(c.Do("XACK", "jobs", "workers", "1-0", "4-0", "9-0"))
+	assert.Equal(t, 2, acked)
+
+	// the history of a consumer holds its unacknowledged entries
+	streams, _ = redis.Values(c.Do("XREADGROUP", "GROUP", "workers", "alice", "STREAMS", "jobs", "0"))
+	stream, _ = redis.Values(streams[0], nil)
+	assert.Equal(t, []string{"2-0", "3-0"}, streamIDs(t, stream[1]))
+
+	claimed, _ := redis.Strings(c.Do("XCLAIM", "jobs", "workers", "bob", "0", "2-0", "JUSTID"))
+	assert.Equal(t, []string{"2-0"}, claimed)
+
+	pending, _ := redis.Values(c.Do("XPENDING", "jobs", "workers", "-", "+", "10", "bob"))
+	assert.Len(t, pending, 2)
+	first, _ := redis.Values(pending[0], nil)
+	assert.Equal(t, "2-0", string(first[0].([]byte)))
+	assert.Equal(t, int64(1), first[3], "Expected JUSTID to leave the delivery count alone")
+
+	// a deleted entry is dropped from the pending list instead of being claimed
+	c.Do("XDEL", "jobs", "3-0")
+	result, _ := redis.Values(c.Do("XAUTOCLAIM", "jobs", "workers", "carol", "0", "0", "COUNT", "10"))
+	assert.Equal(t, "0-0", string(result[0].([]byte)))
+	assert.Equal(t, []string{"2-0", "5-0"}, streamIDs(t, result[1]))
+	deleted, _ := redis.Strings(result[2], nil)
+	assert.Equal(t, []string{"3-0"}, deleted)
+
+	groups, _ := redis.Values(c.Do("XINFO", "GROUPS", "jobs"))
+	group, _ := redis.Values(groups[0], nil)
+	assert.Equal(t, []interface{}{[]byte("consumers"), int64(3), []byte("pending"), int64(2)}, group[2:6])
+
+	_, err = c.Do("XREADGROUP", "GROUP", "missing", "alice", "STREAMS", "jobs", ">")
+	assert.Error(t, err, "Expected NOGROUP error")
+}
+
+// panics on command errors, for replies passed straight to helpers
+func must(reply interface{}, err error) interface{} {
+	if err != nil {
+		panic(err)
+	}
+
+	return reply
+}
@@ -0,0 +1,141 @@
+// tests for string and counter commands
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCounters(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("DEL", "pageviews")
+
+	// INCR on a missing key starts from 0
+	views, err := redis.Int(c.Do("INCR", "pageviews"))
+	if err != nil {
+		t.Fatalf("failed to incr: %v", err)
+	}
+	assert.Equal(t, 1, views)
+
+	views, _ = redis.Int(c.Do("INCRBY", "pageviews", "10"))
+	assert.Equal(t, 11, views)
+
+	views, _ = redis.Int(c.Do("DECRBY", "pageviews", "5"))
+	assert.Equal(t, 6, views)
+
+	views, _ = redis.Int(c.Do("DECR", "pageviews"))
+	assert.Equal(t, 5, views)
+
+	value, _ := redis.String(c.Do("GET", "pageviews"))
+	assert.Equal(t, "5", value)
+
+	price, err := redis.String(c.Do("INCRBYFLOAT", "pageviews", "0.5"))
+	if err != nil {
+		t.Fatalf("failed to incrbyfloat: %v", err)
+	}
+	assert.Equal(t, "5.5", price)
+
+	// non integer strings cannot be incremented
+	c.Do("SET", "not_a_number", "abc")
+	_, err = c.Do("INCR", "not_a_number")
+	assert.Error(t, err, "Expected error for non integer value")
+
+	c.Do("SET", "max_counter", "9223372036854775807")
+	_, err = c.Do("INCR", "max_counter")
+	assert.Error(t, err, "Expected overflow error")
+}
+
+func TestAppendAndRanges(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("DEL", "greeting")
+
+	length, err := redis.Int(c.Do("APPEND", "greeting", "Hello"))
+	if err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	assert.Equal(t, 5, length)
+
+	length, _ = redis.Int(c.Do("APPEND", "greeting", " World"))
+	assert.Equal(t, 11, length)
+
+	length, _ = redis.Int(c.Do("STRLEN", "greeting"))
+	assert.Equal(t, 11, length)
+
+	sub, _ := redis.String(c.Do("GETRANGE", "greeting", "0", "4"))
+	assert.Equal(t, "Hello", sub)
+
+	sub, _ = redis.String(c.Do("GETRANGE", "greeting", "-5", "-1"))
+	assert.Equal(t, "World", sub)
+
+	length, _ = redis.Int(c.Do("SETRANGE", "greeting", "6", "Redis"))
+	assert.Equal(t, 11, length)
+
+	value, _ := redis.String(c.Do("GET", "greeting"))
+	assert.Equal(t, "Hello Redis", value)
+
+	// setting beyond the end pads with zero bytes
+	c.Do("DEL", "padded")
+	length, _ = redis.Int(c.Do("SETRANGE", "padded", "3", "x"))
+	assert.Equal(t, 4, length)
+
+	value, _ = redis.String(c.Do("GET", "padded"))
+	assert.Equal(t, "\x00\x00\x00x", value)
+}
+
+func TestLcs(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("SET", "lcs_a", "ohmytext")
+	c.Do("SET", "lcs_b", "mynewtext")
+
+	match, err := redis.String(c.Do("LCS", "lcs_a", "lcs_b"))
+	if err != nil {
+		t.Fatalf("failed to lcs: %v", err)
+	}
+	assert.Equal(t, "mytext", match)
+
+	length, _ := redis.Int(c.Do("LCS", "lcs_a", "lcs_b", "LEN"))
+	assert.Equal(t, 6, length)
+
+	reply, err := redis.Values(c.Do("LCS", "lcs_a", "lcs_b", "IDX", "MINMATCHLEN", "4", "WITHMATCHLEN"))
+	if err != nil {
+		t.Fatalf("failed to lcs idx: %v", err)
+	}
+
+	expected := []interface{}{
+		[]byte("matches"),
+		[]interface{}{
+			[]interface{}{
+				[]interface{}{int64(4), int64(7)},
+				[]interface{}{int64(5), int64(8)},
+				int64(4),
+			},
+		},
+		[]byte("len"),
+		int64(6),
+	}
+	assert.Equal(t, expected, reply)
+
+	// the table for two 12k strings would take more than proto-max-bulk-len
+	c.Do("SET", "lcs_a", strings.Repeat("a", 12000))
+	c.Do("SET", "lcs_b", strings.Repeat("b", 12000))
+	_, err = c.Do("LCS", "lcs_a", "lcs_b")
+	assert.EqualError(t, err, "ERR Insufficient memory, transient memory for LCS exceeds proto-max-bulk-len")
+}
@@ -0,0 +1,119 @@
+// tests for WATCH and UNWATCH
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchAbortsExec(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	other, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer other.Close()
+
+	c.Do("SET", "watch:stock", "10")
+	c.Do("WATCH", "watch:stock")
+
+	// another connection changes the key between WATCH and EXEC
+	other.Do("INCRBY", "watch:stock", "-1")
+
+	c.Do("MULTI")
+	c.Do("SET", "watch:stock", "5")
+	reply, err := c.Do("EXEC")
+	assert.NoError(t, err)
+	assert.Nil(t, reply, "Expected a null reply")
+
+	stock, _ := redis.Int(c.Do("GET", "watch:stock"))
+	assert.Equal(t, 9, stock)
+
+	// EXEC dropped the watch, the next transaction goes through
+	c.Do("MULTI")
+	c.Do("SET", "watch:stock", "5")
+	results, _ := redis.Values(c.Do("EXEC"))
+	assert.Len(t, results, 1)
+}
+
+func TestWatchDeletedAndHashKeys(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	other, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer other.Close()
+
+	c.Do("SET", "watch:deleted", "1")
+	c.Do("HSET", "watch:hash", "field", "1")
+	c.Do("WATCH", "watch:deleted", "watch:hash")
+
+	other.Do("HSET", "watch:hash", "field", "2")
+
+	c.Do("MULTI")
+	c.Do("GET", "watch:deleted")
+	reply, _ := c.Do("EXEC")
+	assert.Nil(t, reply, "Expected a null reply")
+
+	c.Do("WATCH", "watch:deleted")
+	other.Do("DEL", "watch:deleted")
+
+	c.Do("MULTI")
+	c.Do("GET", "watch:deleted")
+	reply, _ = c.Do("EXEC")
+	assert.Nil(t, reply, "Expected a null reply")
+
+	// UNWATCH forgets the keys
+	c.Do("SET", "watch:deleted", "1")
+	c.Do("WATCH", "watch:deleted")
+	other.Do("SET", "watch:deleted", "2")
+	c.Do("UNWATCH")
+
+	c.Do("MULTI")
+	c.Do("GET", "watch:deleted")
+	results, _ := redis.Strings(c.Do("EXEC"))
+	assert.Equal(t, []string{"2"}, results)
+
+	c.Do("HDEL", "watch:hash", "field")
+	c.Do("DEL", "watch:deleted")
+}
+
+// a key that expires after WATCH fails the transaction even if nobody accessed it
+func TestWatchExpiredKey(t *testing.T) {
+	c, err := redis.Dial("tcp", ":6379")
+	if err != nil {
+		t.Fatalf("failed to connect to database server: %v", err)
+	}
+	defer c.Close()
+
+	c.Do("SET", "watch:expiring", "1")
+	c.Do("EXPIRE", "watch:expiring", "1")
+	c.Do("WATCH", "watch:expiring")
+
+	time.Sleep(2100 * time.Millisecond)
+
+	c.Do("MULTI")
+	c.Do("SET", "watch:expiring", "2")
+	reply, _ := c.Do("EXEC")
+	assert.Nil(t, reply, "Expected a null reply")
+
+	_, err = c.Do("WATCH", "watch:expiring")
+	assert.NoError(t, err)
+	c.Do("MULTI")
+	_, err = c.Do("WATCH", "watch:expiring")
+	assert.ErrorContains(t, err, "WATCH inside MULTI is not allowed")
+	c.Do("DISCARD")
+}